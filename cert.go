@@ -5,6 +5,7 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"strings"
 )
 
@@ -12,21 +13,97 @@ import (
 // environment variables. If no certificate is found then
 // empty string is returned
 func GetCertFromEnvVariables(env []string) (*x509.Certificate, error) {
+	chain, err := certChainFromEnvVariables(env)
+	if err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+// GetCertFromFile returns certificate stored in PEM file with given path. When
+// the file contains a bundle, the first certificate is returned.
+func GetCertFromFile(path string) (*x509.Certificate, error) {
+	chain, err := certChainFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+// VerifyCertChain verifies that the first certificate of the chain is signed by
+// trusted CA, using the rest of the chain as intermediates. CA certificates are
+// read from caFile or system roots are used when it is empty. When hostname is
+// not empty, the certificate must be valid for it.
+func VerifyCertChain(chain []*x509.Certificate, caFile, hostname string) error {
+	if len(chain) == 0 {
+		return errors.New("missing certificate")
+	}
+	options := x509.VerifyOptions{
+		DNSName:       hostname,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, cert := range chain[1:] {
+		options.Intermediates.AddCert(cert)
+	}
+	if caFile != "" {
+		pemEncoded, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("unable to read CA file: %s", err)
+		}
+		options.Roots = x509.NewCertPool()
+		if !options.Roots.AppendCertsFromPEM(pemEncoded) {
+			return fmt.Errorf("no CA certificates found in %s", caFile)
+		}
+	}
+	if _, err := chain[0].Verify(options); err != nil {
+		return fmt.Errorf("certificate verification failed: %s", err)
+	}
+	return nil
+}
+
+func certChainFromEnvVariables(env []string) ([]*x509.Certificate, error) {
 	for _, value := range env {
 		if strings.HasPrefix(value, "CERTIFICATE=") {
-			pemEncoded := []byte(strings.TrimPrefix(value, "CERTIFICATE="))
-			p, _ := pem.Decode(pemEncoded)
-
-			if p == nil {
-				return nil, errors.New("missing certificate data")
-			}
-
-			cert, err := x509.ParseCertificate(p.Bytes)
-			if err != nil {
-				return nil, fmt.Errorf("certificate is invalid: %s", err)
-			}
-			return cert, nil
+			return parseCertChain([]byte(strings.TrimPrefix(value, "CERTIFICATE=")))
 		}
 	}
 	return nil, errors.New("missing certificate")
 }
+
+func certChainFromFile(path string) ([]*x509.Certificate, error) {
+	pemEncoded, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate file: %s", err)
+	}
+	return parseCertChain(pemEncoded)
+}
+
+// parseCertChain returns certificates from the PEM bundle. The first block must
+// be a certificate, other blocks that are not certificates (e.g. keys) are
+// skipped.
+func parseCertChain(pemEncoded []byte) ([]*x509.Certificate, error) {
+	p, rest := pem.Decode(pemEncoded)
+
+	if p == nil {
+		return nil, errors.New("missing certificate data")
+	}
+
+	cert, err := x509.ParseCertificate(p.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("certificate is invalid: %s", err)
+	}
+	chain := []*x509.Certificate{cert}
+
+	for p, rest = pem.Decode(rest); p != nil; p, rest = pem.Decode(rest) {
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("certificate in chain is invalid: %s", err)
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
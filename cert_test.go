@@ -1,8 +1,18 @@
 package executor
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -47,3 +57,67 @@ func TestIfReturnscertificateFromArgs(t *testing.T) {
 	assert.Equal(t, "Vault CA5", cert.Issuer.CommonName)
 	assert.Equal(t, "2017-06-13 13:53:05 +0000 UTC", cert.NotAfter.String())
 }
+
+func TestIfReturnsCertificateFromFile(t *testing.T) {
+	cert, err := GetCertFromFile("testdata/cert.pem")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Vault CA5", cert.Issuer.CommonName)
+}
+
+func TestIfReturnsErrorWhenCertificateFileIsInvalid(t *testing.T) {
+	_, err := GetCertFromFile("testdata/missing.pem")
+	assert.Contains(t, err.Error(), "unable to read certificate file")
+
+	_, err = GetCertFromFile("cert.go")
+	assert.EqualError(t, err, "missing certificate data")
+}
+
+func TestIfVerifiesCertificateChainAndHostname(t *testing.T) {
+	ca, caKey := newTestCert(t, "CA", nil, nil)
+	intermediate, intermediateKey := newTestCert(t, "Intermediate", ca, caKey)
+	leaf, _ := newTestCert(t, "service.example.com", intermediate, intermediateKey)
+
+	dir, err := ioutil.TempDir("", "cert")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600))
+	bundleFile := filepath.Join(dir, "bundle.pem")
+	bundle := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediate.Raw})...)
+	require.NoError(t, ioutil.WriteFile(bundleFile, bundle, 0600))
+
+	chain, err := certChainFromFile(bundleFile)
+	require.NoError(t, err)
+	require.Len(t, chain, 2)
+
+	assert.NoError(t, VerifyCertChain(chain, caFile, ""))
+	assert.NoError(t, VerifyCertChain(chain, caFile, "service.example.com"))
+	assert.Error(t, VerifyCertChain(chain, caFile, "other.example.com"))
+	assert.Error(t, VerifyCertChain(chain[:1], caFile, ""), "intermediate is required")
+	assert.Error(t, VerifyCertChain(chain, "", ""), "CA is not trusted by the system")
+}
+
+func newTestCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil || name == "Intermediate",
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
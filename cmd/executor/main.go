@@ -16,6 +16,7 @@ import (
 	"github.com/allegro/mesos-executor/hook/vaas"
 	"github.com/allegro/mesos-executor/metrics"
 	"github.com/allegro/mesos-executor/runenv"
+	"github.com/allegro/mesos-executor/xio"
 )
 
 // Version designates the version of application.
@@ -29,6 +30,11 @@ func init() {
 		log.WithError(err).Fatal("Failed to load executor configuration")
 	}
 
+	runenv.Environments = nil
+	for _, environment := range Config.Environments {
+		runenv.Environments = append(runenv.Environments, runenv.Env(environment))
+	}
+
 	if err := initSentry(Config); err != nil {
 		log.WithError(err).Fatal("Failed to initialize Sentry")
 	}
@@ -38,6 +44,14 @@ func init() {
 	} else {
 		log.SetLevel(log.InfoLevel)
 	}
+
+	if Config.LogFile != "" {
+		logFile, err := xio.NewRotatingFile(Config.LogFile, Config.LogFileMaxSize, Config.LogFileMaxFiles)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to open executor log file")
+		}
+		log.SetOutput(logFile)
+	}
 }
 
 func initSentry(config executor.Config) error {
@@ -5,9 +5,12 @@ package executor
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"strings"
+	"os/user"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,6 +18,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	osutil "github.com/allegro/mesos-executor/os"
+	"github.com/allegro/mesos-executor/runenv"
 	"github.com/allegro/mesos-executor/servicelog"
 	"github.com/allegro/mesos-executor/servicelog/appender"
 	"github.com/allegro/mesos-executor/servicelog/scraper"
@@ -24,6 +28,12 @@ import (
 type TaskExitState struct {
 	Code TaskExitCode
 	Err  error
+	// ExitCode is a numeric exit code of the task, -1 when the task was
+	// terminated by a signal or the code is unknown.
+	ExitCode int
+	// Signal is a signal that terminated the task, 0 when the task exited
+	// normally.
+	Signal syscall.Signal
 }
 
 // TaskExitCode is an enum.
@@ -38,11 +48,20 @@ const (
 	KilledCode
 )
 
+// logFilePollInterval is an interval of checking scraped log file for new content.
+const logFilePollInterval = 500 * time.Millisecond
+
+// logDrainTimeout is a maximal time of waiting for scraped logs delivery after
+// the command exits.
+const logDrainTimeout = 5 * time.Second
+
 // Command is an interface to abstract command running on a system.
 type Command interface {
 	Start() error
 	Wait() <-chan TaskExitState
-	Stop(gracePeriod time.Duration, sigtermExcludeProcesses []string)
+	Stop(signalSequence []osutil.SignalStep, sigtermExcludeProcesses []string)
+	// Pid returns pid of the started command.
+	Pid() int
 }
 
 type cancellableCommand struct {
@@ -87,57 +106,100 @@ func (c *cancellableCommand) Wait() <-chan TaskExitState {
 			return
 		}
 
+		exitCode, signal := exitStatus(err)
 		exitChan <- TaskExitState{
-			Code: FailedCode,
-			Err:  err,
+			Code:     FailedCode,
+			Err:      err,
+			ExitCode: exitCode,
+			Signal:   signal,
 		}
 	}()
 
 	return exitChan
 }
 
+func (c *cancellableCommand) Pid() int {
+	return c.cmd.Process.Pid
+}
+
+// exitStatus returns exit code and terminating signal of the command that
+// returned passed error.
+func exitStatus(err error) (int, syscall.Signal) {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return -1, 0
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return -1, status.Signal()
+	}
+	return exitErr.ExitCode(), 0
+}
+
 func (c *cancellableCommand) waitForCommand() {
 	err := c.cmd.Wait()
+	closeOutput(c.cmd)
 	c.doneChan <- err
 	close(c.doneChan)
 }
 
-func (c *cancellableCommand) Stop(gracePeriod time.Duration, sigtermExcludeProcesses []string) {
+// closeOutput closes command output writers, so their consumers (e.g. log
+// scrapers) know there is no more data. Files (e.g. standard streams) are never
+// closed.
+func closeOutput(cmd *exec.Cmd) {
+	outputs := []io.Writer{cmd.Stdout}
+	if cmd.Stderr != cmd.Stdout {
+		outputs = append(outputs, cmd.Stderr)
+	}
+	for _, output := range outputs {
+		if _, ok := output.(*os.File); ok {
+			continue
+		}
+		if closer, ok := output.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.WithError(err).Warn("Unable to close command output")
+			}
+		}
+	}
+}
+
+func (c *cancellableCommand) Stop(signalSequence []osutil.SignalStep, sigtermExcludeProcesses []string) {
 	// Return if Stop was already called.
 	if c.killing {
 		return
 	}
 	c.killing = true
-	err := osutil.KillTreeWithExcludes(syscall.SIGTERM, int32(c.cmd.Process.Pid), sigtermExcludeProcesses)
-	if err != nil {
-		log.WithError(err).Errorf("There was a problem with sending %s to %d children", syscall.SIGTERM, c.cmd.Process.Pid)
-		return
-	}
-
-	<-time.After(gracePeriod)
-
-	if err := osutil.KillTree(syscall.SIGKILL, int32(c.cmd.Process.Pid)); err != nil {
-		log.WithError(err).Warnf("There was a problem with sending %s to %d tree", syscall.SIGKILL, c.cmd.Process.Pid)
-		return
+	if err := osutil.KillTreeGracefully(signalSequence, int32(c.cmd.Process.Pid), sigtermExcludeProcesses); err != nil {
+		log.WithError(err).Warnf("There was a problem with stopping %d tree", c.cmd.Process.Pid)
 	}
 }
 
 // NewCommand returns a new command based on passed CommandInfo.
 func NewCommand(commandInfo mesos.CommandInfo, env []string, options ...func(*exec.Cmd) error) (Command, error) {
-	// TODO(janisz): Implement shell policy
+	var cmd *exec.Cmd
 	// From: https://github.com/apache/mesos/blob/1.1.3/include/mesos/mesos.proto#L509-L521
 	// There are two ways to specify the command:
-	// 1) If 'shell == true', the command will be launched via shell
-	//		(i.e., /bin/sh -c 'value'). The 'value' specified will be
-	//		treated as the shell command. The 'arguments' will be ignored.
-	// 2) If 'shell == false', the command will be launched by passing
-	//		arguments to an executable. The 'value' specified will be
-	//		treated as the filename of the executable. The 'arguments'
-	//		will be treated as the arguments to the executable. This is
-	//		similar to how POSIX exec families launch processes (i.e.,
-	//		execlp(value, arguments(0), arguments(1), ...)).
-	cmd := exec.Command("sh", "-c", commandInfo.GetValue()) // #nosec
-	cmd.Env = append(envWithoutExecutorConfig(), env...)
+	if commandInfo.GetShell() {
+		// the command will be launched via shell
+		// (i.e., /bin/sh -c 'value'). The 'value' specified will be
+		// treated as the shell command. The 'arguments' will be ignored.
+		cmd = exec.Command("sh", "-c", commandInfo.GetValue()) // #nosec
+	} else {
+		// the command will be launched by passing
+		// arguments to an executable. The 'value' specified will be
+		// treated as the filename of the executable. The 'arguments'
+		// will be treated as the arguments to the executable. This is
+		// similar to how POSIX exec families launch processes (i.e.,
+		// execlp(value, arguments(0), arguments(1), ...)).
+		// The first argument is argv[0] of the executable, not its argument.
+		args := commandInfo.GetArguments()
+		if len(args) > 0 {
+			cmd = exec.Command(commandInfo.GetValue(), args[1:]...) // #nosec
+			cmd.Args = args
+		} else {
+			cmd = exec.Command(commandInfo.GetValue()) // #nosec
+		}
+	}
+	cmd.Env = append(runenv.EnvWithoutExecutorConfig(), env...)
 	for _, option := range options {
 		if err := option(cmd); err != nil {
 			return nil, fmt.Errorf("invalid config option: %s", err)
@@ -145,10 +207,52 @@ func NewCommand(commandInfo mesos.CommandInfo, env []string, options ...func(*ex
 	}
 	// Set new group for a command
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if username := commandInfo.GetUser(); username != "" {
+		credential, err := credentialFor(username)
+		if err != nil {
+			return nil, fmt.Errorf("unable to run command as user %s: %s", username, err)
+		}
+		cmd.SysProcAttr.Credential = credential
+	}
 
 	return &cancellableCommand{cmd: cmd}, nil
 }
 
+// credentialFor returns credential of the user with given name. Returns nil
+// credential when the user is the one running executor, so there is no need to
+// switch it.
+func credentialFor(username string) (*syscall.Credential, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %s: %s", u.Uid, err)
+	}
+	if int(uid) == os.Getuid() {
+		return nil, nil
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %s: %s", u.Gid, err)
+	}
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get groups: %s", err)
+	}
+	var groups []uint32
+	for _, groupID := range groupIds {
+		group, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group id %s: %s", groupID, err)
+		}
+		groups = append(groups, uint32(group))
+	}
+
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}, nil
+}
+
 // ForwardCmdOutput configures command to forward its output to the system stderr
 // and stdout.
 func ForwardCmdOutput() func(*exec.Cmd) error {
@@ -159,29 +263,121 @@ func ForwardCmdOutput() func(*exec.Cmd) error {
 	}
 }
 
+// TeeCmdOutput configures command to copy its output to passed writers (e.g.
+// sandbox stdout and stderr files) in addition to the already configured one.
+// It should be passed after options setting the command output. Copying errors
+// are ignored, so they never affect the configured output.
+func TeeCmdOutput(stdout, stderr io.Writer) func(*exec.Cmd) error {
+	return func(cmd *exec.Cmd) error {
+		cmd.Stdout = teeOutput(cmd.Stdout, stdout)
+		cmd.Stderr = teeOutput(cmd.Stderr, stderr)
+		return nil
+	}
+}
+
+func teeOutput(output, copy io.Writer) io.Writer {
+	if output == nil {
+		return copy
+	}
+	return &teeWriter{output: output, copy: copy}
+}
+
+// teeWriter writes data to the output and its copy. It could be closed to
+// close the output.
+type teeWriter struct {
+	output io.Writer
+	copy   io.Writer
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if _, err := t.copy.Write(p); err != nil {
+		log.WithError(err).Debug("Unable to copy command output")
+	}
+	return t.output.Write(p)
+}
+
+func (t *teeWriter) Close() error {
+	if closer, ok := t.output.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // ScrapCmdOutput configures command so itd output will be scraped and forwarded
-// by provided log appender.
-func ScrapCmdOutput(s scraper.Scraper, a appender.Appender, extenders ...servicelog.Extender) func(*exec.Cmd) error {
+// by provided log appender. Entries rejected by any of the filters are dropped.
+func ScrapCmdOutput(s scraper.Scraper, a appender.Appender, filters []servicelog.EntryFilter,
+	extenders ...servicelog.Extender) func(*exec.Cmd) error {
+	return ScrapCmdOutputAndFile("", s, a, filters, extenders...)
+}
+
+// ScrapCmdOutputAndFile works like ScrapCmdOutput but additionally scrapes
+// content appended to the file at given path (when it is not empty). It is
+// useful for services that write logs only to a file in the sandbox. After the
+// command exits, remaining logs are delivered and the appender is closed.
+func ScrapCmdOutputAndFile(path string, s scraper.Scraper, a appender.Appender,
+	filters []servicelog.EntryFilter, extenders ...servicelog.Extender) func(*exec.Cmd) error {
 	return func(cmd *exec.Cmd) error {
 		entries, writer := scraper.Pipe(s)
+		output := &scrapedOutput{WriteCloser: writer, done: make(chan struct{})}
+		if path != "" {
+			log.Infof("Service logs will be scraped from %s", path)
+			tail := scraper.TailFile(path, logFilePollInterval)
+			output.tail = tail
+			entries = mergeEntries(entries, s.StartScraping(tail))
+		}
+		entries = servicelog.Filter(entries, filters...)
 		entries = servicelog.Extend(entries, extenders...)
-		cmd.Stderr = writer
-		cmd.Stdout = writer
-		go a.Append(entries)
+		cmd.Stderr = output
+		cmd.Stdout = output
+		go func() {
+			a.Append(entries)
+			if err := a.Close(); err != nil {
+				log.WithError(err).Warn("Unable to close service log appender")
+			}
+			close(output.done)
+		}()
 		return nil
 	}
 }
 
-// envWithoutExecutorConfig returns os.Environ without executor specific entries.
-// Marathon does not support custom executor env and all task env are passed
-// as executor env. This means environment are setup before executor startup.
-func envWithoutExecutorConfig() (env []string) {
-	for _, variable := range os.Environ() {
-		if !strings.HasPrefix(variable, strings.ToUpper(EnvironmentPrefix)) {
-			env = append(env, variable)
-		} else {
+// scrapedOutput is a command output passed to the log scraper. Closing it
+// waits until already written logs are delivered by the appender.
+type scrapedOutput struct {
+	io.WriteCloser
+	tail io.Closer
+	done chan struct{}
+}
 
-		}
+func (o *scrapedOutput) Close() error {
+	err := o.WriteCloser.Close()
+	if o.tail != nil {
+		_ = o.tail.Close()
+	}
+	select {
+	case <-o.done:
+	case <-time.After(logDrainTimeout):
+		log.Warnf("Service logs were not delivered in %s", logDrainTimeout)
+	}
+	return err
+}
+
+// mergeEntries returns a channel with entries received from all passed channels.
+// Returned channel is closed when all passed channels are closed.
+func mergeEntries(channels ...<-chan servicelog.Entry) <-chan servicelog.Entry {
+	out := make(chan servicelog.Entry)
+	var wg sync.WaitGroup
+	wg.Add(len(channels))
+	for _, in := range channels {
+		go func(in <-chan servicelog.Entry) {
+			defer wg.Done()
+			for entry := range in {
+				out <- entry
+			}
+		}(in)
 	}
-	return env
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
 }
@@ -1,12 +1,20 @@
 package executor
 
 import (
+	"bytes"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"syscall"
 	"testing"
 
 	"github.com/mesos/mesos-go/api/v1/lib"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allegro/mesos-executor/servicelog"
+	"github.com/allegro/mesos-executor/servicelog/scraper"
 )
 
 func TestIfNewCancellableCommandReturnsCommandWithoutExecutorEnv(t *testing.T) {
@@ -20,7 +28,7 @@ func TestIfNewCancellableCommandReturnsCommandWithoutExecutorEnv(t *testing.T) {
 	defer os.Unsetenv("TEST")
 	defer os.Unsetenv("_ALLEGRO_EXECUTOR_")
 
-	commandInfo := newCommandInfo("./sleep 100", "ignored", false, []string{"ignored"})
+	commandInfo := newCommandInfo("./sleep 100", "", true, []string{"ignored"})
 	command, err := NewCommand(commandInfo, []string{"ALLEGRO_EXECUTOR_NOT_REMOVED=y", "SOME_ENV=x"})
 	cmd := command.(*cancellableCommand).cmd
 
@@ -36,6 +44,147 @@ func TestIfNewCancellableCommandReturnsCommandWithoutExecutorEnv(t *testing.T) {
 	}
 }
 
+func TestIfNewCancellableCommandReturnsNonShellCommandWithoutExecutorEnv(t *testing.T) {
+	os.Setenv("ALLEGRO_EXECUTOR_TEST_1", "x")
+	os.Setenv("TEST", "z")
+
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_TEST_1")
+	defer os.Unsetenv("TEST")
+
+	commandInfo := newCommandInfo("sleep", "", false, []string{"sleep", "100", "with space"})
+	command, err := NewCommand(commandInfo, []string{"ALLEGRO_EXECUTOR_NOT_REMOVED=y", "SOME_ENV=x"})
+	cmd := command.(*cancellableCommand).cmd
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sleep", "100", "with space"}, cmd.Args)
+	assert.Equal(t, filepath.Base(cmd.Path), "sleep")
+	assert.True(t, cmd.SysProcAttr.Setpgid, "should have pgid flag set to true")
+
+	assert.NotContains(t, cmd.Env, "ALLEGRO_EXECUTOR_TEST_1=x")
+
+	for _, e := range []string{"TEST=z", "ALLEGRO_EXECUTOR_NOT_REMOVED=y", "SOME_ENV=x"} {
+		assert.Contains(t, cmd.Env, e)
+	}
+}
+
+func TestIfNonShellCommandWithoutArgumentsUsesValueAsArgv0(t *testing.T) {
+	commandInfo := newCommandInfo("sleep", "", false, nil)
+	command, err := NewCommand(commandInfo, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"sleep"}, command.(*cancellableCommand).cmd.Args)
+}
+
+func TestIfWaitReturnsExitCodeOfFailedCommand(t *testing.T) {
+	commandInfo := newCommandInfo("exit 3", "", true, nil)
+	command, err := NewCommand(commandInfo, nil)
+	require.NoError(t, err)
+	require.NoError(t, command.Start())
+
+	exitState := <-command.Wait()
+
+	assert.Equal(t, FailedCode, exitState.Code)
+	assert.Equal(t, 3, exitState.ExitCode)
+	assert.Equal(t, syscall.Signal(0), exitState.Signal)
+}
+
+func TestIfWaitReturnsSignalThatTerminatedCommand(t *testing.T) {
+	commandInfo := newCommandInfo("kill -9 $$", "", true, nil)
+	command, err := NewCommand(commandInfo, nil)
+	require.NoError(t, err)
+	require.NoError(t, command.Start())
+
+	exitState := <-command.Wait()
+
+	assert.Equal(t, FailedCode, exitState.Code)
+	assert.Equal(t, -1, exitState.ExitCode)
+	assert.Equal(t, syscall.SIGKILL, exitState.Signal)
+}
+
+func TestIfDeliversScrapedLogsAndClosesAppenderAfterCommandExits(t *testing.T) {
+	appender := &recordingAppender{}
+	commandInfo := newCommandInfo(`echo '{"msg": "first"}'; echo '{"msg": "last"}'`, "", true, nil)
+	command, err := NewCommand(commandInfo, nil, ScrapCmdOutput(&scraper.JSON{}, appender, nil))
+	require.NoError(t, err)
+	require.NoError(t, command.Start())
+
+	exitState := <-command.Wait()
+
+	assert.Equal(t, SuccessCode, exitState.Code)
+	assert.Equal(t, []servicelog.Entry{{"msg": "first"}, {"msg": "last"}}, appender.entries)
+	assert.True(t, appender.closed)
+}
+
+func TestIfCopiesScrapedOutputToPassedWriters(t *testing.T) {
+	appender := &recordingAppender{}
+	var stdout, stderr bytes.Buffer
+	commandInfo := newCommandInfo(`echo '{"msg": "out"}'; echo '{"msg": "err"}' >&2`, "", true, nil)
+	command, err := NewCommand(commandInfo, nil,
+		ScrapCmdOutput(&scraper.JSON{}, appender, nil),
+		TeeCmdOutput(&stdout, &stderr))
+	require.NoError(t, err)
+	require.NoError(t, command.Start())
+
+	exitState := <-command.Wait()
+
+	assert.Equal(t, SuccessCode, exitState.Code)
+	assert.Equal(t, "{\"msg\": \"out\"}\n", stdout.String())
+	assert.Equal(t, "{\"msg\": \"err\"}\n", stderr.String())
+	assert.ElementsMatch(t, []servicelog.Entry{{"msg": "out"}, {"msg": "err"}}, appender.entries)
+	assert.True(t, appender.closed)
+}
+
+type recordingAppender struct {
+	entries []servicelog.Entry
+	closed  bool
+}
+
+func (a *recordingAppender) Append(entries <-chan servicelog.Entry) {
+	for entry := range entries {
+		a.entries = append(a.entries, entry)
+	}
+}
+
+func (a *recordingAppender) Close() error {
+	a.closed = true
+	return nil
+}
+
+func TestIfNewCommandRunsCommandAsGivenUser(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if err != nil || os.Getuid() != 0 {
+		t.Skip("requires root and nobody user")
+	}
+
+	commandInfo := newCommandInfo("id -u", "nobody", true, nil)
+	command, err := NewCommand(commandInfo, nil)
+	require.NoError(t, err)
+	cmd := command.(*cancellableCommand).cmd
+
+	require.NotNil(t, cmd.SysProcAttr.Credential)
+	assert.Equal(t, nobody.Uid, strconv.Itoa(int(cmd.SysProcAttr.Credential.Uid)))
+	assert.Equal(t, nobody.Gid, strconv.Itoa(int(cmd.SysProcAttr.Credential.Gid)))
+	assert.True(t, cmd.SysProcAttr.Setpgid, "should have pgid flag set to true")
+}
+
+func TestIfNewCommandDoesNotSwitchToCurrentUser(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	commandInfo := newCommandInfo("id -u", current.Username, true, nil)
+	command, err := NewCommand(commandInfo, nil)
+	require.NoError(t, err)
+
+	assert.Nil(t, command.(*cancellableCommand).cmd.SysProcAttr.Credential)
+}
+
+func TestIfNewCommandFailsForNonExistingUser(t *testing.T) {
+	commandInfo := newCommandInfo("id -u", "non-existing-user", true, nil)
+	_, err := NewCommand(commandInfo, nil)
+
+	assert.Error(t, err)
+}
+
 func newCommandInfo(command, user string, shell bool, args []string) mesos.CommandInfo {
 	return mesos.CommandInfo{
 		Shell:     &shell,
@@ -3,14 +3,20 @@ package executor
 import (
 	"context"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	mesos "github.com/mesos/mesos-go/api/v1/lib"
@@ -24,6 +30,9 @@ import (
 
 	"github.com/allegro/mesos-executor/hook"
 	"github.com/allegro/mesos-executor/mesosutils"
+	"github.com/allegro/mesos-executor/metrics"
+	osutil "github.com/allegro/mesos-executor/os"
+	"github.com/allegro/mesos-executor/runenv"
 	"github.com/allegro/mesos-executor/servicelog"
 	"github.com/allegro/mesos-executor/servicelog/appender"
 	"github.com/allegro/mesos-executor/servicelog/scraper"
@@ -31,22 +40,56 @@ import (
 )
 
 // EnvironmentPrefix is a prefix for environmental configuration
-const EnvironmentPrefix = "allegro_executor"
+const EnvironmentPrefix = runenv.EnvironmentPrefix
+
+// stateUpdateWALFile is a name of the state updates write-ahead log file
+// created in the task sandbox
+const stateUpdateWALFile = ".executor-state-updates.wal"
+
+// maxTaskRestartBackoff is a limit of the delay before restart of a failed
+// command
+const maxTaskRestartBackoff = 5 * time.Minute
 
 // Config settable from the environment
 type Config struct {
 	// Sets logging level to `debug` when true, `info` otherwise
 	Debug bool `default:"false" split_words:"true"`
+	// Path of the file executor logs are written to instead of stderr
+	LogFile string `split_words:"true"`
+	// Size in bytes after which the log file is rotated, it is not rotated when 0
+	LogFileMaxSize int64 `default:"10485760" split_words:"true"`
+	// Number of rotated log files kept
+	LogFileMaxFiles int `default:"5" split_words:"true"`
 	// Mesos API path
 	APIPath string `default:"/api/v1/executor" split_words:"true"`
 	// Delay between sending TERM and KILL signals
 	KillPolicyGracePeriod time.Duration `default:"5s" split_words:"true"`
+	// Maximal grace period, longer task kill policy grace periods and kill signal sequences are clamped to it (0 means no limit)
+	MaxKillGracePeriod time.Duration `default:"0" split_words:"true"`
 	// Timeout for communication with Mesos
 	HTTPTimeout time.Duration `default:"10s" split_words:"true"`
+	// Time to wait for the first successful subscription to Mesos agent, it
+	// should match executor_registration_timeout of the agent. RecoveryTimeout
+	// is used after executor was subscribed.
+	RegistrationTimeout time.Duration `default:"1m" split_words:"true"`
+	// Host checked by HTTP and TCP health checks instead of the agent public IP
+	// (e.g. on agents with many interfaces). It can be overridden with the
+	// health-check-host task label.
+	HealthCheckHost string `split_words:"true"`
 	// Number of state messages to keep in buffer
 	StateUpdateBufferSize int `default:"1024" split_words:"true"`
 	// Timeout for attempts to send messages in buffer
 	StateUpdateWaitTimeout time.Duration `default:"5s" split_words:"true"`
+	// Persist unacknowledged state messages in the sandbox to resend them after executor restart
+	StateUpdateWALEnabled bool `default:"false" split_words:"true"`
+	// What to do when state messages buffer is full: block, drop-oldest or drop-newest
+	StateUpdateBufferPolicy string `default:"block" split_words:"true"`
+
+	// Call hooks concurrently instead of one by one
+	HooksParallel bool `default:"false" split_words:"true"`
+
+	// Maximal time of a single hook call, calls are not limited when it is 0
+	HookTimeout time.Duration `default:"0" split_words:"true"`
 
 	// Mesos framework configuration
 	MesosConfig config.Config `ignore:"true"`
@@ -54,23 +97,101 @@ type Config struct {
 	// SentryDSN is an address used for sending logs to Sentry
 	SentryDSN string `split_words:"true"`
 
+	// Environments is a list of environments recognized in the hostname
+	// suffix (e.g. host-prod.example.com)
+	Environments []string `default:"prod,test,dev"`
+
 	// ServicelogBufferSize sets a line buffer size used by log scraping module
 	ServicelogBufferSize uint `default:"2000" split_words:"true"`
 
 	// ServicelogIgnoreKeys is a list of ignored keys for log scraping module
 	ServicelogIgnoreKeys []string `split_words:"true"`
 
+	// ServicelogIgnoreKeysMatching sets how ServicelogIgnoreKeys are matched:
+	// exact or glob (shell patterns like debug_*)
+	ServicelogIgnoreKeysMatching string `default:"exact" split_words:"true"`
+
+	// ServicelogRenameKeys maps keys of scraped JSON logs to new names (e.g.
+	// host:app_host), it is useful when keys clash with Logstash mapping
+	ServicelogRenameKeys map[string]string `split_words:"true"`
+
+	// ServicelogMaxLineBytes is a maximal size of a single scraped log line,
+	// longer lines can not be parsed
+	ServicelogMaxLineBytes int `default:"1048576" split_words:"true"`
+
+	// ServicelogMultilinePattern is a regular expression matching lines that
+	// continue previous log entry (e.g. stack traces). Multiline logs are not
+	// aggregated when it is empty.
+	ServicelogMultilinePattern string `split_words:"true"`
+
+	// ServicelogMinLevel is a minimal level of forwarded service logs. Logs
+	// without recognizable level are always forwarded.
+	ServicelogMinLevel string `split_words:"true"`
+
+	// ServicelogSamplingRate enables forwarding only one of every N service
+	// logs with sampled levels. Logs with WARN level or higher are always
+	// forwarded.
+	ServicelogSamplingRate uint64 `split_words:"true"`
+
+	// ServicelogSamplingLevels is a list of log levels that are sampled
+	ServicelogSamplingLevels []string `default:"TRACE,DEBUG,INFO" split_words:"true"`
+
+	// ServicelogSamplingLoggers is a list of sampled loggers, all loggers are
+	// sampled when it is empty
+	ServicelogSamplingLoggers []string `split_words:"true"`
+
+	// ServicelogTimestampLayouts is a list of Go time layouts used to normalize
+	// time field of service logs, default layouts are used when it is empty
+	ServicelogTimestampLayouts []string `split_words:"true"`
+
+	// ServicelogMesosFields adds framework-id, agent-endpoint and task-id
+	// fields to every service log entry
+	ServicelogMesosFields bool `default:"true" split_words:"true"`
+
+	// ServicelogTeeOutput copies scraped service output to the executor
+	// stdout and stderr (sandbox files)
+	ServicelogTeeOutput bool `default:"false" split_words:"true"`
+
+	// DryRunTaskInfo is a path to a JSON encoded TaskInfo. When set, executor
+	// does not connect to the Mesos agent, it only validates that the task
+	// could be launched (runs hooks before task start and creates command
+	// without starting it) and exits.
+	DryRunTaskInfo string `split_words:"true"`
+
 	// Range in which certificate will be considered as expired. Used to
 	// prevent shutdown of all tasks at once.
 	RandomExpirationRange time.Duration `default:"3h" split_words:"true"`
 
+	// CertificateCheckInterval is an interval of reading the task certificate
+	// again to reschedule the task kill when it was changed, certificate is
+	// checked only on launch when it is 0
+	CertificateCheckInterval time.Duration `default:"0" split_words:"true"`
+
+	// KillSignalSequence is a comma separated list of SIGNAL[:delay] pairs sent
+	// to process tree during shutdown before SIGKILL. Steps without delay share
+	// what is left of KillPolicyGracePeriod (e.g. "SIGINT:5s,SIGTERM").
+	KillSignalSequence string `default:"SIGTERM" split_words:"true"`
+
+	// TaskMaxRestarts is a number of times a command that exited with an error
+	// is launched again before the task is failed. It can be overridden with
+	// the max-restarts task label.
+	TaskMaxRestarts int `default:"0" split_words:"true"`
+
+	// TaskRestartBackoff is a delay before the first restart of a failed
+	// command, it is doubled with every next restart up to 5 minutes
+	TaskRestartBackoff time.Duration `default:"1s" split_words:"true"`
+
 	// SigtermExcludeProcesses specifies process names to omit when sending SIGTERM to process tree during shutdown
 	SigtermExcludeProcesses []string `split_words:"true"`
 }
 
 var errMustAbort = errors.New("received abort signal from mesos, will attempt to re-subscribe")
 
-// Executor is responsible for launching and monitoring single Mesos task.
+// exit terminates the executor, replaced in tests
+var exit = os.Exit
+
+// Executor is responsible for launching and monitoring Mesos tasks. Usually it
+// runs a single task, but tasks of a task group are run together.
 type Executor struct {
 	config        Config
 	context       context.Context
@@ -92,10 +213,17 @@ type Event struct {
 	// additional debug message.
 	Message string
 
+	// exitState is set for CommandExited events
+	exitState TaskExitState
+	// taskID is an ID of the task the event concerns, empty for events
+	// concerning all tasks
+	taskID string
+
 	// TODO(medzin): remove abstractions, because they only obscure all the communication
 	kill       executor.Event_Kill
 	subscribed executor.Event_Subscribed
 	launch     executor.Event_Launch
+	message    executor.Event_Message
 }
 
 // EventType defines type of the Event.
@@ -129,25 +257,58 @@ const (
 	Subscribed
 	// Launch means executor should start a task.
 	Launch
+	// Restart means executor should launch again failed command of a task.
+	Restart
+
+	// Message means framework sent a message that should be passed to hooks.
+	Message
 )
 
 // NewExecutor creates new instance of executor configured with by `cfg` with hooks
 func NewExecutor(cfg Config, hooks ...hook.Hook) *Executor {
+	return newExecutor(cfg, newStateUpdater(cfg), hooks...)
+}
 
+func newExecutor(cfg Config, stateUpdater state.Updater, hooks ...hook.Hook) *Executor {
 	log.Info("Initializing executor with following configuration:")
-	log.Infof("AgentEndpoint               = %s", cfg.MesosConfig.AgentEndpoint)
-	log.Infof("Checkpoint                  = %t", cfg.MesosConfig.Checkpoint)
-	log.Infof("Directory                   = %s", cfg.MesosConfig.Directory)
-	log.Infof("ExecutorID                  = %s", cfg.MesosConfig.ExecutorID)
-	log.Infof("ExecutorShutdownGracePeriod = %s", cfg.MesosConfig.ExecutorShutdownGracePeriod)
-	log.Infof("FrameworkID                 = %s", cfg.MesosConfig.FrameworkID)
-	log.Infof("RecoveryTimeout             = %s", cfg.MesosConfig.RecoveryTimeout)
-	log.Infof("SubscriptionBackoffMax      = %s", cfg.MesosConfig.SubscriptionBackoffMax)
-	log.Infof("APIPath                     = %s", cfg.APIPath)
-	log.Infof("Debug                       = %t", cfg.Debug)
-	log.Infof("ServicelogBufferSize        = %d", cfg.ServicelogBufferSize)
-	log.Infof("ServicelogIgnoreKeys        = %s", cfg.ServicelogIgnoreKeys)
-	log.Infof("StateUpdateBufferSize       = %d", cfg.StateUpdateBufferSize)
+	log.Infof("AgentEndpoint                = %s", cfg.MesosConfig.AgentEndpoint)
+	log.Infof("Checkpoint                   = %t", cfg.MesosConfig.Checkpoint)
+	log.Infof("Directory                    = %s", cfg.MesosConfig.Directory)
+	log.Infof("ExecutorID                   = %s", cfg.MesosConfig.ExecutorID)
+	log.Infof("ExecutorShutdownGracePeriod  = %s", cfg.MesosConfig.ExecutorShutdownGracePeriod)
+	log.Infof("FrameworkID                  = %s", cfg.MesosConfig.FrameworkID)
+	log.Infof("RecoveryTimeout              = %s", cfg.MesosConfig.RecoveryTimeout)
+	log.Infof("SubscriptionBackoffMax       = %s", cfg.MesosConfig.SubscriptionBackoffMax)
+	log.Infof("RegistrationTimeout          = %s", cfg.RegistrationTimeout)
+	log.Infof("HealthCheckHost              = %s", cfg.HealthCheckHost)
+	log.Infof("APIPath                      = %s", cfg.APIPath)
+	log.Infof("Debug                        = %t", cfg.Debug)
+	log.Infof("LogFile                      = %s", cfg.LogFile)
+	log.Infof("LogFileMaxSize               = %d", cfg.LogFileMaxSize)
+	log.Infof("LogFileMaxFiles              = %d", cfg.LogFileMaxFiles)
+	log.Infof("ServicelogBufferSize         = %d", cfg.ServicelogBufferSize)
+	log.Infof("ServicelogIgnoreKeys         = %s", cfg.ServicelogIgnoreKeys)
+	log.Infof("ServicelogIgnoreKeysMatching = %s", cfg.ServicelogIgnoreKeysMatching)
+	log.Infof("ServicelogRenameKeys         = %s", cfg.ServicelogRenameKeys)
+	log.Infof("ServicelogMaxLineBytes       = %d", cfg.ServicelogMaxLineBytes)
+	log.Infof("ServicelogMultilinePattern   = %s", cfg.ServicelogMultilinePattern)
+	log.Infof("ServicelogMinLevel           = %s", cfg.ServicelogMinLevel)
+	log.Infof("ServicelogSamplingRate       = %d", cfg.ServicelogSamplingRate)
+	log.Infof("ServicelogSamplingLevels     = %s", cfg.ServicelogSamplingLevels)
+	log.Infof("ServicelogSamplingLoggers    = %s", cfg.ServicelogSamplingLoggers)
+	log.Infof("ServicelogTimestampLayouts   = %s", cfg.ServicelogTimestampLayouts)
+	log.Infof("ServicelogMesosFields        = %t", cfg.ServicelogMesosFields)
+	log.Infof("ServicelogTeeOutput          = %t", cfg.ServicelogTeeOutput)
+	log.Infof("DryRunTaskInfo               = %s", cfg.DryRunTaskInfo)
+	log.Infof("HookTimeout                  = %s", cfg.HookTimeout)
+	log.Infof("HooksParallel                = %t", cfg.HooksParallel)
+	log.Infof("KillSignalSequence           = %s", cfg.KillSignalSequence)
+	log.Infof("MaxKillGracePeriod           = %s", cfg.MaxKillGracePeriod)
+	log.Infof("TaskMaxRestarts              = %d", cfg.TaskMaxRestarts)
+	log.Infof("TaskRestartBackoff           = %s", cfg.TaskRestartBackoff)
+	log.Infof("StateUpdateBufferSize        = %d", cfg.StateUpdateBufferSize)
+	log.Infof("StateUpdateWALEnabled        = %t", cfg.StateUpdateWALEnabled)
+	log.Infof("StateUpdateBufferPolicy      = %s", cfg.StateUpdateBufferPolicy)
 
 	ctx, ctxCancel := context.WithCancel(context.Background())
 	return &Executor{
@@ -158,17 +319,54 @@ func NewExecutor(cfg Config, hooks ...hook.Hook) *Executor {
 		// the executor, and it locks itself on this channel, because after first
 		// kill nobody is listening to it
 		events:       make(chan Event, 128),
-		hookManager:  hook.Manager{Hooks: hooks},
-		stateUpdater: state.BufferedUpdater(cfg.MesosConfig, cfg.StateUpdateBufferSize),
+		hookManager:  hook.Manager{Hooks: hooks, Parallel: cfg.HooksParallel, Timeout: cfg.HookTimeout},
+		stateUpdater: stateUpdater,
 		clock:        systemClock{},
 		random:       newRandom(),
 	}
 }
 
-// StartExecutor creates a new executor instance nad starts it
+func newStateUpdater(cfg Config) state.Updater {
+	options := []state.UpdaterOption{state.BufferFullPolicy(state.BufferPolicy(cfg.StateUpdateBufferPolicy))}
+	if cfg.StateUpdateWALEnabled {
+		walPath := filepath.Join(cfg.MesosConfig.Sandbox, stateUpdateWALFile)
+		options = append(options, state.WriteAheadLog(walPath))
+	}
+	return state.BufferedUpdater(cfg.MesosConfig, cfg.StateUpdateBufferSize, options...)
+}
+
+// StartExecutor creates a new executor instance nad starts it. When
+// DryRunTaskInfo is set, the task read from it is only validated with DryRun.
 func StartExecutor(conf Config, hooks []hook.Hook) error {
-	exec := NewExecutor(sanitizeConfig(conf), hooks...)
-	return exec.Start()
+	conf = sanitizeConfig(conf)
+	if err := validateConfig(conf); err != nil {
+		return fmt.Errorf("invalid executor configuration: %s", err)
+	}
+	if conf.DryRunTaskInfo != "" {
+		taskInfo, err := readTaskInfo(conf.DryRunTaskInfo)
+		if err != nil {
+			return err
+		}
+		// state updates and logs are not sent in dry run
+		return newExecutor(conf, nil, hooks...).DryRun(taskInfo)
+	}
+	// location is looked up in background, so it is known before logs are sent
+	runenv.ResolvePlacement()
+	return NewExecutor(conf, hooks...).Start()
+}
+
+// readTaskInfo reads JSON encoded TaskInfo (as returned by Mesos HTTP API)
+// from the file.
+func readTaskInfo(path string) (mesos.TaskInfo, error) {
+	var taskInfo mesos.TaskInfo
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return taskInfo, fmt.Errorf("unable to read task info: %s", err)
+	}
+	if err := json.Unmarshal(data, &taskInfo); err != nil {
+		return taskInfo, fmt.Errorf("unable to parse task info: %s", err)
+	}
+	return taskInfo, nil
 }
 
 func sanitizeConfig(conf Config) Config {
@@ -184,6 +382,9 @@ func sanitizeConfig(conf Config) Config {
 	if conf.MesosConfig.RecoveryTimeout <= 0 {
 		conf.MesosConfig.RecoveryTimeout = time.Second
 	}
+	if conf.RegistrationTimeout <= 0 {
+		conf.RegistrationTimeout = conf.MesosConfig.RecoveryTimeout
+	}
 	if conf.MesosConfig.SubscriptionBackoffMax < time.Second {
 		conf.MesosConfig.SubscriptionBackoffMax = time.Second
 	}
@@ -191,11 +392,28 @@ func sanitizeConfig(conf Config) Config {
 	return conf
 }
 
+// validateConfig checks values that would otherwise be reported only when
+// they are used by a task.
+func validateConfig(conf Config) error {
+	if _, err := osutil.ParseSignalSequence(conf.KillSignalSequence, conf.KillPolicyGracePeriod); err != nil {
+		return fmt.Errorf("invalid kill signal sequence: %s", err)
+	}
+	if _, err := ignoredKeysFilter(conf); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Start registers executor in Mesos agent and waits for events from it.
 func (e *Executor) Start() error {
 
 	go e.taskEventLoop()
 
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	go e.handleSignals(signals)
+
 	callOptions := executor.CallOptions{
 		calls.Executor(e.config.MesosConfig.ExecutorID),
 		calls.Framework(e.config.MesosConfig.FrameworkID),
@@ -214,12 +432,18 @@ func (e *Executor) Start() error {
 	)
 
 	shouldConnect := backoff.Notifier(time.Second, e.config.MesosConfig.SubscriptionBackoffMax, nil)
-	recoveryTimeout := time.NewTimer(e.config.MesosConfig.RecoveryTimeout)
+	// agent may not be ready yet when executor starts, so the first
+	// subscription is not treated as a recovery
+	subscribed := false
+	recoveryTimeout := time.NewTimer(e.config.RegistrationTimeout)
 
 SUBSCRIBE_LOOP:
 	for {
 		select {
 		case <-recoveryTimeout.C:
+			if !subscribed {
+				return fmt.Errorf("failed to subscribe to agent within %v, aborting", e.config.RegistrationTimeout)
+			}
 			return fmt.Errorf("failed to re-establish subscription with agent within %v, aborting", e.config.MesosConfig.RecoveryTimeout)
 		case <-e.context.Done():
 			log.Info("Executor context cancelled, breaking subscribe loop")
@@ -229,6 +453,7 @@ SUBSCRIBE_LOOP:
 			log.WithField("SubscribeCall", subscribe).Debug("Subscribing to Mesos agent")
 			resp, err := httpClient.Do(subscribe, httpcli.Close(true))
 			if err == nil {
+				subscribed = true
 				err = e.eventLoop(resp.Decoder())
 				e.handleConnError(err)
 				if !recoveryTimeout.Stop() {
@@ -279,14 +504,23 @@ func (e *Executor) handleMesosEvent(event executor.Event) error {
 		e.events <- Event{Type: Subscribed, subscribed: *event.GetSubscribed()}
 	case executor.Event_LAUNCH:
 		e.events <- Event{Type: Launch, launch: *event.GetLaunch()}
+	case executor.Event_LAUNCH_GROUP:
+		for _, task := range event.GetLaunchGroup().TaskGroup.Tasks {
+			e.events <- Event{Type: Launch, launch: executor.Event_Launch{Task: task}}
+		}
 	case executor.Event_KILL:
+		// hooks may block task event loop, so interrupt them before queueing the kill
+		e.hookManager.Stop()
 		e.events <- Event{Type: Kill, kill: *event.GetKill()}
 	case executor.Event_SHUTDOWN:
+		e.hookManager.Stop()
 		e.events <- Event{Type: Shutdown}
 	case executor.Event_ERROR:
 		return errMustAbort
 	case executor.Event_ACKNOWLEDGED:
 		e.stateUpdater.Acknowledge(event.GetAcknowledged().GetUUID())
+	case executor.Event_MESSAGE:
+		e.events <- Event{Type: Message, message: *event.GetMessage()}
 	default:
 		log.WithField("Type", event.Type).Warnf("Unknown event type. Event: %s", event.GoString())
 	}
@@ -294,6 +528,19 @@ func (e *Executor) handleMesosEvent(event executor.Event) error {
 	return nil
 }
 
+// handleSignals translates the first termination signal received by the
+// executor into a Shutdown event, so the task is stopped gracefully. The next
+// signal forces immediate exit.
+func (e *Executor) handleSignals(signals <-chan os.Signal) {
+	sig := <-signals
+	log.Infof("Received %s, shutting down the task", sig)
+	e.events <- Event{Type: Shutdown}
+
+	sig = <-signals
+	log.Warnf("Received %s again, exiting immediately", sig)
+	exit(1)
+}
+
 func (e *Executor) handleConnError(err error) {
 	if err == io.EOF {
 		log.Info("Disconnected from Mesos agent")
@@ -302,76 +549,80 @@ func (e *Executor) handleConnError(err error) {
 	}
 }
 
-// taskEventLoop is responsible for receiving updates about task/command/health and handle them.
+// task is a state of a single task launched by the executor.
+type task struct {
+	info            mesos.TaskInfo
+	cmd             Command
+	fireHealthyHook bool
+	// stop is closed when the command is stopped to stop its health checks,
+	// certificate watch and events
+	stop chan struct{}
+	// certKill kills the task when its certificate expires
+	certKill    *time.Timer
+	restarts    int
+	maxRestarts int
+}
+
+// stopCommandEvents cancels the certificate kill and closes the stop channel of
+// the task command. Channel is already closed when the task waits for a restart.
+func (t *task) stopCommandEvents() {
+	if t.certKill != nil {
+		t.certKill.Stop()
+	}
+	if !stopped(t.stop) {
+		close(t.stop)
+	}
+}
+
+// removeTask stops events of the task command and stops tracking the task.
+func removeTask(tasks map[string]*task, id string) {
+	tasks[id].stopCommandEvents()
+	delete(tasks, id)
+}
+
+// taskEventLoop is responsible for receiving updates about tasks/commands/health
+// and handle them. Events are routed to the task they concern, events without
+// task ID concern all tasks. Loop ends when all launched tasks are terminated.
 func (e *Executor) taskEventLoop() {
 	defer e.contextCancel()
 
-	var taskInfo *mesos.TaskInfo
-	var cmd Command
-
-	fireHealthyHook := true
+	tasks := make(map[string]*task)
 
 	for event := range e.events {
 		switch event.Type {
 		case Subscribed:
 			e.framework = event.subscribed.GetFrameworkInfo()
+			continue
 		case Launch:
-			t := event.launch.GetTask()
-			taskInfo = &t
-			var err error
-			cmd, err = e.launchTask(t)
-			if err != nil {
-				msg := fmt.Sprintf("Cannot launch task: %s", err)
-				e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_FAILED, state.OptionalInfo{Message: &msg})
-				return
+			t := &task{info: event.launch.GetTask(), maxRestarts: e.maxRestarts(event.launch.GetTask())}
+			tasks[t.info.TaskID.GetValue()] = t
+			if launched := e.startTask(t); !launched {
+				removeTask(tasks, t.info.TaskID.GetValue())
 			}
-		case Healthy:
-			if fireHealthyHook {
-				fireHealthyHook = false
-				event := hook.Event{
-					Type:     hook.AfterTaskHealthyEvent,
-					TaskInfo: mesosutils.TaskInfo{TaskInfo: *taskInfo},
+		case Restart:
+			if t, ok := tasks[event.taskID]; ok {
+				if launched := e.startTask(t); !launched {
+					removeTask(tasks, event.taskID)
 				}
-				if _, err := e.hookManager.HandleEvent(event, false); err != nil { // do not ignore errors here, so we will not have an incorrectly configured service
-					log.WithError(err).Errorf("Error calling after task healthy hooks. Stopping the command.")
-					msg := fmt.Sprintf("Error calling after task healthy hooks: %s", err)
-					e.shutDown(taskInfo, cmd)
-					e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_FAILED, state.OptionalInfo{Message: &msg})
-					return
+			}
+		case Healthy, Unhealthy, FailedDueToUnhealthy, FailedDueToExpiredCertificate, CommandExited:
+			for id, t := range tasks {
+				if event.taskID != "" && event.taskID != id {
+					continue
+				}
+				if terminated := e.handleTaskEvent(t, event); terminated {
+					removeTask(tasks, id)
 				}
 			}
-
-			healthy := true
-			e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_RUNNING, state.OptionalInfo{Healthy: &healthy})
-		case Unhealthy:
-			unhealthy := false
-			e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_RUNNING, state.OptionalInfo{Healthy: &unhealthy, Message: &event.Message})
-		case FailedDueToUnhealthy:
-			unhealthy := false
-			info := state.OptionalInfo{Healthy: &unhealthy, Message: &event.Message}
-			e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_RUNNING, info)
-			log.WithFields(log.Fields{"TaskID": taskInfo.GetTaskID(), "Reason": event.Message}).Info("Killing task")
-			e.shutDown(taskInfo, cmd)
-			e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_FAILED, info)
-			return
-		case FailedDueToExpiredCertificate:
-			unhealthy := false
-			info := state.OptionalInfo{Healthy: &unhealthy, Message: &event.Message}
-			e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_RUNNING, info)
-			log.WithFields(log.Fields{"TaskID": taskInfo.GetTaskID(), "Reason": event.Message}).Info("Killing task")
-			e.shutDown(taskInfo, cmd)
-			e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_KILLED, info)
-			return
-		case CommandExited:
-			e.shutDown(taskInfo, cmd)
-			e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_FAILED, state.OptionalInfo{Message: &event.Message})
-			return
 		case Kill:
-			e.shutDown(taskInfo, cmd)
 			// relaying on TaskInfo can be tricky here, as the launch event may
 			// be lost, so we will not have it, and agent still waits for some
 			// TaskStatus with valid ID
 			taskID := event.kill.GetTaskID()
+			if t, ok := tasks[taskID.GetValue()]; ok {
+				e.shutDown(&t.info, t.cmd)
+				removeTask(tasks, taskID.GetValue())
+			}
 			message := "Task killed due to receiving a kill event from Mesos agent"
 			e.stateUpdater.UpdateWithOptions(
 				taskID,
@@ -380,51 +631,322 @@ func (e *Executor) taskEventLoop() {
 					Message: &message,
 				},
 			)
-			return
 		case Shutdown:
-			e.shutDown(taskInfo, cmd)
 			// it is possible to receive a shutdown without launch
-			if taskInfo != nil {
+			for _, t := range tasks {
+				e.shutDown(&t.info, t.cmd)
+				t.stopCommandEvents()
 				message := "Task killed due to receiving a shutdown event from Mesos agent"
 				e.stateUpdater.UpdateWithOptions(
-					event.kill.GetTaskID(),
+					t.info.GetTaskID(),
 					mesos.TASK_KILLED,
 					state.OptionalInfo{
 						Message: &message,
 					},
 				)
 			}
+			// tasks are already stopped, so next events (e.g. shutdown requested
+			// by Mesos after the executor received a signal) must be ignored
+			return
+		case Message:
+			e.handleFrameworkMessage(tasks, event.message.GetData())
+			continue
+		default:
+			continue
+		}
+
+		if len(tasks) == 0 {
+			return
 		}
 	}
 }
 
-func (e *Executor) launchTask(taskInfo mesos.TaskInfo) (Command, error) {
-	commandInfo := taskInfo.GetExecutor().GetCommand()
+// startTask launches the task command. It returns false when the command could
+// not be launched and the task was failed.
+func (e *Executor) startTask(t *task) bool {
+	t.fireHealthyHook = true
+	t.stop = make(chan struct{})
+	var err error
+	t.cmd, t.certKill, err = e.launchTask(t.info, t.stop)
+	if err != nil {
+		msg := fmt.Sprintf("Cannot launch task: %s", err)
+		e.shutDown(&t.info, t.cmd) // command may be already started
+		e.stateUpdater.UpdateWithOptions(t.info.GetTaskID(), mesos.TASK_FAILED, state.OptionalInfo{Message: &msg})
+		return false
+	}
+	return true
+}
+
+// restartTask stops the rest of the failed task command and schedules its
+// launch after the backoff, which is doubled with every restart.
+func (e *Executor) restartTask(t *task) {
+	t.stopCommandEvents()
+	e.stopCommand(&t.info, t.cmd)
+
+	backoff := e.restartBackoff(t.restarts)
+	t.restarts++
+	log.WithField("TaskID", t.info.GetTaskID()).Infof(
+		"Restarting task in %s (restart %d of %d)", backoff, t.restarts, t.maxRestarts)
+	taskID := t.info.TaskID.GetValue()
+	time.AfterFunc(backoff, func() {
+		e.events <- Event{Type: Restart, taskID: taskID}
+	})
+}
+
+// restartBackoff returns delay before the next restart of a command that was
+// already restarted given number of times, limited to maxTaskRestartBackoff.
+func (e *Executor) restartBackoff(restarts int) time.Duration {
+	backoff := e.config.TaskRestartBackoff
+	for i := 0; i < restarts && backoff < maxTaskRestartBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxTaskRestartBackoff {
+		return maxTaskRestartBackoff
+	}
+	return backoff
+}
+
+// maxRestarts returns how many times failed command of the task could be
+// restarted. Value of the max-restarts label takes precedence over the config.
+func (e *Executor) maxRestarts(taskInfo mesos.TaskInfo) int {
+	label := mesosutils.TaskInfo{TaskInfo: taskInfo}.GetLabelValue("max-restarts")
+	if label == "" {
+		return e.config.TaskMaxRestarts
+	}
+	maxRestarts, err := strconv.Atoi(label)
+	if err != nil {
+		log.WithError(err).Warnf("Invalid max-restarts label, using %d", e.config.TaskMaxRestarts)
+		return e.config.TaskMaxRestarts
+	}
+	return maxRestarts
+}
+
+// handleTaskEvent handles health, certificate and exit events of the task. It
+// returns true when the task was terminated.
+func (e *Executor) handleTaskEvent(t *task, event Event) bool {
+	taskInfo := &t.info
+	switch event.Type {
+	case Healthy:
+		if t.fireHealthyHook {
+			t.fireHealthyHook = false
+			event := hook.Event{
+				Type:     hook.AfterTaskHealthyEvent,
+				TaskInfo: mesosutils.TaskInfo{TaskInfo: *taskInfo},
+			}
+			if _, err := e.hookManager.HandleEvent(event, false); err != nil { // do not ignore errors here, so we will not have an incorrectly configured service
+				log.WithError(err).Errorf("Error calling after task healthy hooks. Stopping the command.")
+				msg := fmt.Sprintf("Error calling after task healthy hooks: %s", err)
+				e.shutDown(taskInfo, t.cmd)
+				e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_FAILED, state.OptionalInfo{Message: &msg})
+				return true
+			}
+		}
+
+		e.handleHealthChangeHooks(taskInfo, hook.TaskHealthyEvent)
+
+		healthy := true
+		e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_RUNNING, state.OptionalInfo{Healthy: &healthy})
+	case Unhealthy:
+		e.handleHealthChangeHooks(taskInfo, hook.TaskUnhealthyEvent)
+		unhealthy := false
+		e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_RUNNING, state.OptionalInfo{Healthy: &unhealthy, Message: &event.Message})
+	case FailedDueToUnhealthy:
+		e.handleHealthChangeHooks(taskInfo, hook.TaskUnhealthyEvent)
+		unhealthy := false
+		info := state.OptionalInfo{Healthy: &unhealthy, Message: &event.Message}
+		e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_RUNNING, info)
+		log.WithFields(log.Fields{"TaskID": taskInfo.GetTaskID(), "Reason": event.Message}).Info("Killing task")
+		e.shutDown(taskInfo, t.cmd)
+		e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_FAILED, info)
+		return true
+	case FailedDueToExpiredCertificate:
+		unhealthy := false
+		info := state.OptionalInfo{Healthy: &unhealthy, Message: &event.Message}
+		e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_RUNNING, info)
+		log.WithFields(log.Fields{"TaskID": taskInfo.GetTaskID(), "Reason": event.Message}).Info("Killing task")
+		e.shutDown(taskInfo, t.cmd)
+		e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), mesos.TASK_KILLED, info)
+		return true
+	case CommandExited:
+		log.WithFields(log.Fields{
+			"TaskID":   taskInfo.GetTaskID(),
+			"ExitCode": event.exitState.ExitCode,
+			"Signal":   int(event.exitState.Signal),
+		}).Info(event.Message)
+		if event.exitState.Code == FailedCode && t.restarts < t.maxRestarts {
+			e.restartTask(t)
+			return false
+		}
+		e.shutDown(taskInfo, t.cmd)
+		taskState := mesos.TASK_FAILED
+		if event.exitState.Code == SuccessCode {
+			taskState = mesos.TASK_FINISHED
+		}
+		e.stateUpdater.UpdateWithOptions(taskInfo.GetTaskID(), taskState, state.OptionalInfo{Message: &event.Message})
+		return true
+	}
+	return false
+}
+
+// taskEvents returns a channel for health events of the task command with
+// given ID. Health events and the command exit are marked with the task ID and
+// forwarded to the executor events until the command exits. Events of the
+// stopped command are dropped.
+func (e *Executor) taskEvents(taskID mesos.TaskID, exit <-chan TaskExitState, stop <-chan struct{}) chan<- Event {
+	forward := func(event Event) {
+		if stopped(stop) {
+			return // events of the stopped command are outdated
+		}
+		event.taskID = taskID.GetValue()
+		select {
+		case e.events <- event:
+		case <-stop:
+		}
+	}
+	events := make(chan Event)
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				forward(event)
+			case exitState := <-exit:
+				forward(taskExitEvent(exitState))
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// handleHealthChangeHooks notifies hooks about task health change. Errors are
+// only logged, as health changes should not affect the task lifecycle.
+func (e *Executor) handleHealthChangeHooks(taskInfo *mesos.TaskInfo, eventType hook.EventType) {
+	event := hook.Event{
+		Type:     eventType,
+		TaskInfo: mesosutils.TaskInfo{TaskInfo: *taskInfo},
+	}
+	_, _ = e.hookManager.HandleEvent(event, true)
+}
+
+// handleFrameworkMessage passes message data sent by the framework to hooks,
+// once for every running task. Hook errors are logged and ignored.
+func (e *Executor) handleFrameworkMessage(tasks map[string]*task, data []byte) {
+	log.Infof("Received %d bytes message from framework", len(data))
+	if len(tasks) == 0 {
+		log.Warn("No task is running, framework message is dropped")
+		return
+	}
+	for _, t := range tasks {
+		event := hook.Event{
+			Type:     hook.FrameworkMessageEvent,
+			TaskInfo: mesosutils.TaskInfo{TaskInfo: t.info},
+			Message:  data,
+		}
+		_, _ = e.hookManager.HandleEvent(event, true)
+	}
+}
+
+// launchTask starts the task command. Health checks of the command are stopped
+// when the stop channel is closed. Scheduled certificate kill is returned also
+// with an error, so it could be cancelled.
+func (e *Executor) launchTask(taskInfo mesos.TaskInfo, stop <-chan struct{}) (Command, *time.Timer, error) {
 	e.stateUpdater.Update(taskInfo.GetTaskID(), mesos.TASK_STARTING)
+
+	cmd, certKill, err := e.prepareCommand(taskInfo, stop, false)
+	if err != nil {
+		return nil, certKill, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, certKill, fmt.Errorf("cannot start command: %s", err)
+	}
+
+	taskEvents := e.taskEvents(taskInfo.GetTaskID(), cmd.Wait(), stop)
+	go metrics.CaptureTaskUsage(taskInfo.TaskID.GetValue(), int32(cmd.Pid()), time.Minute, stop)
+
+	afterStartEvent := hook.Event{
+		Type:     hook.AfterTaskStartEvent,
+		TaskInfo: mesosutils.TaskInfo{TaskInfo: taskInfo},
+	}
+	if _, err := e.hookManager.HandleEvent(afterStartEvent, false); err != nil {
+		return cmd, certKill, fmt.Errorf("error running hooks after task start: %s", err)
+	}
+
+	e.stateUpdater.Update(taskInfo.GetTaskID(), mesos.TASK_RUNNING)
+
+	if taskInfo.GetHealthCheck() != nil {
+		options := []HealthCheckOption{StopHealthChecks(stop)}
+		utilTaskInfo := mesosutils.TaskInfo{TaskInfo: taskInfo}
+		if socket := utilTaskInfo.GetLabelValue("health-check-unix-socket"); socket != "" {
+			options = append(options, UnixSocketHealthCheck(socket))
+		}
+		if host := utilTaskInfo.GetLabelValue("health-check-host"); host != "" {
+			options = append(options, HealthCheckHost(host))
+		} else if e.config.HealthCheckHost != "" {
+			options = append(options, HealthCheckHost(e.config.HealthCheckHost))
+		}
+		if utilTaskInfo.GetLabelValue("health-check-follow-redirects") == "true" {
+			options = append(options, FollowHTTPRedirects())
+		}
+		if name := utilTaskInfo.GetLabelValue("health-check-port-name"); name != "" {
+			if port := utilTaskInfo.GetPortByName(name); port != nil {
+				options = append(options, HealthCheckPort(port.GetNumber()))
+			} else {
+				log.Warnf("Health check port %q not found in task ports", name)
+			}
+		}
+		DoHealthChecks(*taskInfo.GetHealthCheck(), taskEvents, options...)
+	}
+
+	return cmd, certKill, nil
+}
+
+// prepareCommand validates the task certificate, runs hooks before task start
+// and creates the task command without starting it. It returns the timer that
+// kills the task when its certificate expires, also with an error. Certificate
+// is watched until the stop channel is closed, it is not watched when the
+// channel is nil. In dry run service logs are scraped, but never sent.
+func (e *Executor) prepareCommand(taskInfo mesos.TaskInfo, stop <-chan struct{}, dryRun bool) (Command, *time.Timer, error) {
+	commandInfo := taskInfo.GetExecutor().GetCommand()
 	prepareCommandInfo(&commandInfo)
 
 	env := os.Environ()
 
+	var certKill *time.Timer
 	utilTaskInfo := mesosutils.TaskInfo{TaskInfo: taskInfo}
 	validateCertificate := utilTaskInfo.GetLabelValue("validate-certificate")
 	if validateCertificate == "true" {
-		if certificate, err := GetCertFromEnvVariables(env); err != nil {
-			return nil, fmt.Errorf("problem with certificate: %s", err)
-		} else if err := e.checkCert(certificate); err != nil {
-			return nil, fmt.Errorf("problem with certificate: %s", err)
+		chain, err := taskCertChain(utilTaskInfo, env)
+		if err == nil {
+			err = verifyTaskCertChain(utilTaskInfo, chain)
+		}
+		if err == nil {
+			certKill, err = e.checkCert(taskInfo.GetTaskID(), chain[0])
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("problem with certificate: %s", err)
+		}
+		if e.config.CertificateCheckInterval > 0 && stop != nil {
+			go e.watchCert(utilTaskInfo, env, chain[0], certKill, stop)
 		}
 	}
 
 	var cmdOption func(*exec.Cmd) error
-	switch utilTaskInfo.GetLabelValue("log-scraping") {
-	case "logstash":
-		log.Info("Service logs will be forwarded to Logstash")
-		options, err := e.createOptionsForLogstashServiceLogScrapping(taskInfo)
+	logScraping := utilTaskInfo.GetLabelValue("log-scraping")
+	if appenderFromEnv, ok := appender.Lookup(logScraping); ok {
+		log.Infof("Service logs will be forwarded to %s", logScraping)
+		if dryRun {
+			appenderFromEnv = discardAppenderFromEnv
+		}
+		options, err := e.createOptionsForServiceLogScrapping(taskInfo, appenderFromEnv)
 		if err != nil {
-			return nil, err
+			return nil, certKill, err
 		}
 		cmdOption = options
-	default:
+	} else {
+		if logScraping != "" {
+			log.Warnf("Unknown log scraping type %q", logScraping)
+		}
 		log.Info("Service logs will be forwarded to stdout/stderr")
 		cmdOption = ForwardCmdOutput()
 	}
@@ -435,42 +957,83 @@ func (e *Executor) launchTask(taskInfo mesos.TaskInfo) (Command, error) {
 	}
 	hookEnv, err := e.hookManager.HandleEvent(beforeStartEvent, false)
 	if err != nil {
-		return nil, fmt.Errorf("error running hooks before task start: %s", err)
+		return nil, certKill, fmt.Errorf("error running hooks before task start: %s", err)
 	}
 
 	cmd, err := NewCommand(commandInfo, append(env, hookEnv...), cmdOption)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create command: %s", err)
+		return nil, certKill, fmt.Errorf("cannot create command: %s", err)
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("cannot start command: %s", err)
+	return cmd, certKill, nil
+}
+
+// DryRun validates that the task could be launched: its certificate is
+// checked, hooks handle the event before task start and the command is
+// created, but it is never started. Mesos agent is not contacted and state
+// updates are not sent.
+func (e *Executor) DryRun(taskInfo mesos.TaskInfo) error {
+	log.WithField("TaskID", taskInfo.TaskID.GetValue()).Info("Dry run - task command will not be started")
+	cmd, certKill, err := e.prepareCommand(taskInfo, nil, true)
+	if certKill != nil {
+		certKill.Stop()
+	}
+	if err != nil {
+		return fmt.Errorf("task could not be launched: %s", err)
+	}
+	if c, ok := cmd.(*cancellableCommand); ok {
+		log.WithField("TaskID", taskInfo.TaskID.GetValue()).Infof("Dry run - would start command: %s", strings.Join(c.cmd.Args, " "))
 	}
+	return nil
+}
 
-	go taskExitToEvent(cmd.Wait(), e.events)
+// discardAppender drops service log entries. It is used in dry run, so the
+// log destination is never contacted.
+type discardAppender struct{}
 
-	e.stateUpdater.Update(taskInfo.GetTaskID(), mesos.TASK_RUNNING)
+func discardAppenderFromEnv() (appender.Appender, error) {
+	return discardAppender{}, nil
+}
 
-	if taskInfo.GetHealthCheck() != nil {
-		DoHealthChecks(*taskInfo.GetHealthCheck(), e.events)
+func (discardAppender) Append(entries <-chan servicelog.Entry) {
+	for range entries {
 	}
+}
 
-	return cmd, nil
+func (discardAppender) Close() error {
+	return nil
 }
 
-func (e *Executor) createOptionsForLogstashServiceLogScrapping(taskInfo mesos.TaskInfo) (func(*exec.Cmd) error, error) {
+func (e *Executor) createOptionsForServiceLogScrapping(taskInfo mesos.TaskInfo,
+	appenderFromEnv appender.FromEnv) (func(*exec.Cmd) error, error) {
 	utilTaskInfo := mesosutils.TaskInfo{TaskInfo: taskInfo}
-	var values [][]byte
-	for _, ignoredKey := range e.config.ServicelogIgnoreKeys {
-		values = append(values, []byte(ignoredKey))
+	filter, err := ignoredKeysFilter(e.config)
+	if err != nil {
+		return nil, err
 	}
-	filter := scraper.ValueFilter{Values: values}
-	scr := &scraper.JSON{
+	jsonScraper := &scraper.JSON{
 		KeyFilter:               filter,
+		KeyRenames:              e.config.ServicelogRenameKeys,
 		BufferSize:              e.config.ServicelogBufferSize,
+		MaxLineSize:             e.config.ServicelogMaxLineBytes,
 		ScrapUnmarshallableLogs: utilTaskInfo.GetLabelValue("log-scraping-all") != "",
 	}
-	apr, err := appender.LogstashAppenderFromEnv()
+	if e.config.ServicelogTeeOutput {
+		// invalid logs are already copied to the sandbox
+		jsonScraper.InvalidLogsWriter = ioutil.Discard
+	}
+	if e.config.ServicelogMultilinePattern != "" {
+		pattern, err := regexp.Compile(e.config.ServicelogMultilinePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service log multiline pattern: %s", err)
+		}
+		jsonScraper.MultilinePattern = pattern
+	}
+	var scr scraper.Scraper = jsonScraper
+	if utilTaskInfo.GetLabelValue("log-format") == "logfmt" {
+		scr = &scraper.LogFmt{KeyFilter: filter}
+	}
+	apr, err := appenderFromEnv()
 	if err != nil {
 		return nil, fmt.Errorf("cannot configure service log scraping: %s", err)
 	}
@@ -479,30 +1042,164 @@ func (e *Executor) createOptionsForLogstashServiceLogScrapping(taskInfo mesos.Ta
 		return nil, fmt.Errorf("cannot parse scid: %s", err)
 	}
 	extenders := []servicelog.Extender{
-		servicelog.StaticDataExtender{
-			Data: map[string]interface{}{
-				"instance-id": taskInfo.Executor.ExecutorID.GetValue(),
-				"scid":        scid,
-			},
-		},
+		servicelog.TimestampNormalizer{Layouts: e.config.ServicelogTimestampLayouts},
+		servicelog.StaticDataExtender{Data: e.serviceLogStaticData(taskInfo, scid)},
 		servicelog.SystemDataExtender{},
 	}
-	return ScrapCmdOutput(scr, apr, extenders...), nil
+	var filters []servicelog.EntryFilter
+	if e.config.ServicelogMinLevel != "" {
+		levelFilter, err := servicelog.NewLevelFilter(e.config.ServicelogMinLevel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service log minimal level: %s", err)
+		}
+		filters = append(filters, levelFilter)
+	}
+	if e.config.ServicelogSamplingRate > 1 {
+		samplingFilter, err := servicelog.NewSamplingFilter(e.config.ServicelogSamplingRate,
+			e.config.ServicelogSamplingLevels, e.config.ServicelogSamplingLoggers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service log sampling: %s", err)
+		}
+		filters = append(filters, samplingFilter)
+	}
+	scrapOption := ScrapCmdOutputAndFile(utilTaskInfo.GetLabelValue("log-scraping-file"), scr, apr, filters, extenders...)
+	if !e.config.ServicelogTeeOutput {
+		return scrapOption, nil
+	}
+	teeOption := TeeCmdOutput(os.Stdout, os.Stderr)
+	return func(cmd *exec.Cmd) error {
+		if err := scrapOption(cmd); err != nil {
+			return err
+		}
+		return teeOption(cmd)
+	}, nil
+}
+
+// serviceLogStaticData returns data added to every service log entry of the
+// task.
+func (e *Executor) serviceLogStaticData(taskInfo mesos.TaskInfo, scid int) map[string]interface{} {
+	data := map[string]interface{}{
+		"instance-id": taskInfo.Executor.ExecutorID.GetValue(),
+		"scid":        scid,
+	}
+	if e.config.ServicelogMesosFields {
+		data["framework-id"] = e.config.MesosConfig.FrameworkID
+		data["agent-endpoint"] = e.config.MesosConfig.AgentEndpoint
+		data["task-id"] = taskInfo.TaskID.GetValue()
+	}
+	return data
+}
+
+// ignoredKeysFilter returns filter of log keys ignored by scrapers, matched
+// exactly or with glob patterns depending on the configuration.
+func ignoredKeysFilter(conf Config) (scraper.Filter, error) {
+	switch conf.ServicelogIgnoreKeysMatching {
+	case "", "exact":
+		var values [][]byte
+		for _, ignoredKey := range conf.ServicelogIgnoreKeys {
+			values = append(values, []byte(ignoredKey))
+		}
+		return scraper.ValueFilter{Values: values}, nil
+	case "glob":
+		filter, err := scraper.NewGlobFilter(conf.ServicelogIgnoreKeys)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service log ignored keys: %s", err)
+		}
+		return filter, nil
+	default:
+		return nil, fmt.Errorf("unknown service log ignored keys matching: %s", conf.ServicelogIgnoreKeysMatching)
+	}
+}
+
+// taskCertChain returns certificate chain from the file set in certificate-file
+// label or, when the label is not set, from the environment.
+func taskCertChain(taskInfo mesosutils.TaskInfo, env []string) ([]*x509.Certificate, error) {
+	if path := taskInfo.GetLabelValue("certificate-file"); path != "" {
+		return certChainFromFile(path)
+	}
+	return certChainFromEnvVariables(env)
+}
+
+// verifyTaskCertChain verifies certificate chain and hostname when it is
+// enabled with validate-certificate-chain label. Trusted CA and hostname are
+// set in certificate-ca-file and certificate-hostname labels.
+func verifyTaskCertChain(taskInfo mesosutils.TaskInfo, chain []*x509.Certificate) error {
+	if taskInfo.GetLabelValue("validate-certificate-chain") != "true" {
+		return nil
+	}
+	return VerifyCertChain(chain,
+		taskInfo.GetLabelValue("certificate-ca-file"),
+		taskInfo.GetLabelValue("certificate-hostname"))
+}
+
+func (e *Executor) checkCert(taskID mesos.TaskID, cert *x509.Certificate) (*time.Timer, error) {
+	certDuration, err := e.certKillDelay(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.AfterFunc(certDuration, func() {
+		e.events <- Event{Type: FailedDueToExpiredCertificate, Message: "Certificate expired", taskID: taskID.GetValue()}
+	})
+
+	return timer, nil
 }
 
-func (e *Executor) checkCert(cert *x509.Certificate) error {
+// certKillDelay returns delay after which the task with given certificate
+// should be killed.
+func (e *Executor) certKillDelay(cert *x509.Certificate) (time.Duration, error) {
 	certDuration := e.clock.Until(cert.NotAfter) - e.random.Duration(e.config.RandomExpirationRange)
 	if certDuration <= 0 {
-		return fmt.Errorf("certificate valid period <= 0 - certificate invalid after %s", cert.NotAfter)
+		return 0, fmt.Errorf("certificate valid period <= 0 - certificate invalid after %s", cert.NotAfter)
 	}
 
 	log.WithField("CertificateExpireDate", cert.NotAfter).Infof(
 		"Schedule task kill in %s", certDuration)
-	time.AfterFunc(certDuration, func() {
-		e.events <- Event{Type: FailedDueToExpiredCertificate, Message: "Certificate expired"}
-	})
+	return certDuration, nil
+}
 
-	return nil
+// watchCert reads the task certificate every CertificateCheckInterval and
+// reschedules the task kill when the certificate was changed (e.g. rotated).
+// Changed certificate chain is verified the same way as on task launch.
+// Kill is rescheduled with the same timer, so it could be cancelled by the task
+// owner. It is also cancelled when the stop channel is closed.
+func (e *Executor) watchCert(taskInfo mesosutils.TaskInfo, env []string, cert *x509.Certificate,
+	kill *time.Timer, stop <-chan struct{}) {
+	ticker := time.NewTicker(e.config.CertificateCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			kill.Stop()
+			return
+		case <-ticker.C:
+			chain, err := taskCertChain(taskInfo, env)
+			if err != nil {
+				log.WithError(err).Warn("Unable to read certificate, keeping previously scheduled task kill")
+				continue
+			}
+			if chain[0].Equal(cert) {
+				continue
+			}
+			log.Info("Certificate changed, rescheduling task kill")
+			kill.Stop()
+			var delay time.Duration
+			err = verifyTaskCertChain(taskInfo, chain)
+			if err == nil {
+				delay, err = e.certKillDelay(chain[0])
+			}
+			if err != nil {
+				e.events <- Event{
+					Type:    FailedDueToExpiredCertificate,
+					Message: fmt.Sprintf("Changed certificate is invalid: %s", err),
+					taskID:  taskInfo.TaskInfo.TaskID.GetValue(),
+				}
+				return
+			}
+			kill.Reset(delay)
+			cert = chain[0]
+		}
+	}
 }
 
 func (e *Executor) shutDown(taskInfo *mesos.TaskInfo, cmd Command) {
@@ -516,26 +1213,58 @@ func (e *Executor) shutDown(taskInfo *mesos.TaskInfo, cmd Command) {
 		}
 	}
 
-	gracePeriod := e.config.KillPolicyGracePeriod
-	if ns := taskInfo.GetKillPolicy().GetGracePeriod().GetNanoseconds(); ns > 0 {
-		gracePeriod = time.Duration(ns)
-	}
+	e.stopCommand(taskInfo, cmd)
+}
+
+// stopCommand calls before terminate hooks and stops the task command.
+func (e *Executor) stopCommand(taskInfo *mesos.TaskInfo, cmd Command) {
+	gracePeriod := e.gracePeriod(taskInfo)
 	beforeTerminateEvent := hook.Event{
 		Type:     hook.BeforeTerminateEvent,
 		TaskInfo: mesosutils.TaskInfo{TaskInfo: *taskInfo},
 	}
+	signalSequence, err := osutil.ParseSignalSequence(e.config.KillSignalSequence, gracePeriod)
+	if err != nil {
+		log.WithError(err).Warn("Invalid kill signal sequence, falling back to default one")
+		signalSequence = osutil.DefaultSignalSequence(gracePeriod)
+	}
+	if max := e.config.MaxKillGracePeriod; max > 0 {
+		signalSequence = osutil.LimitSignalSequence(signalSequence, max)
+	}
 	_, _ = e.hookManager.HandleEvent(beforeTerminateEvent, true) // ignore errors here, so every hook will have a chance to be called
-	cmd.Stop(gracePeriod, e.config.SigtermExcludeProcesses)      // blocking call
+	cmd.Stop(signalSequence, e.config.SigtermExcludeProcesses)   // blocking call
 }
 
-func taskExitToEvent(exitStateChan <-chan TaskExitState, events chan<- Event) {
-	exitState := <-exitStateChan
-	switch exitState.Code {
-	case FailedCode:
-		events <- Event{Type: CommandExited, Message: fmt.Sprintf("Task exited with an error: %s", exitState.Err.Error())}
-	case SuccessCode:
-		events <- Event{Type: CommandExited, Message: "Task exited with success (zero) exit code"}
+// gracePeriod returns grace period from the task kill policy or the configured
+// one, limited to MaxKillGracePeriod.
+func (e *Executor) gracePeriod(taskInfo *mesos.TaskInfo) time.Duration {
+	gracePeriod := e.config.KillPolicyGracePeriod
+	if ns := taskInfo.GetKillPolicy().GetGracePeriod().GetNanoseconds(); ns > 0 {
+		gracePeriod = time.Duration(ns)
+	}
+	if max := e.config.MaxKillGracePeriod; max > 0 && gracePeriod > max {
+		log.WithField("TaskID", taskInfo.GetTaskID()).Warnf(
+			"Grace period %s exceeds the maximal one, using %s", gracePeriod, max)
+		return max
+	}
+	return gracePeriod
+}
+
+func taskExitEvent(exitState TaskExitState) Event {
+	if exitState.Code == SuccessCode {
+		return Event{Type: CommandExited, Message: "Task exited with success (zero) exit code", exitState: exitState}
+	}
+	return Event{Type: CommandExited, Message: exitMessage(exitState), exitState: exitState}
+}
+
+func exitMessage(exitState TaskExitState) string {
+	if exitState.Signal != 0 {
+		return fmt.Sprintf("Task terminated by signal %d (%s)", exitState.Signal, exitState.Signal)
+	}
+	if exitState.ExitCode > 0 {
+		return fmt.Sprintf("Task exited with code %d", exitState.ExitCode)
 	}
+	return fmt.Sprintf("Task exited with an error: %s", exitState.Err)
 }
 
 // Hack: For Marathon #4952
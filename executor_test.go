@@ -3,7 +3,13 @@ package executor
 import (
 	"context"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -14,6 +20,9 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/allegro/mesos-executor/hook"
+	"github.com/allegro/mesos-executor/mesosutils"
+	osutil "github.com/allegro/mesos-executor/os"
+	"github.com/allegro/mesos-executor/servicelog/appender"
 	"github.com/allegro/mesos-executor/state"
 )
 
@@ -21,6 +30,8 @@ const infiniteCommand = "while :; do sleep 1; done"
 
 const shortCommand = "sleep 1"
 
+var registerFailingAppender sync.Once
+
 func TestIfLaunchesCommandAndSendsStateUpdates(t *testing.T) {
 	ctx, ctxCancel := context.WithCancel(context.Background())
 
@@ -195,9 +206,15 @@ func TestIfFiresAfterTaskHealthyOnlyOnFirstHealthyEvent(t *testing.T) {
 	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
 		return event.Type == hook.BeforeTaskStartEvent
 	})).Return(hook.Env{}, nil)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.AfterTaskStartEvent
+	})).Return(hook.Env{}, nil)
 	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
 		return event.Type == hook.BeforeTerminateEvent
 	})).Return(hook.Env{}, nil)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.TaskHealthyEvent
+	})).Return(hook.Env{}, nil)
 
 	exec.hookManager.Hooks = append(exec.hookManager.Hooks, mockedHook)
 
@@ -230,6 +247,9 @@ func TestIfStopsAfterTaskHealthyEventHookFail(t *testing.T) {
 	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
 		return event.Type == hook.BeforeTaskStartEvent
 	})).Return(hook.Env{}, nil).Once()
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.AfterTaskStartEvent
+	})).Return(hook.Env{}, nil).Once()
 	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
 		return event.Type == hook.AfterTaskHealthyEvent
 	})).Return(hook.Env{}, errors.New("error")).Once()
@@ -256,6 +276,43 @@ func TestIfStopsAfterTaskHealthyEventHookFail(t *testing.T) {
 	mockedHook.AssertExpectations(t)
 }
 
+func TestIfStopsAfterTaskStartEventHookFail(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	stateUpdater := new(mockUpdater)
+	stateUpdater.On("Update", mock.AnythingOfType("mesos.TaskID"), mesos.TASK_STARTING).Once()
+	stateUpdater.On("UpdateWithOptions",
+		mock.AnythingOfType("mesos.TaskID"),
+		mesos.TASK_FAILED,
+		mock.AnythingOfType("state.OptionalInfo")).Once()
+
+	mockedHook := new(mockHook)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.BeforeTaskStartEvent
+	})).Return(hook.Env{}, nil).Once()
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.AfterTaskStartEvent
+	})).Return(hook.Env{}, errors.New("error")).Once()
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.BeforeTerminateEvent
+	})).Return(hook.Env{}, nil).Once()
+
+	exec := new(Executor)
+	exec.events = make(chan Event)
+	exec.context = ctx
+	exec.contextCancel = ctxCancel
+	exec.hookManager.Hooks = []hook.Hook{mockedHook}
+	exec.stateUpdater = stateUpdater
+	go exec.taskEventLoop()
+
+	launchErr := exec.handleMesosEvent(launchEventWithCommand(infiniteCommand))
+	require.NoError(t, launchErr)
+
+	<-exec.context.Done()
+	mockedHook.AssertExpectations(t)
+	stateUpdater.AssertExpectations(t)
+}
+
 func TestIfHookCalledAfterTaskExits(t *testing.T) {
 	ctx, ctxCancel := context.WithCancel(context.Background())
 
@@ -268,16 +325,22 @@ func TestIfHookCalledAfterTaskExits(t *testing.T) {
 		mock.AnythingOfType("state.OptionalInfo")).Once()
 	stateUpdater.On("UpdateWithOptions",
 		mock.AnythingOfType("mesos.TaskID"),
-		mesos.TASK_FAILED,
+		mesos.TASK_FINISHED,
 		mock.AnythingOfType("state.OptionalInfo")).Once()
 
 	mockedHook := new(mockHook)
 	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
 		return event.Type == hook.BeforeTaskStartEvent
 	})).Return(hook.Env{}, nil).Once()
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.AfterTaskStartEvent
+	})).Return(hook.Env{}, nil).Once()
 	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
 		return event.Type == hook.AfterTaskHealthyEvent
 	})).Return(hook.Env{}, nil).Once()
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.TaskHealthyEvent
+	})).Return(hook.Env{}, nil).Once()
 	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
 		return event.Type == hook.BeforeTerminateEvent
 	})).Return(hook.Env{}, nil).Once()
@@ -321,7 +384,7 @@ func TestCertificateCheckReturnErrorIfKillWillBeScheduledInThePast(t *testing.T)
 		config: Config{RandomExpirationRange: time.Hour},
 	}
 
-	err := exec.checkCert(fakeCert)
+	_, err := exec.checkCert(mesos.TaskID{Value: "task"}, fakeCert)
 
 	assert.EqualError(t, err, "certificate valid period <= 0 - certificate invalid after 0001-01-01 00:00:00 +0000 UTC")
 	random.AssertExpectations(t)
@@ -348,7 +411,7 @@ func TestCertificateCheckScheduleTaskKillBeforeCertificateExpires(t *testing.T)
 		config: Config{RandomExpirationRange: time.Hour},
 	}
 
-	err := exec.checkCert(fakeCert)
+	_, err := exec.checkCert(mesos.TaskID{Value: "task"}, fakeCert)
 
 	assert.NoError(t, err)
 	random.AssertExpectations(t)
@@ -357,7 +420,256 @@ func TestCertificateCheckScheduleTaskKillBeforeCertificateExpires(t *testing.T)
 	time.Sleep(time.Nanosecond)
 	event := <-exec.events
 
-	assert.Equal(t, Event{Type: FailedDueToExpiredCertificate, Message: "Certificate expired"}, event)
+	assert.Equal(t, Event{Type: FailedDueToExpiredCertificate, Message: "Certificate expired", taskID: "task"}, event)
+}
+
+func TestIfMonitorsTasksOfTaskGroupSeparately(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	stateUpdater := new(mockUpdater)
+	stateUpdater.On("Update", mock.AnythingOfType("mesos.TaskID"), mesos.TASK_STARTING).Twice()
+	stateUpdater.On("Update", mock.AnythingOfType("mesos.TaskID"), mesos.TASK_RUNNING).Twice()
+	stateUpdater.On("UpdateWithOptions",
+		mesos.TaskID{Value: "short"},
+		mesos.TASK_FINISHED,
+		mock.AnythingOfType("state.OptionalInfo")).Once()
+	stateUpdater.On("UpdateWithOptions",
+		mesos.TaskID{Value: "infinite"},
+		mesos.TASK_KILLED,
+		mock.AnythingOfType("state.OptionalInfo")).Once()
+
+	exec := new(Executor)
+	exec.events = make(chan Event)
+	exec.context = ctx
+	exec.contextCancel = ctxCancel
+	exec.stateUpdater = stateUpdater
+	go exec.taskEventLoop()
+
+	short := launchEventWithCommand(shortCommand).Launch.Task
+	short.TaskID = mesos.TaskID{Value: "short"}
+	infinite := launchEventWithCommand(infiniteCommand).Launch.Task
+	infinite.TaskID = mesos.TaskID{Value: "infinite"}
+	launchErr := exec.handleMesosEvent(executor.Event{
+		Type: executor.Event_LAUNCH_GROUP.Enum(),
+		LaunchGroup: &executor.Event_LaunchGroup{
+			TaskGroup: mesos.TaskGroupInfo{Tasks: []mesos.TaskInfo{short, infinite}},
+		},
+	})
+	require.NoError(t, launchErr)
+
+	// short task exits, but the infinite one is still running
+	time.Sleep(2 * time.Second)
+	assert.NoError(t, exec.context.Err())
+
+	killErr := exec.handleMesosEvent(executor.Event{
+		Type: executor.Event_KILL.Enum(),
+		Kill: &executor.Event_Kill{TaskID: infinite.TaskID},
+	})
+	require.NoError(t, killErr)
+
+	<-exec.context.Done()
+	stateUpdater.AssertExpectations(t)
+}
+
+func TestIfFinishesTaskWhenCommandExitsWithSuccess(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	stateUpdater := new(mockUpdater)
+	stateUpdater.On("Update", mock.AnythingOfType("mesos.TaskID"), mesos.TASK_STARTING).Once()
+	stateUpdater.On("Update", mock.AnythingOfType("mesos.TaskID"), mesos.TASK_RUNNING).Once()
+	stateUpdater.On("UpdateWithOptions",
+		mock.AnythingOfType("mesos.TaskID"),
+		mesos.TASK_FINISHED,
+		mock.MatchedBy(func(info state.OptionalInfo) bool {
+			return "Task exited with success (zero) exit code" == *info.Message
+		})).Once()
+
+	exec := new(Executor)
+	exec.events = make(chan Event)
+	exec.context = ctx
+	exec.contextCancel = ctxCancel
+	exec.config = Config{TaskMaxRestarts: 5, TaskRestartBackoff: time.Millisecond}
+	exec.stateUpdater = stateUpdater
+	go exec.taskEventLoop()
+
+	launchErr := exec.handleMesosEvent(launchEventWithCommand("exit 0"))
+	require.NoError(t, launchErr)
+
+	<-exec.context.Done()
+	stateUpdater.AssertExpectations(t)
+}
+
+func TestIfRestartsFailedCommandBeforeFailingTask(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	stateUpdater := new(mockUpdater)
+	stateUpdater.On("Update", mock.AnythingOfType("mesos.TaskID"), mesos.TASK_STARTING).Times(3)
+	stateUpdater.On("Update", mock.AnythingOfType("mesos.TaskID"), mesos.TASK_RUNNING).Times(3)
+	stateUpdater.On("UpdateWithOptions",
+		mock.AnythingOfType("mesos.TaskID"),
+		mesos.TASK_FAILED,
+		mock.AnythingOfType("state.OptionalInfo")).Once()
+
+	mockedHook := new(mockHook)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.BeforeTaskStartEvent
+	})).Return(hook.Env{}, nil).Times(3)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.AfterTaskStartEvent
+	})).Return(hook.Env{}, nil).Times(3)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.BeforeTerminateEvent
+	})).Return(hook.Env{}, nil).Times(3)
+
+	exec := new(Executor)
+	exec.events = make(chan Event)
+	exec.context = ctx
+	exec.contextCancel = ctxCancel
+	exec.config = Config{TaskMaxRestarts: 5, TaskRestartBackoff: time.Millisecond}
+	exec.hookManager.Hooks = []hook.Hook{mockedHook}
+	exec.stateUpdater = stateUpdater
+	go exec.taskEventLoop()
+
+	launchEvent := launchEventWithCommand("exit 1")
+	maxRestarts := "2"
+	launchEvent.Launch.Task.Labels = &mesos.Labels{
+		Labels: []mesos.Label{{Key: "max-restarts", Value: &maxRestarts}}}
+	launchErr := exec.handleMesosEvent(launchEvent)
+	require.NoError(t, launchErr)
+
+	<-exec.context.Done()
+	mockedHook.AssertExpectations(t)
+	stateUpdater.AssertExpectations(t)
+}
+
+func TestIfCertificateWatchReschedulesKillWhenCertificateChanges(t *testing.T) {
+	oldCert, _ := newTestCert(t, "old", nil, nil)
+	newCert, _ := newTestCert(t, "new", nil, nil)
+	dir, err := ioutil.TempDir("", "cert")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: oldCert.Raw}), 0600))
+
+	clock := new(mockClock)
+	clock.On("Until", newCert.NotAfter).Return(time.Duration(0)).Once()
+	random := new(mockRandom)
+	random.On("Duration", time.Hour).Return(time.Second).Once()
+
+	exec := &Executor{
+		events: make(chan Event, 1),
+		clock:  clock,
+		random: random,
+		config: Config{RandomExpirationRange: time.Hour, CertificateCheckInterval: time.Millisecond},
+	}
+	certFileLabel := certFile
+	taskInfo := mesosutils.TaskInfo{TaskInfo: mesos.TaskInfo{Labels: &mesos.Labels{
+		Labels: []mesos.Label{{Key: "certificate-file", Value: &certFileLabel}}}}}
+	kill := time.AfterFunc(time.Hour, func() { t.Error("old kill should be cancelled") })
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go exec.watchCert(taskInfo, nil, oldCert, kill, stop)
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: newCert.Raw}), 0600))
+
+	select {
+	case event := <-exec.events:
+		assert.Equal(t, FailedDueToExpiredCertificate, event.Type)
+		assert.Contains(t, event.Message, "Changed certificate is invalid")
+	case <-time.After(time.Second):
+		t.Fatal("task kill was not rescheduled")
+	}
+	assert.False(t, kill.Stop(), "old kill should be already stopped")
+	clock.AssertExpectations(t)
+}
+
+func TestIfCertificateWatchReschedulesKillWithTheSameTimer(t *testing.T) {
+	oldCert, _ := newTestCert(t, "old", nil, nil)
+	newCert, _ := newTestCert(t, "new", nil, nil)
+	dir, err := ioutil.TempDir("", "cert")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: newCert.Raw}), 0600))
+
+	clock := new(mockClock)
+	clock.On("Until", newCert.NotAfter).Return(time.Millisecond).Once()
+	random := new(mockRandom)
+	random.On("Duration", time.Hour).Return(time.Duration(0)).Once()
+
+	exec := &Executor{
+		events: make(chan Event, 1),
+		clock:  clock,
+		random: random,
+		config: Config{RandomExpirationRange: time.Hour, CertificateCheckInterval: time.Millisecond},
+	}
+	certFileLabel := certFile
+	taskInfo := mesosutils.TaskInfo{TaskInfo: mesos.TaskInfo{Labels: &mesos.Labels{
+		Labels: []mesos.Label{{Key: "certificate-file", Value: &certFileLabel}}}}}
+	killed := make(chan struct{})
+	kill := time.AfterFunc(time.Hour, func() { close(killed) })
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go exec.watchCert(taskInfo, nil, oldCert, kill, stop)
+
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Fatal("task kill was not rescheduled")
+	}
+	clock.AssertExpectations(t)
+}
+
+func TestIfCertificateWatchVerifiesChangedCertificateChain(t *testing.T) {
+	oldCert, _ := newTestCert(t, "old", nil, nil)
+	newCert, _ := newTestCert(t, "new", nil, nil)
+	dir, err := ioutil.TempDir("", "cert")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: newCert.Raw}), 0600))
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: oldCert.Raw}), 0600))
+
+	events := make(chan Event, 1)
+	exec := &Executor{
+		events: events,
+		config: Config{CertificateCheckInterval: time.Millisecond},
+	}
+	validate := "true"
+	taskInfo := mesosutils.TaskInfo{TaskInfo: mesos.TaskInfo{
+		TaskID: mesos.TaskID{Value: "taskID"},
+		Labels: &mesos.Labels{Labels: []mesos.Label{
+			{Key: "certificate-file", Value: &certFile},
+			{Key: "certificate-ca-file", Value: &caFile},
+			{Key: "validate-certificate-chain", Value: &validate},
+		}},
+	}}
+	kill := time.AfterFunc(time.Hour, func() { t.Error("kill should not be rescheduled") })
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go exec.watchCert(taskInfo, nil, oldCert, kill, stop)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, FailedDueToExpiredCertificate, event.Type)
+		assert.Equal(t, "taskID", event.taskID)
+		assert.Contains(t, event.Message, "Changed certificate is invalid")
+	case <-time.After(time.Second):
+		t.Fatal("invalid certificate chain was not reported")
+	}
+	assert.False(t, kill.Stop(), "kill should be already stopped")
+}
+
+func TestIfStoppingTaskCommandEventsCancelsCertificateKill(t *testing.T) {
+	certKill := time.AfterFunc(time.Hour, func() { t.Error("kill should be cancelled") })
+	launched := &task{stop: make(chan struct{}), certKill: certKill}
+
+	launched.stopCommandEvents()
+
+	assert.False(t, certKill.Stop(), "kill should be already stopped")
 }
 
 func TestIfNotPanicsWhenKillWithoutLaunch(t *testing.T) {
@@ -383,12 +695,213 @@ func TestIfNotPanicsWhenKillWithoutLaunch(t *testing.T) {
 	})
 }
 
+func TestIfShutdownCallsBeforeTerminateHooksWithLaunchedTask(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	stateUpdater := new(mockUpdater)
+	stateUpdater.On("Update", mock.AnythingOfType("mesos.TaskID"), mesos.TASK_STARTING).Once()
+	stateUpdater.On("Update", mock.AnythingOfType("mesos.TaskID"), mesos.TASK_RUNNING).Once()
+	stateUpdater.On("UpdateWithOptions",
+		mock.AnythingOfType("mesos.TaskID"),
+		mesos.TASK_KILLED,
+		mock.AnythingOfType("state.OptionalInfo")).Once()
+
+	mockedHook := new(mockHook)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.BeforeTaskStartEvent || event.Type == hook.AfterTaskStartEvent
+	})).Return(hook.Env{}, nil).Twice()
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.BeforeTerminateEvent && event.TaskInfo.GetTaskID() == "task"
+	})).Return(hook.Env{}, nil).Once()
+
+	exec := new(Executor)
+	exec.events = make(chan Event)
+	exec.context = ctx
+	exec.contextCancel = ctxCancel
+	exec.hookManager.Hooks = []hook.Hook{mockedHook}
+	exec.stateUpdater = stateUpdater
+	go exec.taskEventLoop()
+
+	launch := launchEventWithCommand(infiniteCommand)
+	launch.Launch.Task.TaskID = mesos.TaskID{Value: "task"}
+	require.NoError(t, exec.handleMesosEvent(launch))
+	require.NoError(t, exec.handleMesosEvent(executor.Event{Type: executor.Event_SHUTDOWN.Enum()}))
+
+	<-exec.context.Done()
+	mockedHook.AssertExpectations(t)
+	stateUpdater.AssertExpectations(t)
+}
+
+func TestIfShutdownSendsTerminalStatusOfLaunchedTask(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	taskID := mesos.TaskID{Value: "task"}
+	stateUpdater := new(mockUpdater)
+	stateUpdater.On("Update", taskID, mesos.TASK_STARTING).Once()
+	stateUpdater.On("Update", taskID, mesos.TASK_RUNNING).Once()
+	stateUpdater.On("UpdateWithOptions",
+		taskID,
+		mesos.TASK_KILLED,
+		mock.AnythingOfType("state.OptionalInfo")).Once()
+
+	exec := new(Executor)
+	exec.events = make(chan Event)
+	exec.context = ctx
+	exec.contextCancel = ctxCancel
+	exec.stateUpdater = stateUpdater
+	go exec.taskEventLoop()
+
+	launch := launchEventWithCommand(infiniteCommand)
+	launch.Launch.Task.TaskID = taskID
+	require.NoError(t, exec.handleMesosEvent(launch))
+	require.NoError(t, exec.handleMesosEvent(executor.Event{Type: executor.Event_SHUTDOWN.Enum()}))
+
+	<-exec.context.Done()
+	stateUpdater.AssertExpectations(t)
+}
+
+func TestIfShutsDownLaunchedTaskOnlyOnce(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	taskID := mesos.TaskID{Value: "task"}
+	stateUpdater := new(mockUpdater)
+	stateUpdater.On("Update", taskID, mesos.TASK_STARTING).Once()
+	stateUpdater.On("Update", taskID, mesos.TASK_RUNNING).Once()
+	stateUpdater.On("UpdateWithOptions",
+		taskID,
+		mesos.TASK_KILLED,
+		mock.AnythingOfType("state.OptionalInfo")).Once()
+
+	exec := new(Executor)
+	exec.events = make(chan Event, 3)
+	exec.context = ctx
+	exec.contextCancel = ctxCancel
+	exec.stateUpdater = stateUpdater
+
+	launch := launchEventWithCommand(infiniteCommand)
+	launch.Launch.Task.TaskID = taskID
+	require.NoError(t, exec.handleMesosEvent(launch))
+	require.NoError(t, exec.handleMesosEvent(executor.Event{Type: executor.Event_SHUTDOWN.Enum()}))
+	require.NoError(t, exec.handleMesosEvent(executor.Event{Type: executor.Event_SHUTDOWN.Enum()}))
+	exec.taskEventLoop()
+
+	<-exec.context.Done()
+	stateUpdater.AssertExpectations(t)
+}
+
+func TestIfNotPanicsWhenShutdownWithoutLaunch(t *testing.T) {
+	stateUpdater := new(mockUpdater)
+	events := make(chan Event, 1)
+
+	exec := &Executor{
+		contextCancel: func() {},
+		events:        events,
+		stateUpdater:  stateUpdater,
+	}
+
+	assert.NotPanics(t, func() {
+		events <- Event{Type: Shutdown}
+		exec.taskEventLoop()
+	})
+	stateUpdater.AssertNotCalled(t, "UpdateWithOptions", mock.Anything, mock.Anything, mock.Anything)
+}
+
 func TestIfExecutorStartsWithoutConfig(t *testing.T) {
 	assert.NotPanics(t, func() {
 		StartExecutor(Config{}, []hook.Hook{})
 	})
 }
 
+func TestIfExecutorDoesNotStartWithInvalidKillSignalSequence(t *testing.T) {
+	err := StartExecutor(Config{KillSignalSequence: "SIGTERM:soon"}, []hook.Hook{})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid kill signal sequence")
+}
+
+func TestIfExecutorDoesNotStartWithInvalidIgnoredKeysMatching(t *testing.T) {
+	err := StartExecutor(Config{ServicelogIgnoreKeysMatching: "regexp"}, []hook.Hook{})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown service log ignored keys matching")
+}
+
+func TestIfTaskExitEventContainsExitCodeOrSignal(t *testing.T) {
+	event := taskExitEvent(TaskExitState{Code: FailedCode, Err: errors.New("exit status 137"), ExitCode: 137})
+	assert.Equal(t, "Task exited with code 137", event.Message)
+	assert.Equal(t, 137, event.exitState.ExitCode)
+
+	event = taskExitEvent(TaskExitState{Code: FailedCode, Err: errors.New("signal: killed"), ExitCode: -1, Signal: syscall.SIGKILL})
+	assert.Equal(t, "Task terminated by signal 9 (killed)", event.Message)
+
+	event = taskExitEvent(TaskExitState{Code: FailedCode, Err: errors.New("broken pipe"), ExitCode: -1})
+	assert.Equal(t, "Task exited with an error: broken pipe", event.Message)
+}
+
+func TestIfTaskEventsAreForwardedWithTaskIDUntilCommandExits(t *testing.T) {
+	exec := &Executor{events: make(chan Event)}
+	exit := make(chan TaskExitState, 1)
+	taskEvents := exec.taskEvents(mesos.TaskID{Value: "task"}, exit, make(chan struct{}))
+
+	taskEvents <- Event{Type: Healthy}
+	assert.Equal(t, Event{Type: Healthy, taskID: "task"}, <-exec.events)
+
+	exit <- TaskExitState{Code: SuccessCode}
+	event := <-exec.events
+	assert.Equal(t, CommandExited, event.Type)
+	assert.Equal(t, "task", event.taskID)
+
+	select {
+	case taskEvents <- Event{Type: Healthy}:
+		t.Fatal("events should not be forwarded after the command exited")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestIfTaskCommandEventsCouldBeStoppedMoreThanOnce(t *testing.T) {
+	restarting := &task{stop: make(chan struct{})}
+	restarting.stopCommandEvents()
+
+	assert.NotPanics(t, restarting.stopCommandEvents)
+	assert.True(t, stopped(restarting.stop))
+}
+
+func TestIfTaskEventsOfStoppedCommandAreDropped(t *testing.T) {
+	exec := &Executor{events: make(chan Event)}
+	exit := make(chan TaskExitState, 1)
+	stop := make(chan struct{})
+	taskEvents := exec.taskEvents(mesos.TaskID{Value: "task"}, exit, stop)
+	close(stop)
+
+	taskEvents <- Event{Type: Healthy}
+	exit <- TaskExitState{Code: FailedCode}
+
+	select {
+	case event := <-exec.events:
+		t.Fatalf("event of the stopped command should be dropped: %v", event)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestIfFirstSignalShutsDownTaskAndSecondForcesExit(t *testing.T) {
+	exitCode := make(chan int, 1)
+	defer func(original func(int)) { exit = original }(exit)
+	exit = func(code int) { exitCode <- code }
+
+	stoppableHook := new(mockStoppableHook)
+	exec := NewExecutor(Config{}, stoppableHook)
+	signals := make(chan os.Signal, 2)
+	go exec.handleSignals(signals)
+
+	signals <- syscall.SIGTERM
+	assert.Equal(t, Shutdown, (<-exec.events).Type)
+	assert.Empty(t, exitCode)
+	stoppableHook.AssertNotCalled(t, "Stop")
+
+	signals <- syscall.SIGINT
+	assert.Equal(t, 1, <-exitCode)
+}
+
 type mockClock struct {
 	mock.Mock
 }
@@ -416,6 +929,34 @@ func (m *mockHook) HandleEvent(event hook.Event) (hook.Env, error) {
 	return arg.Get(0).(hook.Env), arg.Error(1)
 }
 
+type mockStoppableHook struct {
+	mockHook
+}
+
+func (m *mockStoppableHook) Stop() {
+	m.Called()
+}
+
+type mockCommand struct {
+	mock.Mock
+}
+
+func (m *mockCommand) Start() error {
+	return m.Called().Error(0)
+}
+
+func (m *mockCommand) Wait() <-chan TaskExitState {
+	return m.Called().Get(0).(<-chan TaskExitState)
+}
+
+func (m *mockCommand) Stop(signalSequence []osutil.SignalStep, sigtermExcludeProcesses []string) {
+	m.Called(signalSequence, sigtermExcludeProcesses)
+}
+
+func (m *mockCommand) Pid() int {
+	return m.Called().Int(0)
+}
+
 type mockUpdater struct {
 	mock.Mock
 }
@@ -455,3 +996,201 @@ func launchEventWithCommand(command string) executor.Event {
 func killEvent() executor.Event {
 	return executor.Event{Type: executor.Event_KILL.Enum(), Kill: &executor.Event_Kill{}}
 }
+
+func TestIfAddsMesosFieldsToServiceLogStaticData(t *testing.T) {
+	taskInfo := mesos.TaskInfo{
+		TaskID:   mesos.TaskID{Value: "task"},
+		Executor: &mesos.ExecutorInfo{ExecutorID: mesos.ExecutorID{Value: "executor"}},
+	}
+	exec := &Executor{config: Config{ServicelogMesosFields: true}}
+	exec.config.MesosConfig.FrameworkID = "framework"
+	exec.config.MesosConfig.AgentEndpoint = "agent:5051"
+
+	data := exec.serviceLogStaticData(taskInfo, 42)
+
+	assert.Equal(t, map[string]interface{}{
+		"instance-id":    "executor",
+		"scid":           42,
+		"framework-id":   "framework",
+		"agent-endpoint": "agent:5051",
+		"task-id":        "task",
+	}, data)
+
+	exec.config.ServicelogMesosFields = false
+	assert.Len(t, exec.serviceLogStaticData(taskInfo, 42), 2)
+}
+
+func TestIfLimitsTaskRestartBackoff(t *testing.T) {
+	exec := &Executor{config: Config{TaskRestartBackoff: time.Second}}
+
+	assert.Equal(t, time.Second, exec.restartBackoff(0))
+	assert.Equal(t, 4*time.Second, exec.restartBackoff(2))
+	assert.Equal(t, maxTaskRestartBackoff, exec.restartBackoff(10))
+	assert.Equal(t, maxTaskRestartBackoff, exec.restartBackoff(100))
+}
+
+func TestIfLimitsKillPolicyGracePeriod(t *testing.T) {
+	taskInfo := &mesos.TaskInfo{KillPolicy: &mesos.KillPolicy{
+		GracePeriod: &mesos.DurationInfo{Nanoseconds: int64(time.Hour)},
+	}}
+	exec := &Executor{config: Config{KillPolicyGracePeriod: 5 * time.Second}}
+
+	assert.Equal(t, time.Hour, exec.gracePeriod(taskInfo))
+	assert.Equal(t, 5*time.Second, exec.gracePeriod(&mesos.TaskInfo{}))
+
+	exec.config.MaxKillGracePeriod = time.Minute
+	assert.Equal(t, time.Minute, exec.gracePeriod(taskInfo))
+	assert.Equal(t, 5*time.Second, exec.gracePeriod(&mesos.TaskInfo{}))
+}
+
+func TestIfLimitsWholeKillSignalSequence(t *testing.T) {
+	cmd := new(mockCommand)
+	cmd.On("Stop", []osutil.SignalStep{
+		{Signal: syscall.SIGINT, Delay: 30 * time.Second},
+		{Signal: syscall.SIGTERM, Delay: 30 * time.Second},
+	}, []string(nil)).Once()
+	exec := &Executor{config: Config{
+		KillPolicyGracePeriod: 5 * time.Second,
+		KillSignalSequence:    "SIGINT:1m,SIGTERM:1m",
+		MaxKillGracePeriod:    time.Minute,
+	}}
+
+	exec.stopCommand(&mesos.TaskInfo{}, cmd)
+
+	cmd.AssertExpectations(t)
+}
+
+func TestIfDryRunCreatesCommandWithoutStartingIt(t *testing.T) {
+	stateUpdater := new(mockUpdater)
+	mockedHook := new(mockHook)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.BeforeTaskStartEvent
+	})).Return(hook.Env{}, nil).Once()
+
+	exec := new(Executor)
+	exec.hookManager.Hooks = []hook.Hook{mockedHook}
+	exec.stateUpdater = stateUpdater
+
+	err := exec.DryRun(launchEventWithCommand(infiniteCommand).Launch.Task)
+
+	assert.NoError(t, err)
+	mockedHook.AssertExpectations(t)
+	stateUpdater.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+}
+
+func TestIfDryRunDoesNotCreateServiceLogAppender(t *testing.T) {
+	registerFailingAppender.Do(func() {
+		appender.Register("dry-run-failing", func() (appender.Appender, error) {
+			return nil, errors.New("appender should not be created")
+		})
+	})
+	taskInfo := launchEventWithCommand(infiniteCommand).Launch.Task
+	logScraping, scID := "dry-run-failing", "1"
+	taskInfo.Labels = &mesos.Labels{Labels: []mesos.Label{
+		{Key: "log-scraping", Value: &logScraping},
+		{Key: "scId", Value: &scID},
+	}}
+
+	err := new(Executor).DryRun(taskInfo)
+
+	assert.NoError(t, err)
+}
+
+func TestIfDryRunFailsWhenHookBeforeTaskStartFails(t *testing.T) {
+	mockedHook := new(mockHook)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.BeforeTaskStartEvent
+	})).Return(hook.Env{}, errors.New("error")).Once()
+
+	exec := new(Executor)
+	exec.hookManager.Hooks = []hook.Hook{mockedHook}
+
+	err := exec.DryRun(launchEventWithCommand(infiniteCommand).Launch.Task)
+
+	assert.Error(t, err)
+	mockedHook.AssertExpectations(t)
+}
+
+func TestIfReadsTaskInfoFromJSON(t *testing.T) {
+	file, err := ioutil.TempFile("", "taskinfo")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`{"name": "task", "task_id": {"value": "task-id"}, "agent_id": {"value": "agent"},
+		"executor": {"executor_id": {"value": "executor"}, "command": {"value": "sleep 1"}}}`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	taskInfo, err := readTaskInfo(file.Name())
+
+	require.NoError(t, err)
+	assert.Equal(t, "task-id", taskInfo.TaskID.GetValue())
+	assert.Equal(t, "sleep 1", taskInfo.Executor.Command.GetValue())
+}
+
+func TestIfWaitsForRegistrationTimeoutBeforeFirstSubscription(t *testing.T) {
+	cfg := Config{RegistrationTimeout: 300 * time.Millisecond}
+	cfg.MesosConfig.AgentEndpoint = "127.0.0.1:0"
+	cfg.MesosConfig.RecoveryTimeout = time.Millisecond
+	exec := NewExecutor(sanitizeConfig(cfg))
+	defer exec.contextCancel()
+
+	start := time.Now()
+	err := exec.Start()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to subscribe to agent within 300ms")
+	assert.True(t, time.Since(start) >= 300*time.Millisecond)
+}
+
+func TestIfPassesFrameworkMessageToHooksOfRunningTasks(t *testing.T) {
+	mockedHook := new(mockHook)
+	mockedHook.On("HandleEvent", mock.MatchedBy(func(event hook.Event) bool {
+		return event.Type == hook.FrameworkMessageEvent &&
+			event.TaskInfo.GetTaskID() == "task" &&
+			string(event.Message) == "reload"
+	})).Return(hook.Env{}, errors.New("ignored")).Once()
+
+	exec := new(Executor)
+	exec.events = make(chan Event, 1)
+	exec.hookManager.Hooks = []hook.Hook{mockedHook}
+
+	require.NoError(t, exec.handleMesosEvent(executor.Event{
+		Type:    executor.Event_MESSAGE.Enum(),
+		Message: &executor.Event_Message{Data: []byte("reload")},
+	}))
+	event := <-exec.events
+	require.Equal(t, Message, event.Type)
+
+	exec.handleFrameworkMessage(map[string]*task{
+		"task": {info: mesos.TaskInfo{TaskID: mesos.TaskID{Value: "task"}}},
+	}, event.message.GetData())
+
+	mockedHook.AssertExpectations(t)
+}
+
+func TestIfDropsFrameworkMessageWhenNoTaskIsRunning(t *testing.T) {
+	mockedHook := new(mockHook)
+	exec := new(Executor)
+	exec.hookManager.Hooks = []hook.Hook{mockedHook}
+
+	exec.handleFrameworkMessage(map[string]*task{}, []byte("reload"))
+
+	mockedHook.AssertNotCalled(t, "HandleEvent", mock.Anything)
+}
+
+func TestIfMatchesIgnoredKeysAsConfigured(t *testing.T) {
+	conf := Config{ServicelogIgnoreKeys: []string{"debug_*"}}
+	filter, err := ignoredKeysFilter(conf)
+	require.NoError(t, err)
+	assert.False(t, filter.Match([]byte("debug_id")))
+	assert.True(t, filter.Match([]byte("debug_*")))
+
+	conf.ServicelogIgnoreKeysMatching = "glob"
+	filter, err = ignoredKeysFilter(conf)
+	require.NoError(t, err)
+	assert.True(t, filter.Match([]byte("debug_id")))
+
+	conf.ServicelogIgnoreKeysMatching = "regexp"
+	_, err = ignoredKeysFilter(conf)
+	assert.Error(t, err)
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,32 +26,122 @@ import (
 // See: https://github.com/apache/mesos/blob/1.1.3/include/mesos/mesos.proto#L353-L357
 const defaultDomain = "127.0.0.1"
 
+// commandOutputTailSize is the number of last bytes of command health check
+// output that are included in the returned error.
+const commandOutputTailSize = 200
+
+// HealthCheckOption is a function that configures task specific health check
+// behaviour not covered by the Mesos health check definition.
+type HealthCheckOption func(*healthCheckConfig)
+
+type healthCheckConfig struct {
+	unixSocket      string
+	host            string
+	port            uint32
+	followRedirects bool
+	stop            <-chan struct{}
+}
+
+// UnixSocketHealthCheck makes the TCP health check dial the Unix domain socket
+// at the given path instead of the TCP port.
+func UnixSocketHealthCheck(path string) HealthCheckOption {
+	return func(cfg *healthCheckConfig) {
+		cfg.unixSocket = path
+	}
+}
+
+// HealthCheckHost sets the host checked by HTTP and TCP health checks instead
+// of the default one (see HealthCheckAddress).
+func HealthCheckHost(host string) HealthCheckOption {
+	return func(cfg *healthCheckConfig) {
+		cfg.host = host
+	}
+}
+
+// HealthCheckPort sets the port checked by HTTP and TCP health checks that
+// have no port defined (e.g. port resolved from the task port name).
+func HealthCheckPort(port uint32) HealthCheckOption {
+	return func(cfg *healthCheckConfig) {
+		cfg.port = port
+	}
+}
+
+// FollowHTTPRedirects makes the HTTP health check follow redirects and evaluate
+// status of the final response instead of the redirect itself.
+func FollowHTTPRedirects() HealthCheckOption {
+	return func(cfg *healthCheckConfig) {
+		cfg.followRedirects = true
+	}
+}
+
+// StopHealthChecks stops scheduled health checks when the stop channel is
+// closed (e.g. when the checked command is restarted).
+func StopHealthChecks(stop <-chan struct{}) HealthCheckOption {
+	return func(cfg *healthCheckConfig) {
+		cfg.stop = stop
+	}
+}
+
 // DoHealthChecks schedules health check defined in check.
 // HealthState updates are delivered on provided healthStates channel.
-func DoHealthChecks(check mesos.HealthCheck, healthStates chan<- Event) {
+func DoHealthChecks(check mesos.HealthCheck, healthStates chan<- Event, options ...HealthCheckOption) {
 	log.Debugf("Health check configuration: %s", check.String())
-	performCheck := newHealthCheck(check)
+	performCheck := newHealthCheck(check, options...)
 	delay := mesosutils.Duration(check.GetDelaySeconds())
 
+	var cfg healthCheckConfig
+	for _, option := range options {
+		option(&cfg)
+	}
+
 	healthResults := make(chan error)
-	go handleHealthResults(check, healthResults, healthStates)
+	go handleHealthResults(check, healthResults, healthStates, cfg.stop)
 
 	interval := mesosutils.Duration(check.GetIntervalSeconds())
 
 	log.Infof("Scheduling health check for task in %s", delay)
 	time.AfterFunc(delay, func() {
+		defer close(healthResults)
+		if stopped(cfg.stop) {
+			return
+		}
 
 		healthResults <- performCheck()
 
 		log.Infof("Scheduling health check for task every %s", interval)
 		tick := time.NewTicker(interval)
-		for range tick.C {
-			healthResults <- performCheck()
+		defer tick.Stop()
+		for {
+			select {
+			case <-cfg.stop:
+				log.Info("Health checks stopped")
+				return
+			case <-tick.C:
+				healthResults <- performCheck()
+			}
 		}
 	})
 }
 
-func handleHealthResults(checkDefinition mesos.HealthCheck, healthResults <-chan error, healthStates chan<- Event) {
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleHealthResults turns health check results into health states. States
+// of checks stopped with the stop channel are dropped.
+func handleHealthResults(checkDefinition mesos.HealthCheck, healthResults <-chan error,
+	healthStates chan<- Event, stop <-chan struct{}) {
+	sendState := func(event Event) {
+		select {
+		case healthStates <- event:
+		case <-stop:
+		}
+	}
 	neverPassedBefore := true
 	delay := mesosutils.Duration(checkDefinition.GetDelaySeconds())
 	startTime := time.Now().Truncate(delay)
@@ -67,9 +160,9 @@ func handleHealthResults(checkDefinition mesos.HealthCheck, healthResults <-chan
 			// and honors the type (or not). We have no control over the task's lifetime,
 			// hence we should continue until we are explicitly asked to stop.
 			if consecutiveFailures >= checkDefinition.GetConsecutiveFailures() {
-				healthStates <- Event{Type: FailedDueToUnhealthy, Message: err.Error()}
+				sendState(Event{Type: FailedDueToUnhealthy, Message: err.Error()})
 			} else {
-				healthStates <- Event{Type: Unhealthy, Message: err.Error()}
+				sendState(Event{Type: Unhealthy, Message: err.Error()})
 			}
 			continue
 		}
@@ -77,7 +170,7 @@ func handleHealthResults(checkDefinition mesos.HealthCheck, healthResults <-chan
 		// and on the first success following failure(s).
 		if neverPassedBefore || consecutiveFailures > 0 {
 			log.Info("Health check passed")
-			healthStates <- Event{Type: Healthy}
+			sendState(Event{Type: Healthy})
 		}
 		consecutiveFailures = 0
 		neverPassedBefore = false
@@ -89,15 +182,27 @@ func handleHealthResults(checkDefinition mesos.HealthCheck, healthResults <-chan
 type healthCheckFunction func() error
 
 // NewHealthCheck returns health check that performs check given as a configuration.
-func newHealthCheck(check mesos.HealthCheck) healthCheckFunction {
+func newHealthCheck(check mesos.HealthCheck, options ...HealthCheckOption) healthCheckFunction {
+	var cfg healthCheckConfig
+	for _, option := range options {
+		option(&cfg)
+	}
+	if cfg.port != 0 {
+		check = withDefaultPort(check, cfg.port)
+	}
+
 	// For backward compatibility with Mesos 1.0.0 we can't rely on GetType() here.
 	// See: https://lists.apache.org/thread.html/ec6139491c36a4387ffad4b1e29e3bbce16d99ad0620e1d72e26bc58@%3Cuser.mesos.apache.org%3E
 	if check.GetCommand() != nil {
 		return func() error { return commandHealthCheck(check) }
 	} else if check.GetHTTP() != nil {
-		return func() error { return httpHealthCheck(check) }
+		client := newHealthCheckHTTPClient(check, cfg.followRedirects)
+		return func() error { return httpHealthCheck(check, client, cfg.host) }
 	} else if check.GetTCP() != nil {
-		return func() error { return tcpHealthCheck(check) }
+		if cfg.unixSocket != "" {
+			return func() error { return unixSocketHealthCheck(check, cfg.unixSocket) }
+		}
+		return func() error { return tcpHealthCheck(check, cfg.host) }
 	}
 
 	return func() error { return fmt.Errorf("unknown health check type: %s", check.GetType()) }
@@ -109,7 +214,8 @@ func commandHealthCheck(checkDefinition mesos.HealthCheck) error {
 	}
 
 	timeout := mesosutils.Duration(checkDefinition.GetTimeoutSeconds())
-	ctx, _ := context.WithTimeout(context.Background(), timeout) // nolint: vet
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
 	commandInfo := checkDefinition.GetCommand()
 	var cmd *exec.Cmd
@@ -136,9 +242,14 @@ func commandHealthCheck(checkDefinition mesos.HealthCheck) error {
 		environ = append(environ, fmt.Sprintf("%s=%s", variable.Name, variable.Value))
 	}
 	cmd.Env = environ
-	// Redirect command output
-	cmd.Stdout = os.Stderr
-	cmd.Stderr = os.Stderr
+	// Capture command output, keeping only its tail to bound memory usage
+	output := &tailBuffer{size: commandOutputTailSize}
+	var writer io.Writer = output
+	if log.IsLevelEnabled(log.DebugLevel) {
+		writer = io.MultiWriter(output, os.Stderr)
+	}
+	cmd.Stdout = writer
+	cmd.Stderr = writer
 
 	log.Infof("Launching command health check: %s", strings.Join(cmd.Args, " "))
 	if err := cmd.Run(); err != nil {
@@ -146,14 +257,39 @@ func commandHealthCheck(checkDefinition mesos.HealthCheck) error {
 			log.WithError(ctx.Err()).Info("Command health check timed out")
 			return fmt.Errorf("command health check timed out after %s", timeout)
 		}
+		if tail := strings.TrimSpace(output.String()); tail != "" {
+			return fmt.Errorf("command health check errored: %s: %s", err, tail)
+		}
 		return fmt.Errorf("command health check errored: %s", err)
 	}
 	return nil
 }
 
-func tcpHealthCheck(checkDefinition mesos.HealthCheck) error {
+// tailBuffer is an io.Writer that keeps only the last size bytes written to it.
+type tailBuffer struct {
+	size int
+	buf  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= b.size {
+		p = p[n-b.size:]
+	}
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.size {
+		b.buf = append(b.buf[:0:0], b.buf[len(b.buf)-b.size:]...)
+	}
+	return n, nil
+}
+
+func (b *tailBuffer) String() string {
+	return string(b.buf)
+}
+
+func tcpHealthCheck(checkDefinition mesos.HealthCheck, host string) error {
 	timeout := mesosutils.Duration(checkDefinition.GetTimeoutSeconds())
-	address := HealthCheckAddress(checkDefinition.GetTCP().GetPort())
+	address := HealthCheckAddress(host, checkDefinition.GetTCP().GetPort())
 	conn, err := net.DialTimeout("tcp", address, timeout)
 	if err != nil {
 		return fmt.Errorf("TCP health error: %s", err)
@@ -164,16 +300,59 @@ func tcpHealthCheck(checkDefinition mesos.HealthCheck) error {
 	return nil
 }
 
-func httpHealthCheck(checkDefinition mesos.HealthCheck) error {
-	const defaultHTTPScheme = "http"
-
+func unixSocketHealthCheck(checkDefinition mesos.HealthCheck, path string) error {
 	timeout := mesosutils.Duration(checkDefinition.GetTimeoutSeconds())
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return fmt.Errorf("unix socket health error: %s", err)
+	}
+	if err := conn.Close(); err != nil {
+		log.WithError(err).Warn("Error closing unix socket health check connection")
+	}
+	return nil
+}
+
+// withDefaultPort returns a copy of the check with the port set in HTTP and TCP
+// check details that have no port.
+func withDefaultPort(check mesos.HealthCheck, port uint32) mesos.HealthCheck {
+	if check.HTTP != nil && check.HTTP.GetPort() == 0 {
+		http := *check.HTTP
+		http.Port = port
+		check.HTTP = &http
+	}
+	if check.TCP != nil && check.TCP.GetPort() == 0 {
+		tcp := *check.TCP
+		tcp.Port = port
+		check.TCP = &tcp
+	}
+	return check
+}
+
+// newHealthCheckHTTPClient returns HTTP client that should be shared by all
+// HTTP health checks of a single task, so idle connections are reused between
+// check intervals. The timeout is applied to every request separately.
+// Redirects are not followed unless followRedirects is set, so the status of
+// the checked endpoint is evaluated.
+func newHealthCheckHTTPClient(checkDefinition mesos.HealthCheck, followRedirects bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 1
 	client := &http.Client{
-		Timeout: timeout,
+		Transport: transport,
+		Timeout:   mesosutils.Duration(checkDefinition.GetTimeoutSeconds()),
 	}
+	if !followRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return client
+}
+
+func httpHealthCheck(checkDefinition mesos.HealthCheck, client *http.Client, host string) error {
+	const defaultHTTPScheme = "http"
 
 	var checkURL url.URL
-	checkURL.Host = HealthCheckAddress(checkDefinition.GetHTTP().GetPort())
+	checkURL.Host = HealthCheckAddress(host, checkDefinition.GetHTTP().GetPort())
 	checkURL.Path = checkDefinition.GetHTTP().GetPath()
 	if checkDefinition.GetHTTP().Scheme != nil {
 		checkURL.Scheme = checkDefinition.GetHTTP().GetScheme()
@@ -186,6 +365,10 @@ func httpHealthCheck(checkDefinition mesos.HealthCheck) error {
 		return fmt.Errorf("health check error: %s", err)
 	}
 	defer func() {
+		// Body must be read to EOF, otherwise the connection will not be reused.
+		if _, err := io.Copy(ioutil.Discard, response.Body); err != nil {
+			log.WithError(err).Warn("Error reading HTTP health check response body")
+		}
 		if err := response.Body.Close(); err != nil {
 			log.WithError(err).Warn("Error closing HTTP health check response body")
 		}
@@ -201,14 +384,15 @@ func httpHealthCheck(checkDefinition mesos.HealthCheck) error {
 }
 
 // HealthCheckAddress returns host and port that should be used for health checking
-// service.
-func HealthCheckAddress(port uint32) string {
-	ip := runenv.IP()
-	var host string
-	if ip == nil {
-		host = defaultDomain
-	} else {
-		host = ip.String()
+// service. Passed host is used when it is not empty, otherwise it is the public
+// IP of the agent or loopback address.
+func HealthCheckAddress(host string, port uint32) string {
+	if host == "" {
+		if ip := runenv.IP(); ip != nil {
+			host = ip.String()
+		} else {
+			host = defaultDomain
+		}
 	}
-	return fmt.Sprintf("%s:%d", host, port)
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
 }
@@ -1,12 +1,17 @@
 package executor
 
 import (
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -47,11 +52,41 @@ func TestDoHealthChecksShouldStartHealthCheckig(t *testing.T) {
 	}
 }
 
+func TestDoHealthChecksShouldStopHealthCheckingWhenStopped(t *testing.T) {
+	delay := time.Millisecond.Seconds()
+	gracePeriod := 0.0
+	interval := time.Millisecond.Seconds()
+	check := mesos.HealthCheck{
+		GracePeriodSeconds: &gracePeriod,
+		DelaySeconds:       &delay,
+		IntervalSeconds:    &interval,
+	}
+	healthStates := make(chan Event)
+	stop := make(chan struct{})
+
+	DoHealthChecks(check, healthStates, StopHealthChecks(stop))
+	<-healthStates
+	close(stop)
+
+	// at most one check may be in progress when stopped
+	timeout := time.After(100 * time.Millisecond)
+	received := 0
+	for {
+		select {
+		case <-healthStates:
+			received++
+		case <-timeout:
+			assert.True(t, received <= 1, "health checks should be stopped")
+			return
+		}
+	}
+}
+
 func TestHandleHealthResultsShouldProxyAllUnhealthyResultsAfterGracePeriod(t *testing.T) {
 	healthResults := make(chan error)
 	healthStates := make(chan Event)
 	gracePeriod := 0.0
-	go handleHealthResults(mesos.HealthCheck{GracePeriodSeconds: &gracePeriod}, healthResults, healthStates)
+	go handleHealthResults(mesos.HealthCheck{GracePeriodSeconds: &gracePeriod}, healthResults, healthStates, nil)
 
 	err := errors.New("Error")
 
@@ -77,7 +112,7 @@ func TestHandleHealthResultsShouldSetKillToTrueWhenConsecutiveFailuresExceedsCon
 		GracePeriodSeconds:  &gracePeriod,
 		ConsecutiveFailures: &maxConsecutiveFailures,
 	}
-	go handleHealthResults(check, healthResults, healthStates)
+	go handleHealthResults(check, healthResults, healthStates, nil)
 
 	err := errors.New("Error")
 
@@ -97,7 +132,7 @@ func TestHandleHealthResultsShouldNotNotifyWhenTaskIsHealthy(t *testing.T) {
 		GracePeriodSeconds:  &gracePeriod,
 		ConsecutiveFailures: &maxConsequeltialFailures,
 	}
-	go handleHealthResults(check, healthResults, healthStates)
+	go handleHealthResults(check, healthResults, healthStates, nil)
 
 	err := errors.New("Error")
 
@@ -122,7 +157,7 @@ func TestHandleHealthResultsShouldNotNotifyWhenTaskIsInGracePeriod(t *testing.T)
 		GracePeriodSeconds:  &gracePeriod,
 		ConsecutiveFailures: &maxConsequeltialFailures,
 	}
-	go handleHealthResults(check, healthResults, healthStates)
+	go handleHealthResults(check, healthResults, healthStates, nil)
 
 	err := errors.New("Error")
 
@@ -130,6 +165,30 @@ func TestHandleHealthResultsShouldNotNotifyWhenTaskIsInGracePeriod(t *testing.T)
 	assert.Empty(t, healthStates, "Notification should NOT be sent for failing task during grace period")
 }
 
+func TestHandleHealthResultsShouldDropStatesWhenStopped(t *testing.T) {
+	healthResults := make(chan error)
+	healthStates := make(chan Event)
+	stop := make(chan struct{})
+	gracePeriod := 0.0
+	done := make(chan struct{})
+	go func() {
+		handleHealthResults(mesos.HealthCheck{GracePeriodSeconds: &gracePeriod}, healthResults, healthStates, stop)
+		close(done)
+	}()
+	close(stop)
+
+	healthResults <- errors.New("Error")
+	healthResults <- nil
+	close(healthResults)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("health results handling should not block when stopped")
+	}
+	assert.Empty(t, healthStates)
+}
+
 func TestNewHealthCheckShouldReturnNilWhenCheckPasses(t *testing.T) {
 	commandType := mesos.HealthCheck_COMMAND
 	validCommand := "true"
@@ -170,6 +229,24 @@ func TestCommandHealthCheckShouldReturnErrorOnInvalidCommand(t *testing.T) {
 	assert.EqualError(t, err, "command health check errored: exit status 1")
 }
 
+func TestCommandHealthCheckShouldReturnErrorWithCommandOutput(t *testing.T) {
+	invalidCommand := "echo service is down; false"
+	command := mesos.CommandInfo{Value: &invalidCommand}
+	check := mesos.HealthCheck{Command: &command}
+	err := commandHealthCheck(check)
+	assert.EqualError(t, err, "command health check errored: exit status 1: service is down")
+}
+
+func TestCommandHealthCheckShouldReturnOnlyTailOfCommandOutput(t *testing.T) {
+	invalidCommand := "head -c 1000 /dev/zero | tr '\\0' 'a'; echo end; false"
+	command := mesos.CommandInfo{Value: &invalidCommand}
+	check := mesos.HealthCheck{Command: &command}
+	err := commandHealthCheck(check)
+	require.Error(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "aaaaend"))
+	assert.True(t, len(err.Error()) < commandOutputTailSize+len("command health check errored: exit status 1: "))
+}
+
 func TestCommandHealthCheckShouldReturnErrorOnInvalidCheck(t *testing.T) {
 	cmd := "test $X"
 	env := mesos.Environment{
@@ -206,6 +283,28 @@ func TestCommandHealthCheckShouldReturnErrorOnAfterTimeout(t *testing.T) {
 	assert.EqualError(t, err, "command health check timed out after 0s")
 }
 
+func TestIfHTTPHealthCheckReusesConnections(t *testing.T) {
+	var connections int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+	check := buildHTTPCheckForTestServer(ts, 0.1, "/")
+	client := newHealthCheckHTTPClient(check, false)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, httpHealthCheck(check, client, ""))
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connections))
+}
+
 func TestIfTCPHealthCheckPassesWhenPortIsOpen(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -213,14 +312,36 @@ func TestIfTCPHealthCheckPassesWhenPortIsOpen(t *testing.T) {
 	defer ts.Close()
 	check := buildTCPCheckForTestServer(ts, 0.1)
 
-	err := tcpHealthCheck(check)
+	err := tcpHealthCheck(check, "")
 	assert.NoError(t, err)
 }
 
 func TestIfTCPHealthCheckFailWhenPortIsClosed(t *testing.T) {
 	check := buildTCPCheck(0, 0.1)
 
-	err := tcpHealthCheck(check)
+	err := tcpHealthCheck(check, "")
+	assert.Error(t, err)
+}
+
+func TestIfUnixSocketHealthCheckPassesWhenSocketAcceptsConnections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "service.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	healthCheck := newHealthCheck(buildTCPCheck(0, 0.1), UnixSocketHealthCheck(socket))
+	err = healthCheck()
+
+	assert.NoError(t, err)
+}
+
+func TestIfUnixSocketHealthCheckFailsWhenSocketDoesNotExist(t *testing.T) {
+	healthCheck := newHealthCheck(buildTCPCheck(0, 0.1), UnixSocketHealthCheck("/nonexistent/service.sock"))
+	err := healthCheck()
+
 	assert.Error(t, err)
 }
 
@@ -231,7 +352,7 @@ func TestIfHTTPHealthCheckWithoutPathPassesWhenOKStatusCodeIsReceived(t *testing
 	defer ts.Close()
 	check := buildHTTPCheckForTestServer(ts, 0.1, "")
 
-	err := httpHealthCheck(check)
+	err := httpHealthCheck(check, newHealthCheckHTTPClient(check, false), "")
 
 	assert.NoError(t, err)
 }
@@ -248,11 +369,53 @@ func TestIfHTTPHealthCheckWithPathPassesWhenOKStatusCodeIsReceived(t *testing.T)
 	defer ts.Close()
 	check := buildHTTPCheckForTestServer(ts, 0.1, "/status/info")
 
-	err := httpHealthCheck(check)
+	err := httpHealthCheck(check, newHealthCheckHTTPClient(check, false), "")
 
 	assert.NoError(t, err)
 }
 
+func TestIfHTTPHealthCheckUsesConfiguredPortWhenCheckHasNoPort(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	check := buildHTTPCheckForTestServer(ts, 0.1, "")
+	port := check.HTTP.Port
+	check.HTTP.Port = 0
+
+	healthCheck := newHealthCheck(check, HealthCheckPort(port))
+
+	assert.NoError(t, healthCheck())
+	assert.Equal(t, uint32(0), check.HTTP.Port)
+}
+
+func TestIfHTTPHealthCheckPrefersPortOfCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	check := buildHTTPCheckForTestServer(ts, 0.1, "")
+
+	healthCheck := newHealthCheck(check, HealthCheckPort(1))
+
+	assert.NoError(t, healthCheck())
+}
+
+func TestIfHTTPHealthCheckDoesNotFollowRedirectsByDefault(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/unavailable", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	check := buildHTTPCheckForTestServer(ts, 0.1, "/redirect")
+
+	assert.NoError(t, newHealthCheck(check)())
+	assert.Error(t, newHealthCheck(check, FollowHTTPRedirects())())
+}
+
 func TestIfHTTPHealthCheckFailsWhenTimeoutOccur(t *testing.T) {
 	sleep := make(chan bool)
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -262,7 +425,7 @@ func TestIfHTTPHealthCheckFailsWhenTimeoutOccur(t *testing.T) {
 	defer ts.Close()
 	check := buildHTTPCheckForTestServer(ts, time.Millisecond.Seconds(), "")
 
-	err := httpHealthCheck(check)
+	err := httpHealthCheck(check, newHealthCheckHTTPClient(check, false), "")
 	close(sleep) // release the server
 
 	require.Error(t, err)
@@ -276,7 +439,7 @@ func TestIfHTTPHealthCheckFailsWhenRequestIsInvalid(t *testing.T) {
 	defer ts.Close()
 	check := buildHTTPCheckForTestServer(ts, 0.1, "")
 
-	err := httpHealthCheck(check)
+	err := httpHealthCheck(check, newHealthCheckHTTPClient(check, false), "")
 
 	assert.EqualError(t, err, "health check error: received status code 400, but expected codes between 200 and 399")
 }
@@ -288,7 +451,7 @@ func TestIfHTTPHealthCheckFailsWhenServiceIsUnavailable(t *testing.T) {
 	defer ts.Close()
 	check := buildHTTPCheckForTestServer(ts, 0.1, "")
 
-	err := httpHealthCheck(check)
+	err := httpHealthCheck(check, newHealthCheckHTTPClient(check, false), "")
 
 	assert.EqualError(t, err, "health check error: received status code 503, but expected codes between 200 and 399")
 }
@@ -296,7 +459,7 @@ func TestIfHTTPHealthCheckFailsWhenServiceIsUnavailable(t *testing.T) {
 func TestIfHTTPHealthCheckFailsWhenNoServiceIsListeningOnConfiguredPort(t *testing.T) {
 	check := buildHTTPCheck("http", 1000, "/", 0.1)
 
-	err := httpHealthCheck(check)
+	err := httpHealthCheck(check, newHealthCheckHTTPClient(check, false), "")
 
 	assert.Error(t, err)
 }
@@ -305,16 +468,48 @@ func TestIfUsesPublicIPForHealthCheckAddress(t *testing.T) {
 	os.Setenv("CLOUD_PUBLIC_IP", "6.6.6.6")
 	defer os.Unsetenv("CLOUD_PUBLIC_IP")
 
-	address := HealthCheckAddress(1234)
+	address := HealthCheckAddress("", 1234)
 
 	assert.Equal(t, "6.6.6.6:1234", address)
 }
 
 func TestIfFallbacksToLoopbackIfUnableToDeterminePublicIP(t *testing.T) {
-	address := HealthCheckAddress(1234)
+	address := HealthCheckAddress("", 1234)
 	assert.Equal(t, "127.0.0.1:1234", address)
 }
 
+func TestIfUsesPublicIPv6ForHealthCheckAddress(t *testing.T) {
+	os.Setenv("CLOUD_PUBLIC_IP", "2001:db8::1")
+	defer os.Unsetenv("CLOUD_PUBLIC_IP")
+
+	address := HealthCheckAddress("", 1234)
+
+	assert.Equal(t, "[2001:db8::1]:1234", address)
+	host, port, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", host)
+	assert.Equal(t, "1234", port)
+}
+
+func TestIfUsesPassedHostForHealthCheckAddress(t *testing.T) {
+	os.Setenv("CLOUD_PUBLIC_IP", "6.6.6.6")
+	defer os.Unsetenv("CLOUD_PUBLIC_IP")
+
+	assert.Equal(t, "10.0.0.1:1234", HealthCheckAddress("10.0.0.1", 1234))
+	assert.Equal(t, "[::1]:1234", HealthCheckAddress("::1", 1234))
+}
+
+func TestIfTCPHealthCheckUsesConfiguredHost(t *testing.T) {
+	os.Setenv("CLOUD_PUBLIC_IP", "6.6.6.6")
+	defer os.Unsetenv("CLOUD_PUBLIC_IP")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	healthCheck := newHealthCheck(buildTCPCheckForTestServer(ts, 0.1), HealthCheckHost("127.0.0.1"))
+
+	assert.NoError(t, healthCheck())
+}
+
 func buildHTTPCheck(scheme string, port uint32, path string, timeoutSeconds float64) mesos.HealthCheck {
 	return mesos.HealthCheck{
 		HTTP: &mesos.HealthCheck_HTTPCheckInfo{
@@ -2,8 +2,16 @@ package consul
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	"github.com/hashicorp/consul/api"
 	log "github.com/sirupsen/logrus"
@@ -22,8 +30,46 @@ const (
 	consulTagValue     = "tag"
 	serviceHost        = "127.0.0.1"
 	portPlaceholder    = "{port:%s}"
+	// Task labels with this prefix are registered as Consul service metadata
+	consulMetaLabelPrefix = "consul-meta-"
+	// Task labels enabling gRPC check (optionally over TLS) instead of the one
+	// derived from Mesos health check. Mesos API we use has no gRPC check type.
+	grpcCheckLabelKey    = "consul-check-grpc"
+	grpcTLSCheckLabelKey = "consul-check-grpc-tls"
+	// Task label overriding scheme of HTTP check derived from Mesos health check
+	checkSchemeLabelKey = "consul-check-scheme"
+	// Task label disabling certificate verification of HTTPS checks (when set
+	// to true), e.g. when certificate is not issued for the checked address
+	checkTLSSkipVerifyLabelKey = "consul-check-tls-skip-verify"
+	// Task labels adding HTTP check of given path and TCP check (when set to
+	// true) of the service port to the one derived from Mesos health check
+	additionalHTTPCheckLabelKey = "consul-check-http"
+	additionalTCPCheckLabelKey  = "consul-check-tcp"
+	// Interval and timeout of additional checks when task has no Mesos health
+	// check, they are Mesos defaults
+	defaultCheckInterval = 10 * time.Second
+	defaultCheckTimeout  = 20 * time.Second
+	// Task label overriding address of the registered service
+	addressLabelKey = "consul-address"
+	// Task label overriding host of HTTP check, shared with executor health checks
+	healthCheckHostLabelKey = "health-check-host"
+	// ttlCheckType makes the hook register TTL checks updated by the executor
+	ttlCheckType = "ttl"
+	// registrationPollInterval is a delay between catalog queries when waiting
+	// for registered service instance
+	registrationPollInterval = 100 * time.Millisecond
+	// Ways of handling invalid tags (e.g. with unresolved placeholders)
+	keepInvalidTags = "keep"
+	dropInvalidTags = "drop"
+	failInvalidTags = "fail"
+	// maxTagLength is the longest tag (in bytes) that is considered valid
+	maxTagLength = 255
 )
 
+var unresolvedPlaceholderRegexp = regexp.MustCompile(`\{port:[^}]*\}`)
+
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
 // instance represents a service in consul
 type instance struct {
 	consulServiceName string
@@ -38,6 +84,10 @@ type Hook struct {
 	config           Config
 	client           *api.Client
 	serviceInstances []instance
+
+	ttlHealthy int32
+	ttlStop    chan struct{}
+	ttlDone    chan struct{}
 }
 
 // Config is Consul hook configuration settable from environment
@@ -46,6 +96,31 @@ type Config struct {
 	Enabled bool `default:"true" envconfig:"consul_hook_enabled"`
 	// Consul ACL Token
 	ConsulToken string `default:"" envconfig:"consul_token"`
+	// ConsulTokenFile is a path to a file with Consul ACL Token. It takes
+	// precedence over ConsulToken.
+	ConsulTokenFile string `default:"" envconfig:"consul_token_file"`
+	// ConsulScheme is an URI scheme (http or https) used to connect to Consul agent
+	ConsulScheme string `default:"" envconfig:"consul_scheme"`
+	// ConsulCAFile is a path to a CA certificate used to verify Consul agent
+	ConsulCAFile string `default:"" envconfig:"consul_ca_file"`
+	// ConsulCertFile is a path to a client certificate used to connect to Consul agent
+	ConsulCertFile string `default:"" envconfig:"consul_cert_file"`
+	// ConsulKeyFile is a path to a client key used to connect to Consul agent
+	ConsulKeyFile string `default:"" envconfig:"consul_key_file"`
+	// ConsulNamespace is a Consul Enterprise namespace services are registered
+	// into. Default namespace is used when not set.
+	ConsulNamespace string `default:"" envconfig:"consul_namespace"`
+	// ConsulPartition is a Consul Enterprise admin partition services are
+	// registered into. Default partition is used when not set.
+	ConsulPartition string `default:"" envconfig:"consul_partition"`
+	// ConsulServiceAddress overrides address of registered services, which is
+	// the host IP by default. It can be overridden per task with
+	// consul-address label.
+	ConsulServiceAddress string `default:"" envconfig:"consul_service_address"`
+	// HealthCheckHost overrides host of the HTTP check URL, which is the host
+	// IP by default. It can be overridden per task with health-check-host
+	// label. It is shared with the executor health checks configuration.
+	HealthCheckHost string `default:"" envconfig:"health_check_host"`
 	// ConsulGlobalTag is a tag added to every service registered in Consul.
 	// When executor fails (e.g., OOM, host restarted) task will NOT
 	// be deregistered. This should be done by remote service reconciling
@@ -59,6 +134,32 @@ type Config struct {
 	// By default we assume service health was checked initially by marathon
 	// It will be set to passing.
 	InitialHealthCheckStatus string `default:"passing" envconfig:"initial_health_check_status"`
+	// ConsulCheckType overrides type of the registered health check. When set
+	// to "ttl" a TTL check is registered and kept passing by the executor as
+	// long as the task is healthy. Otherwise check is derived from Mesos one.
+	ConsulCheckType string `default:"" envconfig:"consul_check_type"`
+	// ConsulCheckTTL is a TTL of the check registered when ConsulCheckType is "ttl"
+	ConsulCheckTTL time.Duration `default:"30s" envconfig:"consul_check_ttl"`
+	// ConsulCheckInterval overrides interval of the check copied from Mesos health check
+	ConsulCheckInterval string `default:"" envconfig:"consul_check_interval"`
+	// ConsulCheckTimeout overrides timeout of the check copied from Mesos health check
+	ConsulCheckTimeout string `default:"" envconfig:"consul_check_timeout"`
+	// ConsulDeregisterCriticalAfter makes Consul deregister service instance after
+	// its check was critical for the given duration. Disabled when not set.
+	ConsulDeregisterCriticalAfter time.Duration `envconfig:"consul_deregister_critical_after"`
+	// ConsulDeregisterStale makes the hook deregister services tagged with the
+	// same Marathon task ID (e.g. left by previous executor) before registration
+	ConsulDeregisterStale bool `default:"false" envconfig:"consul_deregister_stale"`
+	// ConsulWaitForRegistration makes the hook wait until every registered
+	// service instance is visible in Consul catalog
+	ConsulWaitForRegistration bool `default:"false" envconfig:"consul_wait_for_registration"`
+	// ConsulRegistrationTimeout is a maximal time to wait for registered service
+	// instance to appear in Consul catalog
+	ConsulRegistrationTimeout time.Duration `default:"10s" envconfig:"consul_registration_timeout"`
+	// ConsulInvalidTags controls how tags that are empty, too long or contain
+	// unresolved placeholders are handled: "keep" registers them as they are,
+	// "drop" skips them and "fail" fails the registration.
+	ConsulInvalidTags string `default:"keep" envconfig:"consul_invalid_tags"`
 }
 
 // HandleEvent calls appropriate hook functions that correspond to supported
@@ -69,6 +170,12 @@ func (h *Hook) HandleEvent(event hook.Event) (hook.Env, error) {
 		return nil, h.RegisterIntoConsul(event.TaskInfo)
 	case hook.BeforeTerminateEvent:
 		return nil, h.DeregisterFromConsul(event.TaskInfo)
+	case hook.TaskHealthyEvent:
+		h.updateTTLHealth(true)
+		return nil, nil
+	case hook.TaskUnhealthyEvent:
+		h.updateTTLHealth(false)
+		return nil, nil
 	default:
 		log.Debugf("Received unsupported event type %s - ignoring", event.Type)
 		return nil, nil // ignore unsupported events
@@ -96,9 +203,15 @@ func (h *Hook) RegisterIntoConsul(taskInfo mesosutils.TaskInfo) error {
 		)
 	}
 
+	address, err := h.serviceAddress(taskInfo)
+	if err != nil {
+		return fmt.Errorf("registration in Consul failed: %s", err)
+	}
+
 	ports := taskInfo.GetPorts()
-	tagPlaceholders := getPlaceholders(ports)
+	tagPlaceholders := getPlaceholders(taskInfo.GetNamedPorts())
 	globalTags := append(taskInfo.GetLabelKeysByValue(consulTagValue), h.config.ConsulGlobalTag)
+	serviceMeta := getServiceMeta(taskInfo.TaskInfo.GetLabels().GetLabels(), tagPlaceholders)
 
 	var instancesToRegister []instance
 	for _, port := range ports {
@@ -113,7 +226,7 @@ func (h *Hook) RegisterIntoConsul(taskInfo mesosutils.TaskInfo) error {
 			// it registers the service
 			// See: https://github.com/allegro/marathon-consul/blob/v1.1.0/consul/consul.go#L299-L301
 			consulServiceID := fmt.Sprintf("%s_%s_%d", taskID, portServiceName, port.GetNumber())
-			marathonTaskTag := fmt.Sprintf("marathon-task:%s", taskID)
+			marathonTaskTag := marathonTaskTag(taskID)
 			portTags := getPortTags(port, portServiceName)
 			portTags = append(portTags, globalTags...)
 			portTags = append(portTags, marathonTaskTag)
@@ -139,18 +252,28 @@ func (h *Hook) RegisterIntoConsul(taskInfo mesosutils.TaskInfo) error {
 		}
 	}
 
+	if h.config.ConsulDeregisterStale {
+		h.deregisterStaleInstances(taskID, instancesToRegister)
+	}
+
 	agent := h.client.Agent()
 	for _, serviceData := range instancesToRegister {
+		tags, err := h.sanitizeTags(resolvePlaceholders(serviceData.tags, tagPlaceholders))
+		if err != nil {
+			return fmt.Errorf("registration in Consul failed: %s", err)
+		}
 		serviceRegistration := api.AgentServiceRegistration{
 			ID:                serviceData.consulServiceID,
 			Name:              serviceData.consulServiceName,
-			Tags:              resolvePlaceholders(serviceData.tags, tagPlaceholders),
+			Tags:              tags,
+			Meta:              serviceMeta,
 			Port:              int(serviceData.port),
-			Address:           runenv.IP().String(),
+			Address:           address,
 			EnableTagOverride: false,
-			Checks:            api.AgentServiceChecks{},
-			Check:             h.generateHealthCheck(taskInfo.GetHealthCheck(), int(serviceData.port)),
+			Checks:            h.generateAdditionalChecks(taskInfo, int(serviceData.port)),
+			Check:             h.generateServiceCheck(taskInfo, int(serviceData.port)),
 		}
+		setCheckIDs(&serviceRegistration)
 
 		if err := agent.ServiceRegister(&serviceRegistration); err != nil {
 			log.WithError(err).Warnf("Unable to register service ID %q in Consul agent", serviceData.consulServiceID)
@@ -159,11 +282,105 @@ func (h *Hook) RegisterIntoConsul(taskInfo mesosutils.TaskInfo) error {
 		log.Debugf("Service %q registered in Consul with port %d and ID %q", serviceData.consulServiceName, serviceData.port, serviceData.consulServiceID)
 		log.Infof("Adding service ID %q to deregister before termination", serviceData.consulServiceID)
 		h.serviceInstances = append(h.serviceInstances, serviceData)
+
+		if h.config.ConsulWaitForRegistration {
+			if err := h.waitUntilResolvable(serviceData); err != nil {
+				return fmt.Errorf("registration in Consul failed: %s", err)
+			}
+		}
+	}
+
+	if h.config.ConsulCheckType == ttlCheckType {
+		h.startTTLUpdates()
 	}
 
 	return nil
 }
 
+// deregisterStaleInstances deregisters services tagged with given task ID that
+// will not be registered again, so each task maps only to its current instances.
+func (h *Hook) deregisterStaleInstances(taskID mesosutils.TaskID, instancesToRegister []instance) {
+	agent := h.client.Agent()
+	services, err := agent.Services()
+	if err != nil {
+		log.WithError(err).Warn("Unable to get services registered in Consul agent")
+		return
+	}
+
+	current := map[string]bool{}
+	for _, serviceData := range instancesToRegister {
+		current[serviceData.consulServiceID] = true
+	}
+	taskTag := marathonTaskTag(taskID)
+	for id, service := range services {
+		if current[id] || !contains(service.Tags, taskTag) {
+			continue
+		}
+		log.Infof("Deregistering stale service ID %q of task %s", id, taskID)
+		if err := agent.ServiceDeregister(id); err != nil {
+			log.WithError(err).Warnf("Unable to deregister stale service ID %q in Consul agent", id)
+		}
+	}
+}
+
+func marathonTaskTag(taskID mesosutils.TaskID) string {
+	return fmt.Sprintf("marathon-task:%s", taskID)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceAddress returns address of registered services taken from the task
+// label, configuration or host IP, in this order. Health checks always target
+// the service on the local host.
+func (h *Hook) serviceAddress(taskInfo mesosutils.TaskInfo) (string, error) {
+	address := taskInfo.GetLabelValue(addressLabelKey)
+	if address == "" {
+		address = h.config.ConsulServiceAddress
+	}
+	if address == "" {
+		return runenv.IP().String(), nil
+	}
+	return address, validateAddress(address)
+}
+
+func validateAddress(address string) error {
+	if net.ParseIP(address) == nil && !hostnameRegexp.MatchString(address) {
+		return fmt.Errorf("invalid Consul service address: %s", address)
+	}
+	return nil
+}
+
+// waitUntilResolvable polls Consul catalog until given service instance appears
+// in it or ConsulRegistrationTimeout elapses.
+func (h *Hook) waitUntilResolvable(serviceData instance) error {
+	catalog := h.client.Catalog()
+	deadline := time.Now().Add(h.config.ConsulRegistrationTimeout)
+	for {
+		services, _, err := catalog.Service(serviceData.consulServiceName, "", nil)
+		if err != nil {
+			log.WithError(err).Debugf("Unable to query Consul catalog for service %q", serviceData.consulServiceName)
+		}
+		for _, service := range services {
+			if service.ServiceID == serviceData.consulServiceID {
+				log.Debugf("Service ID %q is resolvable in Consul catalog", serviceData.consulServiceID)
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service ID %q not found in catalog after %s",
+				serviceData.consulServiceID, h.config.ConsulRegistrationTimeout)
+		}
+		time.Sleep(registrationPollInterval)
+	}
+}
+
 func getPortTags(port mesos.Port, serviceName string) []string {
 	var keys []string
 	labels := port.GetLabels().GetLabels()
@@ -189,6 +406,7 @@ func getPortTags(port mesos.Port, serviceName string) []string {
 // DeregisterFromConsul will deregister service IDs from Consul that were created
 // during AfterTaskStartEvent hook event.
 func (h *Hook) DeregisterFromConsul(taskInfo mesosutils.TaskInfo) error {
+	h.stopTTLUpdates()
 	agent := h.client.Agent()
 
 	var ghostInstances []instance
@@ -205,46 +423,297 @@ func (h *Hook) DeregisterFromConsul(taskInfo mesosutils.TaskInfo) error {
 	return nil
 }
 
-func (h *Hook) generateHealthCheck(mesosCheck mesosutils.HealthCheck, port int) *api.AgentServiceCheck {
+// generateServiceCheck returns Consul check for the given service port. It is
+// a gRPC check when requested with task labels, otherwise it is derived from
+// Mesos health check.
+func (h *Hook) generateServiceCheck(taskInfo mesosutils.TaskInfo, port int) *api.AgentServiceCheck {
+	if h.config.ConsulCheckType != ttlCheckType && taskInfo.GetLabelValue(grpcCheckLabelKey) == "true" {
+		return h.generateGRPCCheck(taskInfo, port)
+	}
+	mesosCheck := taskInfo.GetHealthCheck()
+	if scheme := taskInfo.GetLabelValue(checkSchemeLabelKey); scheme != "" {
+		mesosCheck.HTTP.Scheme = scheme
+	}
+	check := h.generateHealthCheck(mesosCheck, h.checkHost(taskInfo), port)
+	setTLSSkipVerify(taskInfo, check)
+	return check
+}
+
+// generateGRPCCheck returns Consul gRPC check of the service port. It uses
+// interval and timeout of the Mesos health check, when the task has one.
+func (h *Hook) generateGRPCCheck(taskInfo mesosutils.TaskInfo, port int) *api.AgentServiceCheck {
+	check := h.newProbeCheck(withDefaultTiming(taskInfo.GetHealthCheck()))
+	check.GRPC = executor.HealthCheckAddress(h.checkHost(taskInfo), uint32(port))
+	check.GRPCUseTLS = taskInfo.GetLabelValue(grpcTLSCheckLabelKey) == "true"
+	return check
+}
+
+// generateAdditionalChecks returns HTTP and TCP checks of the service port
+// requested with task labels, in addition to the one derived from Mesos health
+// check.
+func (h *Hook) generateAdditionalChecks(taskInfo mesosutils.TaskInfo, port int) api.AgentServiceChecks {
+	mesosCheck := withDefaultTiming(taskInfo.GetHealthCheck())
+
+	checks := api.AgentServiceChecks{}
+	if path := taskInfo.GetLabelValue(additionalHTTPCheckLabelKey); path != "" {
+		httpCheck := mesosutils.HealthCheck{Type: mesosutils.HTTP, Interval: mesosCheck.Interval, Timeout: mesosCheck.Timeout}
+		httpCheck.HTTP = mesosutils.HTTPCheck{Path: path, Scheme: taskInfo.GetLabelValue(checkSchemeLabelKey)}
+		check := h.generateProbeCheck(httpCheck, h.checkHost(taskInfo), port)
+		setTLSSkipVerify(taskInfo, check)
+		checks = append(checks, check)
+	}
+	if taskInfo.GetLabelValue(additionalTCPCheckLabelKey) == "true" {
+		tcpCheck := mesosutils.HealthCheck{Type: mesosutils.TCP, Interval: mesosCheck.Interval, Timeout: mesosCheck.Timeout}
+		checks = append(checks, h.generateProbeCheck(tcpCheck, "", port))
+	}
+	return checks
+}
+
+// setTLSSkipVerify disables certificate verification of HTTPS check when it
+// is requested with task label.
+func setTLSSkipVerify(taskInfo mesosutils.TaskInfo, check *api.AgentServiceCheck) {
+	if check != nil && strings.HasPrefix(check.HTTP, "https:") {
+		check.TLSSkipVerify = taskInfo.GetLabelValue(checkTLSSkipVerifyLabelKey) == "true"
+	}
+}
+
+// withDefaultTiming returns the Mesos health check with default interval and
+// timeout when they are not set (e.g. task has no health check).
+func withDefaultTiming(mesosCheck mesosutils.HealthCheck) mesosutils.HealthCheck {
+	if mesosCheck.Interval == 0 {
+		mesosCheck.Interval = defaultCheckInterval
+	}
+	if mesosCheck.Timeout == 0 {
+		mesosCheck.Timeout = defaultCheckTimeout
+	}
+	return mesosCheck
+}
+
+// setCheckIDs sets unique IDs of service checks when there are additional
+// ones. The main check keeps ID given by Consul to a single service check, so
+// it can be updated when it is a TTL check.
+func setCheckIDs(registration *api.AgentServiceRegistration) {
+	if len(registration.Checks) == 0 {
+		return
+	}
+	checkID := "service:" + registration.ID
+	if registration.Check != nil {
+		registration.Check.CheckID = checkID
+	}
+	for i, check := range registration.Checks {
+		check.CheckID = fmt.Sprintf("%s:%d", checkID, i+1)
+	}
+}
+
+func (h *Hook) checkHost(taskInfo mesosutils.TaskInfo) string {
+	if host := taskInfo.GetLabelValue(healthCheckHostLabelKey); host != "" {
+		return host
+	}
+	return h.config.HealthCheckHost
+}
+
+func (h *Hook) generateHealthCheck(mesosCheck mesosutils.HealthCheck, checkHost string, port int) *api.AgentServiceCheck {
+	if h.config.ConsulCheckType == ttlCheckType {
+		check := h.newCheck()
+		check.TTL = h.config.ConsulCheckTTL.String()
+		return check
+	}
+	return h.generateProbeCheck(mesosCheck, checkHost, port)
+}
+
+func (h *Hook) newCheck() *api.AgentServiceCheck {
 	check := api.AgentServiceCheck{}
+	check.Status = h.config.InitialHealthCheckStatus
+	if h.config.ConsulDeregisterCriticalAfter > 0 {
+		check.DeregisterCriticalServiceAfter = h.config.ConsulDeregisterCriticalAfter.String()
+	}
+	return &check
+}
+
+// newProbeCheck returns Consul check with interval and timeout of the Mesos
+// health check, unless they are overridden in config.
+func (h *Hook) newProbeCheck(mesosCheck mesosutils.HealthCheck) *api.AgentServiceCheck {
+	check := h.newCheck()
+
 	check.Interval = mesosCheck.Interval.String()
+	if h.config.ConsulCheckInterval != "" {
+		check.Interval = h.config.ConsulCheckInterval
+	}
 	check.Timeout = mesosCheck.Timeout.String()
-	check.Status = h.config.InitialHealthCheckStatus
+	if h.config.ConsulCheckTimeout != "" {
+		check.Timeout = h.config.ConsulCheckTimeout
+	}
+	return check
+}
+
+// generateProbeCheck returns Consul check probing the service like the given
+// Mesos health check or nil when it can not be registered in Consul.
+func (h *Hook) generateProbeCheck(mesosCheck mesosutils.HealthCheck, checkHost string, port int) *api.AgentServiceCheck {
+	check := h.newProbeCheck(mesosCheck)
 
 	switch mesosCheck.Type {
 	case mesosutils.HTTP:
-		check.HTTP = generateURL(mesosCheck.HTTP.Path, port)
-		return &check
+		check.HTTP = generateURL(mesosCheck.HTTP.Scheme, mesosCheck.HTTP.Path, checkHost, port)
+		return check
 	case mesosutils.TCP:
-		check.TCP = fmt.Sprintf("%s:%d", serviceHost, port)
-		return &check
+		if mesosCheck.TCP.Port != 0 {
+			port = mesosCheck.TCP.Port
+		}
+		check.TCP = net.JoinHostPort(serviceHost, strconv.Itoa(port))
+		return check
+	case mesosutils.COMMAND:
+		// command is run by the executor in the task sandbox, Consul agent
+		// script checks would run it outside of it
+		log.Debugf("Command health check %q is not registered in Consul", mesosCheck.Command.Value)
 	}
 	return nil
 }
 
-func getPlaceholders(ports []mesos.Port) map[string]string {
-	placeholders := map[string]string{}
-	for _, port := range ports {
-		name := port.GetName()
-		if name != "" {
-			placeholder := fmt.Sprintf(portPlaceholder, name)
-			placeholders[placeholder] = fmt.Sprint(port.GetNumber())
+// startTTLUpdates starts a goroutine that periodically passes or fails TTL
+// checks of registered instances, depending on the last known task health.
+func (h *Hook) startTTLUpdates() {
+	if h.ttlStop != nil {
+		return
+	}
+	interval := h.config.ConsulCheckTTL / 3
+	if interval <= 0 {
+		log.Warnf("Invalid Consul check TTL %s - TTL checks will not be updated", h.config.ConsulCheckTTL)
+		return
+	}
+	atomic.StoreInt32(&h.ttlHealthy, 1)
+	instances := h.serviceInstances
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	h.ttlStop, h.ttlDone = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.updateTTL(instances)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopTTLUpdates stops TTL updates goroutine and waits until it finishes.
+func (h *Hook) stopTTLUpdates() {
+	if h.ttlStop == nil {
+		return
+	}
+	close(h.ttlStop)
+	<-h.ttlDone
+	h.ttlStop, h.ttlDone = nil, nil
+}
+
+// updateTTLHealth records task health and immediately propagates it to TTL checks.
+func (h *Hook) updateTTLHealth(healthy bool) {
+	if h.config.ConsulCheckType != ttlCheckType {
+		return
+	}
+	var value int32
+	if healthy {
+		value = 1
+	}
+	atomic.StoreInt32(&h.ttlHealthy, value)
+	if h.ttlStop != nil {
+		h.updateTTL(h.serviceInstances)
+	}
+}
+
+func (h *Hook) updateTTL(instances []instance) {
+	status := api.HealthCritical
+	output := "Task is unhealthy"
+	if atomic.LoadInt32(&h.ttlHealthy) == 1 {
+		status = api.HealthPassing
+		output = "Task is healthy"
+	}
+
+	agent := h.client.Agent()
+	for _, serviceData := range instances {
+		// Consul assigns this ID to a check registered together with a service
+		checkID := "service:" + serviceData.consulServiceID
+		if err := agent.UpdateTTL(checkID, output, status); err != nil {
+			log.WithError(err).Warnf("Unable to update TTL check of service ID %q", serviceData.consulServiceID)
 		}
 	}
+}
+
+func getPlaceholders(namedPorts map[string]mesos.Port) map[string]string {
+	placeholders := map[string]string{}
+	for name, port := range namedPorts {
+		placeholder := fmt.Sprintf(portPlaceholder, name)
+		placeholders[placeholder] = fmt.Sprint(port.GetNumber())
+	}
 	return placeholders
 }
 
 func resolvePlaceholders(values []string, placeholders map[string]string) []string {
 	resolved := make([]string, 0, len(values))
 	for _, value := range values {
-		for placeholder, replacement := range placeholders {
-			value = strings.Replace(value, placeholder, replacement, -1)
-		}
-		resolved = append(resolved, value)
+		resolved = append(resolved, resolvePlaceholder(value, placeholders))
 	}
 	return resolved
 }
 
+func resolvePlaceholder(value string, placeholders map[string]string) string {
+	for placeholder, replacement := range placeholders {
+		value = strings.Replace(value, placeholder, replacement, -1)
+	}
+	return value
+}
+
+// sanitizeTags trims whitespace and removes control characters from given
+// tags. Tags that are empty, longer than maxTagLength or contain unresolved
+// placeholders are handled as configured with ConsulInvalidTags.
+func (h *Hook) sanitizeTags(tags []string) ([]string, error) {
+	sanitized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, tag))
+		if tag != "" && len(tag) <= maxTagLength && !unresolvedPlaceholderRegexp.MatchString(tag) {
+			sanitized = append(sanitized, tag)
+			continue
+		}
+		switch h.config.ConsulInvalidTags {
+		case failInvalidTags:
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		case dropInvalidTags:
+			log.Warnf("Dropping invalid tag %q", tag)
+		default:
+			log.Warnf("Registering invalid tag %q", tag)
+			sanitized = append(sanitized, tag)
+		}
+	}
+	return sanitized, nil
+}
+
+// getServiceMeta returns Consul service metadata built from labels prefixed
+// with consulMetaLabelPrefix, e.g., consul-meta-version=1.2.3 gives version=1.2.3.
+func getServiceMeta(labels []mesos.Label, placeholders map[string]string) map[string]string {
+	meta := map[string]string{}
+	for _, label := range labels {
+		key := strings.TrimPrefix(label.GetKey(), consulMetaLabelPrefix)
+		if key == label.GetKey() || key == "" {
+			continue
+		}
+		meta[key] = resolvePlaceholder(label.GetValue(), placeholders)
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	return meta
+}
+
 func getServiceLabels(port mesos.Port) ([]string, error) {
 	label := mesosutils.FindLabel(port.GetLabels().GetLabels(), consulNameLabelKey)
 	if label == nil {
@@ -267,22 +736,116 @@ func marathonAppNameToServiceName(name mesosutils.TaskID) string {
 	return sanitizedName
 }
 
-func generateURL(path string, port int) string {
+// generateURL returns URL of the local service endpoint. Scheme defaults to
+// http when it is empty, host defaults to the one used by executor health checks.
+func generateURL(scheme, path, host string, port int) string {
 	var checkURL url.URL
-	checkURL.Scheme = "http"
-	checkURL.Host = executor.HealthCheckAddress(uint32(port))
+	checkURL.Scheme = scheme
+	if checkURL.Scheme == "" {
+		checkURL.Scheme = "http"
+	}
+	checkURL.Host = executor.HealthCheckAddress(host, uint32(port))
 	checkURL.Path = path
 
 	return checkURL.String()
 }
 
+func validateDuration(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := time.ParseDuration(value); err != nil {
+		return fmt.Errorf("invalid Consul %s: %s", name, err)
+	}
+	return nil
+}
+
+func clientConfig(cfg Config) (*api.Config, error) {
+	config := api.DefaultConfig()
+	config.Token = cfg.ConsulToken
+	if cfg.ConsulTokenFile != "" {
+		token, err := ioutil.ReadFile(cfg.ConsulTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read Consul token file: %s", err)
+		}
+		config.Token = strings.TrimSpace(string(token))
+	}
+	if cfg.ConsulScheme != "" {
+		config.Scheme = cfg.ConsulScheme
+	}
+	if cfg.ConsulCAFile != "" {
+		config.TLSConfig.CAFile = cfg.ConsulCAFile
+	}
+	if cfg.ConsulCertFile != "" {
+		config.TLSConfig.CertFile = cfg.ConsulCertFile
+	}
+	if cfg.ConsulKeyFile != "" {
+		config.TLSConfig.KeyFile = cfg.ConsulKeyFile
+	}
+	if cfg.ConsulNamespace != "" || cfg.ConsulPartition != "" {
+		// Consul API client we use does not support namespaces, so they are
+		// passed as query parameters of every request.
+		httpClient, err := api.NewHttpClient(config.Transport, config.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create Consul HTTP client: %s", err)
+		}
+		httpClient.Transport = &tenancyTransport{
+			namespace: cfg.ConsulNamespace,
+			partition: cfg.ConsulPartition,
+			next:      httpClient.Transport,
+		}
+		config.HttpClient = httpClient
+	}
+	return config, nil
+}
+
+// tenancyTransport adds Consul Enterprise namespace and partition to every
+// request, so registration, deregistration and checks updates target the same
+// namespace.
+type tenancyTransport struct {
+	namespace string
+	partition string
+	next      http.RoundTripper
+}
+
+func (t *tenancyTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	query := request.URL.Query()
+	if t.namespace != "" {
+		query.Set("ns", t.namespace)
+	}
+	if t.partition != "" {
+		query.Set("partition", t.partition)
+	}
+	request.URL.RawQuery = query.Encode()
+	return t.next.RoundTrip(request)
+}
+
 // NewHook creates new Consul hook that is responsible for graceful Consul deregistration.
 func NewHook(cfg Config) (hook.Hook, error) {
 	if !cfg.Enabled {
 		return hook.NoopHook{}, nil
 	}
-	config := api.DefaultConfig()
-	config.Token = cfg.ConsulToken
+	if err := validateDuration("check interval", cfg.ConsulCheckInterval); err != nil {
+		return nil, err
+	}
+	if err := validateDuration("check timeout", cfg.ConsulCheckTimeout); err != nil {
+		return nil, err
+	}
+	switch cfg.ConsulInvalidTags {
+	case "", keepInvalidTags, dropInvalidTags, failInvalidTags:
+	default:
+		return nil, fmt.Errorf("unknown handling of invalid Consul tags: %s", cfg.ConsulInvalidTags)
+	}
+	if cfg.ConsulServiceAddress != "" {
+		if err := validateAddress(cfg.ConsulServiceAddress); err != nil {
+			return nil, err
+		}
+	}
+	config, err := clientConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 	client, err := api.NewClient(config)
 	if err != nil {
 		return nil, err
@@ -2,8 +2,15 @@ package consul
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil"
@@ -460,6 +467,337 @@ func TestIfPlaceholdersAreResolved(t *testing.T) {
 	requireEqualElements(t, expectedAdminTags, services[consulNameAdmin])
 }
 
+func TestIfRegistersServiceMetaFromLabels(t *testing.T) {
+	consulName := "serviceName"
+	version := "1.2.3"
+	adminURL := "http://localhost:{port:admin}/admin"
+	adminPortName := "admin"
+	taskInfo := prepareTaskInfo("taskId", consulName, consulName, nil, []mesos.Port{
+		{Number: 666},
+		{Number: 777, Name: &adminPortName},
+	})
+	taskInfo.TaskInfo.Labels.Labels = append(taskInfo.TaskInfo.Labels.Labels,
+		mesos.Label{Key: "consul-meta-version", Value: &version},
+		mesos.Label{Key: "consul-meta-admin-url", Value: &adminURL},
+	)
+
+	// Create a test Consul server
+	config, server := createTestConsulServer(t)
+	client, _ := api.NewClient(config) // #nosec
+	defer stopConsul(server)
+
+	h := &Hook{config: Config{ConsulGlobalTag: "marathon"}, client: client}
+	err := h.RegisterIntoConsul(taskInfo)
+	require.NoError(t, err)
+
+	services, err := client.Agent().Services()
+	require.NoError(t, err)
+	require.Contains(t, services, createServiceID("taskId", consulName, 666))
+	service := services[createServiceID("taskId", consulName, 666)]
+	require.Equal(t, map[string]string{"version": "1.2.3", "admin-url": "http://localhost:777/admin"}, service.Meta)
+	require.NotContains(t, service.Tags, "consul-meta-version")
+}
+
+func TestIfGetServiceMetaIgnoresLabelsWithoutPrefix(t *testing.T) {
+	value := "value"
+	labels := []mesos.Label{
+		{Key: "consul", Value: &value},
+		{Key: "consul-meta-", Value: &value},
+		{Key: "consul-meta-key", Value: &value},
+	}
+
+	require.Equal(t, map[string]string{"key": "value"}, getServiceMeta(labels, nil))
+	require.Nil(t, getServiceMeta(labels[:1], nil))
+}
+
+func TestIfTTLCheckIsPassedAndFailedOnHealthEvents(t *testing.T) {
+	consulName := "serviceName"
+	taskInfo := prepareTaskInfo("taskId", consulName, consulName, nil, []mesos.Port{{Number: 666}})
+	checkID := "service:" + createServiceID("taskId", consulName, 666)
+
+	// Create a test Consul server
+	config, server := createTestConsulServer(t)
+	client, _ := api.NewClient(config) // #nosec
+	defer stopConsul(server)
+
+	h := &Hook{config: Config{
+		ConsulGlobalTag:          "marathon",
+		ConsulCheckType:          "ttl",
+		ConsulCheckTTL:           time.Minute,
+		InitialHealthCheckStatus: "critical",
+	}, client: client}
+
+	_, err := h.HandleEvent(hook.Event{Type: hook.AfterTaskHealthyEvent, TaskInfo: taskInfo})
+	require.NoError(t, err)
+	_, err = h.HandleEvent(hook.Event{Type: hook.TaskHealthyEvent, TaskInfo: taskInfo})
+	require.NoError(t, err)
+
+	checks, err := client.Agent().Checks()
+	require.NoError(t, err)
+	require.Contains(t, checks, checkID)
+	require.Equal(t, api.HealthPassing, checks[checkID].Status)
+
+	_, err = h.HandleEvent(hook.Event{Type: hook.TaskUnhealthyEvent, TaskInfo: taskInfo})
+	require.NoError(t, err)
+
+	checks, err = client.Agent().Checks()
+	require.NoError(t, err)
+	require.Equal(t, api.HealthCritical, checks[checkID].Status)
+
+	_, err = h.HandleEvent(hook.Event{Type: hook.BeforeTerminateEvent, TaskInfo: taskInfo})
+	require.NoError(t, err)
+	require.Nil(t, h.ttlStop)
+}
+
+func TestIfGeneratesTTLCheckWhenConfigured(t *testing.T) {
+	h := &Hook{config: Config{ConsulCheckType: "ttl", ConsulCheckTTL: 30 * time.Second, InitialHealthCheckStatus: "passing"}}
+
+	check := h.generateHealthCheck(mesosutils.HealthCheck{Type: mesosutils.HTTP, Interval: time.Second}, "", 666)
+
+	require.Equal(t, &api.AgentServiceCheck{TTL: "30s", Status: "passing"}, check)
+}
+
+func TestIfSetsDeregisterCriticalServiceAfterOnlyWhenConfigured(t *testing.T) {
+	mesosCheck := mesosutils.HealthCheck{Type: mesosutils.TCP, Interval: time.Second, Timeout: time.Second}
+
+	h := &Hook{config: Config{ConsulDeregisterCriticalAfter: 10 * time.Minute}}
+	require.Equal(t, "10m0s", h.generateHealthCheck(mesosCheck, "", 666).DeregisterCriticalServiceAfter)
+
+	h = &Hook{config: Config{}}
+	require.Empty(t, h.generateHealthCheck(mesosCheck, "", 666).DeregisterCriticalServiceAfter)
+}
+
+func TestIfGeneratesTCPCheckForPortOfMesosCheck(t *testing.T) {
+	h := &Hook{config: Config{}}
+
+	check := h.generateHealthCheck(mesosutils.HealthCheck{Type: mesosutils.TCP, TCP: mesosutils.TCPCheck{Port: 777}}, "", 666)
+	require.Equal(t, "127.0.0.1:777", check.TCP)
+
+	check = h.generateHealthCheck(mesosutils.HealthCheck{Type: mesosutils.TCP}, "", 666)
+	require.Equal(t, "127.0.0.1:666", check.TCP)
+}
+
+func TestIfGeneratesHTTPCheckWithHostFromLabel(t *testing.T) {
+	host := "10.0.0.1"
+	taskInfo := prepareTaskInfo("taskId", "taskName", "taskName", nil, []mesos.Port{{Number: 666}})
+	taskInfo.TaskInfo.Labels.Labels = append(taskInfo.TaskInfo.Labels.Labels,
+		mesos.Label{Key: "health-check-host", Value: &host})
+	h := &Hook{config: Config{HealthCheckHost: "10.0.0.2"}}
+
+	require.Equal(t, "http://10.0.0.1:666/", h.generateServiceCheck(taskInfo, 666).HTTP)
+
+	taskInfo.TaskInfo.Labels.Labels = taskInfo.TaskInfo.Labels.Labels[:len(taskInfo.TaskInfo.Labels.Labels)-1]
+	require.Equal(t, "http://10.0.0.2:666/", h.generateServiceCheck(taskInfo, 666).HTTP)
+}
+
+func TestIfGeneratesHTTPCheckForPublicIPv6(t *testing.T) {
+	os.Setenv("CLOUD_PUBLIC_IP", "2001:db8::1")
+	defer os.Unsetenv("CLOUD_PUBLIC_IP")
+
+	require.Equal(t, "http://[2001:db8::1]:666/ping", generateURL("", "/ping", "", 666))
+
+	address, err := (&Hook{}).serviceAddress(mesosutils.TaskInfo{})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::1", address)
+}
+
+func TestIfGeneratesAdditionalChecksFromLabels(t *testing.T) {
+	path, enabled := "/ready", "true"
+	taskInfo := prepareTaskInfo("taskId", "taskName", "taskName", nil, []mesos.Port{{Number: 666}})
+	h := &Hook{config: Config{InitialHealthCheckStatus: "passing"}}
+	require.Empty(t, h.generateAdditionalChecks(taskInfo, 666))
+
+	taskInfo.TaskInfo.Labels.Labels = append(taskInfo.TaskInfo.Labels.Labels,
+		mesos.Label{Key: "consul-check-http", Value: &path},
+		mesos.Label{Key: "consul-check-tcp", Value: &enabled})
+	checks := h.generateAdditionalChecks(taskInfo, 666)
+
+	require.Len(t, checks, 2)
+	require.Equal(t, "http://127.0.0.1:666/ready", checks[0].HTTP)
+	require.Equal(t, "127.0.0.1:666", checks[1].TCP)
+	for _, check := range checks {
+		require.Equal(t, "passing", check.Status)
+		require.NotEmpty(t, check.Interval)
+		require.NotEmpty(t, check.Timeout)
+	}
+}
+
+func TestIfSetsUniqueCheckIDsOnlyWhenThereAreAdditionalChecks(t *testing.T) {
+	registration := &api.AgentServiceRegistration{ID: "service", Check: &api.AgentServiceCheck{}}
+	setCheckIDs(registration)
+	require.Empty(t, registration.Check.CheckID)
+
+	registration.Checks = api.AgentServiceChecks{{}, {}}
+	setCheckIDs(registration)
+
+	require.Equal(t, "service:service", registration.Check.CheckID)
+	require.Equal(t, "service:service:1", registration.Checks[0].CheckID)
+	require.Equal(t, "service:service:2", registration.Checks[1].CheckID)
+}
+
+func TestIfDoesNotGenerateCheckForCommandHealthCheck(t *testing.T) {
+	h := &Hook{config: Config{}}
+	mesosCheck := mesosutils.HealthCheck{Type: mesosutils.COMMAND, Command: mesosutils.CommandCheck{Value: "true", Shell: true}}
+
+	require.Nil(t, h.generateHealthCheck(mesosCheck, "", 666))
+}
+
+func TestIfCheckIntervalAndTimeoutCanBeOverridden(t *testing.T) {
+	mesosCheck := mesosutils.HealthCheck{Type: mesosutils.HTTP, Interval: time.Second, Timeout: time.Second}
+
+	h := &Hook{config: Config{ConsulCheckInterval: "30s", ConsulCheckTimeout: "5s"}}
+	check := h.generateHealthCheck(mesosCheck, "", 666)
+	require.Equal(t, "30s", check.Interval)
+	require.Equal(t, "5s", check.Timeout)
+
+	h = &Hook{config: Config{}}
+	check = h.generateHealthCheck(mesosCheck, "", 666)
+	require.Equal(t, "1s", check.Interval)
+	require.Equal(t, "1s", check.Timeout)
+}
+
+func TestIfNewHookFailsOnInvalidCheckInterval(t *testing.T) {
+	_, err := NewHook(Config{Enabled: true, ConsulCheckInterval: "often"})
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid Consul check interval")
+}
+
+func TestIfGeneratesGRPCCheckWhenLabelIsPresent(t *testing.T) {
+	enabled := "true"
+	taskInfo := prepareTaskInfo("taskId", "taskName", "taskName", nil, []mesos.Port{{Number: 666}})
+	h := &Hook{config: Config{ConsulCheckInterval: "30s", HealthCheckHost: "10.0.0.1"}}
+
+	check := h.generateServiceCheck(taskInfo, 666)
+	require.NotEmpty(t, check.HTTP)
+	require.Empty(t, check.GRPC)
+
+	taskInfo.TaskInfo.Labels.Labels = append(taskInfo.TaskInfo.Labels.Labels,
+		mesos.Label{Key: "consul-check-grpc", Value: &enabled},
+		mesos.Label{Key: "consul-check-grpc-tls", Value: &enabled},
+	)
+	check = h.generateServiceCheck(taskInfo, 666)
+
+	require.Empty(t, check.HTTP)
+	require.Equal(t, "10.0.0.1:666", check.GRPC)
+	require.True(t, check.GRPCUseTLS)
+	require.Equal(t, "30s", check.Interval)
+	require.Equal(t, "5s", check.Timeout)
+}
+
+func TestIfGeneratesGRPCCheckForTaskWithoutMesosHealthCheck(t *testing.T) {
+	enabled := "true"
+	taskInfo := prepareTaskInfo("taskId", "taskName", "taskName", nil, []mesos.Port{{Number: 666}})
+	taskInfo.TaskInfo.HealthCheck = nil
+	taskInfo.TaskInfo.Labels.Labels = append(taskInfo.TaskInfo.Labels.Labels,
+		mesos.Label{Key: "consul-check-grpc", Value: &enabled},
+	)
+	h := &Hook{config: Config{HealthCheckHost: "10.0.0.1"}}
+
+	check := h.generateServiceCheck(taskInfo, 666)
+
+	require.NotNil(t, check)
+	require.Equal(t, "10.0.0.1:666", check.GRPC)
+	require.False(t, check.GRPCUseTLS)
+	require.Equal(t, "10s", check.Interval)
+	require.Equal(t, "20s", check.Timeout)
+}
+
+func TestIfGeneratesHTTPCheckWithSchemeFromLabel(t *testing.T) {
+	scheme := "https"
+	taskInfo := prepareTaskInfo("taskId", "taskName", "taskName", nil, []mesos.Port{{Number: 666}})
+	h := &Hook{}
+
+	check := h.generateServiceCheck(taskInfo, 666)
+	require.True(t, strings.HasPrefix(check.HTTP, "http://"), check.HTTP)
+	require.False(t, check.TLSSkipVerify)
+
+	taskInfo.TaskInfo.Labels.Labels = append(taskInfo.TaskInfo.Labels.Labels,
+		mesos.Label{Key: "consul-check-scheme", Value: &scheme},
+	)
+	check = h.generateServiceCheck(taskInfo, 666)
+
+	require.True(t, strings.HasPrefix(check.HTTP, "https://"), check.HTTP)
+	require.False(t, check.TLSSkipVerify)
+}
+
+func TestIfSkipsTLSVerificationOfHTTPSChecksWhenRequestedByLabel(t *testing.T) {
+	scheme, skip, path := "https", "true", "/ready"
+	taskInfo := prepareTaskInfo("taskId", "taskName", "taskName", nil, []mesos.Port{{Number: 666}})
+	taskInfo.TaskInfo.Labels.Labels = append(taskInfo.TaskInfo.Labels.Labels,
+		mesos.Label{Key: "consul-check-scheme", Value: &scheme},
+		mesos.Label{Key: "consul-check-tls-skip-verify", Value: &skip},
+		mesos.Label{Key: "consul-check-http", Value: &path},
+	)
+	h := &Hook{}
+
+	check := h.generateServiceCheck(taskInfo, 666)
+	require.True(t, check.TLSSkipVerify)
+
+	checks := h.generateAdditionalChecks(taskInfo, 666)
+	require.Len(t, checks, 1)
+	require.True(t, strings.HasPrefix(checks[0].HTTP, "https://"), checks[0].HTTP)
+	require.True(t, checks[0].TLSSkipVerify)
+}
+
+func TestIfTTLUpdatesStopOnDeregistration(t *testing.T) {
+	config := api.DefaultConfig()
+	config.Address = "http://localhost:5200"
+	client, _ := api.NewClient(config) // #nosec
+	h := &Hook{config: Config{ConsulCheckType: "ttl", ConsulCheckTTL: 3 * time.Millisecond}, client: client}
+
+	h.startTTLUpdates()
+	require.NotNil(t, h.ttlStop)
+	done := h.ttlDone
+	time.Sleep(10 * time.Millisecond)
+
+	err := h.DeregisterFromConsul(mesosutils.TaskInfo{})
+
+	require.NoError(t, err)
+	require.Nil(t, h.ttlStop)
+	select {
+	case <-done:
+	default:
+		t.Error("TTL updates goroutine should be stopped")
+	}
+}
+
+func TestIfWaitsUntilRegisteredInstancesAreResolvable(t *testing.T) {
+	consulName := "serviceName"
+	taskInfo := prepareTaskInfo("taskId", consulName, consulName, nil, []mesos.Port{{Number: 666}})
+
+	// Create a test Consul server
+	config, server := createTestConsulServer(t)
+	client, _ := api.NewClient(config) // #nosec
+	defer stopConsul(server)
+
+	h := &Hook{config: Config{
+		ConsulGlobalTag:           "marathon",
+		ConsulWaitForRegistration: true,
+		ConsulRegistrationTimeout: 5 * time.Second,
+	}, client: client}
+	err := h.RegisterIntoConsul(taskInfo)
+
+	require.NoError(t, err)
+	services, _, err := client.Catalog().Service(consulName, "", nil)
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	require.Equal(t, createServiceID("taskId", consulName, 666), services[0].ServiceID)
+}
+
+func TestIfWaitingForRegistrationTimesOut(t *testing.T) {
+	config := api.DefaultConfig()
+	config.Address = "http://localhost:5200"
+	client, _ := api.NewClient(config) // #nosec
+	h := &Hook{config: Config{ConsulRegistrationTimeout: 200 * time.Millisecond}, client: client}
+
+	start := time.Now()
+	err := h.waitUntilResolvable(instance{consulServiceName: "service", consulServiceID: "service_id"})
+
+	require.EqualError(t, err, `service ID "service_id" not found in catalog after 200ms`)
+	require.True(t, time.Since(start) < time.Second)
+}
+
 func TestIfErrorHandledOnNoConsul(t *testing.T) {
 	consulName := "consulName"
 	taskID := "taskID"
@@ -485,6 +823,132 @@ func TestIfNewHookCreatesNoopHookWhenHookDisabled(t *testing.T) {
 	require.IsType(t, hook.NoopHook{}, h)
 }
 
+func TestIfClientConfigReadsTokenFromFile(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "token")
+	require.NoError(t, err)
+	defer os.Remove(tokenFile.Name())
+	_, err = tokenFile.WriteString("file-token\n")
+	require.NoError(t, err)
+	require.NoError(t, tokenFile.Close())
+
+	config, err := clientConfig(Config{
+		ConsulToken:     "env-token",
+		ConsulTokenFile: tokenFile.Name(),
+		ConsulScheme:    "https",
+		ConsulCAFile:    "/ca.pem",
+		ConsulCertFile:  "/cert.pem",
+		ConsulKeyFile:   "/key.pem",
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "file-token", config.Token)
+	require.Equal(t, "https", config.Scheme)
+	require.Equal(t, "/ca.pem", config.TLSConfig.CAFile)
+	require.Equal(t, "/cert.pem", config.TLSConfig.CertFile)
+	require.Equal(t, "/key.pem", config.TLSConfig.KeyFile)
+}
+
+func TestIfNewHookFailsOnUnreadableTokenFile(t *testing.T) {
+	_, err := NewHook(Config{Enabled: true, ConsulToken: "token", ConsulTokenFile: "/nonexistent/token"})
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unable to read Consul token file")
+}
+
+func TestIfServiceAddressCanBeOverridden(t *testing.T) {
+	os.Setenv("CLOUD_PUBLIC_IP", "10.0.0.1")
+	defer os.Unsetenv("CLOUD_PUBLIC_IP")
+	labelled := func(address string) mesosutils.TaskInfo {
+		return mesosutils.TaskInfo{TaskInfo: mesos.TaskInfo{Labels: &mesos.Labels{Labels: []mesos.Label{
+			{Key: addressLabelKey, Value: &address},
+		}}}}
+	}
+
+	testCases := []struct {
+		config   string
+		taskInfo mesosutils.TaskInfo
+		expected string
+	}{
+		{"", mesosutils.TaskInfo{}, "10.0.0.1"},
+		{"192.168.0.1", mesosutils.TaskInfo{}, "192.168.0.1"},
+		{"192.168.0.1", labelled("service.example.com"), "service.example.com"},
+		{"", labelled("fe80::1"), "fe80::1"},
+	}
+	for _, tc := range testCases {
+		h := &Hook{config: Config{ConsulServiceAddress: tc.config}}
+		address, err := h.serviceAddress(tc.taskInfo)
+
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, address)
+	}
+
+	h := &Hook{}
+	_, err := h.serviceAddress(labelled("not a host:80"))
+	require.Error(t, err)
+}
+
+func TestIfNewHookFailsOnInvalidServiceAddress(t *testing.T) {
+	_, err := NewHook(Config{Enabled: true, ConsulServiceAddress: "http://host"})
+
+	require.Error(t, err)
+}
+
+func TestIfDeregistersStaleInstancesOfTheSameTask(t *testing.T) {
+	var deregistered []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/agent/services":
+			_, _ = w.Write([]byte(`{
+				"task_service_1":  {"ID": "task_service_1", "Tags": ["marathon-task:task"]},
+				"task_service_2":  {"ID": "task_service_2", "Tags": ["marathon-task:task"]},
+				"other_service_1": {"ID": "other_service_1", "Tags": ["marathon-task:other"]}
+			}`))
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+			deregistered = append(deregistered, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+		}
+	}))
+	defer server.Close()
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	client, err := api.NewClient(config)
+	require.NoError(t, err)
+
+	h := &Hook{config: Config{ConsulDeregisterStale: true}, client: client}
+	h.deregisterStaleInstances("task", []instance{{consulServiceID: "task_service_2"}})
+
+	require.Equal(t, []string{"task_service_1"}, deregistered)
+}
+
+func TestIfClientConfigSetsNamespaceAndPartitionOnEveryRequest(t *testing.T) {
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+	}))
+	defer server.Close()
+
+	config, err := clientConfig(Config{ConsulNamespace: "team", ConsulPartition: "part"})
+	require.NoError(t, err)
+	config.Address = server.URL
+	client, err := api.NewClient(config)
+	require.NoError(t, err)
+
+	require.NoError(t, client.Agent().ServiceRegister(&api.AgentServiceRegistration{ID: "id", Name: "name"}))
+	require.NoError(t, client.Agent().ServiceDeregister("id"))
+
+	require.Len(t, queries, 2)
+	for _, query := range queries {
+		require.Equal(t, "team", query.Get("ns"))
+		require.Equal(t, "part", query.Get("partition"))
+	}
+}
+
+func TestIfClientConfigUsesDefaultNamespaceWhenNotConfigured(t *testing.T) {
+	config, err := clientConfig(Config{})
+
+	require.NoError(t, err)
+	require.Nil(t, config.HttpClient)
+}
+
 func stopConsul(server *testutil.TestServer) {
 	_ = server.Stop()
 }
@@ -513,7 +977,7 @@ func prepareTaskInfo(taskID string, taskName string, consulName string, tags []s
 
 	healthPort := ports[0].GetNumber()
 
-	return mesosutils.TaskInfo{mesos.TaskInfo{
+	return mesosutils.TaskInfo{TaskInfo: mesos.TaskInfo{
 		Discovery: &mesos.DiscoveryInfo{
 			Ports: &mesos.Ports{
 				Ports: ports,
@@ -547,3 +1011,47 @@ func createTestConsulServer(t *testing.T) (config *api.Config, server *testutil.
 	config.Address = server.HTTPAddr
 	return config, server
 }
+
+func TestIfSanitizesTagsAsConfigured(t *testing.T) {
+	tags := []string{" marathon\n", "admin-port:655", "{port:unknown} is not replaced", "\t"}
+
+	h := &Hook{config: Config{}}
+	sanitized, err := h.sanitizeTags(tags)
+	require.NoError(t, err)
+	require.Equal(t, []string{"marathon", "admin-port:655", "{port:unknown} is not replaced", ""}, sanitized)
+
+	h = &Hook{config: Config{ConsulInvalidTags: "drop"}}
+	sanitized, err = h.sanitizeTags(tags)
+	require.NoError(t, err)
+	require.Equal(t, []string{"marathon", "admin-port:655"}, sanitized)
+
+	h = &Hook{config: Config{ConsulInvalidTags: "fail"}}
+	_, err = h.sanitizeTags(tags)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "{port:unknown}")
+}
+
+func TestIfTooLongTagsAreInvalid(t *testing.T) {
+	longest := strings.Repeat("a", 255)
+	tags := []string{"marathon", longest, longest + "a"}
+
+	h := &Hook{config: Config{}}
+	sanitized, err := h.sanitizeTags(tags)
+	require.NoError(t, err)
+	require.Equal(t, tags, sanitized)
+
+	h = &Hook{config: Config{ConsulInvalidTags: "drop"}}
+	sanitized, err = h.sanitizeTags(tags)
+	require.NoError(t, err)
+	require.Equal(t, []string{"marathon", longest}, sanitized)
+
+	h = &Hook{config: Config{ConsulInvalidTags: "fail"}}
+	_, err = h.sanitizeTags(tags)
+	require.Error(t, err)
+}
+
+func TestIfNewHookFailsOnUnknownInvalidTagsHandling(t *testing.T) {
+	_, err := NewHook(Config{Enabled: true, ConsulInvalidTags: "ignore"})
+
+	require.Error(t, err)
+}
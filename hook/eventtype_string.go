@@ -4,9 +4,9 @@ package hook
 
 import "fmt"
 
-const _EventType_name = "BeforeTaskStartEventAfterTaskStartEventBeforeTerminateEvent"
+const _EventType_name = "BeforeTaskStartEventAfterTaskHealthyEventBeforeTerminateEventTaskHealthyEventTaskUnhealthyEventAfterTaskStartEventFrameworkMessageEvent"
 
-var _EventType_index = [...]uint8{0, 20, 39, 59}
+var _EventType_index = [...]uint8{0, 20, 41, 61, 77, 95, 114, 135}
 
 func (i EventType) String() string {
 	if i < 0 || i >= EventType(len(_EventType_index)-1) {
@@ -1,35 +1,133 @@
 package exec
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
+	"regexp"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/allegro/mesos-executor/hook"
+	"github.com/allegro/mesos-executor/runenv"
 )
 
 // Hook is an executor hook implementation that will call defined external commands
 // on specified hook events.
 type Hook struct {
-	commands map[hook.EventType]*exec.Cmd
+	commands map[hook.EventType][]command
 }
 
-// HandleEvent calls configured external command (if it is specified) for given
-// hook event.
+// command is an external command definition. New exec.Cmd is created for
+// every call, because exec.Cmd can not be reused.
+type command struct {
+	name string
+	args []string
+	// captureEnv makes the hook return command output as environment
+	captureEnv bool
+}
+
+var invalidEnvKeyChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// HandleEvent calls configured external commands (if they are specified) for
+// given hook event in registration order. Task ID, ports and labels are passed
+// to the commands in HOOK_* environment variables, framework message data is
+// passed on their standard input.
 func (h *Hook) HandleEvent(event hook.Event) (hook.Env, error) {
-	if cmd, ok := h.commands[event.Type]; ok {
-		log.WithField("path", cmd.Path).WithField("args", cmd.Args).Info("Running hook command")
-		return nil, cmd.Run()
+	commands, ok := h.commands[event.Type]
+	if !ok {
+		log.Debugf("Received unsupported event type %s - ignoring", event.Type)
+		return nil, nil // ignore unsupported events
+	}
+
+	if len(commands) == 1 {
+		return commands[0].run(event)
+	}
+
+	var env hook.Env
+	var messages []string
+	for _, command := range commands {
+		commandEnv, err := command.run(event)
+		if err != nil {
+			log.WithError(err).Errorf("Hook command %s failed to handle %s", command.name, event.Type)
+			messages = append(messages, fmt.Sprintf("%s: %s", command.name, err))
+			continue
+		}
+		env = append(env, commandEnv...)
+	}
+
+	if len(messages) > 0 {
+		return nil, fmt.Errorf("%d hook commands failed to handle %s: %s", len(messages), event.Type, strings.Join(messages, "; "))
+	}
+	return env, nil
+}
+
+func (c command) run(event hook.Event) (hook.Env, error) {
+	cmd := exec.Command(c.name, c.args...) // #nosec
+	cmd.Env = append(runenv.EnvWithoutExecutorConfig(), eventEnv(event)...)
+	if len(event.Message) > 0 {
+		cmd.Stdin = bytes.NewReader(event.Message)
+	}
+	log.WithField("path", cmd.Path).WithField("args", cmd.Args).Info("Running hook command")
+	if c.captureEnv {
+		return runCapturingEnv(cmd)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return nil, cmd.Run()
+}
+
+// runCapturingEnv runs command and parses its output as KEY=VALUE lines. Empty
+// lines and lines starting with # are ignored.
+func runCapturingEnv(cmd *exec.Cmd) (hook.Env, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var env hook.Env
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Index(line, "=") < 1 {
+			return nil, fmt.Errorf("invalid environment variable in command output: %q", line)
+		}
+		env = append(env, line)
 	}
-	log.Debugf("Received unsupported event type %s - ignoring", event.Type)
-	return nil, nil // ignore unsupported events
+	return env, nil
+}
+
+// eventEnv returns environment variables describing the event and its task,
+// e.g. HOOK_TASK_ID=id, HOOK_TASK_PORTS=31000,31001 or HOOK_TASK_LABEL_CONSUL=name.
+func eventEnv(event hook.Event) []string {
+	env := []string{
+		fmt.Sprintf("HOOK_EVENT=%s", event.Type),
+		fmt.Sprintf("HOOK_TASK_ID=%s", event.TaskInfo.GetTaskID()),
+	}
+
+	var ports []string
+	for _, port := range event.TaskInfo.GetPorts() {
+		ports = append(ports, fmt.Sprint(port.GetNumber()))
+	}
+	env = append(env, fmt.Sprintf("HOOK_TASK_PORTS=%s", strings.Join(ports, ",")))
+
+	for _, label := range event.TaskInfo.TaskInfo.GetLabels().GetLabels() {
+		key := invalidEnvKeyChars.ReplaceAllString(strings.ToUpper(label.GetKey()), "_")
+		env = append(env, fmt.Sprintf("HOOK_TASK_LABEL_%s=%s", key, label.GetValue()))
+	}
+	return env
 }
 
 // NewHook creates new exec hook with specified commands.
 func NewHook(commands ...func(*Hook)) hook.Hook {
 	h := &Hook{
-		commands: make(map[hook.EventType]*exec.Cmd),
+		commands: make(map[hook.EventType][]command),
 	}
 	for _, command := range commands {
 		command(h)
@@ -37,13 +135,19 @@ func NewHook(commands ...func(*Hook)) hook.Hook {
 	return h
 }
 
-// HookCommand sets a new command that will be run on specified event type. Only
-// one command can be configured for each event type.
+// HookCommand adds a new command that will be run on specified event type.
+// Commands configured for the same event type are run in registration order.
 func HookCommand(eventType hook.EventType, name string, arg ...string) func(*Hook) {
 	return func(h *Hook) {
-		cmd := exec.Command(name, arg...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		h.commands[eventType] = cmd
+		h.commands[eventType] = append(h.commands[eventType], command{name: name, args: arg})
+	}
+}
+
+// EnvHookCommand adds a new command that will be run on specified event type.
+// Command output is parsed as KEY=VALUE lines and returned as environment (e.g.
+// for the task on BeforeTaskStartEvent).
+func EnvHookCommand(eventType hook.EventType, name string, arg ...string) func(*Hook) {
+	return func(h *Hook) {
+		h.commands[eventType] = append(h.commands[eventType], command{name: name, args: arg, captureEnv: true})
 	}
 }
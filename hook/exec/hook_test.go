@@ -1,11 +1,17 @@
 package exec
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	mesos "github.com/mesos/mesos-go/api/v1/lib"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/allegro/mesos-executor/hook"
+	"github.com/allegro/mesos-executor/mesosutils"
 )
 
 func TestIfFailsToRunInvalidCommand(t *testing.T) {
@@ -23,3 +29,105 @@ func TestIfRunsValidCommand(t *testing.T) {
 
 	assert.NoError(t, err)
 }
+
+func TestIfRunsCommandAgain(t *testing.T) {
+	h := NewHook(HookCommand(hook.TaskHealthyEvent, "true"))
+
+	_, err := h.HandleEvent(hook.Event{Type: hook.TaskHealthyEvent})
+	assert.NoError(t, err)
+	_, err = h.HandleEvent(hook.Event{Type: hook.TaskHealthyEvent})
+	assert.NoError(t, err)
+}
+
+func TestIfPassesTaskInfoAsEnvWithoutExecutorConfig(t *testing.T) {
+	os.Setenv("ALLEGRO_EXECUTOR_SECRET", "secret")
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_SECRET")
+	consul := "service"
+	event := hook.Event{Type: hook.AfterTaskHealthyEvent, TaskInfo: mesosutils.TaskInfo{TaskInfo: mesos.TaskInfo{
+		TaskID: mesos.TaskID{Value: "task-id"},
+		Labels: &mesos.Labels{Labels: []mesos.Label{{Key: "consul", Value: &consul}}},
+		Discovery: &mesos.DiscoveryInfo{Ports: &mesos.Ports{Ports: []mesos.Port{
+			{Number: 31000}, {Number: 31001},
+		}}},
+	}}}
+
+	h := NewHook(HookCommand(hook.AfterTaskHealthyEvent, "sh", "-c",
+		`test "$HOOK_EVENT $HOOK_TASK_ID $HOOK_TASK_PORTS $HOOK_TASK_LABEL_CONSUL" = `+
+			`"AfterTaskHealthyEvent task-id 31000,31001 service" && test -z "$ALLEGRO_EXECUTOR_SECRET"`))
+
+	_, err := h.HandleEvent(event)
+
+	assert.NoError(t, err)
+}
+
+func TestIfReturnsCommandOutputAsEnv(t *testing.T) {
+	h := NewHook(EnvHookCommand(hook.BeforeTaskStartEvent, "sh", "-c", `printf "# comment\nSECRET=value\n\nOTHER=a=b\n"`))
+
+	env, err := h.HandleEvent(hook.Event{Type: hook.BeforeTaskStartEvent})
+
+	assert.NoError(t, err)
+	assert.Equal(t, hook.Env{"SECRET=value", "OTHER=a=b"}, env)
+}
+
+func TestIfPassesFrameworkMessageOnCommandStdin(t *testing.T) {
+	h := NewHook(EnvHookCommand(hook.FrameworkMessageEvent, "sh", "-c", `echo "MESSAGE=$(cat)"`))
+
+	env, err := h.HandleEvent(hook.Event{Type: hook.FrameworkMessageEvent, Message: []byte("reload")})
+
+	assert.NoError(t, err)
+	assert.Equal(t, hook.Env{"MESSAGE=reload"}, env)
+}
+
+func TestIfReturnsStderrOfFailedEnvCommand(t *testing.T) {
+	h := NewHook(EnvHookCommand(hook.BeforeTaskStartEvent, "sh", "-c", "echo 'no secret' >&2; exit 1"))
+
+	_, err := h.HandleEvent(hook.Event{Type: hook.BeforeTaskStartEvent})
+
+	assert.EqualError(t, err, "exit status 1: no secret")
+}
+
+func TestIfFailsOnMalformedEnvCommandOutput(t *testing.T) {
+	h := NewHook(EnvHookCommand(hook.BeforeTaskStartEvent, "echo", "not env"))
+
+	_, err := h.HandleEvent(hook.Event{Type: hook.BeforeTaskStartEvent})
+
+	assert.Error(t, err)
+}
+
+func TestIfRunsAllCommandsOfEventTypeInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-hook")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "output")
+	h := NewHook(
+		HookCommand(hook.BeforeTerminateEvent, "sh", "-c", "echo first >> "+output),
+		EnvHookCommand(hook.BeforeTerminateEvent, "echo", "A=1"),
+		HookCommand(hook.BeforeTerminateEvent, "sh", "-c", "echo second >> "+output),
+	)
+
+	env, err := h.HandleEvent(hook.Event{Type: hook.BeforeTerminateEvent})
+
+	require.NoError(t, err)
+	assert.Equal(t, hook.Env{"A=1"}, env)
+	content, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(content))
+}
+
+func TestIfRunsRemainingCommandsAndAggregatesErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-hook")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "output")
+	h := NewHook(
+		HookCommand(hook.BeforeTerminateEvent, "false"),
+		HookCommand(hook.BeforeTerminateEvent, "sh", "-c", "echo run >> "+output),
+		HookCommand(hook.BeforeTerminateEvent, "non-existing-command"),
+	)
+
+	_, err = h.HandleEvent(hook.Event{Type: hook.BeforeTerminateEvent})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 hook commands failed to handle BeforeTerminateEvent")
+	assert.FileExists(t, output)
+}
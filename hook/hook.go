@@ -17,6 +17,20 @@ const (
 	// BeforeTerminateEvent is an event type that occurs right before task is terminated.
 	// It is guaranteed to occur in task lifecycle and to be last event received.
 	BeforeTerminateEvent
+	// TaskHealthyEvent is an event type that occurs every time task health check
+	// state changes to healthy, including the first successful pass.
+	TaskHealthyEvent
+	// TaskUnhealthyEvent is an event type that occurs every time task health check
+	// state changes to unhealthy.
+	TaskUnhealthyEvent
+	// AfterTaskStartEvent is an event type that occurs right after task process
+	// is started, before its health is checked. It is not guaranteed to occur in
+	// task lifecycle, e.g. when task fails to start.
+	AfterTaskStartEvent
+	// FrameworkMessageEvent is an event type that occurs every time framework
+	// sends a message to the executor. Message data is passed in the event
+	// Message field.
+	FrameworkMessageEvent
 )
 
 // NoopHook is a hook that ignores all events
@@ -32,11 +46,22 @@ func (noop NoopHook) HandleEvent(event Event) (Env, error) {
 type Event struct {
 	Type     EventType
 	TaskInfo mesosutils.TaskInfo
+	// Message contains data sent by the framework with FrameworkMessageEvent
+	Message []byte
 }
 
 // Env is a container for os.Environ style list of combined environment variable strings.
 type Env []string
 
+// Stopper is an optional interface for hooks that perform long running
+// operations (e.g., retries) that should be interrupted when executor is asked
+// to kill the task.
+type Stopper interface {
+	// Stop interrupts pending operations. It may be called concurrently with
+	// HandleEvent and hook should still handle the BeforeTerminateEvent after it.
+	Stop()
+}
+
 // Hook is an interface for various executor extensions, that can add some actions
 // during task lifecycle events.
 type Hook interface {
@@ -1,6 +1,11 @@
 package hook
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -8,27 +13,165 @@ import (
 // returned errors.
 type Manager struct {
 	Hooks []Hook
+	// Parallel makes manager call all hooks concurrently instead of one by one
+	Parallel bool
+	// Timeout bounds time of a single hook call, there is no limit when it is 0
+	Timeout time.Duration
+
+	mutex sync.Mutex
+	locks []*sync.Mutex
 }
 
 // HandleEvent calls group of hooks sequentially. It returns error on first hook
 // call error when ignoreErrors argument is false. When ignoreErrors is set to
 // true it will only log errors returned from each hook and will never return an
-// error itself.
+// error itself. Hook call that exceeds manager timeout fails with timeout error
+// and the hook is stopped when it implements Stopper. Hook is never called
+// concurrently, so next events are passed to it after the timed out call
+// returns. In parallel mode all hooks are called concurrently and errors
+// returned by them are combined into one.
 func (m *Manager) HandleEvent(event Event, ignoreErrors bool) (Env, error) {
+	if m.Parallel {
+		return m.handleEventInParallel(event, ignoreErrors)
+	}
+
 	var combinedEnv = Env{}
-	for _, hook := range m.Hooks {
+	for i, hook := range m.Hooks {
 		log.Infof("Calling %T hook to handle %s", hook, event.Type)
 
-		moreEnvValues, err := hook.HandleEvent(event)
+		moreEnvValues, err := m.callHook(i, hook, event)
+		if err == nil {
+			combinedEnv, err = mergeEnv(combinedEnv, moreEnvValues, hook)
+		}
 		if err != nil {
 			if !ignoreErrors {
 				return nil, err
 			}
 			log.WithError(err).Errorf("%T hook failed to handle %s", hook, event.Type)
-		} else {
-			combinedEnv = append(combinedEnv, moreEnvValues...)
 		}
 	}
 
 	return combinedEnv, nil
 }
+
+func (m *Manager) handleEventInParallel(event Event, ignoreErrors bool) (Env, error) {
+	envs := make([]Env, len(m.Hooks))
+	errs := make([]error, len(m.Hooks))
+	var wg sync.WaitGroup
+	for i, hook := range m.Hooks {
+		wg.Add(1)
+		go func(i int, hook Hook) {
+			defer wg.Done()
+			log.Infof("Calling %T hook to handle %s", hook, event.Type)
+			envs[i], errs[i] = m.callHook(i, hook, event)
+		}(i, hook)
+	}
+	wg.Wait()
+
+	// environment is combined in hooks order, so the result is deterministic
+	var combinedEnv = Env{}
+	var messages []string
+	for i, hook := range m.Hooks {
+		err := errs[i]
+		if err == nil {
+			combinedEnv, err = mergeEnv(combinedEnv, envs[i], hook)
+		}
+		if err != nil {
+			log.WithError(err).Errorf("%T hook failed to handle %s", hook, event.Type)
+			messages = append(messages, fmt.Sprintf("%T: %s", hook, err))
+		}
+	}
+
+	if len(messages) > 0 && !ignoreErrors {
+		return nil, fmt.Errorf("%d hooks failed to handle %s: %s", len(messages), event.Type, strings.Join(messages, "; "))
+	}
+	return combinedEnv, nil
+}
+
+// mergeEnv adds variables returned by the hook to the environment. Values of
+// variables set by previous hooks are replaced. It fails without modifying the
+// environment when any variable is not in KEY=VALUE form.
+func mergeEnv(env Env, values Env, hook Hook) (Env, error) {
+	for _, value := range values {
+		if strings.Index(value, "=") < 1 {
+			return env, fmt.Errorf("%T hook returned malformed environment variable %q", hook, value)
+		}
+	}
+
+	for _, value := range values {
+		key := value[:strings.Index(value, "=")+1]
+		replaced := false
+		for i, existing := range env {
+			if strings.HasPrefix(existing, key) {
+				if existing != value {
+					log.Warnf("%T hook overrides environment variable %s", hook, strings.TrimSuffix(key, "="))
+				}
+				env[i] = value
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			env = append(env, value)
+		}
+	}
+	return env, nil
+}
+
+type hookResult struct {
+	env Env
+	err error
+}
+
+// callHook calls i-th hook after its previous call returned. When the call
+// exceeds manager timeout the hook is stopped, so its pending operations are
+// interrupted. Waiting for the previous call does not count to the timeout.
+func (m *Manager) callHook(i int, hook Hook, event Event) (Env, error) {
+	lock := m.hookLock(i)
+	lock.Lock()
+	if m.Timeout <= 0 {
+		defer lock.Unlock()
+		return hook.HandleEvent(event)
+	}
+
+	result := make(chan hookResult, 1) // buffered, so timed out hook will not leak blocked
+	go func() {
+		defer lock.Unlock() // released when the call returns, also after the timeout
+		env, err := hook.HandleEvent(event)
+		result <- hookResult{env: env, err: err}
+	}()
+
+	timer := time.NewTimer(m.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		return r.env, r.err
+	case <-timer.C:
+		if stopper, ok := hook.(Stopper); ok {
+			log.Warnf("Stopping %T hook that did not handle %s in time", hook, event.Type)
+			stopper.Stop()
+		}
+		return nil, fmt.Errorf("%T hook did not handle %s within %s", hook, event.Type, m.Timeout)
+	}
+}
+
+// hookLock returns a lock serializing calls of i-th hook.
+func (m *Manager) hookLock(i int) *sync.Mutex {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for len(m.locks) <= i {
+		m.locks = append(m.locks, &sync.Mutex{})
+	}
+	return m.locks[i]
+}
+
+// Stop interrupts pending operations of hooks that implement Stopper interface.
+func (m *Manager) Stop() {
+	for _, hook := range m.Hooks {
+		if stopper, ok := hook.(Stopper); ok {
+			log.Infof("Stopping %T hook", hook)
+			stopper.Stop()
+		}
+	}
+}
@@ -2,10 +2,13 @@ package hook
 
 import (
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIfFailsOnFirstError(t *testing.T) {
@@ -40,6 +43,161 @@ func TestIfIgnoresErrors(t *testing.T) {
 	hook2.AssertExpectations(t)
 }
 
+func TestIfCallsAllHooksInParallelAndCombinesErrors(t *testing.T) {
+	hook1 := new(mockHook)
+	hook1.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{}, errors.New("first")).Once()
+	hook2 := new(mockHook)
+	hook2.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"A=1"}, nil).Once()
+	hook3 := new(mockHook)
+	hook3.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{}, errors.New("third")).Once()
+
+	manager := Manager{Hooks: []Hook{hook1, hook2, hook3}, Parallel: true}
+	_, err := manager.HandleEvent(Event{}, false)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "first")
+	assert.Contains(t, err.Error(), "third")
+	hook1.AssertExpectations(t)
+	hook2.AssertExpectations(t)
+	hook3.AssertExpectations(t)
+}
+
+func TestIfMergesEnvInHooksOrderInParallelMode(t *testing.T) {
+	hook1 := new(mockHook)
+	hook1.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"A=1"}, nil).Once()
+	hook2 := new(mockHook)
+	hook2.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{}, errors.New("test")).Once()
+	hook3 := new(mockHook)
+	hook3.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"B=2", "C=3"}, nil).Once()
+
+	manager := Manager{Hooks: []Hook{hook1, hook2, hook3}, Parallel: true}
+	env, err := manager.HandleEvent(Event{}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Env{"A=1", "B=2", "C=3"}, env)
+}
+
+func TestIfFailsWhenHookExceedsTimeout(t *testing.T) {
+	slow := new(mockHook)
+	slow.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{}, nil).After(time.Second).Once()
+	next := new(mockHook)
+
+	manager := Manager{Hooks: []Hook{slow, next}, Timeout: 10 * time.Millisecond}
+	_, err := manager.HandleEvent(Event{}, false)
+
+	assert.Error(t, err)
+	next.AssertNotCalled(t, "HandleEvent")
+}
+
+func TestIfCallsNextHookWhenIgnoredHookExceedsTimeout(t *testing.T) {
+	slow := new(mockHook)
+	slow.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"A=1"}, nil).After(time.Second).Once()
+	next := new(mockHook)
+	next.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"B=2"}, nil).Once()
+
+	manager := Manager{Hooks: []Hook{slow, next}, Timeout: 10 * time.Millisecond}
+	start := time.Now()
+	env, err := manager.HandleEvent(Event{Type: BeforeTerminateEvent}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Env{"B=2"}, env)
+	assert.True(t, time.Since(start) < time.Second)
+	next.AssertExpectations(t)
+}
+
+func TestIfStopsHookThatExceedsTimeout(t *testing.T) {
+	slow := new(mockStoppableHook)
+	slow.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{}, nil).After(100 * time.Millisecond).Once()
+	slow.On("Stop").Once()
+
+	manager := Manager{Hooks: []Hook{slow}, Timeout: 10 * time.Millisecond}
+	_, err := manager.HandleEvent(Event{}, false)
+
+	assert.Error(t, err)
+	slow.AssertExpectations(t)
+}
+
+func TestIfPassesNextEventToHookAfterTimedOutCallReturns(t *testing.T) {
+	var calls []EventType
+	var mutex sync.Mutex
+	record := func(args mock.Arguments) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls = append(calls, args.Get(0).(Event).Type)
+	}
+	slow := new(mockHook)
+	slow.On("HandleEvent", Event{Type: AfterTaskHealthyEvent}).Return(Env{}, nil).After(100 * time.Millisecond).Run(record).Once()
+	slow.On("HandleEvent", Event{Type: BeforeTerminateEvent}).Return(Env{}, nil).Run(record).Once()
+
+	manager := Manager{Hooks: []Hook{slow}, Timeout: 10 * time.Millisecond}
+	_, err := manager.HandleEvent(Event{Type: AfterTaskHealthyEvent}, false)
+	require.Error(t, err)
+	_, err = manager.HandleEvent(Event{Type: BeforeTerminateEvent}, true)
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []EventType{AfterTaskHealthyEvent, BeforeTerminateEvent}, calls)
+}
+
+func TestIfWaitingForPreviousHookCallDoesNotCountToTimeout(t *testing.T) {
+	slow := new(mockHook)
+	slow.On("HandleEvent", Event{Type: AfterTaskHealthyEvent}).Return(Env{}, nil).After(100 * time.Millisecond).Once()
+	slow.On("HandleEvent", Event{Type: BeforeTerminateEvent}).Return(Env{}, nil).After(20 * time.Millisecond).Once()
+
+	manager := Manager{Hooks: []Hook{slow}, Timeout: 50 * time.Millisecond}
+	_, err := manager.HandleEvent(Event{Type: AfterTaskHealthyEvent}, false)
+	require.Error(t, err)
+	_, err = manager.HandleEvent(Event{Type: BeforeTerminateEvent}, false)
+
+	assert.NoError(t, err)
+	slow.AssertExpectations(t)
+}
+
+func TestIfLastHookWinsOnConflictingEnv(t *testing.T) {
+	hook1, hook2 := new(mockHook), new(mockHook)
+
+	for _, parallel := range []bool{false, true} {
+		manager := Manager{Hooks: []Hook{hook1, hook2}, Parallel: parallel}
+		hook1.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"A=1", "B=2"}, nil).Once()
+		hook2.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"A=3", "C=x=y"}, nil).Once()
+
+		env, err := manager.HandleEvent(Event{}, false)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Env{"A=3", "B=2", "C=x=y"}, env)
+	}
+}
+
+func TestIfFailsOnMalformedEnv(t *testing.T) {
+	for _, malformed := range []string{"A", "=1", ""} {
+		hook1 := new(mockHook)
+		hook1.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"B=2", malformed}, nil).Once()
+		hook2 := new(mockHook)
+		hook2.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"C=3"}, nil).Once()
+
+		manager := Manager{Hooks: []Hook{hook1, hook2}}
+		_, err := manager.HandleEvent(Event{}, false)
+		assert.Error(t, err, malformed)
+
+		hook1.On("HandleEvent", mock.AnythingOfType("hook.Event")).Return(Env{"B=2", malformed}, nil).Once()
+		env, err := manager.HandleEvent(Event{}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, Env{"C=3"}, env)
+	}
+}
+
+func TestIfStopsHooksImplementingStopper(t *testing.T) {
+	stoppable := new(mockStoppableHook)
+	stoppable.On("Stop").Once()
+
+	manager := Manager{Hooks: []Hook{new(mockHook), stoppable}}
+	manager.Stop()
+
+	stoppable.AssertExpectations(t)
+}
+
 type mockHook struct {
 	mock.Mock
 }
@@ -48,3 +206,11 @@ func (m *mockHook) HandleEvent(event Event) (Env, error) {
 	args := m.Called(event)
 	return args.Get(0).(Env), args.Error(1)
 }
+
+type mockStoppableHook struct {
+	mockHook
+}
+
+func (m *mockStoppableHook) Stop() {
+	m.Called()
+}
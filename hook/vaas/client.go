@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -17,23 +19,32 @@ const (
 	apiDirectorPath = apiPrefixPath + "/director/"
 )
 
+// defaultTimeout is a default timeout of a single VaaS API call.
+const defaultTimeout = 10 * time.Second
+
 const (
 	contentTypeHeader = "Content-Type"
 	acceptHeader      = "Accept"
 	applicationJSON   = "application/json"
 )
 
-// Backend represents JSON structure of backend in VaaS API.
+// Backend represents JSON structure of backend in VaaS API. Timeouts are
+// expressed in seconds.
 type Backend struct {
-	ID                 *int     `json:"id,omitempty"`
-	Address            string   `json:"address,omitempty"`
-	Director           string   `json:"director,omitempty"`
-	DC                 DC       `json:"dc,omitempty"`
-	Port               int      `json:"port,omitempty"`
-	InheritTimeProfile bool     `json:"inherit_time_profile,omitempty"`
-	Weight             *int     `json:"weight,omitempty"`
-	Tags               []string `json:"tags,omitempty"`
-	ResourceURI        string   `json:"resource_uri,omitempty"`
+	ID                  *int     `json:"id,omitempty"`
+	Address             string   `json:"address,omitempty"`
+	Director            string   `json:"director,omitempty"`
+	DC                  DC       `json:"dc,omitempty"`
+	Port                int      `json:"port,omitempty"`
+	InheritTimeProfile  bool     `json:"inherit_time_profile"`
+	ConnectTimeout      string   `json:"connect_timeout,omitempty"`
+	FirstByteTimeout    string   `json:"first_byte_timeout,omitempty"`
+	BetweenBytesTimeout string   `json:"between_bytes_timeout,omitempty"`
+	MaxConnections      *int     `json:"max_connections,omitempty"`
+	Weight              *int     `json:"weight,omitempty"`
+	Enabled             *bool    `json:"enabled,omitempty"`
+	Tags                []string `json:"tags,omitempty"`
+	ResourceURI         string   `json:"resource_uri,omitempty"`
 }
 
 // DC represents JSON structure of DC in VaaS API.
@@ -73,18 +84,44 @@ type Meta struct {
 	TotalCount int     `json:"total_count,omitempty"`
 }
 
+// TaskStatus represents status of a VaaS task.
+type TaskStatus string
+
+const (
+	// StatusPending is a status of a task waiting for execution.
+	StatusPending TaskStatus = "PENDING"
+	// StatusSuccess is a status of a successfully finished task.
+	StatusSuccess TaskStatus = "SUCCESS"
+	// StatusFailure is a status of a failed task.
+	StatusFailure TaskStatus = "FAILURE"
+)
+
 // Task represents JSON structure of a VaaS task in API.
 type Task struct {
-	Info        string `json:"info,omitempty"`
-	ResourceURI string `json:"resource_uri,omitempty"`
+	Info        string     `json:"info,omitempty"`
+	ResourceURI string     `json:"resource_uri,omitempty"`
+	Status      TaskStatus `json:"status,omitempty"`
+}
+
+// APIError is returned when VaaS API responds with a non 2xx status code.
+type APIError struct {
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("VaaS API error at %s (HTTP %d): %s", e.Path, e.StatusCode, e.Message)
 }
 
 // Client is an interface for VaaS API.
 type Client interface {
 	FindDirectorID(string) (int, error)
-	AddBackend(*Backend) (string, error)
-	DeleteBackend(int) error
+	AddBackend(*Backend, bool) (string, error)
+	DeleteBackend(int) (string, error)
+	UpdateBackend(int, *Backend) error
 	GetDC(string) (*DC, error)
+	TaskStatus(*Task) (TaskStatus, error)
 }
 
 // DefaultClient is a REST client for VaaS API.
@@ -120,12 +157,17 @@ func (c *defaultClient) FindDirectorID(name string) (int, error) {
 	return 0, fmt.Errorf("no Director with name %s found", name)
 }
 
-// AddBackend adds backend in VaaS director.
-func (c *defaultClient) AddBackend(backend *Backend) (string, error) {
+// AddBackend adds backend in VaaS director. In async mode VaaS only schedules
+// the change and returned location points to a task that should be polled
+// with TaskStatus.
+func (c *defaultClient) AddBackend(backend *Backend, async bool) (string, error) {
 	request, err := c.newRequest("POST", c.host+apiBackendPath, backend)
 	if err != nil {
 		return "", err
 	}
+	if async {
+		request.Header.Set("Prefer", "respond-async")
+	}
 
 	response, err := c.doRequest(request, backend)
 	if err != nil {
@@ -135,30 +177,69 @@ func (c *defaultClient) AddBackend(backend *Backend) (string, error) {
 	return response.Header.Get("Location"), nil
 }
 
-// DeleteBacked removes backend with given id from VaaS director.
-func (c *defaultClient) DeleteBackend(id int) error {
+// DeleteBacked schedules removal of backend with given id from VaaS director.
+// Returned location points to a task that could be polled with TaskStatus. It
+// is empty when backend does not exist.
+func (c *defaultClient) DeleteBackend(id int) (string, error) {
 	request, err := c.newRequest("DELETE", fmt.Sprintf("%s%s%d/", c.host, apiBackendPath, id), nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	request.Header.Set("Prefer", "respond-async")
-	response, err := c.do(request)
+	response, err := c.doRequest(request, nil)
 	if response != nil && response.StatusCode == http.StatusNotFound {
 		log.WithField(vaasBackendIDKey, id).Warn("Tried to remove a non-existent backend")
-		return nil
+		return "", nil
 	}
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	request.Header.Set("Prefer", "respond-async")
+	return response.Header.Get("Location"), nil
+}
 
-	_, err = c.doRequest(request, nil)
+// UpdateBackend changes fields of backend with given id that are set in the
+// patch. InheritTimeProfile is changed only when it is true, as false can not
+// be told apart from not set. Missing backend is not treated as an error.
+func (c *defaultClient) UpdateBackend(id int, patch *Backend) error {
+	body, err := backendPatch(patch)
+	if err != nil {
+		return err
+	}
+	request, err := c.newRequest("PATCH", fmt.Sprintf("%s%s%d/", c.host, apiBackendPath, id), body)
+	if err != nil {
+		return err
+	}
 
+	response, err := c.doRequest(request, nil)
+	if response != nil && response.StatusCode == http.StatusNotFound {
+		log.WithField(vaasBackendIDKey, id).Warn("Tried to update a non-existent backend")
+		return nil
+	}
 	return err
 }
 
+// backendPatch returns JSON fields of the backend without the ones that could
+// not be omitted with struct tags when they are not set.
+func backendPatch(patch *Backend) (map[string]interface{}, error) {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if patch.DC == (DC{}) {
+		delete(fields, "dc")
+	}
+	if !patch.InheritTimeProfile {
+		delete(fields, "inherit_time_profile")
+	}
+	return fields, nil
+}
+
 // GetDC finds DC by name.
 func (c *defaultClient) GetDC(name string) (*DC, error) {
 	request, err := c.newRequest("GET", c.host+apiDcPath, nil)
@@ -180,6 +261,24 @@ func (c *defaultClient) GetDC(name string) (*DC, error) {
 	return nil, fmt.Errorf("no DC with name %s found", name)
 }
 
+// TaskStatus fetches current state of the given task and returns its status.
+func (c *defaultClient) TaskStatus(task *Task) (TaskStatus, error) {
+	taskURL := task.ResourceURI
+	if !strings.HasPrefix(taskURL, "http://") && !strings.HasPrefix(taskURL, "https://") {
+		taskURL = c.host + taskURL
+	}
+	request, err := c.newRequest("GET", taskURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := c.doRequest(request, task); err != nil {
+		return "", err
+	}
+
+	return task.Status, nil
+}
+
 func (c *defaultClient) newRequest(method, url string, body interface{}) (*http.Request, error) {
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
@@ -238,19 +337,39 @@ func (c *defaultClient) do(request *http.Request) (*http.Response, error) {
 		} else {
 			message = string(rawResponse)
 		}
-		return response, fmt.Errorf("VaaS API error at %s (HTTP %d): %s",
-			request.URL.Path, response.StatusCode, message)
+		return response, &APIError{Path: request.URL.Path, StatusCode: response.StatusCode, Message: message}
 	}
 
 	return response, nil
 }
 
+// ClientOption is an optional configuration of VaaS API client.
+type ClientOption func(*defaultClient)
+
+// HTTPTimeout sets a timeout of a single VaaS API call.
+func HTTPTimeout(timeout time.Duration) ClientOption {
+	return func(c *defaultClient) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+// HTTPTransport sets a transport used to make VaaS API calls.
+func HTTPTransport(transport http.RoundTripper) ClientOption {
+	return func(c *defaultClient) {
+		c.httpClient.Transport = transport
+	}
+}
+
 // NewClient creates new REST client for VaaS API.
-func NewClient(hostname string, username string, apiKey string) Client {
-	return &defaultClient{
-		httpClient: http.DefaultClient,
+func NewClient(hostname string, username string, apiKey string, options ...ClientOption) Client {
+	client := &defaultClient{
+		httpClient: &http.Client{Timeout: defaultTimeout},
 		username:   username,
 		apiKey:     apiKey,
 		host:       hostname,
 	}
+	for _, option := range options {
+		option(client)
+	}
+	return client
 }
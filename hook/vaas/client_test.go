@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -68,7 +69,7 @@ func TestBackendRegistrationFailureAfterVaasServerError(t *testing.T) {
 
 	client := NewClient(ts.URL, "username", "api-key")
 
-	_, err := client.AddBackend(&Backend{})
+	_, err := client.AddBackend(&Backend{}, false)
 
 	assert.Error(t, err)
 }
@@ -83,7 +84,7 @@ func TestBackendRemovalFailureAfterVaasServerError(t *testing.T) {
 
 	client := NewClient(ts.URL, "username", "api-key")
 
-	err := client.DeleteBackend(123)
+	_, err := client.DeleteBackend(123)
 
 	assert.Error(t, err)
 }
@@ -138,12 +139,52 @@ func TestIfBackendLocationIsSetFromVaasResponseHeader(t *testing.T) {
 		Director: "director",
 		DC:       DC{1, "DC1", "api/dc/1", "dc1"},
 		Port:     8080,
-	})
+	}, false)
 
 	require.NoError(t, err)
 	assert.Equal(t, "location", location)
 }
 
+func TestIfAsyncBackendRegistrationPrefersAsyncResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "respond-async", r.Header.Get("Prefer"))
+		w.Header().Set("Location", "/api/v0.1/task/1/")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(mockAddBackendResponse)
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "username", "api-key")
+
+	location, err := client.AddBackend(&Backend{}, true)
+
+	require.NoError(t, err)
+	assert.Equal(t, "/api/v0.1/task/1/", location)
+}
+
+func TestIfTaskStatusIsFetchedFromTaskLocation(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v0.1/task/1/" && r.Method == "GET" {
+			w.Write([]byte(`{"info": "done", "resource_uri": "/api/v0.1/task/1/", "status": "SUCCESS"}`))
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "username", "api-key")
+
+	task := &Task{ResourceURI: "/api/v0.1/task/1/"}
+	status, err := client.TaskStatus(task)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, status)
+	assert.Equal(t, "done", task.Info)
+
+	status, err = client.TaskStatus(&Task{ResourceURI: ts.URL + "/api/v0.1/task/1/"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, status)
+}
+
 func TestNoFailureWhenRemovingExistingBackendInVaas(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, applicationJSON, r.Header.Get(contentTypeHeader))
@@ -158,11 +199,78 @@ func TestNoFailureWhenRemovingExistingBackendInVaas(t *testing.T) {
 
 	client := NewClient(ts.URL, "username", "api-key")
 
-	err := client.DeleteBackend(123)
+	_, err := client.DeleteBackend(123)
+
+	assert.NoError(t, err)
+}
+
+func TestIfBackendIsUpdatedWithSetFieldsOnly(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PATCH", r.Method)
+		assert.Equal(t, "/api/v0.1/backend/123/", r.URL.Path)
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"weight": 20, "enabled": false, "tags": ["canary"]}`, string(body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "username", "api-key")
+
+	weight, enabled := 20, false
+	err := client.UpdateBackend(123, &Backend{Weight: &weight, Enabled: &enabled, Tags: []string{"canary"}})
+
+	assert.NoError(t, err)
+}
+
+func TestNoFailureWhenUpdatingNonExistingBackendInVaas(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "username", "api-key")
+
+	weight := 20
+	err := client.UpdateBackend(123, &Backend{Weight: &weight})
 
 	assert.NoError(t, err)
 }
 
+func TestBackendUpdateFailureAfterVaasServerError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "username", "api-key")
+
+	weight := 20
+	err := client.UpdateBackend(123, &Backend{Weight: &weight})
+
+	assert.Error(t, err)
+}
+
+func TestIfBackendRemovalReturnsTaskLocation(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "respond-async", r.Header.Get("Prefer"))
+		w.Header().Set("Location", "/api/v0.1/task/2/")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "username", "api-key")
+
+	location, err := client.DeleteBackend(123)
+
+	require.NoError(t, err)
+	assert.Equal(t, "/api/v0.1/task/2/", location)
+	assert.Equal(t, 1, requests)
+}
+
 func TestNoFailureWhenRemovingNonExistingBackendInVaas(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, applicationJSON, r.Header.Get(contentTypeHeader))
@@ -177,7 +285,7 @@ func TestNoFailureWhenRemovingNonExistingBackendInVaas(t *testing.T) {
 
 	client := NewClient(ts.URL, "username", "api-key")
 
-	err := client.DeleteBackend(123)
+	_, err := client.DeleteBackend(123)
 
 	assert.NoError(t, err)
 }
@@ -210,3 +318,18 @@ var mockAddBackendResponse = []byte(`{
    },
    "weight":1
 }`)
+
+func TestIfClientCallsTimeOut(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	client := NewClient(ts.URL, "username", "api-key", HTTPTimeout(10*time.Millisecond))
+
+	_, err := client.GetDC("dc1")
+
+	assert.Error(t, err)
+}
@@ -3,7 +3,11 @@ package vaas
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -18,6 +22,30 @@ const vaasDirectorLabelKey = "director"
 const vaasAsyncLabelKey = "vaas-queue"
 const vaasFrontendSyncPortLabelKey = "frontend-sync"
 
+// Labels with explicit backend time profile. When any of them is set backend
+// does not inherit time profile from the director.
+const (
+	vaasConnectTimeoutLabelKey      = "vaas-connect-timeout"
+	vaasFirstByteTimeoutLabelKey    = "vaas-first-byte-timeout"
+	vaasBetweenBytesTimeoutLabelKey = "vaas-between-bytes-timeout"
+	vaasMaxConnectionsLabelKey      = "vaas-max-connections"
+)
+
+// Labels with weight ramp schedule. Backend is registered with the start
+// weight, which is increased (or decreased) to the task weight over the ramp
+// duration.
+const (
+	vaasWeightRampStartLabelKey    = "vaas-weight-ramp-start"
+	vaasWeightRampDurationLabelKey = "vaas-weight-ramp-duration"
+	vaasWeightRampIntervalLabelKey = "vaas-weight-ramp-interval"
+)
+
+// defaultWeightRampInterval is a default delay between backend weight updates.
+const defaultWeightRampInterval = 30 * time.Second
+
+// asyncPollInterval is a delay between VaaS task status checks.
+const asyncPollInterval = time.Second
+
 // vaasInitialWeight is an environment variable used to override initial weight.
 const vaasInitialWeight = "VAAS_INITIAL_WEIGHT"
 
@@ -27,9 +55,29 @@ const canaryLabelKey = "canary"
 // Hook manages lifecycle of Varnish backend related to executed service
 // instance.
 type Hook struct {
-	backendID    *int
-	client       Client
-	asyncTimeout time.Duration
+	backendID        *int
+	client           Client
+	asyncTimeout     time.Duration
+	waitForDeletion  bool
+	retryMaxAttempts int
+	retryBaseDelay   time.Duration
+	drainDuration    time.Duration
+	stop             chan struct{}
+	stopOnce         sync.Once
+
+	drainMutex  sync.Mutex
+	drainCancel chan struct{}
+
+	rampStop chan struct{}
+	rampDone chan struct{}
+}
+
+// weightRamp is a schedule of backend weight changes.
+type weightRamp struct {
+	start    int
+	target   int
+	duration time.Duration
+	interval time.Duration
 }
 
 // Config is Varnish configuration settable from environment
@@ -44,6 +92,24 @@ type Config struct {
 	VaasAPIKey string `default:"" envconfig:"vaas_token"`
 	// VaasAsyncTimeout is a timeout for async registration in VaaS
 	VaasAsyncTimeout time.Duration `default:"90s" envconfig:"vaas_async_timeout"`
+	// VaasHTTPTimeout is a timeout of a single VaaS API call
+	VaasHTTPTimeout time.Duration `default:"10s" envconfig:"vaas_http_timeout"`
+	// VaasWaitForDeregistration is a flag to control whether backend
+	// deregistration should wait until VaaS removes the backend
+	VaasWaitForDeregistration bool `default:"false" envconfig:"vaas_wait_for_deregistration"`
+	// VaasRetryMaxAttempts is a maximal number of attempts of VaaS API calls
+	// during registration that failed with a transient error
+	VaasRetryMaxAttempts int `default:"3" envconfig:"vaas_retry_max_attempts"`
+	// VaasRetryBaseDelay is a delay before the first retry, it doubles with
+	// every next attempt
+	VaasRetryBaseDelay time.Duration `default:"1s" envconfig:"vaas_retry_base_delay"`
+	// VaasDrainDuration is a time to wait after setting backend weight to 0
+	// before it is deleted, so in-flight requests could finish. Backend is
+	// deleted immediately when it is 0.
+	VaasDrainDuration time.Duration `default:"0" envconfig:"vaas_drain_duration"`
+	// HookTimeout is the executor hook timeout, drain is limited to half of
+	// it, so backend could be deleted in the rest of the time
+	HookTimeout time.Duration `default:"0" envconfig:"hook_timeout"`
 }
 
 // RegisterBackend adds new backend to VaaS if it does not exist.
@@ -62,13 +128,20 @@ func (sh *Hook) RegisterBackend(taskInfo mesosutils.TaskInfo) error {
 		return err
 	}
 
-	dc, err := sh.client.GetDC(runtimeDC)
-
+	var dc *DC
+	err = sh.withRetry("Getting VaaS DC", func() (err error) {
+		dc, err = sh.client.GetDC(runtimeDC)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
-	directorID, err := sh.client.FindDirectorID(director)
+	var directorID int
+	err = sh.withRetry("Finding VaaS director", func() (err error) {
+		directorID, err = sh.client.FindDirectorID(director)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -101,6 +174,14 @@ func (sh *Hook) RegisterBackend(taskInfo mesosutils.TaskInfo) error {
 		initialWeight = &val
 	}
 
+	ramp, err := getWeightRamp(taskInfo, initialWeight)
+	if err != nil {
+		return err
+	}
+	if ramp != nil {
+		initialWeight = &ramp.start
+	}
+
 	// check if it's canary instance - if yes, add new tag "canary" for VaaS
 	// (VaaS requires every canary instance to be tagged with "canary" tag)
 	// see https://github.com/allegro/vaas/blob/master/docs/documentation/canary.md for details
@@ -119,33 +200,269 @@ func (sh *Hook) RegisterBackend(taskInfo mesosutils.TaskInfo) error {
 		InheritTimeProfile: true,
 		Tags:               tags,
 	}
-
-	if taskInfo.GetLabelValue(vaasAsyncLabelKey) == "true" {
-		log.Warn("Async VaaS registration is no longer supported")
+	if err := setTimeProfile(backend, taskInfo); err != nil {
+		return err
 	}
-	_, err = sh.client.AddBackend(backend)
+
+	async := taskInfo.GetLabelValue(vaasAsyncLabelKey) == "true"
+	var location string
+	err = sh.withRetry("Adding VaaS backend", func() (err error) {
+		location, err = sh.client.AddBackend(backend, async)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("unable to register backend with VaaS, %s", err)
 	}
+	if async {
+		log.Infof("Waiting up to %s for async backend registration in VaaS", sh.asyncTimeout)
+		if err := sh.waitForTask(&Task{ResourceURI: location}, sh.stop); err != nil {
+			return fmt.Errorf("unable to register backend with VaaS, %s", err)
+		}
+	}
+	if backend.ID == nil {
+		return errors.New("unable to register backend with VaaS, no backend ID returned")
+	}
 	sh.backendID = backend.ID
 
 	log.WithField(vaasBackendIDKey, *sh.backendID).Info("Registered backend with VaaS")
 
+	if ramp != nil {
+		sh.startWeightRamp(*sh.backendID, *ramp)
+	}
+
+	return nil
+}
+
+// getWeightRamp returns weight ramp schedule from task labels or nil when it
+// is not set. Ramp ends at the target weight, so it requires task weight.
+func getWeightRamp(taskInfo mesosutils.TaskInfo, target *int) (*weightRamp, error) {
+	startValue := taskInfo.GetLabelValue(vaasWeightRampStartLabelKey)
+	if startValue == "" {
+		return nil, nil
+	}
+	if target == nil {
+		log.Warnf("Label %s set but task has no weight, skipping weight ramp", vaasWeightRampStartLabelKey)
+		return nil, nil
+	}
+	start, err := strconv.Atoi(startValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value of %s label: %s", vaasWeightRampStartLabelKey, err)
+	}
+	duration, err := time.ParseDuration(taskInfo.GetLabelValue(vaasWeightRampDurationLabelKey))
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid value of %s label: %q", vaasWeightRampDurationLabelKey,
+			taskInfo.GetLabelValue(vaasWeightRampDurationLabelKey))
+	}
+	interval := defaultWeightRampInterval
+	if value := taskInfo.GetLabelValue(vaasWeightRampIntervalLabelKey); value != "" {
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid value of %s label: %q", vaasWeightRampIntervalLabelKey, value)
+		}
+	}
+	return &weightRamp{start: start, target: *target, duration: duration, interval: interval}, nil
+}
+
+// weight returns backend weight after given time since the ramp started.
+func (r weightRamp) weight(elapsed time.Duration) int {
+	if elapsed >= r.duration {
+		return r.target
+	}
+	return r.start + int(float64(r.target-r.start)*float64(elapsed)/float64(r.duration))
+}
+
+// startWeightRamp starts a goroutine that periodically updates weight of the
+// backend with given ID until it reaches the ramp target or the ramp is stopped.
+// Failed updates are retried with the next one.
+func (sh *Hook) startWeightRamp(backendID int, ramp weightRamp) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	sh.rampStop, sh.rampDone = stop, done
+
+	log.WithField(vaasBackendIDKey, backendID).
+		Infof("Ramping backend weight from %d to %d over %s", ramp.start, ramp.target, ramp.duration)
+	go func() {
+		defer close(done)
+		started := time.Now()
+		current := ramp.start
+		ticker := time.NewTicker(ramp.interval)
+		defer ticker.Stop()
+		for current != ramp.target {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			case <-sh.stop:
+				return
+			}
+			weight := ramp.weight(time.Since(started))
+			if weight == current {
+				continue
+			}
+			if err := sh.client.UpdateBackend(backendID, &Backend{Weight: &weight}); err != nil {
+				log.WithError(err).WithField(vaasBackendIDKey, backendID).Warnf("Unable to update backend weight to %d", weight)
+				continue
+			}
+			current = weight
+		}
+		log.WithField(vaasBackendIDKey, backendID).Infof("Backend weight ramped to %d", current)
+	}()
+}
+
+// stopWeightRamp stops weight ramp goroutine and waits until it finishes.
+func (sh *Hook) stopWeightRamp() {
+	if sh.rampStop == nil {
+		return
+	}
+	close(sh.rampStop)
+	<-sh.rampDone
+	sh.rampStop, sh.rampDone = nil, nil
+}
+
+// setTimeProfile sets backend time profile from task labels.
+func setTimeProfile(backend *Backend, taskInfo mesosutils.TaskInfo) error {
+	timeouts := map[string]*string{
+		vaasConnectTimeoutLabelKey:      &backend.ConnectTimeout,
+		vaasFirstByteTimeoutLabelKey:    &backend.FirstByteTimeout,
+		vaasBetweenBytesTimeoutLabelKey: &backend.BetweenBytesTimeout,
+	}
+	for key, timeout := range timeouts {
+		value := taskInfo.GetLabelValue(key)
+		if value == "" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("invalid value of %s label: %s", key, err)
+		}
+		*timeout = value
+		backend.InheritTimeProfile = false
+	}
+
+	if value := taskInfo.GetLabelValue(vaasMaxConnectionsLabelKey); value != "" {
+		maxConnections, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value of %s label: %s", vaasMaxConnectionsLabelKey, err)
+		}
+		backend.MaxConnections = &maxConnections
+		backend.InheritTimeProfile = false
+	}
+
 	return nil
 }
 
+// withRetry calls operation until it succeeds, fails with a non-retryable error,
+// retryMaxAttempts is reached or the hook is stopped.
+func (sh *Hook) withRetry(name string, operation func() error) error {
+	return sh.withRetryUntil(name, operation, sh.stop)
+}
+
+// withRetryUntil works like withRetry but retries are interrupted when passed
+// stop channel is closed.
+func (sh *Hook) withRetryUntil(name string, operation func() error, stop <-chan struct{}) error {
+	delay := sh.retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || !isRetryable(err) || attempt >= sh.retryMaxAttempts {
+			return err
+		}
+
+		log.WithError(err).Warnf("%s failed (attempt %d of %d), retrying in %s",
+			name, attempt, sh.retryMaxAttempts, delay)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return fmt.Errorf("%s interrupted: %s", strings.ToLower(name), err)
+		}
+		delay *= 2
+	}
+}
+
+// isRetryable returns true for network errors and VaaS server errors (5xx).
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Stop interrupts pending retries of VaaS API calls made during registration.
+// It is safe to call it many times. Deregistration, which happens after the
+// hook was stopped, is not interrupted - it is bounded by the drain duration
+// and retry attempts instead. Stop called during the drain (e.g. when the
+// deregistration exceeds the hook timeout) cuts the drain short.
+func (sh *Hook) Stop() {
+	sh.stopOnce.Do(func() {
+		if sh.stop != nil {
+			close(sh.stop)
+		}
+	})
+	sh.cancelDrain()
+}
+
+// waitForTask polls VaaS task status until the task is finished, asyncTimeout
+// elapses or stop channel is closed.
+func (sh *Hook) waitForTask(task *Task, stop <-chan struct{}) error {
+	if task.ResourceURI == "" {
+		return errors.New("no task location returned")
+	}
+
+	deadline := time.Now().Add(sh.asyncTimeout)
+	for {
+		status, err := sh.client.TaskStatus(task)
+		if err != nil {
+			log.WithError(err).Warnf("Unable to get status of VaaS task %s", task.ResourceURI)
+		}
+		switch status {
+		case StatusSuccess:
+			return nil
+		case StatusFailure:
+			return fmt.Errorf("task %s failed: %s", task.ResourceURI, task.Info)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("task %s not finished in %s", task.ResourceURI, sh.asyncTimeout)
+		}
+		select {
+		case <-time.After(asyncPollInterval):
+		case <-stop:
+			return fmt.Errorf("waiting for task %s interrupted", task.ResourceURI)
+		}
+	}
+}
+
 // DeregisterBackend deletes backend from VaaS.
 func (sh *Hook) DeregisterBackend(_ mesosutils.TaskInfo) error {
+	sh.stopWeightRamp()
 	if sh.backendID != nil {
+		sh.drainBackend(*sh.backendID)
+
 		log.WithField(vaasBackendIDKey, *sh.backendID).
 			Info("backendID is set - scheduling backend for deletion via VaaS")
 
-		if err := sh.client.DeleteBackend(*sh.backendID); err != nil {
+		var location string
+		// deregistration happens after the hook was stopped, so its retries could not be interrupted
+		err := sh.withRetryUntil("Deleting VaaS backend", func() (err error) {
+			location, err = sh.client.DeleteBackend(*sh.backendID)
+			return err
+		}, nil)
+		if err != nil {
 			return err
 		}
 
-		log.WithField(vaasBackendIDKey, *sh.backendID).
-			Info("Successfully scheduled backend for deletion via VaaS")
+		if sh.waitForDeletion && location != "" {
+			log.Infof("Waiting up to %s for backend deletion in VaaS", sh.asyncTimeout)
+			// deregistration happens after the hook was stopped, so it could not be interrupted
+			if err := sh.waitForTask(&Task{ResourceURI: location}, nil); err != nil {
+				return fmt.Errorf("unable to delete backend %d: %s", *sh.backendID, err)
+			}
+			log.WithField(vaasBackendIDKey, *sh.backendID).
+				Info("Successfully deleted backend via VaaS")
+		} else {
+			log.WithField(vaasBackendIDKey, *sh.backendID).
+				Info("Successfully scheduled backend for deletion via VaaS")
+		}
 		// we will not try to remove the same backend (and get an error) if this hook gets called again
 		sh.backendID = nil
 
@@ -157,6 +474,48 @@ func (sh *Hook) DeregisterBackend(_ mesosutils.TaskInfo) error {
 	return nil
 }
 
+// drainBackend sets weight of backend with given ID to 0 and waits
+// drainDuration, so VaaS stops sending new requests to it before deletion.
+// Waiting ends earlier when the drain is cancelled.
+func (sh *Hook) drainBackend(backendID int) {
+	if sh.drainDuration <= 0 {
+		return
+	}
+	weight := 0
+	if err := sh.client.UpdateBackend(backendID, &Backend{Weight: &weight}); err != nil {
+		log.WithError(err).WithField(vaasBackendIDKey, backendID).
+			Warn("Unable to drain backend, deleting it immediately")
+		return
+	}
+	log.WithField(vaasBackendIDKey, backendID).Infof("Draining backend for %s", sh.drainDuration)
+	cancel := sh.startDrain()
+	defer sh.cancelDrain()
+	select {
+	case <-time.After(sh.drainDuration):
+	case <-cancel:
+		log.WithField(vaasBackendIDKey, backendID).Info("Draining backend cancelled")
+	}
+}
+
+// startDrain returns a channel that is closed when the drain is cancelled.
+func (sh *Hook) startDrain() <-chan struct{} {
+	sh.drainMutex.Lock()
+	defer sh.drainMutex.Unlock()
+	sh.drainCancel = make(chan struct{})
+	return sh.drainCancel
+}
+
+// cancelDrain cuts the pending drain short. It does nothing when there is no
+// drain in progress.
+func (sh *Hook) cancelDrain() {
+	sh.drainMutex.Lock()
+	defer sh.drainMutex.Unlock()
+	if sh.drainCancel != nil {
+		close(sh.drainCancel)
+		sh.drainCancel = nil
+	}
+}
+
 // HandleEvent calls appropriate hook functions that correspond to supported
 // event types. Unsupported events are ignored.
 func (sh *Hook) HandleEvent(event hook.Event) (hook.Env, error) {
@@ -181,7 +540,24 @@ func NewHook(cfg Config) (hook.Hook, error) {
 			cfg.VaasAPIHost,
 			cfg.VaasAPIUsername,
 			cfg.VaasAPIKey,
+			HTTPTimeout(cfg.VaasHTTPTimeout),
 		),
-		asyncTimeout: cfg.VaasAsyncTimeout,
+		asyncTimeout:     cfg.VaasAsyncTimeout,
+		waitForDeletion:  cfg.VaasWaitForDeregistration,
+		retryMaxAttempts: cfg.VaasRetryMaxAttempts,
+		retryBaseDelay:   cfg.VaasRetryBaseDelay,
+		drainDuration:    drainDurationWithin(cfg.VaasDrainDuration, cfg.HookTimeout),
+		stop:             make(chan struct{}),
 	}, nil
 }
+
+// drainDurationWithin limits drain duration to half of the hook timeout, so
+// the hook call is not timed out before the backend is deleted.
+func drainDurationWithin(drainDuration, hookTimeout time.Duration) time.Duration {
+	if hookTimeout <= 0 || drainDuration <= hookTimeout/2 {
+		return drainDuration
+	}
+	log.Warnf("VaaS drain duration %s does not fit in hook timeout %s, using %s",
+		drainDuration, hookTimeout, hookTimeout/2)
+	return hookTimeout / 2
+}
@@ -3,8 +3,10 @@ package vaas
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/mesos/mesos-go/api/v1/lib"
 	"github.com/stretchr/testify/assert"
@@ -25,8 +27,8 @@ func (m *MockClient) FindDirectorID(name string) (int, error) {
 	return args.Int(0), args.Error(1)
 }
 
-func (m *MockClient) AddBackend(backend *Backend) (string, error) {
-	args := m.Called(backend)
+func (m *MockClient) AddBackend(backend *Backend, async bool) (string, error) {
+	args := m.Called(backend, async)
 
 	backendId := 123
 	backend.ID = &backendId
@@ -35,11 +37,21 @@ func (m *MockClient) AddBackend(backend *Backend) (string, error) {
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockClient) DeleteBackend(id int) error {
+func (m *MockClient) DeleteBackend(id int) (string, error) {
 	args := m.Called(id)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockClient) UpdateBackend(id int, patch *Backend) error {
+	args := m.Called(id, patch)
 	return args.Error(0)
 }
 
+func (m *MockClient) TaskStatus(task *Task) (TaskStatus, error) {
+	args := m.Called(task)
+	return args.Get(0).(TaskStatus), args.Error(1)
+}
+
 func (m *MockClient) GetDC(name string) (*DC, error) {
 	args := m.Called(name)
 
@@ -54,7 +66,7 @@ func prepareTaskInfo() mesosutils.TaskInfo {
 	ports := mesos.Ports{Ports: []mesos.Port{{Number: uint32(8080)}}}
 	discovery := mesos.DiscoveryInfo{Ports: &ports}
 
-	return mesosutils.TaskInfo{mesos.TaskInfo{Discovery: &discovery}}
+	return mesosutils.TaskInfo{TaskInfo: mesos.TaskInfo{Discovery: &discovery}}
 }
 
 func prepareTaskInfoWithMultiplePortsAndFrontendPortLabel(directorName string) mesosutils.TaskInfo {
@@ -68,7 +80,7 @@ func prepareTaskInfoWithMultiplePortsAndFrontendPortLabel(directorName string) m
 	}
 	discovery := mesos.DiscoveryInfo{Ports: &ports}
 
-	return mesosutils.TaskInfo{mesos.TaskInfo{Discovery: &discovery}}
+	return mesosutils.TaskInfo{TaskInfo: mesos.TaskInfo{Discovery: &discovery}}
 }
 
 func prepareTaskInfoWithDirectorWithLabeledPort(directorName string, extraLabels ...mesos.Label) (taskInfo mesosutils.TaskInfo) {
@@ -101,6 +113,27 @@ func prepareTaskInfoWithDirector(directorName string, extraLabels ...mesos.Label
 	return taskInfo
 }
 
+func TestIfRegistersBackendWithIPv6Address(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+	_ = os.Setenv("CLOUD_PUBLIC_IP", "[2001:db8::1]")
+	defer os.Unsetenv("CLOUD_PUBLIC_IP")
+
+	mockClient := new(MockClient)
+	mockDC := DC{ID: 1, ResourceURI: "dc/6"}
+	mockClient.On("GetDC", "dc6").Return(&mockDC, nil)
+	mockClient.On("FindDirectorID", "abc456").Return(456, nil)
+	mockClient.On("AddBackend", mock.MatchedBy(func(backend *Backend) bool {
+		return backend.Address == "2001:db8::1" && backend.Port == 8081
+	}), false).Return("/api/v0.1/backend/123/", nil)
+
+	serviceHook := Hook{client: mockClient}
+	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirectorWithLabeledPort("abc456"))
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
 func TestIfBackendIDSetWhenBackendRegistrationSucceeds(t *testing.T) {
 	_ = os.Setenv("CLOUD_DC", "dc6")
 	defer os.Unsetenv("CLOUD_DC")
@@ -123,7 +156,7 @@ func TestIfBackendIDSetWhenBackendRegistrationSucceeds(t *testing.T) {
 		Port:               8081,
 		Weight:             &weight,
 		Tags:               []string(nil),
-	}).Return("/api/v0.1/backend/123/", nil)
+	}, false).Return("/api/v0.1/backend/123/", nil)
 
 	serviceHook := Hook{client: mockClient}
 	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirectorWithLabeledPort("abc456"))
@@ -134,6 +167,168 @@ func TestIfBackendIDSetWhenBackendRegistrationSucceeds(t *testing.T) {
 	mockClient.AssertExpectations(t)
 }
 
+func TestIfAsyncBackendRegistrationWaitsForTaskSuccess(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+
+	mockClient := new(MockClient)
+	mockDC := DC{ID: 1, ResourceURI: "dc/6"}
+	mockClient.On("GetDC", "dc6").Return(&mockDC, nil)
+	mockClient.On("FindDirectorID", "abc456").Return(456, nil)
+	mockClient.On("AddBackend", mock.AnythingOfType("*vaas.Backend"), true).Return("/api/v0.1/task/1/", nil)
+	mockClient.On("TaskStatus", &Task{ResourceURI: "/api/v0.1/task/1/"}).Return(StatusSuccess, nil)
+
+	asyncValue := "true"
+	serviceHook := Hook{client: mockClient, asyncTimeout: time.Minute}
+	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirector("abc456", mesos.Label{Key: "vaas-queue", Value: &asyncValue}))
+
+	require.NoError(t, err)
+	expectedID := 123
+	assert.Equal(t, &expectedID, serviceHook.backendID)
+	mockClient.AssertExpectations(t)
+}
+
+func TestIfAsyncBackendRegistrationFailsWhenTaskFails(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+
+	mockClient := new(MockClient)
+	mockClient.On("GetDC", "dc6").Return(&DC{ID: 1}, nil)
+	mockClient.On("FindDirectorID", "abc456").Return(456, nil)
+	mockClient.On("AddBackend", mock.AnythingOfType("*vaas.Backend"), true).Return("/api/v0.1/task/1/", nil)
+	mockClient.On("TaskStatus", mock.AnythingOfType("*vaas.Task")).Return(StatusFailure, nil)
+
+	asyncValue := "true"
+	serviceHook := Hook{client: mockClient, asyncTimeout: time.Minute}
+	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirector("abc456", mesos.Label{Key: "vaas-queue", Value: &asyncValue}))
+
+	require.EqualError(t, err, "unable to register backend with VaaS, task /api/v0.1/task/1/ failed: ")
+	assert.Nil(t, serviceHook.backendID)
+}
+
+func TestIfAsyncBackendRegistrationTimesOut(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+
+	mockClient := new(MockClient)
+	mockClient.On("GetDC", "dc6").Return(&DC{ID: 1}, nil)
+	mockClient.On("FindDirectorID", "abc456").Return(456, nil)
+	mockClient.On("AddBackend", mock.AnythingOfType("*vaas.Backend"), true).Return("/api/v0.1/task/1/", nil)
+	mockClient.On("TaskStatus", mock.AnythingOfType("*vaas.Task")).Return(StatusPending, nil)
+
+	asyncValue := "true"
+	serviceHook := Hook{client: mockClient}
+	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirector("abc456", mesos.Label{Key: "vaas-queue", Value: &asyncValue}))
+
+	require.EqualError(t, err, "unable to register backend with VaaS, task /api/v0.1/task/1/ not finished in 0s")
+	assert.Nil(t, serviceHook.backendID)
+}
+
+func TestIfBackendRegistrationIsRetriedOnServerError(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+
+	mockClient := new(MockClient)
+	mockClient.On("GetDC", "dc6").Return(&DC{ID: 1}, nil)
+	mockClient.On("FindDirectorID", "abc456").Return(456, nil)
+	mockClient.On("AddBackend", mock.AnythingOfType("*vaas.Backend"), false).
+		Return("", &APIError{StatusCode: http.StatusBadGateway}).Once()
+	mockClient.On("AddBackend", mock.AnythingOfType("*vaas.Backend"), false).
+		Return("/api/v0.1/backend/123/", nil).Once()
+
+	serviceHook := Hook{client: mockClient, retryMaxAttempts: 3, retryBaseDelay: time.Millisecond}
+	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirector("abc456"))
+
+	require.NoError(t, err)
+	mockClient.AssertNumberOfCalls(t, "AddBackend", 2)
+}
+
+func TestIfBackendRegistrationIsNotRetriedOnClientError(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+
+	mockClient := new(MockClient)
+	mockClient.On("GetDC", "dc6").Return(&DC{ID: 1}, nil)
+	mockClient.On("FindDirectorID", "abc456").Return(456, nil)
+	mockClient.On("AddBackend", mock.AnythingOfType("*vaas.Backend"), false).
+		Return("", &APIError{StatusCode: http.StatusBadRequest})
+
+	serviceHook := Hook{client: mockClient, retryMaxAttempts: 3, retryBaseDelay: time.Millisecond}
+	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirector("abc456"))
+
+	require.Error(t, err)
+	mockClient.AssertNumberOfCalls(t, "AddBackend", 1)
+}
+
+func TestIfBackendRegistrationRetriesAreInterruptedOnStop(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+
+	mockClient := new(MockClient)
+	mockClient.On("GetDC", "dc6").Return(nil, &APIError{StatusCode: http.StatusServiceUnavailable})
+
+	serviceHook := &Hook{client: mockClient, retryMaxAttempts: 3, retryBaseDelay: time.Hour, stop: make(chan struct{})}
+	time.AfterFunc(10*time.Millisecond, serviceHook.Stop)
+	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirector("abc456"))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "interrupted")
+	mockClient.AssertNumberOfCalls(t, "GetDC", 1)
+}
+
+func TestIfBackendTimeProfileIsSetFromLabels(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+
+	mockClient := new(MockClient)
+	mockDC := DC{ID: 1, ResourceURI: "dc/6"}
+	mockClient.On("GetDC", "dc6").Return(&mockDC, nil)
+	mockClient.On("FindDirectorID", "abc456").Return(456, nil)
+	weight := 50
+	maxConnections := 10
+	mockClient.On("AddBackend", &Backend{
+		Address:            runenv.IP().String(),
+		DC:                 mockDC,
+		Director:           "/api/v0.1/director/456/",
+		InheritTimeProfile: false,
+		ConnectTimeout:     "0.5",
+		FirstByteTimeout:   "3",
+		MaxConnections:     &maxConnections,
+		Port:               8080,
+		Weight:             &weight,
+	}, false).Return("/api/v0.1/backend/123/", nil)
+
+	connectTimeout := "0.5"
+	firstByteTimeout := "3"
+	maxConnectionsValue := "10"
+	serviceHook := Hook{client: mockClient}
+	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirector("abc456",
+		mesos.Label{Key: "vaas-connect-timeout", Value: &connectTimeout},
+		mesos.Label{Key: "vaas-first-byte-timeout", Value: &firstByteTimeout},
+		mesos.Label{Key: "vaas-max-connections", Value: &maxConnectionsValue},
+	))
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestIfBackendRegistrationFailsOnInvalidTimeProfileLabel(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+
+	mockClient := new(MockClient)
+	mockClient.On("GetDC", "dc6").Return(&DC{ID: 1}, nil)
+	mockClient.On("FindDirectorID", "abc456").Return(456, nil)
+
+	timeout := "fast"
+	serviceHook := Hook{client: mockClient}
+	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirector("abc456",
+		mesos.Label{Key: "vaas-connect-timeout", Value: &timeout}))
+
+	require.Error(t, err)
+	mockClient.AssertNotCalled(t, "AddBackend", mock.Anything, mock.Anything)
+}
+
 func TestBackendRegistrationWhenAddBackendFails(t *testing.T) {
 	_ = os.Setenv("CLOUD_DC", "dc6")
 	defer os.Unsetenv("CLOUD_DC")
@@ -154,7 +349,7 @@ func TestBackendRegistrationWhenAddBackendFails(t *testing.T) {
 		InheritTimeProfile: true,
 		Port:               8080,
 		Weight:             &weight,
-	}).Return("/api/v0.1/backend/123/", fmt.Errorf("test error"))
+	}, false).Return("/api/v0.1/backend/123/", fmt.Errorf("test error"))
 
 	serviceHook := Hook{client: mockClient}
 	err := serviceHook.RegisterBackend(prepareTaskInfoWithDirector("abc456"))
@@ -183,13 +378,13 @@ func TestIfWeightIsOverriddenByEnvironmentVariable(t *testing.T) {
 		InheritTimeProfile: true,
 		Port:               8080,
 		Weight:             &weight,
-	}).Return("/api/v0.1/backend/123/", nil)
+	}, false).Return("/api/v0.1/backend/123/", nil)
 
 	serviceHook := Hook{client: mockClient}
 
 	taskInfo := prepareTaskInfoWithDirector("abc456")
 	taskInfo.TaskInfo.Command.Environment = &mesos.Environment{
-		Variables: []mesos.Environment_Variable{{"VAAS_INITIAL_WEIGHT", "15"}},
+		Variables: []mesos.Environment_Variable{{Name: "VAAS_INITIAL_WEIGHT", Value: "15"}},
 	}
 
 	err := serviceHook.RegisterBackend(taskInfo)
@@ -221,7 +416,7 @@ func TestIfBackendTagsSetWhenCanaryBackendRegistrationSucceeds(t *testing.T) {
 		Port:               8080,
 		Tags:               []string{"canary"},
 		Weight:             &weight,
-	}).Return("/api/v0.1/backend/123/", nil)
+	}, false).Return("/api/v0.1/backend/123/", nil)
 
 	serviceHook := Hook{client: mockClient}
 
@@ -252,7 +447,7 @@ func TestFailureWhenGettingDCListTimeout(t *testing.T) {
 func TestIfVaasBackendDeleteIsCalledWhenBackendIDSet(t *testing.T) {
 	mockClient := new(MockClient)
 	backendId := 1324
-	mockClient.On("DeleteBackend", backendId).Return(nil)
+	mockClient.On("DeleteBackend", backendId).Return("/api/v0.1/task/2/", nil)
 
 	serviceHook := Hook{
 		backendID: &backendId,
@@ -263,6 +458,168 @@ func TestIfVaasBackendDeleteIsCalledWhenBackendIDSet(t *testing.T) {
 
 	require.NoError(t, err)
 	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "TaskStatus", mock.Anything)
+}
+
+func TestIfVaasBackendDeregistrationWaitsForDeletionWhenEnabled(t *testing.T) {
+	mockClient := new(MockClient)
+	backendId := 1324
+	mockClient.On("DeleteBackend", backendId).Return("/api/v0.1/task/2/", nil)
+	mockClient.On("TaskStatus", &Task{ResourceURI: "/api/v0.1/task/2/"}).Return(StatusSuccess, nil)
+
+	serviceHook := Hook{
+		backendID:       &backendId,
+		client:          mockClient,
+		asyncTimeout:    time.Second,
+		waitForDeletion: true,
+		stop:            make(chan struct{}),
+	}
+	serviceHook.Stop()
+
+	err := serviceHook.DeregisterBackend(prepareTaskInfo())
+
+	require.NoError(t, err)
+	assert.Nil(t, serviceHook.backendID)
+	mockClient.AssertExpectations(t)
+}
+
+func TestIfVaasBackendIsDrainedBeforeDeletionWhenEnabled(t *testing.T) {
+	mockClient := new(MockClient)
+	backendID := 1324
+	weight := 0
+	drained := time.Time{}
+	mockClient.On("UpdateBackend", backendID, &Backend{Weight: &weight}).Return(nil).Run(func(mock.Arguments) {
+		drained = time.Now()
+	}).Once()
+	mockClient.On("DeleteBackend", backendID).Return("", nil).Once()
+
+	serviceHook := Hook{
+		backendID:     &backendID,
+		client:        mockClient,
+		drainDuration: 50 * time.Millisecond,
+	}
+
+	err := serviceHook.DeregisterBackend(prepareTaskInfo())
+
+	require.NoError(t, err)
+	assert.True(t, time.Since(drained) >= 50*time.Millisecond)
+	mockClient.AssertExpectations(t)
+}
+
+func TestIfVaasBackendDrainingIsNotInterruptedByStopBeforeDeregistration(t *testing.T) {
+	mockClient := new(MockClient)
+	backendID := 1324
+	drained := time.Time{}
+	mockClient.On("UpdateBackend", backendID, mock.AnythingOfType("*vaas.Backend")).Return(nil).Run(func(mock.Arguments) {
+		drained = time.Now()
+	}).Once()
+	mockClient.On("DeleteBackend", backendID).Return("", nil).Once()
+
+	serviceHook := Hook{
+		backendID:     &backendID,
+		client:        mockClient,
+		drainDuration: 50 * time.Millisecond,
+		stop:          make(chan struct{}),
+	}
+	serviceHook.Stop()
+
+	err := serviceHook.DeregisterBackend(prepareTaskInfo())
+
+	require.NoError(t, err)
+	assert.True(t, time.Since(drained) >= 50*time.Millisecond)
+	mockClient.AssertExpectations(t)
+}
+
+func TestIfVaasBackendDrainingIsCutShortWhenCancelled(t *testing.T) {
+	mockClient := new(MockClient)
+	backendID := 1324
+	mockClient.On("UpdateBackend", backendID, mock.AnythingOfType("*vaas.Backend")).Return(nil).Once()
+	mockClient.On("DeleteBackend", backendID).Return("", nil).Once()
+
+	serviceHook := Hook{
+		backendID:     &backendID,
+		client:        mockClient,
+		drainDuration: time.Hour,
+		stop:          make(chan struct{}),
+	}
+	serviceHook.Stop()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		serviceHook.Stop()
+	}()
+
+	start := time.Now()
+	err := serviceHook.DeregisterBackend(prepareTaskInfo())
+
+	require.NoError(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	mockClient.AssertExpectations(t)
+}
+
+func TestIfVaasBackendDeletionIsRetriedAfterHookWasStopped(t *testing.T) {
+	mockClient := new(MockClient)
+	backendID := 1324
+	mockClient.On("UpdateBackend", backendID, mock.AnythingOfType("*vaas.Backend")).Return(nil).Once()
+	mockClient.On("DeleteBackend", backendID).Return("", &APIError{StatusCode: http.StatusServiceUnavailable}).Once()
+	mockClient.On("DeleteBackend", backendID).Return("", nil).Once()
+
+	serviceHook := Hook{
+		backendID:        &backendID,
+		client:           mockClient,
+		drainDuration:    time.Millisecond,
+		retryMaxAttempts: 2,
+		retryBaseDelay:   time.Millisecond,
+		stop:             make(chan struct{}),
+	}
+	serviceHook.Stop()
+
+	err := serviceHook.DeregisterBackend(prepareTaskInfo())
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestIfVaasDrainDurationIsLimitedByHookTimeout(t *testing.T) {
+	assert.Equal(t, time.Minute, drainDurationWithin(time.Minute, 0))
+	assert.Equal(t, time.Minute, drainDurationWithin(time.Minute, 5*time.Minute))
+	assert.Equal(t, 30*time.Second, drainDurationWithin(time.Minute, time.Minute))
+}
+
+func TestIfVaasBackendIsDeletedWhenDrainingFails(t *testing.T) {
+	mockClient := new(MockClient)
+	backendID := 1324
+	mockClient.On("UpdateBackend", backendID, mock.AnythingOfType("*vaas.Backend")).Return(errors.New("error")).Once()
+	mockClient.On("DeleteBackend", backendID).Return("", nil).Once()
+
+	serviceHook := Hook{
+		backendID:     &backendID,
+		client:        mockClient,
+		drainDuration: time.Hour,
+	}
+
+	err := serviceHook.DeregisterBackend(prepareTaskInfo())
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestIfVaasBackendDeregistrationFailsWhenDeletionTaskFails(t *testing.T) {
+	mockClient := new(MockClient)
+	backendId := 1324
+	mockClient.On("DeleteBackend", backendId).Return("/api/v0.1/task/2/", nil)
+	mockClient.On("TaskStatus", mock.AnythingOfType("*vaas.Task")).Return(StatusFailure, nil)
+
+	serviceHook := Hook{
+		backendID:       &backendId,
+		client:          mockClient,
+		asyncTimeout:    time.Second,
+		waitForDeletion: true,
+	}
+
+	err := serviceHook.DeregisterBackend(prepareTaskInfo())
+
+	require.Error(t, err)
+	mockClient.AssertExpectations(t)
 }
 
 func TestDoNotCallVaasBackendDeleteWhenBackendIDEmpty(t *testing.T) {
@@ -307,3 +664,85 @@ func TestIfNewHookCreatesNoopHookWhenHookDisabled(t *testing.T) {
 	require.NoError(t, err)
 	assert.IsType(t, hook.NoopHook{}, h)
 }
+
+func TestIfBackendWeightIsRampedAfterRegistration(t *testing.T) {
+	_ = os.Setenv("CLOUD_DC", "dc6")
+	defer os.Unsetenv("CLOUD_DC")
+
+	mockClient := new(MockClient)
+	mockDC := DC{ID: 1, ResourceURI: "dc/6"}
+	mockClient.On("GetDC", "dc6").Return(&mockDC, nil)
+	mockClient.On("FindDirectorID", "abc456").Return(456, nil)
+	mockClient.On("AddBackend", mock.MatchedBy(func(backend *Backend) bool {
+		return *backend.Weight == 10
+	}), false).Return("/api/v0.1/backend/123/", nil)
+	updated := make(chan int, 100)
+	mockClient.On("UpdateBackend", 123, mock.AnythingOfType("*vaas.Backend")).Return(nil).Run(func(args mock.Arguments) {
+		updated <- *args.Get(1).(*Backend).Weight
+	})
+
+	serviceHook := Hook{client: mockClient}
+	rampStart, rampDuration, rampInterval := "10", "50ms", "5ms"
+	taskInfo := prepareTaskInfoWithDirector("abc456",
+		mesos.Label{Key: "vaas-weight-ramp-start", Value: &rampStart},
+		mesos.Label{Key: "vaas-weight-ramp-duration", Value: &rampDuration},
+		mesos.Label{Key: "vaas-weight-ramp-interval", Value: &rampInterval},
+	)
+
+	err := serviceHook.RegisterBackend(taskInfo)
+	require.NoError(t, err)
+
+	last := 10
+	for last != 50 {
+		select {
+		case weight := <-updated:
+			assert.True(t, weight > last, "weight should only increase")
+			last = weight
+		case <-time.After(time.Second):
+			t.Fatalf("backend weight not ramped to 50, last update: %d", last)
+		}
+	}
+	mockClient.On("DeleteBackend", 123).Return("", nil)
+	require.NoError(t, serviceHook.DeregisterBackend(taskInfo))
+}
+
+func TestIfBackendWeightRampStopsOnDeregistration(t *testing.T) {
+	mockClient := new(MockClient)
+	backendID := 123
+	mockClient.On("DeleteBackend", backendID).Return("", nil)
+
+	serviceHook := Hook{backendID: &backendID, client: mockClient}
+	serviceHook.startWeightRamp(backendID, weightRamp{start: 10, target: 50, duration: time.Hour, interval: time.Hour})
+
+	err := serviceHook.DeregisterBackend(prepareTaskInfo())
+
+	require.NoError(t, err)
+	assert.Nil(t, serviceHook.rampStop)
+	mockClient.AssertNotCalled(t, "UpdateBackend", mock.Anything, mock.Anything)
+}
+
+func TestIfWeightRampIsReadFromLabels(t *testing.T) {
+	weight := 50
+	start, duration := "10", "1m"
+	ramp, err := getWeightRamp(prepareTaskInfoWithDirector("abc456",
+		mesos.Label{Key: "vaas-weight-ramp-start", Value: &start},
+		mesos.Label{Key: "vaas-weight-ramp-duration", Value: &duration},
+	), &weight)
+
+	require.NoError(t, err)
+	assert.Equal(t, &weightRamp{start: 10, target: 50, duration: time.Minute, interval: defaultWeightRampInterval}, ramp)
+	assert.Equal(t, 10, ramp.weight(0))
+	assert.Equal(t, 30, ramp.weight(30*time.Second))
+	assert.Equal(t, 50, ramp.weight(2*time.Minute))
+
+	ramp, err = getWeightRamp(prepareTaskInfoWithDirector("abc456"), &weight)
+	require.NoError(t, err)
+	assert.Nil(t, ramp)
+
+	invalid := "soon"
+	_, err = getWeightRamp(prepareTaskInfoWithDirector("abc456",
+		mesos.Label{Key: "vaas-weight-ramp-start", Value: &start},
+		mesos.Label{Key: "vaas-weight-ramp-duration", Value: &invalid},
+	), &weight)
+	require.Error(t, err)
+}
@@ -42,12 +42,32 @@ type HealthCheck struct {
 	Timeout time.Duration
 	// HTTP contains details about heatlhcheck when HTTP Type is set
 	HTTP HTTPCheck
-	//TODO(janisz): Implement other healthchecks
+	// TCP contains details about healthcheck when TCP Type is set
+	TCP TCPCheck
+	// Command contains details about healthcheck when COMMAND Type is set
+	Command CommandCheck
 }
 
 // HTTPCheck contains details about HTTP healthcheck
 type HTTPCheck struct {
 	Path string
+	// Scheme is a scheme of the checked URL, empty when not set
+	Scheme string
+}
+
+// TCPCheck contains details about TCP healthcheck
+type TCPCheck struct {
+	// Port is a checked port, 0 when not set
+	Port int
+}
+
+// CommandCheck contains details about command healthcheck
+type CommandCheck struct {
+	// Value is a shell command when Shell is set, otherwise it is a path of
+	// executable called with Arguments
+	Value     string
+	Arguments []string
+	Shell     bool
 }
 
 // TaskID is framework-generated ID to distinguish a task
@@ -74,10 +94,19 @@ func (h TaskInfo) GetHealthCheck() (check HealthCheck) {
 
 	if mesosCheck.HTTP != nil {
 		check.Type = HTTP
-		check.HTTP = HTTPCheck{Path: mesosCheck.GetHTTP().GetPath()}
+		check.HTTP = HTTPCheck{Path: mesosCheck.GetHTTP().GetPath(), Scheme: mesosCheck.GetHTTP().GetScheme()}
 	}
 	if mesosCheck.TCP != nil {
 		check.Type = TCP
+		check.TCP = TCPCheck{Port: int(mesosCheck.GetTCP().GetPort())}
+	}
+	if mesosCheck.Command != nil {
+		check.Type = COMMAND
+		check.Command = CommandCheck{
+			Value:     mesosCheck.GetCommand().GetValue(),
+			Arguments: mesosCheck.GetCommand().GetArguments(),
+			Shell:     mesosCheck.GetCommand().GetShell(),
+		}
 	}
 
 	return check
@@ -101,8 +130,9 @@ func (h TaskInfo) GetLabelValue(key string) string {
 	return ""
 }
 
-// GetWeight return a initial weight of the task.
-// If weight is not set or has malformed format then returned
+// GetWeight return a initial weight of the task. Weight is read from
+// weight:NN tag label or from the value of weight label (the tag takes
+// precedence). If weight is not set or has malformed format then returned
 // weight is 0 and error is not nil.
 func (h TaskInfo) GetWeight() (int, error) {
 	for _, tags := range h.GetLabelKeysByValue("tag") {
@@ -111,6 +141,9 @@ func (h TaskInfo) GetWeight() (int, error) {
 			return strconv.Atoi(strings.TrimPrefix(tags, weightPrefix))
 		}
 	}
+	if label := h.FindLabel("weight"); label != nil {
+		return strconv.Atoi(label.GetValue())
+	}
 	return 0, fmt.Errorf("no weight defined")
 }
 
@@ -119,22 +152,49 @@ func (h TaskInfo) GetPorts() []mesos.Port {
 	return h.TaskInfo.GetDiscovery().GetPorts().GetPorts()
 }
 
+// GetNamedPorts returns task ports with non empty names mapped by their names
+func (h TaskInfo) GetNamedPorts() map[string]mesos.Port {
+	named := map[string]mesos.Port{}
+	for _, port := range h.GetPorts() {
+		if name := port.GetName(); name != "" {
+			named[name] = port
+		}
+	}
+	return named
+}
+
+// GetPortByName returns task port with given name or nil when there is no such
+// port
+func (h TaskInfo) GetPortByName(name string) *mesos.Port {
+	if port, ok := h.GetNamedPorts()[name]; ok {
+		return &port
+	}
+	return nil
+}
+
 // GetFirstPortWithLabel returns port with specified label
 func (h TaskInfo) GetFirstPortWithLabel(portLabel string) (*mesos.Port, error) {
-	ports := h.GetPorts()
-	if len(ports) < 1 {
+	if len(h.GetPorts()) < 1 {
 		return nil, fmt.Errorf("service has no ports available")
 	}
+	if port := h.GetPortByLabel(portLabel, ""); port != nil {
+		return port, nil
+	}
+	return nil, fmt.Errorf("No port with label %s", portLabel)
+}
+
+// GetPortByLabel returns the first port with label of given key and value or
+// nil when there is no such port. Empty value matches label with any value.
+func (h TaskInfo) GetPortByLabel(key, value string) *mesos.Port {
+	ports := h.GetPorts()
 	for i := range ports {
-		if ports[i].Labels != nil && ports[i].Labels.Labels != nil {
-			for _, label := range ports[i].Labels.Labels {
-				if portLabel == label.Key {
-					return &ports[i], nil
-				}
+		for _, label := range ports[i].GetLabels().GetLabels() {
+			if label.GetKey() == key && (value == "" || label.GetValue() == value) {
+				return &ports[i]
 			}
 		}
 	}
-	return nil, fmt.Errorf("No port with label %s", portLabel)
+	return nil
 }
 
 // FindEnvValue returns the value of an environment variable
@@ -173,11 +233,12 @@ func GetLabelKeysByValue(labels []mesos.Label, value string) []string {
 	return keys
 }
 
-// FindLabel returns a label matching given key
+// FindLabel returns a label matching given key. Returned pointer refers to the
+// element of passed slice.
 func FindLabel(labels []mesos.Label, key string) *mesos.Label {
-	for _, label := range labels {
-		if label.GetKey() == key {
-			return &label
+	for i := range labels {
+		if labels[i].GetKey() == key {
+			return &labels[i]
 		}
 	}
 	return nil
@@ -47,7 +47,7 @@ func TestIfGetHealthChecksReturnsNoneIfSomeDetailsAreMissing(t *testing.T) {
 }
 
 func TestIfGetHealthChecksReturnsHTTPWithAllDetails(t *testing.T) {
-	scheme := "ignored"
+	scheme := "https"
 	path := "/ping"
 	httpCheckDetails := mesos.HealthCheck_HTTPCheckInfo{
 		Scheme: &scheme,
@@ -74,10 +74,47 @@ func TestIfGetHealthChecksReturnsHTTPWithAllDetails(t *testing.T) {
 	}
 	assert.Equal(t, HTTP, taskInfo.GetHealthCheck().Type)
 	assert.Equal(t, "/ping", taskInfo.GetHealthCheck().HTTP.Path)
+	assert.Equal(t, "https", taskInfo.GetHealthCheck().HTTP.Scheme)
 	assert.Equal(t, Duration(3), taskInfo.GetHealthCheck().Timeout)
 	assert.Equal(t, Duration(2), taskInfo.GetHealthCheck().Interval)
 }
 
+func TestIfGetHealthChecksReturnsTCPWithPort(t *testing.T) {
+	intervalSeconds := 2.0
+	timeoutSeconds := 3.0
+	taskInfo := TaskInfo{
+		TaskInfo: mesos.TaskInfo{
+			HealthCheck: &mesos.HealthCheck{
+				IntervalSeconds: &intervalSeconds,
+				TimeoutSeconds:  &timeoutSeconds,
+				Type:            mesos.HealthCheck_TCP.Enum(),
+				TCP:             &mesos.HealthCheck_TCPCheckInfo{Port: 8080},
+			},
+		},
+	}
+	assert.Equal(t, TCP, taskInfo.GetHealthCheck().Type)
+	assert.Equal(t, 8080, taskInfo.GetHealthCheck().TCP.Port)
+}
+
+func TestIfGetHealthChecksReturnsCommandWithDetails(t *testing.T) {
+	intervalSeconds := 2.0
+	timeoutSeconds := 3.0
+	value := "/bin/check"
+	shell := false
+	taskInfo := TaskInfo{
+		TaskInfo: mesos.TaskInfo{
+			HealthCheck: &mesos.HealthCheck{
+				IntervalSeconds: &intervalSeconds,
+				TimeoutSeconds:  &timeoutSeconds,
+				Type:            mesos.HealthCheck_COMMAND.Enum(),
+				Command:         &mesos.CommandInfo{Value: &value, Arguments: []string{"check", "-v"}, Shell: &shell},
+			},
+		},
+	}
+	assert.Equal(t, COMMAND, taskInfo.GetHealthCheck().Type)
+	assert.Equal(t, CommandCheck{Value: "/bin/check", Arguments: []string{"check", "-v"}}, taskInfo.GetHealthCheck().Command)
+}
+
 func TestIfExtractsServiceIDFromLabel(t *testing.T) {
 	serviceIDLabelValue := "XXX"
 	mesosTaskInfo := mesos.TaskInfo{
@@ -150,6 +187,24 @@ func TestIfFindsLabel(t *testing.T) {
 	require.Equal(t, found.Key, label)
 }
 
+func TestIfFoundLabelsAreNotAliased(t *testing.T) {
+	first, second := "first value", "second value"
+	labels := []mesos.Label{
+		{Key: "first", Value: &first},
+		{Key: "second", Value: &second},
+	}
+
+	foundFirst := FindLabel(labels, "first")
+	foundSecond := FindLabel(labels, "second")
+
+	require.True(t, foundFirst == &labels[0])
+	require.True(t, foundSecond == &labels[1])
+	require.Equal(t, "first", foundFirst.GetKey())
+	require.Equal(t, "first value", foundFirst.GetValue())
+	require.Equal(t, "second", foundSecond.GetKey())
+	require.Equal(t, "second value", foundSecond.GetValue())
+}
+
 func TestIfExtractsLabelsByValue(t *testing.T) {
 	expectedValue := "tag"
 	otherValue := "other"
@@ -264,6 +319,57 @@ func TestGetWeightReturnsWeightFromTagLabel(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestGetWeightReturnsWeightFromLabelValue(t *testing.T) {
+	labelValue := "50"
+	taskInfo := TaskInfo{
+		TaskInfo: mesos.TaskInfo{
+			Labels: &mesos.Labels{
+				Labels: []mesos.Label{{Key: "weight", Value: &labelValue}},
+			},
+		},
+	}
+
+	weight, err := taskInfo.GetWeight()
+
+	require.Equal(t, 50, weight)
+	require.NoError(t, err)
+}
+
+func TestGetWeightPrefersWeightFromTagLabel(t *testing.T) {
+	tag, labelValue := "tag", "50"
+	taskInfo := TaskInfo{
+		TaskInfo: mesos.TaskInfo{
+			Labels: &mesos.Labels{
+				Labels: []mesos.Label{
+					{Key: "weight", Value: &labelValue},
+					{Key: "weight:20", Value: &tag},
+				},
+			},
+		},
+	}
+
+	weight, err := taskInfo.GetWeight()
+
+	require.Equal(t, 20, weight)
+	require.NoError(t, err)
+}
+
+func TestGetWeightIfReturnsErrorIfWeightLabelValueIsNaN(t *testing.T) {
+	labelValue := "nan"
+	taskInfo := TaskInfo{
+		TaskInfo: mesos.TaskInfo{
+			Labels: &mesos.Labels{
+				Labels: []mesos.Label{{Key: "weight", Value: &labelValue}},
+			},
+		},
+	}
+
+	weight, err := taskInfo.GetWeight()
+
+	require.Equal(t, 0, weight)
+	require.Error(t, err)
+}
+
 func TestFindEnvValueReturnsCorrectValue(t *testing.T) {
 	expectedValue := "test env value"
 	key := "TEST_ENV_KEY"
@@ -283,3 +389,65 @@ func TestFindEnvValueReturnsCorrectValue(t *testing.T) {
 
 	require.Equal(t, expectedValue, returnedValue)
 }
+
+func TestIfGetsNamedPorts(t *testing.T) {
+	http, admin := "http", "admin"
+	taskInfo := taskInfoWithPorts(
+		mesos.Port{Number: 31000, Name: &http},
+		mesos.Port{Number: 31001},
+		mesos.Port{Number: 31002, Name: &admin},
+	)
+
+	ports := taskInfo.GetNamedPorts()
+
+	assert.Len(t, ports, 2)
+	assert.Equal(t, uint32(31000), ports["http"].Number)
+	assert.Equal(t, uint32(31002), ports["admin"].Number)
+}
+
+func TestIfGetsPortByName(t *testing.T) {
+	http := "http"
+	taskInfo := taskInfoWithPorts(mesos.Port{Number: 31000}, mesos.Port{Number: 31001, Name: &http})
+
+	port := taskInfo.GetPortByName("http")
+
+	require.NotNil(t, port)
+	assert.Equal(t, uint32(31001), port.GetNumber())
+	assert.Nil(t, taskInfo.GetPortByName("admin"))
+}
+
+func TestIfGetsPortByLabel(t *testing.T) {
+	other, service := "other", "service"
+	taskInfo := taskInfoWithPorts(
+		mesos.Port{Number: 31000, Labels: &mesos.Labels{Labels: []mesos.Label{{Key: "consul", Value: &other}}}},
+		mesos.Port{Number: 31001, Labels: &mesos.Labels{Labels: []mesos.Label{{Key: "consul", Value: &service}}}},
+	)
+
+	port := taskInfo.GetPortByLabel("consul", "service")
+
+	require.NotNil(t, port)
+	assert.Equal(t, uint32(31001), port.GetNumber())
+	assert.Nil(t, taskInfo.GetPortByLabel("consul", "missing"))
+	assert.Nil(t, taskInfo.GetPortByLabel("missing", "service"))
+}
+
+func TestIfGetsPortByLabelWithAnyValue(t *testing.T) {
+	other := "other"
+	taskInfo := taskInfoWithPorts(
+		mesos.Port{Number: 31000},
+		mesos.Port{Number: 31001, Labels: &mesos.Labels{Labels: []mesos.Label{{Key: "consul", Value: &other}}}},
+		mesos.Port{Number: 31002, Labels: &mesos.Labels{Labels: []mesos.Label{{Key: "consul"}}}},
+	)
+
+	port := taskInfo.GetPortByLabel("consul", "")
+
+	require.NotNil(t, port)
+	assert.Equal(t, uint32(31001), port.GetNumber())
+	assert.Nil(t, taskInfo.GetPortByLabel("missing", ""))
+}
+
+func taskInfoWithPorts(ports ...mesos.Port) TaskInfo {
+	return TaskInfo{TaskInfo: mesos.TaskInfo{
+		Discovery: &mesos.DiscoveryInfo{Ports: &mesos.Ports{Ports: ports}},
+	}}
+}
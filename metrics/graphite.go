@@ -3,6 +3,7 @@ package metrics
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -47,14 +48,39 @@ type GraphiteConfig struct {
 // SetupGraphite will configure metric system to periodically send metrics to
 // Graphite.
 func SetupGraphite(cfg GraphiteConfig) error {
-	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
 	if _, err := net.ResolveTCPAddr("tcp", addr); err != nil {
 		return fmt.Errorf("invalid Graphite address: %s", err)
 	}
-	go graphite.Graphite(metrics.DefaultRegistry, time.Minute, buildUniquePrefix(cfg.Prefix), addr)
+	go reportToGraphite(graphite.Config{
+		Addr:          addr,
+		Registry:      metrics.DefaultRegistry,
+		FlushInterval: time.Minute,
+		DurationUnit:  time.Nanosecond,
+		Prefix:        buildUniquePrefix(cfg.Prefix),
+		Percentiles:   []float64{0.5, 0.75, 0.95, 0.99, 0.999},
+	}, time.Tick(time.Minute))
 	return nil
 }
 
+// reportToGraphite sends metrics to Graphite on every tick. Each flush dials
+// the address again, so the host name is re-resolved and failed connections
+// are re-established on the next flush.
+func reportToGraphite(cfg graphite.Config, ticks <-chan time.Time) {
+	failures := 0
+	for range ticks {
+		if err := graphite.Once(cfg); err != nil {
+			failures++
+			log.WithError(err).Warnf("Unable to send metrics to Graphite (%d consecutive failures)", failures)
+			continue
+		}
+		if failures > 0 {
+			log.Infof("Metrics sent to Graphite after %d failed attempts", failures)
+		}
+		failures = 0
+	}
+}
+
 func buildUniquePrefix(basePrefix string) string {
 	hostname, err := runenv.Hostname()
 	if err != nil {
@@ -1,11 +1,17 @@
 package metrics
 
 import (
+	"bufio"
 	"fmt"
+	"net"
 	"os"
 	"testing"
+	"time"
 
+	graphite "github.com/allegro/go-metrics-graphite"
+	"github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIfFailsToSetupGraphiteWithInvalidConfig(t *testing.T) {
@@ -48,3 +54,35 @@ func TestIfBuildsCorrectMetricsPrefix(t *testing.T) {
 		})
 	}
 }
+
+func TestIfReportsToGraphiteAfterFailedAttempt(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("counter", registry).Inc(1)
+	ticks := make(chan time.Time)
+	go reportToGraphite(graphite.Config{
+		Addr:          addr,
+		Registry:      registry,
+		FlushInterval: time.Minute,
+		DurationUnit:  time.Nanosecond,
+		Prefix:        "prefix",
+	}, ticks)
+
+	ticks <- time.Now() // nobody listens
+
+	ln, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer ln.Close()
+	ticks <- time.Now()
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, line, "prefix.counter.count 1")
+}
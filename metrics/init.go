@@ -8,6 +8,7 @@ import (
 
 func init() {
 	go CaptureCPUTime(time.Minute)
+	go CaptureMemory(time.Minute)
 	metrics.RegisterRuntimeMemStats(metrics.DefaultRegistry)
 	go metrics.CaptureRuntimeMemStats(metrics.DefaultRegistry, time.Minute)
 }
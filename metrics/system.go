@@ -52,6 +52,46 @@ func CaptureCPUTime(interval time.Duration) {
 	}
 }
 
+// Memory returns resident (RSS) and virtual (VMS) memory size (in bytes) of the
+// executor process.
+func Memory() (rss uint64, vms uint64, err error) {
+	p := getExecutorProcess()
+	m, err := p.MemoryInfo()
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to get memory info: %s", err)
+	}
+
+	return m.RSS, m.VMS, nil
+}
+
+// CaptureMemory starts collecting memory usage of the executor process with
+// given interval. It is a blocking call so it is advised to call this function
+// in goroutine.
+func CaptureMemory(interval time.Duration) {
+	rssGauge := metrics.NewGauge()
+	vmsGauge := metrics.NewGauge()
+	for name, gauge := range map[string]metrics.Gauge{
+		"runtime.MemStats.RSS": rssGauge,
+		"runtime.MemStats.VMS": vmsGauge,
+	} {
+		if err := metrics.Register(name, gauge); err != nil {
+			log.Warnf("Could not register memory metric %s: %s", name, err)
+			return
+		}
+	}
+	ticker := time.NewTicker(interval)
+
+	for range ticker.C {
+		rss, vms, err := Memory()
+		if err != nil {
+			log.WithError(err).Warn("Unable to send current memory usage metric")
+			continue
+		}
+		rssGauge.Update(int64(rss))
+		vmsGauge.Update(int64(vms))
+	}
+}
+
 func getExecutorProcess() *process.Process {
 	pid := os.Getpid()
 	p, _ := process.NewProcess(int32(pid)) // never fails as we use our own pid here
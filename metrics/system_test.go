@@ -11,3 +11,11 @@ func TestIfNotFailsToGetCPUTime(t *testing.T) {
 
 	assert.NoError(t, err)
 }
+
+func TestIfNotFailsToGetMemory(t *testing.T) {
+	rss, vms, err := Memory()
+
+	assert.NoError(t, err)
+	assert.NotZero(t, rss)
+	assert.True(t, vms >= rss)
+}
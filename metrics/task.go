@@ -0,0 +1,72 @@
+// +build !windows
+
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	log "github.com/sirupsen/logrus"
+
+	osutil "github.com/allegro/mesos-executor/os"
+)
+
+// TaskUsage returns an amount of CPU time (in seconds) and resident memory (in
+// bytes) used by the whole process tree starting from given pid.
+func TaskUsage(pid int32) (cpu float64, rss uint64, err error) {
+	processes, err := osutil.ProcessTree(pid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to get task processes: %s", err)
+	}
+
+	for _, p := range processes {
+		// processes may exit while we are iterating, so errors are ignored
+		if t, err := p.Times(); err == nil {
+			cpu += t.User + t.System + t.Nice + t.Iowait + t.Irq + t.Softirq +
+				t.Steal + t.Guest + t.GuestNice + t.Stolen
+		}
+		if m, err := p.MemoryInfo(); err == nil {
+			rss += m.RSS
+		}
+	}
+
+	return cpu, rss, nil
+}
+
+// CaptureTaskUsage starts collecting CPU utilization and memory usage of the
+// task process tree starting from given pid. Gauges are scoped by the task ID,
+// so usage of many tasks run by the executor is reported separately. It returns
+// when the task process exits or the stop channel is closed, so it is advised
+// to call this function in goroutine.
+func CaptureTaskUsage(taskID string, pid int32, interval time.Duration, stop <-chan struct{}) {
+	prefix := "task." + strings.Replace(taskID, ".", "_", -1)
+	cpuGauge := metrics.GetOrRegisterGaugeFloat64(prefix+".cpu", metrics.DefaultRegistry)
+	memoryGauge := metrics.GetOrRegisterGauge(prefix+".memory", metrics.DefaultRegistry)
+	defer metrics.Unregister(prefix + ".cpu")
+	defer metrics.Unregister(prefix + ".memory")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastSeconds, _, _ := TaskUsage(pid) // if we are unable to get initial value 0.0 is okay
+	for {
+		select {
+		case <-stop:
+			log.Info("Task stopped, stopping task usage metrics")
+			return
+		case <-ticker.C:
+		}
+		seconds, rss, err := TaskUsage(pid)
+		if err != nil {
+			log.WithError(err).Info("Task exited, stopping task usage metrics")
+			return
+		}
+		// CPU time of processes that exited since last tick is lost
+		if cpuUtilization := (seconds - lastSeconds) / interval.Seconds(); cpuUtilization >= 0 {
+			cpuGauge.Update(cpuUtilization)
+		}
+		memoryGauge.Update(int64(rss))
+		lastSeconds = seconds
+	}
+}
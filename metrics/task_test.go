@@ -0,0 +1,57 @@
+// +build !windows
+
+package metrics
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfGetsTaskUsageOfProcessTree(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 1 & sleep 1")
+	require.NoError(t, cmd.Start())
+	defer cmd.Wait()
+
+	_, rss, err := TaskUsage(int32(cmd.Process.Pid))
+
+	assert.NoError(t, err)
+	assert.NotZero(t, rss)
+}
+
+func TestIfFailsToGetTaskUsageOfExitedProcess(t *testing.T) {
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+
+	_, _, err := TaskUsage(int32(cmd.Process.Pid))
+
+	assert.Error(t, err)
+}
+
+func TestIfCapturesTaskUsageScopedByTaskIDUntilStopped(t *testing.T) {
+	cmd := exec.Command("sleep", "1")
+	require.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		CaptureTaskUsage("app.task-1", int32(cmd.Process.Pid), 10*time.Millisecond, stop)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	memory, ok := metrics.Get("task.app_task-1.memory").(metrics.Gauge)
+	require.True(t, ok)
+	assert.NotZero(t, memory.Value())
+
+	close(stop)
+	<-done
+	assert.Nil(t, metrics.Get("task.app_task-1.memory"))
+	assert.Nil(t, metrics.Get("task.app_task-1.cpu"))
+}
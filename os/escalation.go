@@ -0,0 +1,113 @@
+// +build !windows
+
+package os
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var signalsByName = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// SignalStep is a single step of process tree termination: signal sent to the
+// tree followed by time to wait before the next step.
+type SignalStep struct {
+	Signal syscall.Signal
+	Delay  time.Duration
+}
+
+// DefaultSignalSequence returns termination sequence that sends SIGTERM and
+// waits gracePeriod before the tree is killed.
+func DefaultSignalSequence(gracePeriod time.Duration) []SignalStep {
+	return []SignalStep{{Signal: syscall.SIGTERM, Delay: gracePeriod}}
+}
+
+// ParseSignalSequence parses comma separated list of SIGNAL[:delay] pairs
+// (e.g. "SIGINT:5s,SIGTERM"). Steps without delay share equally what is left
+// of gracePeriod after delays of the other steps.
+func ParseSignalSequence(sequence string, gracePeriod time.Duration) ([]SignalStep, error) {
+	if strings.TrimSpace(sequence) == "" {
+		return DefaultSignalSequence(gracePeriod), nil
+	}
+
+	var steps []SignalStep
+	var withoutDelay []int
+	remaining := gracePeriod
+	for _, pair := range strings.Split(sequence, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		name := strings.ToUpper(parts[0])
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+		signal, ok := signalsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown signal: %s", parts[0])
+		}
+		if signal == syscall.SIGKILL {
+			return nil, fmt.Errorf("%s is always sent at the end of the sequence", name)
+		}
+
+		var delay time.Duration
+		if len(parts) == 2 {
+			var err error
+			if delay, err = time.ParseDuration(parts[1]); err != nil {
+				return nil, fmt.Errorf("invalid delay for %s: %s", name, err)
+			}
+			remaining -= delay
+		} else {
+			withoutDelay = append(withoutDelay, len(steps))
+		}
+		steps = append(steps, SignalStep{Signal: signal, Delay: delay})
+	}
+	if len(withoutDelay) > 0 && remaining > 0 {
+		for _, i := range withoutDelay {
+			steps[i].Delay = remaining / time.Duration(len(withoutDelay))
+		}
+	}
+	return steps, nil
+}
+
+// LimitSignalSequence returns passed steps with delays shortened
+// proportionally, so the whole sequence takes at most max.
+func LimitSignalSequence(steps []SignalStep, max time.Duration) []SignalStep {
+	var total time.Duration
+	for _, step := range steps {
+		total += step.Delay
+	}
+	if total <= max {
+		return steps
+	}
+	limited := make([]SignalStep, len(steps))
+	for i, step := range steps {
+		limited[i] = SignalStep{
+			Signal: step.Signal,
+			Delay:  time.Duration(float64(step.Delay) * float64(max) / float64(total)),
+		}
+	}
+	return limited
+}
+
+// KillTreeGracefully sends signals from passed steps to whole process tree,
+// waiting configured delay after each of them, and finally kills the tree with
+// SIGKILL. Processes matching names in processesToExclude are omitted in all
+// steps except the final SIGKILL.
+func KillTreeGracefully(steps []SignalStep, pid int32, processesToExclude []string) error {
+	for _, step := range steps {
+		if err := KillTreeWithExcludes(step.Signal, pid, processesToExclude); err != nil {
+			return fmt.Errorf("unable to send %s to %d tree: %s", step.Signal, pid, err)
+		}
+		<-time.After(step.Delay)
+	}
+
+	return KillTree(syscall.SIGKILL, pid)
+}
@@ -0,0 +1,84 @@
+// +build !windows
+
+package os
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSignalSequence(t *testing.T) {
+	steps, err := ParseSignalSequence("SIGINT:5s, term,SIGUSR1:100ms", time.Minute)
+	require.NoError(t, err)
+
+	assert.Equal(t, []SignalStep{
+		{Signal: syscall.SIGINT, Delay: 5 * time.Second},
+		{Signal: syscall.SIGTERM, Delay: time.Minute - 5100*time.Millisecond},
+		{Signal: syscall.SIGUSR1, Delay: 100 * time.Millisecond},
+	}, steps)
+}
+
+func TestParseSignalSequence_StepsWithoutDelayShareGracePeriod(t *testing.T) {
+	steps, err := ParseSignalSequence("SIGINT,SIGTERM", 10*time.Second)
+	require.NoError(t, err)
+
+	assert.Equal(t, []SignalStep{
+		{Signal: syscall.SIGINT, Delay: 5 * time.Second},
+		{Signal: syscall.SIGTERM, Delay: 5 * time.Second},
+	}, steps)
+}
+
+func TestParseSignalSequence_StepsWithoutDelayDoNotWaitWhenGracePeriodIsUsed(t *testing.T) {
+	steps, err := ParseSignalSequence("SIGINT:15s,SIGTERM", 10*time.Second)
+	require.NoError(t, err)
+
+	assert.Equal(t, []SignalStep{
+		{Signal: syscall.SIGINT, Delay: 15 * time.Second},
+		{Signal: syscall.SIGTERM},
+	}, steps)
+}
+
+func TestParseSignalSequence_EmptySequenceIsDefault(t *testing.T) {
+	steps, err := ParseSignalSequence("", time.Minute)
+	require.NoError(t, err)
+
+	assert.Equal(t, DefaultSignalSequence(time.Minute), steps)
+}
+
+func TestParseSignalSequence_InvalidSequence(t *testing.T) {
+	for _, sequence := range []string{"SIGFOO", "SIGTERM:soon", "SIGKILL:1s", "SIGTERM,"} {
+		_, err := ParseSignalSequence(sequence, time.Minute)
+		assert.Error(t, err, sequence)
+	}
+}
+
+func TestLimitSignalSequence(t *testing.T) {
+	steps := []SignalStep{
+		{Signal: syscall.SIGINT, Delay: 30 * time.Second},
+		{Signal: syscall.SIGTERM, Delay: 10 * time.Second},
+	}
+
+	assert.Equal(t, steps, LimitSignalSequence(steps, time.Minute))
+	assert.Equal(t, []SignalStep{
+		{Signal: syscall.SIGINT, Delay: 15 * time.Second},
+		{Signal: syscall.SIGTERM, Delay: 5 * time.Second},
+	}, LimitSignalSequence(steps, 20*time.Second))
+}
+
+func TestKillTreeGracefully_ComplexTree(t *testing.T) {
+	startTime := time.Now()
+	cmd := startTestProcesses(t, "testdata/fork2.sh")
+	cmdPids := addAllChildrenPids(cmd.Process.Pid)
+
+	steps := []SignalStep{{Signal: syscall.SIGINT, Delay: 10 * time.Millisecond}}
+	killErr := KillTreeGracefully(steps, int32(cmd.Process.Pid), nil)
+	require.NoError(t, killErr)
+	waitToDie(cmd)
+
+	assertProcessesDontExist(t, cmdPids)
+	assertFinishedWithinTimeLimit(t, startTime)
+}
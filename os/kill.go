@@ -3,10 +3,8 @@
 package os
 
 import (
-	"bufio"
 	"fmt"
-	"os/exec"
-	"strconv"
+	"regexp"
 	"strings"
 	"syscall"
 
@@ -26,14 +24,22 @@ func KillTree(signal syscall.Signal, pid int32) error {
 	return sendSignalsToProcessGroups(signals, pgids)
 }
 
-func getProcessGroupsInTree(pid int32) ([]int, error) {
+// ProcessTree returns process with given pid and all its descendants. Order
+// of returned processes is undefined.
+func ProcessTree(pid int32) ([]*process.Process, error) {
 	proc, err := process.NewProcess(pid)
 	if err != nil {
 		return nil, err
 	}
 
-	processes := getAllChildren(proc)
-	processes = append(processes, proc)
+	return append(getAllChildren(proc), proc), nil
+}
+
+func getProcessGroupsInTree(pid int32) ([]int, error) {
+	processes, err := ProcessTree(pid)
+	if err != nil {
+		return nil, err
+	}
 
 	curPid := syscall.Getpid()
 	curPgid, err := syscall.Getpgid(curPid)
@@ -96,7 +102,9 @@ func sendSignalsToProcessGroups(signals []syscall.Signal, pgids []int) error {
 }
 
 // KillTreeWithExcludes sends signal to whole process tree, starting from given pid as root.
-// Omits processes matching names specified in processesToExclude. Kills using pids instead of pgids.
+// Omits processes matching names specified in processesToExclude. Entries prefixed with "re:"
+// are regular expressions matched against both process name and its full command line.
+// Kills using pids instead of pgids.
 func KillTreeWithExcludes(signal syscall.Signal, pid int32, processesToExclude []string) error {
 	log.Infof("Will send signal %s to tree starting from %d", signal.String(), pid)
 
@@ -128,24 +136,24 @@ func KillTreeWithExcludes(signal syscall.Signal, pid int32, processesToExclude [
 }
 
 func findProcessesInGroups(pgids []int) ([]int, error) {
-	var pids []int
+	allPids, err := process.Pids()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list processes: %s", err)
+	}
+
+	inGroups := make(map[int]bool, len(pgids))
 	for _, pgid := range pgids {
-		cmd := exec.Command("pgrep", "-g", strconv.Itoa(pgid))
-		output, err := cmd.CombinedOutput()
+		inGroups[pgid] = true
+	}
+
+	var pids []int
+	for _, pid := range allPids {
+		pgid, err := syscall.Getpgid(int(pid))
 		if err != nil {
-			return nil, fmt.Errorf("'pgrep -g %d' failed: %s", pgid, err)
+			continue // process exited after listing
 		}
-		if !cmd.ProcessState.Success() {
-			return nil, fmt.Errorf("'pgrep -g %d' failed, output was: '%s'", pgid, output)
-		}
-
-		scanner := bufio.NewScanner(strings.NewReader(string(output)))
-		for scanner.Scan() {
-			pid, err := strconv.Atoi(scanner.Text())
-			if err != nil {
-				return nil, fmt.Errorf("cannot convert pgrep output: %s. Output was '%s'", err, output)
-			}
-			pids = append(pids, pid)
+		if inGroups[pgid] {
+			pids = append(pids, int(pid))
 		}
 	}
 
@@ -153,6 +161,8 @@ func findProcessesInGroups(pgids []int) ([]int, error) {
 }
 
 func excludeProcesses(pids []int, processesToExclude []string) ([]int, error) {
+	matchers := newProcessMatchers(processesToExclude)
+
 	var retainedPids []int
 	for _, pid := range pids {
 		proc, err := process.NewProcess(int32(pid))
@@ -163,7 +173,7 @@ func excludeProcesses(pids []int, processesToExclude []string) ([]int, error) {
 		name, err := proc.Name()
 		if err != nil {
 			log.Infof("Could not get process name of %d, will not exclude it from kill", pid)
-		} else if isExcluded(name, processesToExclude) {
+		} else if isExcluded(proc, name, matchers) {
 			log.Infof("Excluding process %s with pid %d from kill", name, pid)
 			continue
 		}
@@ -174,9 +184,53 @@ func excludeProcesses(pids []int, processesToExclude []string) ([]int, error) {
 	return retainedPids, nil
 }
 
-func isExcluded(name string, namesToExclude []string) bool {
-	for _, exclude := range namesToExclude {
-		if strings.ToLower(name) == strings.ToLower(exclude) {
+// patternPrefix marks excluded processes entries that are regular expressions.
+const patternPrefix = "re:"
+
+// processMatcher matches processes by exact (case-insensitive) name or, when
+// created from a regular expression, by name or full command line.
+type processMatcher struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+func newProcessMatchers(processesToExclude []string) []processMatcher {
+	var matchers []processMatcher
+	for _, exclude := range processesToExclude {
+		if !strings.HasPrefix(exclude, patternPrefix) {
+			matchers = append(matchers, processMatcher{name: exclude})
+			continue
+		}
+		pattern, err := regexp.Compile("(?i)" + strings.TrimPrefix(exclude, patternPrefix))
+		if err != nil {
+			log.WithError(err).Warnf("Invalid pattern %q, will not exclude processes matching it", exclude)
+			continue
+		}
+		matchers = append(matchers, processMatcher{pattern: pattern})
+	}
+	return matchers
+}
+
+func isExcluded(proc *process.Process, name string, matchers []processMatcher) bool {
+	var cmdline *string
+	for _, matcher := range matchers {
+		if matcher.pattern == nil {
+			if strings.ToLower(name) == strings.ToLower(matcher.name) {
+				return true
+			}
+			continue
+		}
+		if matcher.pattern.MatchString(name) {
+			return true
+		}
+		if cmdline == nil {
+			line, err := proc.Cmdline()
+			if err != nil {
+				log.Infof("Could not get command line of %d, will match only its name", proc.Pid)
+			}
+			cmdline = &line
+		}
+		if *cmdline != "" && matcher.pattern.MatchString(*cmdline) {
 			return true
 		}
 	}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/shirou/gopsutil/process"
 	"os/exec"
+	"regexp"
 	"strings"
 	"syscall"
 	"testing"
@@ -185,3 +186,36 @@ func assertFinishedWithinTimeLimit(t *testing.T, startTime time.Time) {
 func processExists(pid int) bool {
 	return syscall.Kill(pid, syscall.Signal(0)) == nil
 }
+
+func TestKillTreeWithExcludes_ComplexTreeExcludingOneExistingProcessByPattern(t *testing.T) {
+	startTime := time.Now()
+	cmd := startTestProcesses(t, "testdata/fork2.sh")
+	cmdPids := addAllChildrenPids(cmd.Process.Pid)
+	excluded, findErr := findPidWithProcessName("python", cmdPids)
+	require.NoError(t, findErr)
+
+	killErr := KillTreeWithExcludes(syscall.SIGTERM, int32(cmd.Process.Pid), []string{`re:import time;.*sleep\(3\)`})
+	require.NoError(t, killErr)
+	waitToDie(cmd)
+
+	assertProcessesDontExist(t, removePid(cmdPids, excluded))
+	assertProcessExists(t, excluded)
+	assertFinishedWithinTimeLimit(t, startTime)
+}
+
+func TestIsExcluded_MatchesPlainNamesExactlyAndPrefixedPatternsByCmdline(t *testing.T) {
+	proc, err := process.NewProcess(int32(syscall.Getpid()))
+	require.NoError(t, err)
+	name, err := proc.Name()
+	require.NoError(t, err)
+	cmdline, err := proc.Cmdline()
+	require.NoError(t, err)
+
+	assert.True(t, isExcluded(proc, name, newProcessMatchers([]string{strings.ToUpper(name)})))
+	assert.False(t, isExcluded(proc, name, newProcessMatchers([]string{"non-existing"})))
+	assert.False(t, isExcluded(proc, name, newProcessMatchers([]string{name[:len(name)-1] + "."})))
+	assert.True(t, isExcluded(proc, name, newProcessMatchers([]string{"re:^" + regexp.QuoteMeta(name[:len(name)-1])})))
+	assert.True(t, isExcluded(proc, name, newProcessMatchers([]string{"re:" + regexp.QuoteMeta(cmdline) + "$"})))
+	assert.False(t, isExcluded(proc, name, newProcessMatchers([]string{"re:^non-existing.*"})))
+	assert.False(t, isExcluded(proc, name, newProcessMatchers([]string{"re:("})))
+}
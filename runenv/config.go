@@ -0,0 +1,22 @@
+package runenv
+
+import (
+	"os"
+	"strings"
+)
+
+// EnvironmentPrefix is a prefix for environmental configuration of the executor
+const EnvironmentPrefix = "allegro_executor"
+
+// EnvWithoutExecutorConfig returns os.Environ without executor specific entries,
+// which may contain secrets. Marathon does not support custom executor env and
+// all task env are passed as executor env, so commands started by executor
+// should get this environment.
+func EnvWithoutExecutorConfig() (env []string) {
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, strings.ToUpper(EnvironmentPrefix)) {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
@@ -0,0 +1,20 @@
+package runenv
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfEnvWithoutExecutorConfigSkipsExecutorVariables(t *testing.T) {
+	os.Setenv("ALLEGRO_EXECUTOR_SECRET", "x")
+	os.Setenv("TEST", "z")
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_SECRET")
+	defer os.Unsetenv("TEST")
+
+	env := EnvWithoutExecutorConfig()
+
+	assert.NotContains(t, env, "ALLEGRO_EXECUTOR_SECRET=x")
+	assert.Contains(t, env, "TEST=z")
+}
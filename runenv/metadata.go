@@ -0,0 +1,167 @@
+package runenv
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metadataProviderEnv = "CLOUD_METADATA_PROVIDER"
+	awsProvider         = "aws"
+	gcpProvider         = "gcp"
+)
+
+var (
+	awsMetadataURL = "http://169.254.169.254/latest"
+	gcpMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+	metadataClient = &http.Client{Timeout: time.Second}
+)
+
+// placement holds location of the instance read from cloud metadata service.
+type placement struct {
+	zone   string
+	region string
+	err    error
+}
+
+// placementRetryInterval is a minimal time between lookups of the placement
+// after a failed one.
+var placementRetryInterval = time.Minute
+
+var (
+	placementMutex    sync.Mutex
+	cachedPlacement   placement
+	placementResolved bool
+	placementPending  bool
+	placementFailedAt time.Time
+)
+
+// ResolvePlacement starts lookup of the instance location in the metadata
+// service of the cloud provider selected with CLOUD_METADATA_PROVIDER. Lookup
+// is made in background, so it should be started early (e.g. on executor
+// start) to have the location known when it is needed.
+func ResolvePlacement() {
+	placementMutex.Lock()
+	defer placementMutex.Unlock()
+	startPlacementLookup()
+}
+
+// metadataPlacement returns location of the instance read from metadata
+// service. It never blocks - until the lookup succeeds it returns an error and
+// starts the lookup again when the previous one failed more than
+// placementRetryInterval ago.
+func metadataPlacement() (zone string, region string, err error) {
+	placementMutex.Lock()
+	defer placementMutex.Unlock()
+	if placementResolved {
+		return cachedPlacement.zone, cachedPlacement.region, nil
+	}
+	startPlacementLookup()
+	if cachedPlacement.err != nil {
+		return "", "", cachedPlacement.err
+	}
+	return "", "", errors.New("instance placement not resolved yet")
+}
+
+// startPlacementLookup starts lookup of the placement in background unless it
+// is already resolved, pending or failed recently. It must be called with
+// placementMutex held.
+func startPlacementLookup() {
+	if placementResolved || placementPending {
+		return
+	}
+	if !placementFailedAt.IsZero() && time.Since(placementFailedAt) < placementRetryInterval {
+		return
+	}
+	placementPending = true
+	go func() {
+		result := fetchPlacement(os.Getenv(metadataProviderEnv))
+
+		placementMutex.Lock()
+		defer placementMutex.Unlock()
+		placementPending = false
+		cachedPlacement = result
+		if result.err != nil {
+			placementFailedAt = time.Now()
+			return
+		}
+		placementResolved = true
+	}()
+}
+
+func fetchPlacement(provider string) placement {
+	switch provider {
+	case awsProvider:
+		zone, err := awsMetadata("meta-data/placement/availability-zone")
+		if err != nil {
+			return placement{err: err}
+		}
+		// e.g. eu-west-1a is a zone in eu-west-1 region
+		return placement{zone: zone, region: strings.TrimRight(zone, "abcdefghijklmnopqrstuvwxyz")}
+	case gcpProvider:
+		path, err := gcpMetadata("instance/zone")
+		if err != nil {
+			return placement{err: err}
+		}
+		// e.g. projects/123/zones/europe-west1-b is a zone in europe-west1 region
+		zone := path[strings.LastIndex(path, "/")+1:]
+		i := strings.LastIndex(zone, "-")
+		if i < 0 {
+			return placement{err: fmt.Errorf("invalid GCP zone: %s", zone)}
+		}
+		return placement{zone: zone, region: zone[:i]}
+	case "":
+		return placement{err: fmt.Errorf("no %s environment variable set", metadataProviderEnv)}
+	default:
+		return placement{err: fmt.Errorf("unknown cloud metadata provider: %s", provider)}
+	}
+}
+
+func awsMetadata(path string) (string, error) {
+	request, err := http.NewRequest(http.MethodGet, awsMetadataURL+"/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	// use session token (IMDSv2) when available, fall back to IMDSv1 otherwise
+	tokenRequest, err := http.NewRequest(http.MethodPut, awsMetadataURL+"/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	tokenRequest.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	if token, err := doMetadataRequest(tokenRequest); err == nil {
+		request.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	return doMetadataRequest(request)
+}
+
+func gcpMetadata(path string) (string, error) {
+	request, err := http.NewRequest(http.MethodGet, gcpMetadataURL+"/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Metadata-Flavor", "Google")
+	return doMetadataRequest(request)
+}
+
+func doMetadataRequest(request *http.Request) (string, error) {
+	response, err := metadataClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("unable to query metadata service: %s", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata service returned %s for %s", response.Status, request.URL.Path)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read metadata service response: %s", err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
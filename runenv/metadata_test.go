@@ -0,0 +1,113 @@
+package runenv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfReadsPlacementFromAWSMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			_, _ = w.Write([]byte("token"))
+		case r.URL.Path == "/latest/meta-data/placement/availability-zone" &&
+			r.Header.Get("X-aws-ec2-metadata-token") == "token":
+			_, _ = w.Write([]byte("eu-west-1a"))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	defer func(url string) { awsMetadataURL = url }(awsMetadataURL)
+	awsMetadataURL = server.URL + "/latest"
+
+	p := fetchPlacement("aws")
+
+	require.NoError(t, p.err)
+	assert.Equal(t, "eu-west-1a", p.zone)
+	assert.Equal(t, "eu-west-1", p.region)
+}
+
+func TestIfReadsPlacementFromGCPMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/zone" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("projects/123/zones/europe-west1-b"))
+	}))
+	defer server.Close()
+	defer func(url string) { gcpMetadataURL = url }(gcpMetadataURL)
+	gcpMetadataURL = server.URL + "/computeMetadata/v1"
+
+	p := fetchPlacement("gcp")
+
+	require.NoError(t, p.err)
+	assert.Equal(t, "europe-west1-b", p.zone)
+	assert.Equal(t, "europe-west1", p.region)
+}
+
+func TestIfFailsToReadPlacementWhenMetadataIsUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	defer func(url string) { awsMetadataURL = url }(awsMetadataURL)
+	awsMetadataURL = server.URL
+
+	assert.Error(t, fetchPlacement("aws").err)
+	assert.Error(t, fetchPlacement("azure").err)
+	assert.Error(t, fetchPlacement("").err)
+}
+
+func TestIfResolvesPlacementInBackgroundAndRetriesAfterError(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("projects/123/zones/europe-west1-b"))
+	}))
+	defer server.Close()
+	defer func(url string) { gcpMetadataURL = url }(gcpMetadataURL)
+	gcpMetadataURL = server.URL
+	defer func(interval time.Duration) { placementRetryInterval = interval }(placementRetryInterval)
+	placementRetryInterval = 0
+	defer resetPlacement()
+	resetPlacement()
+	os.Setenv(metadataProviderEnv, gcpProvider)
+	defer os.Unsetenv(metadataProviderEnv)
+
+	ResolvePlacement()
+	_, _, err := metadataPlacement()
+	assert.Error(t, err, "placement should not be resolved before lookup finishes")
+
+	assert.Eventually(t, func() bool {
+		_, region, err := metadataPlacement()
+		return err == nil && region == "europe-west1"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func resetPlacement() {
+	for {
+		placementMutex.Lock()
+		pending := placementPending
+		if !pending {
+			cachedPlacement = placement{}
+			placementResolved = false
+			placementFailedAt = time.Time{}
+		}
+		placementMutex.Unlock()
+		if !pending {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
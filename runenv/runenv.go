@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"regexp"
+	"strings"
 )
 
 const (
@@ -16,11 +17,16 @@ const (
 	TestEnv = Env("test")
 	// ProdEnv represents production environment.
 	ProdEnv = Env("prod")
+	// StageEnv represents staging environment.
+	StageEnv = Env("stage")
 
 	defaultEnvironment = LocalEnv
 )
 
-var environmentRegexp = regexp.MustCompile(`.*-(prod|test|dev)\..*`)
+// Environments is a list of environments recognized in the hostname suffix
+// (e.g. host-prod.example.com). Hosts without recognized suffix are in the
+// local environment.
+var Environments = []Env{ProdEnv, TestEnv, DevEnv}
 
 var getOsHostname = OsHostname
 
@@ -35,8 +41,11 @@ func Environment() (Env, error) {
 	if err != nil {
 		return defaultEnvironment, err
 	}
+	if len(Environments) == 0 {
+		return defaultEnvironment, nil
+	}
 
-	matches := environmentRegexp.FindStringSubmatch(hostname)
+	matches := environmentRegexp().FindStringSubmatch(hostname)
 
 	if matches == nil || len(matches) == 1 {
 		return defaultEnvironment, nil
@@ -45,10 +54,26 @@ func Environment() (Env, error) {
 	return Env(matches[1]), nil
 }
 
-// AvailabilityZone return the name of runtime availability zone. It returns
+func environmentRegexp() *regexp.Regexp {
+	names := make([]string, 0, len(Environments))
+	for _, env := range Environments {
+		names = append(names, regexp.QuoteMeta(string(env)))
+	}
+	return regexp.MustCompile(fmt.Sprintf(`.*-(%s)\..*`, strings.Join(names, "|")))
+}
+
+// AvailabilityZone return the name of runtime availability zone. When it is
+// not set in the environment, it is read from cloud metadata service. It returns
 // empty string with erro if it cannot determine the name.
 func AvailabilityZone() (string, error) {
-	return getEnvVarIfSet("CLOUD_AVAILABILITY_ZONE")
+	zone, err := getEnvVarIfSet("CLOUD_AVAILABILITY_ZONE")
+	if err == nil {
+		return zone, nil
+	}
+	if zone, _, metadataErr := metadataPlacement(); metadataErr == nil {
+		return zone, nil
+	}
+	return "", err
 }
 
 // Datacenter returns the name of runtime datacenter. It returns empty string with
@@ -68,9 +93,14 @@ func Hostname() (string, error) {
 	return getOsHostname()
 }
 
-// IP returns the IP of runtime host.
+// IP returns the IP of runtime host. IPv6 address may be enclosed in square
+// brackets.
 func IP() net.IP {
-	return net.ParseIP(os.Getenv("CLOUD_PUBLIC_IP"))
+	ip := strings.TrimSpace(os.Getenv("CLOUD_PUBLIC_IP"))
+	if strings.HasPrefix(ip, "[") && strings.HasSuffix(ip, "]") {
+		ip = ip[1 : len(ip)-1]
+	}
+	return net.ParseIP(ip)
 }
 
 // MarathonAppID returns ID of Marathon application in which context the process
@@ -79,10 +109,18 @@ func MarathonAppID() (string, error) {
 	return getEnvVarIfSet("MARATHON_APP_ID")
 }
 
-// Region returns the name of runtime cloud region. It returns empty string with
-// error if it cannot determine the name.
+// Region returns the name of runtime cloud region. When it is not set in the
+// environment, it is read from cloud metadata service. It returns empty string
+// with error if it cannot determine the name.
 func Region() (string, error) {
-	return getEnvVarIfSet("CLOUD_REGION")
+	region, err := getEnvVarIfSet("CLOUD_REGION")
+	if err == nil {
+		return region, nil
+	}
+	if _, region, metadataErr := metadataPlacement(); metadataErr == nil {
+		return region, nil
+	}
+	return "", err
 }
 
 // TaskID returns mesos task ID. It returns empty string with error if it cannot
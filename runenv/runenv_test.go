@@ -38,6 +38,44 @@ func TestEnvironmentParsingFromEnvVariables(t *testing.T) {
 	}
 }
 
+var configuredEnvironmentTests = []struct {
+	hostname    string
+	environment Env
+}{
+	{"host-stage.example.com", StageEnv},
+	{"host-sandbox.example.com", Env("sandbox")},
+	{"host-prod.example.com", ProdEnv},
+	{"host-dev.example.com", LocalEnv},
+	{"host-stage-x.example.com", LocalEnv},
+}
+
+func TestEnvironmentParsingWithConfiguredEnvironments(t *testing.T) {
+	defer func(environments []Env) { Environments = environments }(Environments)
+	Environments = []Env{ProdEnv, StageEnv, Env("sandbox")}
+
+	for _, environmentTest := range configuredEnvironmentTests {
+		os.Clearenv()
+		_ = os.Setenv("MESOS_HOSTNAME", environmentTest.hostname)
+
+		env, err := Environment()
+
+		require.NoError(t, err)
+		assert.Equal(t, environmentTest.environment, env, environmentTest.hostname)
+	}
+}
+
+func TestEnvironmentIsLocalWhenNoEnvironmentsAreConfigured(t *testing.T) {
+	defer func(environments []Env) { Environments = environments }(Environments)
+	Environments = nil
+	os.Clearenv()
+	_ = os.Setenv("MESOS_HOSTNAME", "host-prod.example.com")
+
+	env, err := Environment()
+
+	require.NoError(t, err)
+	assert.Equal(t, LocalEnv, env)
+}
+
 func TestGetEnvVarIfSetGetsEnvVar(t *testing.T) {
 	_ = os.Setenv("TEST_ENV1", "TEST_VALUE1")
 
@@ -75,3 +113,17 @@ func TestGetEnvWithNoVariablesReturnsOsFqdnBasedEnv(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, ProdEnv, env)
 }
+
+func TestIfParsesIPv4AndIPv6PublicIP(t *testing.T) {
+	defer os.Unsetenv("CLOUD_PUBLIC_IP")
+
+	for env, expected := range map[string]string{
+		"10.0.0.1":      "10.0.0.1",
+		"2001:db8::1":   "2001:db8::1",
+		"[2001:db8::1]": "2001:db8::1",
+	} {
+		os.Setenv("CLOUD_PUBLIC_IP", env)
+		require.NotNil(t, IP(), env)
+		assert.Equal(t, expected, IP().String())
+	}
+}
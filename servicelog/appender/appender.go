@@ -1,8 +1,47 @@
 package appender
 
-import "github.com/allegro/mesos-executor/servicelog"
+import (
+	"fmt"
+	"sync"
+
+	"github.com/allegro/mesos-executor/servicelog"
+)
 
 // Appender delivers service log entries to their destination.
 type Appender interface {
+	// Append sends entries received from the channel until it is closed.
 	Append(entries <-chan servicelog.Entry)
+	// Close sends entries buffered by the appender and frees its resources
+	// (e.g. network connections). It should be called after Append returns.
+	Close() error
+}
+
+// FromEnv creates an appender configured with the environment variables.
+type FromEnv func() (Appender, error)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]FromEnv)
+)
+
+// Register makes an appender available under the given name, used as a value of
+// the log-scraping task label. It panics when the name is already registered.
+func Register(name string, fromEnv FromEnv) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("appender %s is already registered", name))
+	}
+	registry[name] = fromEnv
+}
+
+// Lookup returns a function creating the appender registered under the given
+// name, or false when there is no such appender.
+func Lookup(name string) (FromEnv, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	fromEnv, ok := registry[name]
+	return fromEnv, ok
 }
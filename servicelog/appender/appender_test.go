@@ -0,0 +1,23 @@
+package appender
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfLogstashAndSyslogAppendersAreRegistered(t *testing.T) {
+	for _, name := range []string{"logstash", "syslog"} {
+		_, ok := Lookup(name)
+		assert.True(t, ok, name)
+	}
+
+	_, ok := Lookup("default")
+	assert.False(t, ok)
+}
+
+func TestIfRegisterPanicsWhenAppenderIsAlreadyRegistered(t *testing.T) {
+	assert.Panics(t, func() {
+		Register("logstash", LogstashAppenderFromEnv)
+	})
+}
@@ -1,10 +1,15 @@
 package appender
 
 import (
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"time"
+	"unicode/utf8"
 
 	"github.com/hashicorp/consul/api"
 	jsoniter "github.com/json-iterator/go"
@@ -20,31 +25,78 @@ import (
 const (
 	logstashVersion      = 1
 	logstashConfigPrefix = "allegro_executor_servicelog_logstash"
+	truncationMarker     = "...(truncated)"
+
+	defaultTimestampKey = "time"
+	defaultMessageKey   = "msg"
 )
 
 var json = jsoniter.ConfigFastest
 
+func init() {
+	Register("logstash", LogstashAppenderFromEnv)
+}
+
 type logstashConfig struct {
 	Protocol                 string `default:"tcp"`
 	Address                  string
 	DiscoveryRefreshInterval time.Duration `default:"1s" split_words:"true"`
 	DiscoveryServiceName     string        `split_words:"true"`
+	// DiscoveryType selects how instances are discovered: with Consul or with
+	// DNS SRV records of the service in DiscoveryDomain
+	DiscoveryType   string `default:"consul" split_words:"true"`
+	DiscoveryDomain string `split_words:"true"`
+	// DiscoveryFallbackDatacenters are queried in order when there are no
+	// Logstash instances in the local datacenter
+	DiscoveryFallbackDatacenters []string `split_words:"true"`
+
+	RateLimit int  `split_words:"true"`
+	RateBurst int  `split_words:"true"`
+	SizeLimit int  `split_words:"true"`
+	Gzip      bool `default:"false"`
+	// SizeLimitMode controls what happens with logs exceeding SizeLimit, they
+	// are dropped by default or truncated with "truncate" mode
+	SizeLimitMode string `default:"drop" split_words:"true"`
 
-	RateLimit int `split_words:"true"`
-	SizeLimit int `split_words:"true"`
+	TimestampKey string `default:"time" split_words:"true"`
+	MessageKey   string `default:"msg" split_words:"true"`
 
 	TCPKeepAlive time.Duration `default:"5s" envconfig:"tcp_keep_alive"`
 	TCPTimeout   time.Duration `default:"2s" envconfig:"tcp_timeout"`
+
+	// TCPMaxConnections is a maximal number of TCP connections kept open to
+	// discovered instances, number of connections is unlimited when it is 0
+	TCPMaxConnections int `envconfig:"tcp_max_connections"`
+
+	// UDPWriteBuffer is a size of the system send buffer of UDP sockets,
+	// system default is used when it is 0
+	UDPWriteBuffer int `envconfig:"udp_write_buffer"`
+
+	// BatchSize is a maximal size of entries sent to discovered instances with
+	// a single write, batching is disabled when it is 0
+	BatchSize     int           `split_words:"true"`
+	BatchMaxDelay time.Duration `default:"1s" split_words:"true"`
+
+	TLSEnabled    bool   `envconfig:"tls_enabled"`
+	TLSCAFile     string `envconfig:"tls_ca_file"`
+	TLSCertFile   string `envconfig:"tls_cert_file"`
+	TLSKeyFile    string `envconfig:"tls_key_file"`
+	TLSServerName string `envconfig:"tls_server_name"`
 }
 
 type logstashEntry map[string]interface{}
 
 type logstash struct {
-	writer io.Writer
+	writer       io.Writer
+	closer       io.Closer
+	timestampKey string
+	messageKey   string
+	truncateSize int
 
 	droppedBecauseOfRate    metrics.Counter
 	droppedBecauseOfSize    metrics.Counter
 	droppedBecauseOfTimeout metrics.Counter
+	truncatedBecauseOfSize  metrics.Counter
 	writeTimer              metrics.Timer
 }
 
@@ -56,14 +108,37 @@ func (l *logstash) Append(entries <-chan servicelog.Entry) {
 	}
 }
 
+// Close flushes and closes the underlying writer when it is an io.Closer.
+func (l *logstash) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	if err := l.closer.Close(); err != nil {
+		return fmt.Errorf("unable to close Logstash writer: %s", err)
+	}
+	return nil
+}
+
 func (l *logstash) formatEntry(entry servicelog.Entry) logstashEntry {
+	timestampKey, messageKey := l.timestampKey, l.messageKey
+	if timestampKey == "" {
+		timestampKey = defaultTimestampKey
+	}
+	if messageKey == "" {
+		messageKey = defaultMessageKey
+	}
+
 	formattedEntry := logstashEntry{}
-	formattedEntry["@timestamp"] = entry["time"]
+	if timestamp, ok := entry[timestampKey]; ok {
+		formattedEntry["@timestamp"] = timestamp
+	} else {
+		formattedEntry["@timestamp"] = time.Now().Format(time.RFC3339Nano)
+	}
 	formattedEntry["@version"] = logstashVersion
-	formattedEntry["message"] = entry["msg"]
+	formattedEntry["message"] = entry[messageKey]
 
 	for key, value := range entry {
-		if key == "msg" || key == "time" {
+		if key == messageKey || key == timestampKey {
 			continue
 		}
 		formattedEntry[key] = value
@@ -78,6 +153,11 @@ func (l *logstash) sendEntry(entry servicelog.Entry) error {
 	if err != nil {
 		return fmt.Errorf("unable to marshal log entry: %s", err)
 	}
+	if l.truncateSize > 0 && len(bytes) > l.truncateSize {
+		if bytes, err = l.truncate(formattedEntry, bytes); err != nil {
+			return fmt.Errorf("unable to marshal truncated log entry: %s", err)
+		}
+	}
 	log.WithField("entry", string(bytes)).Debug("Sending log entry to Logstash")
 	l.writeTimer.Time(func() { _, err = l.writer.Write(bytes) })
 	if err != nil {
@@ -99,6 +179,41 @@ func (l *logstash) sendEntry(entry servicelog.Entry) error {
 	return nil
 }
 
+// truncate shortens message of the entry until marshaled entry fits in the
+// truncation size. Entry that does not fit even with empty message is returned
+// as is.
+func (l *logstash) truncate(entry logstashEntry, bytes []byte) ([]byte, error) {
+	message, ok := entry["message"].(string)
+	if !ok {
+		return bytes, nil
+	}
+	l.truncatedBecauseOfSize.Inc(1)
+	for len(bytes) > l.truncateSize && message != "" {
+		message = truncateString(message, len(message)-(len(bytes)-l.truncateSize)-len(truncationMarker))
+		entry["message"] = message + truncationMarker
+		var err error
+		if bytes, err = l.marshal(entry); err != nil {
+			return nil, err
+		}
+	}
+	return bytes, nil
+}
+
+// truncateString returns at most size bytes long prefix of the passed string
+// without splitting multibyte characters.
+func truncateString(value string, size int) string {
+	if size <= 0 {
+		return ""
+	}
+	if size >= len(value) {
+		return value
+	}
+	for size > 0 && !utf8.RuneStart(value[size]) {
+		size--
+	}
+	return value[:size]
+}
+
 func (l *logstash) marshal(entry logstashEntry) ([]byte, error) {
 	bytes, err := json.Marshal(entry)
 	if err != nil {
@@ -111,13 +226,16 @@ func (l *logstash) marshal(entry logstashEntry) ([]byte, error) {
 }
 
 // NewLogstash creates new appender that will send log entries to Logstash using
-// passed writer.
+// passed writer. Writer is closed with the appender when it is an io.Closer.
 func NewLogstash(writer io.Writer, options ...func(*logstash) error) (Appender, error) {
+	closer, _ := writer.(io.Closer)
 	l := &logstash{
 		writer:                  writer,
+		closer:                  closer,
 		droppedBecauseOfRate:    metrics.GetOrRegisterCounter("servicelog.logstash.dropped.RateExceeded", metrics.DefaultRegistry),
 		droppedBecauseOfSize:    metrics.GetOrRegisterCounter("servicelog.logstash.dropped.SizeExceeded", metrics.DefaultRegistry),
 		droppedBecauseOfTimeout: metrics.GetOrRegisterCounter("servicelog.logstash.dropped.Timeout", metrics.DefaultRegistry),
+		truncatedBecauseOfSize:  metrics.GetOrRegisterCounter("servicelog.logstash.truncated.SizeExceeded", metrics.DefaultRegistry),
 		writeTimer:              metrics.GetOrRegisterTimer("servicelog.logstash.WriteTimer", metrics.DefaultRegistry),
 	}
 	for _, option := range options {
@@ -128,30 +246,127 @@ func NewLogstash(writer io.Writer, options ...func(*logstash) error) (Appender,
 	return l, nil
 }
 
+// LogstashWriterOption is an optional configuration of writers sending data to
+// discovered Logstash instances.
+type LogstashWriterOption func(*logstashWriterConfig)
+
+type logstashWriterConfig struct {
+	dialer              *net.Dialer
+	tlsConfig           *tls.Config
+	maxConnections      int
+	udpWriteBuffer      int
+	batchSize           int
+	batchMaxDelay       time.Duration
+	fallbackDatacenters []string
+}
+
+// LogstashWriterDialer sets a dialer used to make TCP connections. Default
+// dialer is used when it is not set.
+func LogstashWriterDialer(dialer *net.Dialer) LogstashWriterOption {
+	return func(cfg *logstashWriterConfig) {
+		cfg.dialer = dialer
+	}
+}
+
+// LogstashWriterTLS makes TCP connections to every instance encrypted with
+// passed TLS config.
+func LogstashWriterTLS(tlsConfig *tls.Config) LogstashWriterOption {
+	return func(cfg *logstashWriterConfig) {
+		cfg.tlsConfig = tlsConfig
+	}
+}
+
+// LogstashWriterMaxConnections limits number of TCP connections kept open to
+// discovered instances. Least recently used connections are closed when the
+// limit is reached.
+func LogstashWriterMaxConnections(max int) LogstashWriterOption {
+	return func(cfg *logstashWriterConfig) {
+		cfg.maxConnections = max
+	}
+}
+
+// LogstashWriterUDPWriteBuffer sets size of send buffers of UDP sockets.
+func LogstashWriterUDPWriteBuffer(size int) LogstashWriterOption {
+	return func(cfg *logstashWriterConfig) {
+		cfg.udpWriteBuffer = size
+	}
+}
+
+// LogstashWriterBatching makes entries sent in batches of up to size bytes,
+// delayed by at most maxDelay.
+func LogstashWriterBatching(size int, maxDelay time.Duration) LogstashWriterOption {
+	return func(cfg *logstashWriterConfig) {
+		cfg.batchSize = size
+		cfg.batchMaxDelay = maxDelay
+	}
+}
+
+// LogstashWriterFallbackDatacenters sets Consul datacenters used when there are
+// no instances in the local one.
+func LogstashWriterFallbackDatacenters(datacenters ...string) LogstashWriterOption {
+	return func(cfg *logstashWriterConfig) {
+		cfg.fallbackDatacenters = datacenters
+	}
+}
+
 // NewConsulLogstashWriter creates a new writer that will write data to instances
 // provided by local Consul agent. It will use round robin algorithm to spread
-// logs evenly to every Logstash instance. For TCP connections customised dialer
-// can be optionally passed to have more control over how the connections are made.
-func NewConsulLogstashWriter(protocol, serviceName string, refreshInterval time.Duration, dialer *net.Dialer) (io.Writer, error) {
+// logs evenly to every Logstash instance. Connections and batching can be
+// customised with options.
+func NewConsulLogstashWriter(protocol, serviceName string, refreshInterval time.Duration,
+	options ...LogstashWriterOption) (io.Writer, error) {
 	consulClient, err := api.NewClient(api.DefaultConfig())
 	if err != nil {
 		return nil, fmt.Errorf("unable to create Consul client: %s", err)
 	}
-	discoveryClient := xnet.NewConsulDiscoveryServiceClient(consulClient)
+	cfg := newLogstashWriterConfig(options)
+	discoveryClient := xnet.NewConsulDiscoveryServiceClient(consulClient, cfg.fallbackDatacenters...)
+	return NewDiscoveryLogstashWriter(protocol, serviceName, refreshInterval, discoveryClient, options...), nil
+}
+
+// NewDiscoveryLogstashWriter works like NewConsulLogstashWriter but uses passed
+// discovery client to find Logstash instances.
+func NewDiscoveryLogstashWriter(protocol, serviceName string, refreshInterval time.Duration,
+	discoveryClient xnet.DiscoveryServiceClient, options ...LogstashWriterOption) io.Writer {
 	instanceProvider := xnet.DiscoveryServiceInstanceProvider(serviceName, refreshInterval, discoveryClient)
+	sender := logstashSender(protocol, newLogstashWriterConfig(options))
+	return xnet.RoundRobinWriter(instanceProvider, sender)
+}
+
+func newLogstashWriterConfig(options []LogstashWriterOption) logstashWriterConfig {
+	var cfg logstashWriterConfig
+	for _, option := range options {
+		option(&cfg)
+	}
+	return cfg
+}
+
+// logstashSender creates a sender for discovered Logstash instances. Passed
+// dialer is used as is, default one is used only when it is nil.
+func logstashSender(protocol string, cfg logstashWriterConfig) xnet.Sender {
 	var sender xnet.Sender
 	if protocol == "udp" {
-		sender = &xnet.UDPSender{}
+		sender = &xnet.UDPSender{WriteBuffer: cfg.udpWriteBuffer}
 	} else {
-		if dialer != nil {
+		dialer := cfg.dialer
+		if dialer == nil {
 			dialer = &net.Dialer{}
 		}
-		tcpSender := &xnet.TCPSender{
-			Dialer: *dialer,
+		sender = &xnet.TCPSender{
+			Dialer:         *dialer,
+			TLSConfig:      cfg.tlsConfig,
+			MaxConnections: cfg.maxConnections,
+		}
+	}
+	if cfg.batchSize > 0 {
+		sender = &xnet.BatchingSender{
+			Sender:   sender,
+			MaxSize:  cfg.batchSize,
+			MaxDelay: cfg.batchMaxDelay,
+			Dropped:  metrics.GetOrRegisterCounter("servicelog.logstash.dropped.BatchSendFailed", metrics.DefaultRegistry),
 		}
-		sender = tcpSender
 	}
-	return xnet.RoundRobinWriter(instanceProvider, sender), nil
+	return sender
 }
 
 // LogstashAppenderFromEnv creates the appender from the environment variables.
@@ -167,35 +382,160 @@ func LogstashAppenderFromEnv() (Appender, error) {
 	log.Infof("Address                  = %s", config.Address)
 	log.Infof("DiscoveryRefreshInterval = %s", config.DiscoveryRefreshInterval)
 	log.Infof("DiscoveryServiceName     = %s", config.DiscoveryServiceName)
+	log.Infof("DiscoveryType            = %s", config.DiscoveryType)
+	log.Infof("DiscoveryDomain          = %s", config.DiscoveryDomain)
+	log.Infof("DiscoveryFallbackDCs     = %s", config.DiscoveryFallbackDatacenters)
 	log.Infof("RateLimit                = %d", config.RateLimit)
+	log.Infof("RateBurst                = %d", config.RateBurst)
 	log.Infof("SizeLimit                = %d", config.SizeLimit)
+	log.Infof("SizeLimitMode            = %s", config.SizeLimitMode)
+	log.Infof("Gzip                     = %t", config.Gzip)
+	log.Infof("TimestampKey             = %s", config.TimestampKey)
+	log.Infof("MessageKey               = %s", config.MessageKey)
 	log.Infof("TCPKeepAlive             = %s", config.TCPKeepAlive)
 	log.Infof("TCPTimeout               = %s", config.TCPTimeout)
+	log.Infof("TCPMaxConnections        = %d", config.TCPMaxConnections)
+	log.Infof("UDPWriteBuffer           = %d", config.UDPWriteBuffer)
+	log.Infof("BatchSize                = %d", config.BatchSize)
+	log.Infof("BatchMaxDelay            = %s", config.BatchMaxDelay)
+	log.Infof("TLSEnabled               = %t", config.TLSEnabled)
+	log.Infof("TLSCAFile                = %s", config.TLSCAFile)
+	log.Infof("TLSCertFile              = %s", config.TLSCertFile)
+	log.Infof("TLSKeyFile               = %s", config.TLSKeyFile)
+	log.Infof("TLSServerName            = %s", config.TLSServerName)
 
-	var baseWriter io.Writer
-	if len(config.DiscoveryServiceName) > 0 {
-		dialer := &net.Dialer{
-			KeepAlive: config.TCPKeepAlive,
-			Timeout:   config.TCPTimeout,
+	if config.SizeLimitMode != "drop" && config.SizeLimitMode != "truncate" {
+		return nil, fmt.Errorf("invalid size limit mode: %s", config.SizeLimitMode)
+	}
+	if config.DiscoveryType != "consul" && config.DiscoveryType != "dns" {
+		return nil, fmt.Errorf("invalid discovery type: %s", config.DiscoveryType)
+	}
+
+	var tlsConfig *tls.Config
+	if config.TLSEnabled {
+		if config.Protocol != "tcp" {
+			return nil, fmt.Errorf("TLS is not supported with %s protocol", config.Protocol)
+		}
+		if tlsConfig, err = logstashTLSConfig(config); err != nil {
+			return nil, fmt.Errorf("invalid logstash TLS configuration: %s", err)
 		}
-		baseWriter, err = NewConsulLogstashWriter(config.Protocol,
-			config.DiscoveryServiceName, config.DiscoveryRefreshInterval, dialer)
+	}
+
+	dialer := &net.Dialer{
+		KeepAlive: config.TCPKeepAlive,
+		Timeout:   config.TCPTimeout,
+	}
+	writerOptions := []LogstashWriterOption{
+		LogstashWriterDialer(dialer),
+		LogstashWriterTLS(tlsConfig),
+		LogstashWriterMaxConnections(config.TCPMaxConnections),
+		LogstashWriterUDPWriteBuffer(config.UDPWriteBuffer),
+		LogstashWriterBatching(config.BatchSize, config.BatchMaxDelay),
+		LogstashWriterFallbackDatacenters(config.DiscoveryFallbackDatacenters...),
+	}
+	var baseWriter io.Writer
+	if len(config.DiscoveryServiceName) > 0 && config.DiscoveryType == "dns" {
+		discoveryClient := xnet.NewDNSDiscoveryServiceClient(config.Protocol, config.DiscoveryDomain)
+		baseWriter = NewDiscoveryLogstashWriter(config.Protocol, config.DiscoveryServiceName,
+			config.DiscoveryRefreshInterval, discoveryClient, writerOptions...)
+	} else if len(config.DiscoveryServiceName) > 0 {
+		baseWriter, err = NewConsulLogstashWriter(config.Protocol, config.DiscoveryServiceName,
+			config.DiscoveryRefreshInterval, writerOptions...)
+	} else if tlsConfig != nil {
+		baseWriter, err = tls.DialWithDialer(dialer, config.Protocol, config.Address, tlsConfig)
 	} else {
 		baseWriter, err = net.Dial(config.Protocol, config.Address)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("invalid logstash connection data: %s", err)
 	}
-	var options []func(*logstash) error
-	if config.RateLimit > 0 {
+	if udpConn, ok := baseWriter.(*net.UDPConn); ok && config.UDPWriteBuffer > 0 {
+		if err := udpConn.SetWriteBuffer(config.UDPWriteBuffer); err != nil {
+			return nil, fmt.Errorf("unable to set UDP write buffer: %s", err)
+		}
+	}
+	options := []func(*logstash) error{
+		LogstashTimestampKey(config.TimestampKey),
+		LogstashMessageKey(config.MessageKey),
+	}
+	// compression is applied first, so limits are checked against uncompressed entries
+	if config.Gzip {
+		options = append(options, LogstashGzip())
+	}
+	if config.RateLimit > 0 && config.RateBurst > 0 {
+		options = append(options, LogstashRateLimitWithBurst(config.RateLimit, config.RateBurst))
+	} else if config.RateLimit > 0 {
 		options = append(options, LogstashRateLimit(config.RateLimit))
 	}
-	if config.SizeLimit > 0 {
+	if config.SizeLimit > 0 && config.SizeLimitMode == "truncate" {
+		options = append(options, LogstashSizeTruncate(config.SizeLimit))
+	} else if config.SizeLimit > 0 {
 		options = append(options, LogstashSizeLimit(config.SizeLimit))
 	}
 	return NewLogstash(baseWriter, options...)
 }
 
+func logstashTLSConfig(config *logstashConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: config.TLSServerName}
+	if config.TLSCAFile != "" {
+		ca, err := ioutil.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", config.TLSCAFile)
+		}
+	}
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// LogstashTimestampKey sets a key of log entry field used as a Logstash
+// @timestamp. Current time is used for entries without it.
+func LogstashTimestampKey(key string) func(*logstash) error {
+	return func(l *logstash) error {
+		l.timestampKey = key
+		return nil
+	}
+}
+
+// LogstashMessageKey sets a key of log entry field used as a Logstash message.
+func LogstashMessageKey(key string) func(*logstash) error {
+	return func(l *logstash) error {
+		l.messageKey = key
+		return nil
+	}
+}
+
+// LogstashSizeTruncate adds size limiting to logs sending. Messages of logs that
+// exceeds passed size (in bytes) are truncated to fit in it. Logs that does not
+// fit even with truncated message will be discarded.
+func LogstashSizeTruncate(size int) func(*logstash) error {
+	return func(l *logstash) error {
+		l.truncateSize = size
+		l.writer = xio.DecorateWriter(l.writer, xio.SizeLimit(size))
+		return nil
+	}
+}
+
+// LogstashGzip adds gzip compression to logs sending. Every log entry is
+// compressed separately, so Logstash needs to decompress the input stream
+// (e.g. with gzip_lines codec) to get newline delimited entries. It should be
+// passed before limiting options for limits to apply to uncompressed entries.
+func LogstashGzip() func(*logstash) error {
+	return func(l *logstash) error {
+		l.writer = xio.DecorateWriter(l.writer, xio.Gzip(gzip.DefaultCompression))
+		return nil
+	}
+}
+
 // LogstashRateLimit adds rate limiting to logs sending. Logs send in higher rate
 // (log lines per seconds) will be discarded.
 func LogstashRateLimit(limit int) func(*logstash) error {
@@ -205,6 +545,15 @@ func LogstashRateLimit(limit int) func(*logstash) error {
 	}
 }
 
+// LogstashRateLimitWithBurst works like LogstashRateLimit but allows bursts of
+// up to burst log lines exceeding the average rate limit.
+func LogstashRateLimitWithBurst(limit, burst int) func(*logstash) error {
+	return func(l *logstash) error {
+		l.writer = xio.DecorateWriter(l.writer, xio.RateLimitWithBurst(limit, burst))
+		return nil
+	}
+}
+
 // LogstashSizeLimit adds size limiting to logs sending. Logs that exceeds passed
 // size (in bytes) will be discarded.
 func LogstashSizeLimit(size int) func(*logstash) error {
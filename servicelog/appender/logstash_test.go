@@ -6,8 +6,10 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/allegro/mesos-executor/servicelog"
+	"github.com/allegro/mesos-executor/xnet"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -59,6 +61,82 @@ func TestIfFormatsLogsCorrectly(t *testing.T) {
 	assert.Equal(t, "my logger", formattedEntry["logger"])
 }
 
+func TestIfFormatsLogsWithCustomTimestampAndMessageKeys(t *testing.T) {
+	appender, err := NewLogstash(nil, LogstashTimestampKey("@t"), LogstashMessageKey("message"))
+	require.NoError(t, err)
+	servicelogEntry := servicelog.Entry{}
+	servicelogEntry["@t"] = "time"
+	servicelogEntry["message"] = "log message"
+	servicelogEntry["time"] = "other time"
+
+	formattedEntry := appender.(*logstash).formatEntry(servicelogEntry)
+
+	assert.Equal(t, "time", formattedEntry["@timestamp"])
+	assert.Equal(t, "log message", formattedEntry["message"])
+	assert.Equal(t, "other time", formattedEntry["time"])
+	assert.NotContains(t, formattedEntry, "@t")
+}
+
+func TestIfUsesCurrentTimeWhenLogHasNoTimestamp(t *testing.T) {
+	logstash := logstash{}
+	servicelogEntry := servicelog.Entry{}
+	servicelogEntry["msg"] = "log message"
+
+	formattedEntry := logstash.formatEntry(servicelogEntry)
+
+	timestamp, err := time.Parse(time.RFC3339Nano, formattedEntry["@timestamp"].(string))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), timestamp, time.Minute)
+}
+
+func TestIfTruncatesStringsWithoutSplittingCharacters(t *testing.T) {
+	assert.Equal(t, "abc", truncateString("abcdef", 3))
+	assert.Equal(t, "abcdef", truncateString("abcdef", 10))
+	assert.Equal(t, "", truncateString("abcdef", -1))
+	assert.Equal(t, "za", truncateString("zażółć", 3))
+}
+
+func TestIfLogstashSenderUsesPassedDialer(t *testing.T) {
+	dialer := &net.Dialer{Timeout: 3 * time.Second, KeepAlive: 7 * time.Second}
+
+	sender := logstashSender("tcp", newLogstashWriterConfig([]LogstashWriterOption{LogstashWriterDialer(dialer)}))
+
+	require.IsType(t, &xnet.TCPSender{}, sender)
+	assert.Equal(t, 3*time.Second, sender.(*xnet.TCPSender).Dialer.Timeout)
+	assert.Equal(t, 7*time.Second, sender.(*xnet.TCPSender).Dialer.KeepAlive)
+}
+
+func TestIfLogstashSenderLimitsTCPConnections(t *testing.T) {
+	cfg := newLogstashWriterConfig([]LogstashWriterOption{LogstashWriterMaxConnections(5)})
+
+	sender := logstashSender("tcp", cfg)
+
+	require.IsType(t, &xnet.TCPSender{}, sender)
+	assert.Equal(t, 5, sender.(*xnet.TCPSender).MaxConnections)
+}
+
+func TestIfLogstashSenderUsesDefaultDialerWhenNoneIsPassed(t *testing.T) {
+	sender := logstashSender("tcp", logstashWriterConfig{})
+
+	require.IsType(t, &xnet.TCPSender{}, sender)
+	assert.Equal(t, net.Dialer{}, sender.(*xnet.TCPSender).Dialer)
+}
+
+func TestIfLogstashSenderBatchesEntriesWhenBatchingIsConfigured(t *testing.T) {
+	cfg := newLogstashWriterConfig([]LogstashWriterOption{
+		LogstashWriterUDPWriteBuffer(1024),
+		LogstashWriterBatching(512, time.Second),
+	})
+
+	sender := logstashSender("udp", cfg)
+
+	require.IsType(t, &xnet.BatchingSender{}, sender)
+	batchingSender := sender.(*xnet.BatchingSender)
+	assert.Equal(t, 512, batchingSender.MaxSize)
+	assert.Equal(t, time.Second, batchingSender.MaxDelay)
+	assert.Equal(t, &xnet.UDPSender{WriteBuffer: 1024}, batchingSender.Sender)
+}
+
 func TestIfCreatesAppenderWithValidDiscoveryConfigurationInEnv(t *testing.T) {
 	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_PROTOCOL", "tcp")
 	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_DISCOVERY_SERVICE_NAME", "logstash")
@@ -71,6 +149,20 @@ func TestIfCreatesAppenderWithValidDiscoveryConfigurationInEnv(t *testing.T) {
 	assert.NotNil(t, logstash)
 }
 
+func TestIfCreatesAppenderWithValidDNSDiscoveryConfigurationInEnv(t *testing.T) {
+	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_DISCOVERY_SERVICE_NAME", "logstash")
+	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_DISCOVERY_TYPE", "dns")
+	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_DISCOVERY_DOMAIN", "example.com")
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_DISCOVERY_SERVICE_NAME")
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_DISCOVERY_TYPE")
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_DISCOVERY_DOMAIN")
+
+	logstash, err := LogstashAppenderFromEnv()
+
+	assert.NoError(t, err)
+	assert.NotNil(t, logstash)
+}
+
 func TestIfCreatesAppenderWithValidStaticAddressConfigurationInEnv(t *testing.T) {
 	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_PROTOCOL", "udp")
 	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_ADDRESS", "localhost:12345")
@@ -105,6 +197,10 @@ func TestIfFailsToCreateAppenderWithInvalidOptionalConfigurationInEnv(t *testing
 	}{
 		{"ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_RATE_LIMIT", "invalid"},
 		{"ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_SIZE_LIMIT", "invalid"},
+		{"ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_RATE_BURST", "invalid"},
+		{"ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_SIZE_LIMIT_MODE", "invalid"},
+		{"ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_TLS_ENABLED", "true"},
+		{"ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_DISCOVERY_TYPE", "invalid"},
 	}
 
 	for _, tc := range testCases {
@@ -117,3 +213,18 @@ func TestIfFailsToCreateAppenderWithInvalidOptionalConfigurationInEnv(t *testing
 		})
 	}
 }
+
+func TestIfFailsToCreateAppenderWithInvalidTLSConfigurationInEnv(t *testing.T) {
+	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_PROTOCOL", "tcp")
+	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_ADDRESS", "localhost:8080")
+	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_TLS_ENABLED", "true")
+	os.Setenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_TLS_CA_FILE", "/non/existing/ca.pem")
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_PROTOCOL")
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_ADDRESS")
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_TLS_ENABLED")
+	defer os.Unsetenv("ALLEGRO_EXECUTOR_SERVICELOG_LOGSTASH_TLS_CA_FILE")
+
+	_, err := LogstashAppenderFromEnv()
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,251 @@
+package appender
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/rcrowley/go-metrics"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/allegro/mesos-executor/servicelog"
+)
+
+const (
+	syslogConfigPrefix = "allegro_executor_servicelog_syslog"
+	syslogVersion      = 1
+	// syslogStructuredDataID is an ID of structured data element containing
+	// log entry fields. 32473 is an enterprise number reserved for examples.
+	syslogStructuredDataID = "fields@32473"
+	syslogMaxParamNameLen  = 32
+	syslogNilValue         = "-"
+	syslogRedialDelay      = time.Second
+)
+
+var errSyslogUnavailable = errors.New("syslog endpoint unavailable")
+
+func init() {
+	Register("syslog", SyslogAppenderFromEnv)
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"EMERG": 0, "EMERGENCY": 0, "PANIC": 0, "ALERT": 1, "CRIT": 2, "CRITICAL": 2,
+	"FATAL": 2, "ERR": 3, "ERROR": 3, "WARN": 4, "WARNING": 4, "NOTICE": 5,
+	"INFO": 6, "DEBUG": 7, "TRACE": 7,
+}
+
+type syslogConfig struct {
+	Protocol string        `default:"unixgram"`
+	Address  string        `default:"/dev/log"`
+	Facility string        `default:"local0"`
+	Tag      string        `default:"mesos-executor"`
+	Timeout  time.Duration `default:"2s"`
+}
+
+type syslog struct {
+	writer   io.Writer
+	facility int
+	tag      string
+	hostname string
+
+	droppedBecauseOfError metrics.Counter
+}
+
+func (s *syslog) Append(entries <-chan servicelog.Entry) {
+	for entry := range entries {
+		if _, err := s.writer.Write(s.formatEntry(entry)); err != nil {
+			s.droppedBecauseOfError.Inc(1)
+			log.WithError(err).Debug("Unable to send log entry to syslog")
+		}
+	}
+}
+
+// Close closes the syslog connection when the writer is an io.Closer.
+func (s *syslog) Close() error {
+	if closer, ok := s.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// formatEntry formats given entry as a RFC5424 message. Entry message is used
+// as the message body and all other fields are sent as structured data.
+func (s *syslog) formatEntry(entry servicelog.Entry) []byte {
+	severity := syslogSeverities["INFO"]
+	if level, ok := entry["level"].(string); ok {
+		if value, ok := syslogSeverities[strings.ToUpper(level)]; ok {
+			severity = value
+		}
+	}
+	timestamp := time.Now().Format(time.RFC3339Nano)
+	if value, ok := entry["time"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			timestamp = parsed.Format(time.RFC3339Nano)
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "<%d>%d %s %s %s %s %s ", s.facility*8+severity, syslogVersion,
+		timestamp, s.hostname, s.tag, syslogNilValue, syslogNilValue)
+	writeStructuredData(buffer, entry)
+	if msg, ok := entry["msg"]; ok {
+		buffer.WriteByte(' ')
+		fmt.Fprint(buffer, msg)
+	}
+	return buffer.Bytes()
+}
+
+func writeStructuredData(buffer *bytes.Buffer, entry servicelog.Entry) {
+	var keys []string
+	for key := range entry {
+		if key == "msg" || key == "time" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		buffer.WriteString(syslogNilValue)
+		return
+	}
+	sort.Strings(keys)
+
+	buffer.WriteString("[" + syslogStructuredDataID)
+	for _, key := range keys {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(fmt.Sprint(entry[key]))
+		fmt.Fprintf(buffer, ` %s="%s"`, syslogParamName(key), value)
+	}
+	buffer.WriteString("]")
+}
+
+// syslogParamName replaces characters not allowed in structured data parameter
+// names and truncates it to the maximal allowed length.
+func syslogParamName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+	if len(name) > syslogMaxParamNameLen {
+		name = name[:syslogMaxParamNameLen]
+	}
+	return name
+}
+
+// syslogWriter writes messages to the syslog endpoint. Connection is
+// established lazily and recreated after errors. Writes fail fast when the
+// endpoint is unavailable, so they never block log scraping for long.
+type syslogWriter struct {
+	protocol string
+	address  string
+	timeout  time.Duration
+
+	conn     net.Conn
+	nextDial time.Time
+}
+
+func (w *syslogWriter) Write(message []byte) (int, error) {
+	if w.conn == nil {
+		if time.Now().Before(w.nextDial) {
+			return 0, errSyslogUnavailable
+		}
+		conn, err := net.DialTimeout(w.protocol, w.address, w.timeout)
+		if err != nil {
+			w.nextDial = time.Now().Add(syslogRedialDelay)
+			return 0, fmt.Errorf("unable to connect to syslog: %s", err)
+		}
+		w.conn = conn
+	}
+
+	if w.protocol == "tcp" {
+		// stream transports use octet counting framing (RFC6587)
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+	if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		log.WithError(err).Debug("Unable to set syslog write deadline")
+	}
+	n, err := w.conn.Write(message)
+	if err != nil {
+		if closeErr := w.conn.Close(); closeErr != nil {
+			log.WithError(closeErr).Debug("Unable to close syslog connection properly")
+		}
+		w.conn = nil
+	}
+	return n, err
+}
+
+// Close closes the current syslog connection. New connection is established
+// on the next write.
+func (w *syslogWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// NewSyslog creates new appender that will send log entries to syslog using
+// passed writer. Facility is a syslog facility name (e.g. local0) and tag is
+// used as an application name.
+func NewSyslog(writer io.Writer, facility, tag string) (Appender, error) {
+	facilityCode, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility: %s", facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = syslogNilValue
+	}
+	return &syslog{
+		writer:                writer,
+		facility:              facilityCode,
+		tag:                   syslogParamName(tag),
+		hostname:              hostname,
+		droppedBecauseOfError: metrics.GetOrRegisterCounter("servicelog.syslog.dropped.Error", metrics.DefaultRegistry),
+	}, nil
+}
+
+// SyslogAppenderFromEnv creates the syslog appender from the environment
+// variables.
+func SyslogAppenderFromEnv() (Appender, error) {
+	config := &syslogConfig{}
+	err := envconfig.Process(syslogConfigPrefix, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get config from env: %s", err)
+	}
+
+	log.Info("Initializing syslog appender with following configuration:")
+	log.Infof("Protocol = %s", config.Protocol)
+	log.Infof("Address  = %s", config.Address)
+	log.Infof("Facility = %s", config.Facility)
+	log.Infof("Tag      = %s", config.Tag)
+	log.Infof("Timeout  = %s", config.Timeout)
+
+	switch config.Protocol {
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("unsupported syslog protocol: %s", config.Protocol)
+	}
+	writer := &syslogWriter{
+		protocol: config.Protocol,
+		address:  config.Address,
+		timeout:  config.Timeout,
+	}
+	return NewSyslog(writer, config.Facility, config.Tag)
+}
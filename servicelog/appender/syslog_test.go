@@ -0,0 +1,88 @@
+package appender
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allegro/mesos-executor/servicelog"
+)
+
+func TestIfFormatsEntryAsRFC5424Message(t *testing.T) {
+	s, err := NewSyslog(nil, "local0", "my-service")
+	require.NoError(t, err)
+	s.(*syslog).hostname = "host"
+
+	message := s.(*syslog).formatEntry(servicelog.Entry{
+		"time":   "2017-01-02T03:04:05.6Z",
+		"level":  "ERROR",
+		"msg":    "something failed",
+		"logger": "main",
+		"quote":  `a "b" ]`,
+	})
+
+	assert.Equal(t, `<131>1 2017-01-02T03:04:05.6Z host my-service - - `+
+		`[fields@32473 level="ERROR" logger="main" quote="a \"b\" \]"] something failed`, string(message))
+}
+
+func TestIfFormatsEntryWithoutFieldsAsRFC5424Message(t *testing.T) {
+	s, err := NewSyslog(nil, "user", "my-service")
+	require.NoError(t, err)
+	s.(*syslog).hostname = "host"
+
+	message := s.(*syslog).formatEntry(servicelog.Entry{"time": "2017-01-02T03:04:05Z", "msg": "hello"})
+
+	assert.Equal(t, "<14>1 2017-01-02T03:04:05Z host my-service - - - hello", string(message))
+}
+
+func TestIfFailsToCreateSyslogAppenderWithUnknownFacility(t *testing.T) {
+	_, err := NewSyslog(nil, "unknown", "my-service")
+
+	assert.Error(t, err)
+}
+
+func TestIfSendsLogsToSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	writer := &syslogWriter{protocol: "udp", address: conn.LocalAddr().String(), timeout: time.Second}
+	s, err := NewSyslog(writer, "local0", "my-service")
+	require.NoError(t, err)
+
+	entries := make(chan servicelog.Entry, 1)
+	entries <- servicelog.Entry{"msg": "hello"}
+	close(entries)
+	s.Append(entries)
+
+	buffer := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := conn.ReadFrom(buffer)
+	require.NoError(t, err)
+	assert.Contains(t, string(buffer[:n]), "my-service - - - hello")
+}
+
+func TestIfDropsLogsWhenSyslogIsUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	writer := &syslogWriter{protocol: "tcp", address: address, timeout: time.Second}
+	s, err := NewSyslog(writer, "local0", "my-service")
+	require.NoError(t, err)
+	dropped := s.(*syslog).droppedBecauseOfError.Count()
+
+	entries := make(chan servicelog.Entry, 2)
+	entries <- servicelog.Entry{"msg": "first"}
+	entries <- servicelog.Entry{"msg": "second"}
+	close(entries)
+	s.Append(entries)
+
+	assert.Equal(t, dropped+2, s.(*syslog).droppedBecauseOfError.Count())
+	_, err = writer.Write([]byte("third"))
+	assert.Equal(t, errSyslogUnavailable, err)
+}
@@ -17,13 +17,15 @@ type Extender interface {
 
 // Extend returns a channel that will return log entries extended with passed
 // extenders list. Original log entries are not modified, but duplicate keys are
-// overwritten in returned ones.
+// overwritten in returned ones. Returned channel is closed when the passed one
+// is closed.
 func Extend(in <-chan Entry, extenders ...Extender) <-chan Entry {
 	if len(extenders) == 0 {
 		return in
 	}
 	out := make(chan Entry)
 	go func() {
+		defer close(out)
 		for entry := range in {
 			extendedEntry := entry
 			for _, extender := range extenders {
@@ -0,0 +1,160 @@
+package servicelog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// EntryFilter decides which log entries should be forwarded.
+type EntryFilter interface {
+	// Accept returns true when passed log entry should be forwarded.
+	Accept(Entry) bool
+}
+
+// Filter returns a channel that will return only log entries accepted by all
+// passed filters. Returned channel is closed when the passed one is closed.
+func Filter(in <-chan Entry, filters ...EntryFilter) <-chan Entry {
+	if len(filters) == 0 {
+		return in
+	}
+	out := make(chan Entry)
+	go func() {
+		defer close(out)
+		for entry := range in {
+			if accepted(entry, filters) {
+				out <- entry
+			}
+		}
+	}()
+	return out
+}
+
+func accepted(entry Entry, filters []EntryFilter) bool {
+	for _, filter := range filters {
+		if !filter.Accept(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+var levels = map[string]int{
+	"TRACE":    0,
+	"DEBUG":    1,
+	"INFO":     2,
+	"WARN":     3,
+	"WARNING":  3,
+	"ERR":      4,
+	"ERROR":    4,
+	"CRIT":     5,
+	"CRITICAL": 5,
+	"FATAL":    5,
+	"PANIC":    5,
+}
+
+// entryLevel returns severity of the log entry level and false when the entry
+// has no recognizable level.
+func entryLevel(entry Entry) (int, bool) {
+	name, ok := entry["level"].(string)
+	if !ok {
+		return 0, false
+	}
+	level, ok := levels[strings.ToUpper(name)]
+	return level, ok
+}
+
+// LevelFilter drops log entries with level lower than the minimal one. Entries
+// without recognizable level are always accepted.
+type LevelFilter struct {
+	minLevel int
+	dropped  metrics.Counter
+}
+
+// NewLevelFilter creates filter that accepts log entries with at least passed
+// level (e.g. INFO).
+func NewLevelFilter(minLevel string) (*LevelFilter, error) {
+	level, ok := levels[strings.ToUpper(minLevel)]
+	if !ok {
+		return nil, fmt.Errorf("unknown log level: %s", minLevel)
+	}
+	return &LevelFilter{
+		minLevel: level,
+		dropped:  metrics.GetOrRegisterCounter("servicelog.filtered.Level", metrics.DefaultRegistry),
+	}, nil
+}
+
+// Accept returns false for log entries with level lower than the minimal one.
+func (f *LevelFilter) Accept(entry Entry) bool {
+	level, ok := entryLevel(entry)
+	if !ok || level >= f.minLevel {
+		return true
+	}
+	f.dropped.Inc(1)
+	return false
+}
+
+// SamplingFilter forwards only every n-th log entry of sampled levels,
+// separately for each logger and level. Entries with WARN level or higher and
+// without recognizable level are always accepted. It is not safe for
+// concurrent use.
+type SamplingFilter struct {
+	rate    uint64
+	levels  map[int]bool
+	loggers map[string]bool
+	seen    map[string]uint64
+	dropped metrics.Counter
+}
+
+// NewSamplingFilter creates filter that accepts one of every rate entries with
+// passed levels. When loggers list is not empty only entries from these
+// loggers are sampled.
+func NewSamplingFilter(rate uint64, levelNames, loggers []string) (*SamplingFilter, error) {
+	if rate == 0 {
+		return nil, fmt.Errorf("sampling rate must be positive")
+	}
+	sampledLevels := make(map[int]bool)
+	for _, name := range levelNames {
+		level, ok := levels[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown log level: %s", name)
+		}
+		if level >= levels["WARN"] {
+			return nil, fmt.Errorf("log level %s can not be sampled", name)
+		}
+		sampledLevels[level] = true
+	}
+	sampledLoggers := make(map[string]bool)
+	for _, logger := range loggers {
+		sampledLoggers[logger] = true
+	}
+	return &SamplingFilter{
+		rate:    rate,
+		levels:  sampledLevels,
+		loggers: sampledLoggers,
+		seen:    make(map[string]uint64),
+		dropped: metrics.GetOrRegisterCounter("servicelog.filtered.Sampling", metrics.DefaultRegistry),
+	}, nil
+}
+
+// Accept returns true for every n-th entry of each sampled logger and level.
+func (f *SamplingFilter) Accept(entry Entry) bool {
+	level, ok := entryLevel(entry)
+	if !ok || !f.levels[level] {
+		return true
+	}
+	logger := fmt.Sprint(entry["logger"])
+	if len(f.loggers) > 0 && !f.loggers[logger] {
+		return true
+	}
+
+	key := fmt.Sprintf("%s:%d", logger, level)
+	seen := f.seen[key]
+	f.seen[key] = seen + 1
+	if seen%f.rate == 0 {
+		return true
+	}
+	f.dropped.Inc(1)
+	return false
+}
@@ -0,0 +1,85 @@
+package servicelog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfLevelFilterDropsEntriesBelowMinimalLevel(t *testing.T) {
+	filter, err := NewLevelFilter("info")
+	require.NoError(t, err)
+	dropped := filter.dropped.Count()
+
+	assert.False(t, filter.Accept(Entry{"level": "DEBUG"}))
+	assert.False(t, filter.Accept(Entry{"level": "trace"}))
+	assert.True(t, filter.Accept(Entry{"level": "INFO"}))
+	assert.True(t, filter.Accept(Entry{"level": "error"}))
+	assert.Equal(t, dropped+2, filter.dropped.Count())
+}
+
+func TestIfLevelFilterAcceptsEntriesWithoutRecognizableLevel(t *testing.T) {
+	filter, err := NewLevelFilter("ERROR")
+	require.NoError(t, err)
+
+	assert.True(t, filter.Accept(Entry{"msg": "no level"}))
+	assert.True(t, filter.Accept(Entry{"level": "VERBOSE"}))
+	assert.True(t, filter.Accept(Entry{"level": 1}))
+}
+
+func TestIfFailsToCreateLevelFilterWithUnknownLevel(t *testing.T) {
+	_, err := NewLevelFilter("VERBOSE")
+
+	assert.Error(t, err)
+}
+
+func TestIfFiltersEntriesInPipeline(t *testing.T) {
+	filter, err := NewLevelFilter("INFO")
+	require.NoError(t, err)
+	in := make(chan Entry, 3)
+	in <- Entry{"level": "DEBUG", "msg": "first"}
+	in <- Entry{"level": "INFO", "msg": "second"}
+	in <- Entry{"msg": "third"}
+
+	out := Filter(in, filter)
+
+	assert.Equal(t, "second", (<-out)["msg"])
+	assert.Equal(t, "third", (<-out)["msg"])
+}
+
+func TestIfSamplingFilterAcceptsEveryNthEntryOfEachLogger(t *testing.T) {
+	filter, err := NewSamplingFilter(3, []string{"DEBUG", "INFO"}, nil)
+	require.NoError(t, err)
+	dropped := filter.dropped.Count()
+
+	var accepted []bool
+	for i := 0; i < 4; i++ {
+		accepted = append(accepted, filter.Accept(Entry{"level": "INFO", "logger": "a"}))
+	}
+	assert.Equal(t, []bool{true, false, false, true}, accepted)
+	assert.True(t, filter.Accept(Entry{"level": "INFO", "logger": "b"}))
+	assert.True(t, filter.Accept(Entry{"level": "DEBUG", "logger": "a"}))
+	assert.Equal(t, dropped+2, filter.dropped.Count())
+}
+
+func TestIfSamplingFilterAlwaysAcceptsNotSampledEntries(t *testing.T) {
+	filter, err := NewSamplingFilter(100, []string{"INFO"}, []string{"noisy"})
+	require.NoError(t, err)
+	filter.Accept(Entry{"level": "INFO", "logger": "noisy"})
+
+	assert.True(t, filter.Accept(Entry{"level": "WARN", "logger": "noisy"}))
+	assert.True(t, filter.Accept(Entry{"level": "ERROR", "logger": "noisy"}))
+	assert.True(t, filter.Accept(Entry{"level": "DEBUG", "logger": "noisy"}))
+	assert.True(t, filter.Accept(Entry{"level": "INFO", "logger": "quiet"}))
+	assert.True(t, filter.Accept(Entry{"logger": "noisy"}))
+	assert.False(t, filter.Accept(Entry{"level": "INFO", "logger": "noisy"}))
+}
+
+func TestIfFailsToCreateSamplingFilterForWarnings(t *testing.T) {
+	_, err := NewSamplingFilter(10, []string{"WARN"}, nil)
+	assert.Error(t, err)
+
+	_, err = NewSamplingFilter(0, []string{"INFO"}, nil)
+	assert.Error(t, err)
+}
@@ -1,6 +1,10 @@
 package scraper
 
-import "bytes"
+import (
+	"bytes"
+	"fmt"
+	"path"
+)
 
 // Filter is an interface that performs filtering tasks during log scraping. It
 // allows to ignore log values based on implementation logic.
@@ -32,3 +36,31 @@ func (f ValueFilter) Match(v []byte) bool {
 	}
 	return false
 }
+
+// GlobFilter allows to ignore values matching shell patterns (e.g. debug_*)
+// during log scraping. See path.Match for the pattern syntax.
+type GlobFilter struct {
+	patterns []string
+}
+
+// NewGlobFilter returns a filter matching values with passed patterns. It
+// returns an error when any of the patterns is malformed.
+func NewGlobFilter(patterns []string) (GlobFilter, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return GlobFilter{}, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+	}
+	return GlobFilter{patterns: patterns}, nil
+}
+
+// Match returns true if passed value matches any of the patterns - false otherwise.
+func (f GlobFilter) Match(v []byte) bool {
+	value := string(v)
+	for _, pattern := range f.patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -29,3 +29,20 @@ func TestIfFiltersConfiguredValues(t *testing.T) {
 		})
 	}
 }
+
+func TestIfFiltersValuesMatchingPatterns(t *testing.T) {
+	filter, err := NewGlobFilter([]string{"debug_*", "trace"})
+	assert.NoError(t, err)
+
+	assert.True(t, filter.Match([]byte("debug_")))
+	assert.True(t, filter.Match([]byte("debug_id")))
+	assert.True(t, filter.Match([]byte("trace")))
+	assert.False(t, filter.Match([]byte("tracer")))
+	assert.False(t, filter.Match([]byte("my_debug_id")))
+}
+
+func TestIfGlobFilterRejectsMalformedPatterns(t *testing.T) {
+	_, err := NewGlobFilter([]string{"debug_["})
+
+	assert.Error(t, err)
+}
@@ -4,6 +4,9 @@ import (
 	"bufio"
 	"io"
 	"os"
+	"regexp"
+	"sort"
+	"sync"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -18,36 +21,67 @@ import (
 const (
 	kilobyte = 1024
 	megabyte = 1024 * kilobyte
+
+	// DefaultMaxLineSize is a maximal size of a scraped log line used when it
+	// is not configured.
+	DefaultMaxLineSize = megabyte
+	initialBufferSize  = 64 * kilobyte
 )
 
 var json = jsoniter.ConfigFastest
 
-// JSON is a scraper for logs represented as JSON objects.
+// multilineFlushTimeout is a time after which aggregated multiline entry is
+// sent even if no next log record was started.
+const multilineFlushTimeout = time.Second
+
+// JSON is a scraper for logs represented as JSON objects. When MultilinePattern
+// is set, lines matching it are treated as a continuation of the previous entry
+// (e.g. stack trace) and appended to its message. Lines longer than MaxLineSize
+// (DefaultMaxLineSize when 0) can not be parsed. Keys of parsed entries are
+// renamed according to KeyRenames after filtering them with KeyFilter.
 type JSON struct {
 	InvalidLogsWriter              io.Writer
 	KeyFilter                      Filter
+	KeyRenames                     map[string]string
 	BufferSize                     uint
+	MaxLineSize                    int
 	ScrapUnmarshallableLogs        bool
+	MultilinePattern               *regexp.Regexp
 	droppedBecauseOfBufferOverflow metrics.Counter
 	receivedLogsTotal              metrics.Counter
+	unparseableLogs                metrics.Counter
+	metricsOnce                    sync.Once
 }
 
 // StartScraping starts scraping logs in JSON format from given reader and sends
 // parsed entries to the returned unbuffered channel. Logs are scraped as long
-// as the passed reader does not return an io.EOF error.
+// as the passed reader does not return an io.EOF error, then the channel is
+// closed. It could be called many times to scrap logs from several readers
+// concurrently.
 func (j *JSON) StartScraping(reader io.Reader) <-chan servicelog.Entry {
-	scanner := bufio.NewScanner(reader)
-	scanner.Buffer(make([]byte, 64*kilobyte), megabyte)
 	logEntries := make(chan servicelog.Entry, j.BufferSize)
 
-	j.receivedLogsTotal = metrics.GetOrRegisterCounter(
-		"servicelog.received.Total", metrics.DefaultRegistry)
-	j.droppedBecauseOfBufferOverflow = metrics.GetOrRegisterCounter(
-		"servicelog.scrapped.dropped.BufferOverflow", metrics.DefaultRegistry)
+	j.metricsOnce.Do(func() {
+		j.receivedLogsTotal = metrics.GetOrRegisterCounter(
+			"servicelog.received.Total", metrics.DefaultRegistry)
+		j.droppedBecauseOfBufferOverflow = metrics.GetOrRegisterCounter(
+			"servicelog.scrapped.dropped.BufferOverflow", metrics.DefaultRegistry)
+		j.unparseableLogs = metrics.GetOrRegisterCounter(
+			"servicelog.scrapped.Unparseable", metrics.DefaultRegistry)
+	})
 
 	go func() {
+		defer close(logEntries)
 		for {
-			err := j.scanLoop(reader, logEntries)
+			var err error
+			if j.MultilinePattern != nil {
+				err = j.scanMultilineLoop(reader, logEntries)
+			} else {
+				err = j.scanLoop(reader, logEntries)
+			}
+			if err == nil {
+				return
+			}
 			log.WithError(err).Warn("Service log scraping failed, restarting")
 		}
 	}()
@@ -56,43 +90,164 @@ func (j *JSON) StartScraping(reader io.Reader) <-chan servicelog.Entry {
 }
 
 func (j *JSON) scanLoop(reader io.Reader, logEntries chan<- servicelog.Entry) error {
-	var invalidLogsWriter io.Writer = os.Stdout
-	if j.InvalidLogsWriter != nil {
-		invalidLogsWriter = j.InvalidLogsWriter
+	scanner := j.newScanner(reader)
+	for scanner.Scan() {
+		if logEntry := j.parse(scanner.Bytes()); logEntry != nil {
+			j.send(logEntry, logEntries)
+		}
+	}
+	return scanner.Err()
+}
+
+func (j *JSON) maxLineSize() int {
+	if j.MaxLineSize <= 0 {
+		return DefaultMaxLineSize
+	}
+	return j.MaxLineSize
+}
+
+func (j *JSON) newScanner(reader io.Reader) *bufio.Scanner {
+	maxLineSize := j.maxLineSize()
+	bufferSize := initialBufferSize
+	if maxLineSize < bufferSize {
+		bufferSize = maxLineSize
 	}
 	scanner := bufio.NewScanner(reader)
-	scanner.Buffer(make([]byte, 64*kilobyte), megabyte)
-	for scanner.Scan() {
-		j.receivedLogsTotal.Inc(1)
-		logEntry := servicelog.Entry{}
-		if err := json.Unmarshal(scanner.Bytes(), &logEntry); err != nil {
-			if j.ScrapUnmarshallableLogs {
-				log.WithError(err).Debug("Unable to unmarshal log entry - wrapping in default entry")
-				logEntry = j.wrapInDefault(scanner.Bytes())
-			} else {
-				if _, err = fmt.Fprintf(invalidLogsWriter, "%s\n", scanner.Bytes()); err != nil {
-					log.WithError(err).Error("unable to print out unmarshallable log")
-				}
+	scanner.Buffer(make([]byte, bufferSize), maxLineSize)
+	return scanner
+}
 
+// scanMultilineLoop works like scanLoop but holds the last entry until the
+// next record is started or multilineFlushTimeout elapses, so continuation
+// lines could be appended to it.
+func (j *JSON) scanMultilineLoop(reader io.Reader, logEntries chan<- servicelog.Entry) error {
+	lines := make(chan []byte)
+	scanErr := make(chan error, 1)
+	go func() {
+		scanner := j.newScanner(reader)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+		scanErr <- scanner.Err()
+		close(lines)
+	}()
+
+	var pending servicelog.Entry
+	var flush <-chan time.Time
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if pending != nil {
+					j.send(pending, logEntries)
+				}
+				return <-scanErr
+			}
+			if pending != nil && j.MultilinePattern.Match(line) {
+				j.receivedLogsTotal.Inc(1)
+				appendToMessage(pending, line, j.maxLineSize())
+				flush = time.After(multilineFlushTimeout)
 				continue
 			}
-		} else if j.KeyFilter != nil {
-			for key := range logEntry {
-				if j.KeyFilter.Match([]byte(key)) {
-					delete(logEntry, key)
-				}
+			if pending != nil {
+				j.send(pending, logEntries)
 			}
+			pending = j.parse(line)
+			flush = time.After(multilineFlushTimeout)
+		case <-flush:
+			if pending != nil {
+				j.send(pending, logEntries)
+				pending = nil
+			}
+		}
+	}
+}
+
+// parse returns an entry parsed from the given line or nil when the line is not
+// a valid log entry and was printed out.
+func (j *JSON) parse(line []byte) servicelog.Entry {
+	var invalidLogsWriter io.Writer = os.Stdout
+	if j.InvalidLogsWriter != nil {
+		invalidLogsWriter = j.InvalidLogsWriter
+	}
+	j.receivedLogsTotal.Inc(1)
+	logEntry := servicelog.Entry{}
+	if err := json.Unmarshal(line, &logEntry); err != nil {
+		j.unparseableLogs.Inc(1)
+		if j.ScrapUnmarshallableLogs {
+			log.WithError(err).Debug("Unable to unmarshal log entry - wrapping in default entry")
+			return wrapInDefault(line)
 		}
-		if j.BufferSize > 0 && len(logEntries) >= int(j.BufferSize) {
-			j.droppedBecauseOfBufferOverflow.Inc(1)
-			continue
+		if _, err = fmt.Fprintf(invalidLogsWriter, "%s\n", line); err != nil {
+			log.WithError(err).Error("unable to print out unmarshallable log")
 		}
-		logEntries <- logEntry
+		return nil
 	}
-	return scanner.Err()
+	if j.KeyFilter != nil {
+		for key := range logEntry {
+			if j.KeyFilter.Match([]byte(key)) {
+				delete(logEntry, key)
+			}
+		}
+	}
+	j.renameKeys(logEntry)
+	return logEntry
+}
+
+// renameKeys renames entry keys according to KeyRenames. Renamed values replace
+// existing values of target keys, which is logged as a warning. Renames are
+// applied in sorted order of source keys, so the result is deterministic.
+func (j *JSON) renameKeys(logEntry servicelog.Entry) {
+	if len(j.KeyRenames) == 0 {
+		return
+	}
+	values := make(map[string]interface{})
+	var sources []string
+	for from := range j.KeyRenames {
+		if value, ok := logEntry[from]; ok {
+			values[from] = value
+			sources = append(sources, from)
+			delete(logEntry, from)
+		}
+	}
+	sort.Strings(sources)
+	for _, from := range sources {
+		to := j.KeyRenames[from]
+		if _, exists := logEntry[to]; exists {
+			log.Warnf("Overwriting %s key of log entry with renamed %s key", to, from)
+		}
+		logEntry[to] = values[from]
+	}
+}
+
+func (j *JSON) send(logEntry servicelog.Entry, logEntries chan<- servicelog.Entry) {
+	if j.BufferSize > 0 && len(logEntries) >= int(j.BufferSize) {
+		j.droppedBecauseOfBufferOverflow.Inc(1)
+		return
+	}
+	logEntries <- logEntry
+}
+
+// appendToMessage appends given line to the entry message. Message is not
+// extended above the maximal line size, so runaway traces could not exhaust
+// memory.
+func appendToMessage(logEntry servicelog.Entry, line []byte, maxSize int) {
+	message, ok := logEntry["msg"].(string)
+	if !ok && logEntry["msg"] != nil {
+		message = fmt.Sprint(logEntry["msg"])
+	}
+	if len(message)+len(line) >= maxSize {
+		return
+	}
+	if message == "" {
+		logEntry["msg"] = string(line)
+		return
+	}
+	logEntry["msg"] = message + "\n" + string(line)
 }
 
-func (j *JSON) wrapInDefault(bytes []byte) servicelog.Entry {
+// wrapInDefault creates an entry with given raw log line as a message.
+func wrapInDefault(bytes []byte) servicelog.Entry {
 	return servicelog.Entry{
 		"time":   time.Now().Format(time.RFC3339Nano),
 		"level":  "INFO",
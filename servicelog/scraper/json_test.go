@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"regexp"
 	"testing"
 	"time"
 
@@ -42,6 +43,21 @@ func TestIfFiltersKeysFromScrapedJSONs(t *testing.T) {
 	assert.Len(t, entry, 1)
 }
 
+func TestIfRenamesKeysOfScrapedJSONs(t *testing.T) {
+	reader, writer := io.Pipe()
+	scraper := JSON{
+		KeyFilter:  FilterFunc(func(v []byte) bool { return bytes.Equal(v, []byte("a")) }),
+		KeyRenames: map[string]string{"host": "app_host", "a": "filtered", "type": "app_type", "b": "app_type"},
+	}
+
+	entries := scraper.StartScraping(reader)
+	go writer.Write([]byte("{\"a\":\"x\", \"host\":\"h\", \"type\":\"t\", \"b\":\"b\", \"app_host\":\"old\"}\n"))
+
+	entry := <-entries
+
+	assert.Equal(t, servicelog.Entry{"app_host": "h", "app_type": "t"}, entry)
+}
+
 func TestIfPrintsToStdoutValuesInvalidLogEntriesWhenDisabled(t *testing.T) {
 	mockStdout := &mockWriter{}
 	mockStdout.On("Write", []byte("ERROR my invalid format\n")).Return(0, nil).Once()
@@ -75,6 +91,27 @@ func TestIfWrapsInDefaultValuesInvalidLogEntriesWhenEnabled(t *testing.T) {
 	assert.Equal(t, "INFO", entry["level"])
 }
 
+func TestIfCountsUnparseableLogs(t *testing.T) {
+	for _, scrapUnmarshallableLogs := range []bool{false, true} {
+		reader, writer := io.Pipe()
+		scraper := JSON{
+			InvalidLogsWriter:       ioutil.Discard,
+			ScrapUnmarshallableLogs: scrapUnmarshallableLogs,
+		}
+
+		entries := scraper.StartScraping(reader)
+		unparseable := scraper.unparseableLogs.Count()
+		go writer.Write([]byte("ERROR my invalid format\n{\"a\":\"b\"}\n"))
+
+		for entry := range entries {
+			if entry["a"] == "b" {
+				break
+			}
+		}
+		assert.Equal(t, unparseable+1, scraper.unparseableLogs.Count())
+	}
+}
+
 func TestIfNotFailsWithTooLongTokens(t *testing.T) {
 	reader, writer := io.Pipe()
 	scraper := JSON{
@@ -95,6 +132,25 @@ func TestIfNotFailsWithTooLongTokens(t *testing.T) {
 	assert.Len(t, entry, 2)
 }
 
+func TestIfScrapsLinesUpToConfiguredMaxLineSize(t *testing.T) {
+	reader, writer := io.Pipe()
+	scraper := JSON{
+		InvalidLogsWriter: ioutil.Discard,
+		MaxLineSize:       32,
+	}
+
+	entries := scraper.StartScraping(reader)
+	go func() {
+		// 31 bytes including new line
+		writer.Write([]byte("{\"msg\":\"just under the limit\"}\n"))
+		writer.Write([]byte("{\"msg\":\"a bit over the configured limit\"}\n"))
+		writer.Write([]byte("{\"msg\":\"next\"}\n"))
+	}()
+
+	assert.Equal(t, "just under the limit", (<-entries)["msg"])
+	assert.Equal(t, "next", (<-entries)["msg"])
+}
+
 func TestIfIgnoresEmptyLogLines(t *testing.T) {
 	reader, writer := io.Pipe()
 	scraper := JSON{}
@@ -123,6 +179,50 @@ func TestIfReadingIsNotBlockingAndLogsAreDroppedWhenBufferedWithOverflow(t *test
 
 	assert.Len(t, entries, 1)
 }
+func TestIfAppendsContinuationLinesToPreviousEntryInMultilineMode(t *testing.T) {
+	reader, writer := io.Pipe()
+	scraper := JSON{
+		ScrapUnmarshallableLogs: true,
+		MultilinePattern:        regexp.MustCompile(`^\s`),
+	}
+
+	entries := scraper.StartScraping(reader)
+	go writer.Write([]byte("{\"msg\":\"panic\"}\n\tat main.go:10\n\tat main.go:20\n{\"msg\":\"next\"}\n"))
+
+	entry := <-entries
+	assert.Equal(t, "panic\n\tat main.go:10\n\tat main.go:20", entry["msg"])
+
+	entry = <-entries
+	assert.Equal(t, "next", entry["msg"])
+}
+
+func TestIfSendsPendingEntryAfterTimeoutInMultilineMode(t *testing.T) {
+	reader, writer := io.Pipe()
+	scraper := JSON{
+		MultilinePattern: regexp.MustCompile(`^\s`),
+	}
+
+	entries := scraper.StartScraping(reader)
+	go writer.Write([]byte("{\"msg\":\"last\"}\n"))
+
+	select {
+	case entry := <-entries:
+		assert.Equal(t, "last", entry["msg"])
+	case <-time.After(2 * multilineFlushTimeout):
+		t.Fatal("pending entry was not sent")
+	}
+}
+
+func TestIfDoesNotExtendMessageAboveMaxLineSizeInMultilineMode(t *testing.T) {
+	entry := servicelog.Entry{"msg": "start"}
+	line := bytes.Repeat([]byte("x"), megabyte/2)
+
+	appendToMessage(entry, line, megabyte)
+	appendToMessage(entry, line, megabyte)
+
+	assert.Len(t, entry["msg"], len("start")+1+megabyte/2)
+}
+
 func BenchmarkJSONScraping(b *testing.B) {
 	exampleLog, err := ioutil.ReadFile("testdata/log.json")
 	if err != nil {
@@ -163,3 +263,19 @@ func (w *mockWriter) Write(p []byte) (n int, err error) {
 	args := w.Called(p)
 	return args.Int(0), args.Error(1)
 }
+
+func TestIfScrapsLogsFromManyReadersConcurrently(t *testing.T) {
+	scraper := &JSON{}
+	first, firstWriter := io.Pipe()
+	second, secondWriter := io.Pipe()
+
+	firstEntries := scraper.StartScraping(first)
+	received := scraper.receivedLogsTotal.Count()
+	secondEntries := scraper.StartScraping(second)
+	go firstWriter.Write([]byte("{\"a\":\"b\"}\n"))
+	go secondWriter.Write([]byte("{\"c\":\"d\"}\n"))
+
+	assert.Equal(t, "b", (<-firstEntries)["a"])
+	assert.Equal(t, "d", (<-secondEntries)["c"])
+	assert.Equal(t, received+2, scraper.receivedLogsTotal.Count())
+}
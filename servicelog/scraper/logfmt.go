@@ -1,14 +1,19 @@
 package scraper
 
 import (
+	"bufio"
+	"bytes"
 	"io"
 
 	"github.com/go-logfmt/logfmt"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/allegro/mesos-executor/servicelog"
 )
 
-// LogFmt is a scraper for logs in logfmt format.
+// LogFmt is a scraper for logs in logfmt format. Quoted values with escaped
+// quotes are supported. Lines without any key=value pair or with invalid
+// syntax are wrapped in a default entry with the whole line as a message.
 //
 // See: https://brandur.org/logfmt
 type LogFmt struct {
@@ -17,27 +22,57 @@ type LogFmt struct {
 
 // StartScraping starts scraping logs in logfmt format from given reader and sends
 // parsed entries to the returned unbuffered channel. Logs are scraped as long
-// as the passed reader does not return an io.EOF error.
+// as the passed reader does not return an io.EOF error, then the channel is
+// closed.
 func (logFmt *LogFmt) StartScraping(reader io.Reader) <-chan servicelog.Entry {
-	decoder := logfmt.NewDecoder(reader)
 	logEntries := make(chan servicelog.Entry)
 
 	go func() {
-		for decoder.ScanRecord() {
-			logEntry := servicelog.Entry{}
-
-			for decoder.ScanKeyval() {
-				key := decoder.Key()
-				if logFmt.KeyFilter != nil && logFmt.KeyFilter.Match(key) {
-					continue
-				}
-				value := string(decoder.Value())
-				logEntry[string(key)] = value
+		defer close(logEntries)
+		for {
+			err := logFmt.scanLoop(reader, logEntries)
+			if err == nil {
+				return
 			}
-
-			logEntries <- logEntry
+			log.WithError(err).Warn("Service log scraping failed, restarting")
 		}
 	}()
 
 	return logEntries
 }
+
+func (logFmt *LogFmt) scanLoop(reader io.Reader, logEntries chan<- servicelog.Entry) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*kilobyte), megabyte)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		logEntries <- logFmt.parse(scanner.Bytes())
+	}
+	return scanner.Err()
+}
+
+func (logFmt *LogFmt) parse(line []byte) servicelog.Entry {
+	if bytes.IndexByte(line, '=') == -1 {
+		return wrapInDefault(line)
+	}
+
+	decoder := logfmt.NewDecoder(bytes.NewReader(line))
+	logEntry := servicelog.Entry{}
+	for decoder.ScanRecord() {
+		for decoder.ScanKeyval() {
+			key := decoder.Key()
+			if logFmt.KeyFilter != nil && logFmt.KeyFilter.Match(key) {
+				continue
+			}
+			logEntry[string(key)] = string(decoder.Value())
+		}
+	}
+
+	if err := decoder.Err(); err != nil {
+		log.WithError(err).Debug("Unable to parse logfmt entry - wrapping in default entry")
+		return wrapInDefault(line)
+	}
+	return logEntry
+}
@@ -35,3 +35,37 @@ func TestIfFiltersKeysFromScrapedLogs(t *testing.T) {
 	assert.Equal(t, "d", entry["c"])
 	assert.Len(t, entry, 1)
 }
+
+func TestIfScrapsQuotedValuesWithEscapedQuotesInLogFmtFormat(t *testing.T) {
+	reader, writer := io.Pipe()
+	scraper := LogFmt{}
+
+	entries := scraper.StartScraping(reader)
+	go writer.Write([]byte(`level=info msg="said \"hello\" to all" dur=3ms empty=` + "\n"))
+
+	entry := <-entries
+
+	assert.Equal(t, "info", entry["level"])
+	assert.Equal(t, `said "hello" to all`, entry["msg"])
+	assert.Equal(t, "3ms", entry["dur"])
+	assert.Equal(t, "", entry["empty"])
+}
+
+func TestIfWrapsLinesWithoutKeyValuePairsInDefaultEntry(t *testing.T) {
+	reader, writer := io.Pipe()
+	scraper := LogFmt{}
+
+	entries := scraper.StartScraping(reader)
+	go writer.Write([]byte("Starting server\nmsg=\"unterminated\na=b\n"))
+
+	entry := <-entries
+	assert.Equal(t, "Starting server", entry["msg"])
+	assert.Equal(t, "invalid-format", entry["logger"])
+
+	entry = <-entries
+	assert.Equal(t, `msg="unterminated`, entry["msg"])
+	assert.Equal(t, "invalid-format", entry["logger"])
+
+	entry = <-entries
+	assert.Equal(t, "b", entry["a"])
+}
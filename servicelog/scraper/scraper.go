@@ -12,8 +12,9 @@ type Scraper interface {
 }
 
 // Pipe returns a channel with log entries and writer that can be used as a data
-// provider for given scraper.
-func Pipe(scraper Scraper) (<-chan servicelog.Entry, io.Writer) {
+// provider for given scraper. Closing the writer closes the channel once all
+// written logs are scraped.
+func Pipe(scraper Scraper) (<-chan servicelog.Entry, io.WriteCloser) {
 	reader, writer := io.Pipe()
 	entries := scraper.StartScraping(reader)
 	return entries, writer
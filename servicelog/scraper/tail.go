@@ -0,0 +1,119 @@
+package scraper
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fileTail is a reader that follows content appended to the file. It returns
+// io.EOF only after it is closed - when there is no new data it waits for it.
+type fileTail struct {
+	path         string
+	pollInterval time.Duration
+	file         *os.File
+	offset       int64
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// TailFile returns reader with content appended to the file at given path.
+// Reading starts from the end of the file, so the content written before the
+// call is omitted. File is reopened when it is truncated or replaced (e.g. by
+// log rotation) and read from the beginning. Missing file is awaited. After the
+// reader is closed, remaining content is read and then io.EOF is returned.
+func TailFile(path string, pollInterval time.Duration) io.ReadCloser {
+	tail := &fileTail{path: path, pollInterval: pollInterval, closed: make(chan struct{})}
+	tail.open(io.SeekEnd)
+	return tail
+}
+
+func (t *fileTail) Read(p []byte) (int, error) {
+	for {
+		if t.file == nil {
+			t.open(io.SeekStart)
+		}
+		if t.file != nil {
+			n, err := t.file.Read(p)
+			t.offset += int64(n)
+			if n > 0 {
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			if t.rotated() {
+				continue
+			}
+		}
+		select {
+		case <-t.closed:
+			t.closeFile()
+			return 0, io.EOF
+		case <-time.After(t.pollInterval):
+		}
+	}
+}
+
+// Close stops waiting for new content of the file.
+func (t *fileTail) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+func (t *fileTail) closeFile() {
+	if t.file != nil {
+		_ = t.file.Close()
+		t.file = nil
+	}
+}
+
+func (t *fileTail) open(whence int) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		log.WithError(err).Debugf("Unable to open %s for tailing", t.path)
+		return
+	}
+	offset, err := file.Seek(0, whence)
+	if err != nil {
+		log.WithError(err).Warnf("Unable to seek in %s", t.path)
+		_ = file.Close()
+		return
+	}
+	t.file = file
+	t.offset = offset
+}
+
+// rotated checks if the tailed file was truncated or replaced with a new one.
+// Truncated file is read again from the beginning and replaced file is closed,
+// so the new one will be opened on the next read.
+func (t *fileTail) rotated() bool {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		// file was moved and a new one is not created yet
+		return false
+	}
+	current, err := t.file.Stat()
+	if err != nil || !os.SameFile(info, current) {
+		log.Infof("File %s was replaced, reopening", t.path)
+		_ = t.file.Close()
+		t.file = nil
+		return true
+	}
+	if current.Size() < t.offset {
+		log.Infof("File %s was truncated, reading from the beginning", t.path)
+		offset, err := t.file.Seek(0, io.SeekStart)
+		if err != nil {
+			_ = t.file.Close()
+			t.file = nil
+			return true
+		}
+		t.offset = offset
+		return true
+	}
+	return false
+}
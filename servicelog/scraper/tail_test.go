@@ -0,0 +1,108 @@
+package scraper
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfTailsFileFromTheEnd(t *testing.T) {
+	path, cleanup := tempLogFile(t, "old\n")
+	defer cleanup()
+
+	lines := readLines(TailFile(path, time.Millisecond))
+	appendToFile(t, path, "new\n")
+
+	assert.Equal(t, "new\n", nextLine(t, lines))
+}
+
+func TestIfTailsFileAfterRotation(t *testing.T) {
+	path, cleanup := tempLogFile(t, "")
+	defer cleanup()
+
+	lines := readLines(TailFile(path, time.Millisecond))
+	appendToFile(t, path, "before rotation\n")
+	assert.Equal(t, "before rotation\n", nextLine(t, lines))
+
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendToFile(t, path, "after rotation\n")
+
+	assert.Equal(t, "after rotation\n", nextLine(t, lines))
+}
+
+func TestIfTailsFileAfterTruncation(t *testing.T) {
+	path, cleanup := tempLogFile(t, "")
+	defer cleanup()
+
+	lines := readLines(TailFile(path, time.Millisecond))
+	appendToFile(t, path, "before truncation\n")
+	assert.Equal(t, "before truncation\n", nextLine(t, lines))
+
+	require.NoError(t, os.Truncate(path, 0))
+	time.Sleep(10 * time.Millisecond)
+	appendToFile(t, path, "new\n")
+
+	assert.Equal(t, "new\n", nextLine(t, lines))
+}
+
+func tempLogFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "tail")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "service.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path, func() { _ = os.RemoveAll(dir) }
+}
+
+func appendToFile(t *testing.T, path, content string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteString(content)
+	require.NoError(t, err)
+}
+
+func readLines(reader io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		buffered := bufio.NewReader(reader)
+		for {
+			line, err := buffered.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	return lines
+}
+
+func nextLine(t *testing.T, lines <-chan string) string {
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("no line read from tailed file")
+		return ""
+	}
+}
+
+func TestIfReadsRemainingContentAndReturnsEOFAfterClose(t *testing.T) {
+	path, cleanup := tempLogFile(t, "")
+	defer cleanup()
+
+	tail := TailFile(path, time.Millisecond)
+	appendToFile(t, path, "last line\n")
+	require.NoError(t, tail.Close())
+
+	content, err := ioutil.ReadAll(tail)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "last line\n", string(content))
+}
@@ -0,0 +1,82 @@
+package servicelog
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+const timeKey = "time"
+
+// epochMillisThreshold is the lowest epoch time treated as milliseconds. Seconds
+// since epoch reach it in year 5138, milliseconds passed it in 1973.
+const epochMillisThreshold = 1e11
+
+// DefaultTimestampLayouts is a list of layouts used by TimestampNormalizer when
+// none are configured.
+var DefaultTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05,999",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// TimestampNormalizer rewrites time field of log entries to RFC3339Nano format.
+// Time is parsed with Layouts (DefaultTimestampLayouts when empty) or as
+// seconds or milliseconds since epoch. Entries with time that can not be parsed
+// are left untouched.
+type TimestampNormalizer struct {
+	Layouts []string
+}
+
+// Extend returns a new log entry, based on the passed entry, with normalized
+// time field.
+func (n TimestampNormalizer) Extend(entry Entry) Entry {
+	timestamp, ok := n.parse(entry[timeKey])
+	if !ok {
+		return entry
+	}
+
+	extendedEntry := Entry{}
+	for key, value := range entry {
+		extendedEntry[key] = value
+	}
+	extendedEntry[timeKey] = timestamp.UTC().Format(time.RFC3339Nano)
+	return extendedEntry
+}
+
+func (n TimestampNormalizer) parse(value interface{}) (time.Time, bool) {
+	switch value := value.(type) {
+	case float64:
+		return fromEpoch(value), true
+	case int64:
+		return fromEpoch(float64(value)), true
+	case int:
+		return fromEpoch(float64(value)), true
+	case string:
+		if epoch, err := strconv.ParseFloat(value, 64); err == nil {
+			return fromEpoch(epoch), true
+		}
+		layouts := n.Layouts
+		if len(layouts) == 0 {
+			layouts = DefaultTimestampLayouts
+		}
+		for _, layout := range layouts {
+			if timestamp, err := time.Parse(layout, value); err == nil {
+				return timestamp, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func fromEpoch(epoch float64) time.Time {
+	if epoch >= epochMillisThreshold {
+		epoch /= 1000
+	}
+	seconds, fraction := math.Modf(epoch)
+	return time.Unix(int64(seconds), int64(math.Round(fraction*1e6))*1e3)
+}
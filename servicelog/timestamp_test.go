@@ -0,0 +1,50 @@
+package servicelog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfNormalizesTimestamps(t *testing.T) {
+	normalizer := TimestampNormalizer{}
+
+	for _, testCase := range []struct {
+		name     string
+		time     interface{}
+		expected string
+	}{
+		{"epoch milliseconds", float64(1500000000123), "2017-07-14T02:40:00.123Z"},
+		{"epoch milliseconds as string", "1500000000123", "2017-07-14T02:40:00.123Z"},
+		{"epoch seconds", float64(1500000000), "2017-07-14T02:40:00Z"},
+		{"epoch seconds with fraction", "1500000000.5", "2017-07-14T02:40:00.5Z"},
+		{"RFC3339", "2017-07-14T04:40:00+02:00", "2017-07-14T02:40:00Z"},
+		{"RFC3339 with nanoseconds", "2017-07-14T02:40:00.123456789Z", "2017-07-14T02:40:00.123456789Z"},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			entry := Entry{"time": testCase.time, "msg": "message"}
+
+			normalized := normalizer.Extend(entry)
+
+			assert.Equal(t, testCase.expected, normalized["time"])
+			assert.Equal(t, "message", normalized["msg"])
+			assert.Equal(t, testCase.time, entry["time"])
+		})
+	}
+}
+
+func TestIfNormalizesTimestampsWithConfiguredLayouts(t *testing.T) {
+	normalizer := TimestampNormalizer{Layouts: []string{"02/01/2006 15:04:05"}}
+
+	normalized := normalizer.Extend(Entry{"time": "14/07/2017 02:40:00"})
+
+	assert.Equal(t, "2017-07-14T02:40:00Z", normalized["time"])
+}
+
+func TestIfLeavesEntriesWithUnknownTimestampsUntouched(t *testing.T) {
+	normalizer := TimestampNormalizer{}
+
+	assert.Equal(t, Entry{"time": "yesterday"}, normalizer.Extend(Entry{"time": "yesterday"}))
+	assert.Equal(t, Entry{"time": true}, normalizer.Extend(Entry{"time": true}))
+	assert.Equal(t, Entry{"msg": "no time"}, normalizer.Extend(Entry{"msg": "no time"}))
+}
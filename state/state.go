@@ -1,6 +1,7 @@
 package state
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net/url"
@@ -14,6 +15,7 @@ import (
 	"github.com/mesos/mesos-go/api/v1/lib/executor/config"
 	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
 	"github.com/pborman/uuid"
+	metrics "github.com/rcrowley/go-metrics"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,6 +24,23 @@ const (
 
 	// httpTimeout is a connection and keep-alive timeout used by HTTP client
 	httpTimeout = 10 * time.Second
+
+	// initialRetryDelay is a delay before next send attempt after the first failure
+	initialRetryDelay = 100 * time.Millisecond
+	// maxRetryDelay is a maximal delay between send attempts when agent is unavailable
+	maxRetryDelay = 5 * time.Second
+)
+
+// BufferPolicy defines what happens with a state update when the buffer is full.
+type BufferPolicy string
+
+const (
+	// BlockPolicy blocks the caller until there is space in the buffer
+	BlockPolicy BufferPolicy = "block"
+	// DropOldestPolicy drops the oldest buffered update to make space for the new one
+	DropOldestPolicy BufferPolicy = "drop-oldest"
+	// DropNewestPolicy drops the update that does not fit into the buffer
+	DropNewestPolicy BufferPolicy = "drop-newest"
 )
 
 // OptionalInfo contains optional info that could be attached to Task State update.
@@ -57,7 +76,7 @@ type Updater interface {
 
 type bufferedUpdater struct {
 	mutex         sync.RWMutex
-	buffer        chan mesos.TaskStatus
+	buffer        chan *mesos.TaskStatus
 	bufferSize    int
 	callOptions   executor.CallOptions
 	cfg           config.Config
@@ -65,6 +84,65 @@ type bufferedUpdater struct {
 	ctxCancel     context.CancelFunc
 	httpClient    *httpcli.Client
 	unAckStatuses map[string]mesos.TaskStatus
+	wal           *writeAheadLog
+	// lastQueued is the most recently buffered status that was not sent yet
+	lastQueued *mesos.TaskStatus
+	// terminalQueued is the number of terminal statuses in the buffer
+	terminalQueued int
+
+	bufferPolicy   BufferPolicy
+	bufferLength   metrics.Gauge
+	unacknowledged metrics.Gauge
+	sendErrors     metrics.Counter
+	dropped        metrics.Counter
+}
+
+// UpdaterOption is a function that configures optional behaviour of the buffered
+// updater.
+type UpdaterOption func(*bufferedUpdater)
+
+// BufferFullPolicy sets the policy used when the state update buffer is full.
+// Terminal state updates are never dropped, regardless of the policy.
+func BufferFullPolicy(policy BufferPolicy) UpdaterOption {
+	return func(u *bufferedUpdater) {
+		switch policy {
+		case "":
+			u.bufferPolicy = BlockPolicy
+		case BlockPolicy, DropOldestPolicy, DropNewestPolicy:
+			u.bufferPolicy = policy
+		default:
+			log.Warnf("Unknown state update buffer policy %q, using %q", policy, BlockPolicy)
+			u.bufferPolicy = BlockPolicy
+		}
+	}
+}
+
+// WriteAheadLog makes the updater persist task state updates in a file under
+// the given path until they are acknowledged. Updates that were not
+// acknowledged before the executor restart will be resent. Failures of the
+// disk writes are logged and the updater continues without persistence.
+func WriteAheadLog(path string) UpdaterOption {
+	return func(u *bufferedUpdater) {
+		wal, pending, err := openWriteAheadLog(path)
+		if err != nil {
+			log.WithError(err).Warn("Unable to open write-ahead log, state updates will not be persisted")
+			return
+		}
+		u.wal = wal
+		for i := range pending {
+			status := &pending[i]
+			select {
+			case u.buffer <- status:
+				if isTerminalState(status.GetState()) {
+					u.terminalQueued++
+				}
+				log.WithField("UUID", uuid.UUID(status.GetUUID()).String()).
+					Infof("Replaying unacknowledged %s task state update", status.GetState())
+			default:
+				log.Warnf("State update buffer is full, dropping unacknowledged %s task state update", status.GetState())
+			}
+		}
+	}
 }
 
 func (u *bufferedUpdater) Update(taskID mesos.TaskID, state mesos.TaskState) {
@@ -87,15 +165,126 @@ func (u *bufferedUpdater) update(taskID mesos.TaskID, state mesos.TaskState, mes
 		Timestamp:  &now,
 		UUID:       []byte(uuid.NewRandom()),
 	}
+
+	u.mutex.Lock()
+	if queued := u.lastQueued; queued != nil && isRedundantUpdate(*queued, status) {
+		// queued status is not sent until the loop clears lastQueued, so it
+		// could be safely replaced with the latest information
+		queued.Timestamp = status.Timestamp
+		queued.Message = status.Message
+		u.wal.append(*queued)
+		u.mutex.Unlock()
+		log.WithField("UUID", uuid.UUID(queued.GetUUID()).String()).
+			Debugf("Merging %s task state update into the one still waiting to be sent", state)
+		return
+	}
+	u.lastQueued = &status
+	u.mutex.Unlock()
+
+	u.wal.append(status)
+	u.enqueue(&status)
+	u.bufferLength.Update(int64(len(u.buffer)))
+}
+
+func (u *bufferedUpdater) enqueue(status *mesos.TaskStatus) {
+	if u.bufferPolicy == "" || u.bufferPolicy == BlockPolicy || isTerminalState(status.GetState()) {
+		u.queue(status)
+		return
+	}
+
+	select {
+	case u.buffer <- status:
+		return
+	default:
+	}
+
+	if u.bufferPolicy == DropOldestPolicy && u.dropOldest() {
+		u.buffer <- status
+		return
+	}
+	u.drop(status)
+}
+
+// queue puts the status into the buffer, waiting for space in it. Buffered
+// terminal statuses are counted, so dropOldest never removes them.
+func (u *bufferedUpdater) queue(status *mesos.TaskStatus) {
+	if isTerminalState(status.GetState()) {
+		u.mutex.Lock()
+		u.terminalQueued++
+		u.mutex.Unlock()
+	}
 	u.buffer <- status
 }
 
+// dropOldest removes the oldest update from the buffer. It returns true if
+// there is space in the buffer for the new update. Terminal updates can't be
+// dropped, so nothing is removed while any of them is buffered.
+func (u *bufferedUpdater) dropOldest() bool {
+	u.mutex.Lock()
+	if u.terminalQueued > 0 {
+		u.mutex.Unlock()
+		return false
+	}
+	// terminal statuses are counted before they are buffered, so none of them
+	// could be received here
+	var oldest *mesos.TaskStatus
+	select {
+	case oldest = <-u.buffer:
+	default: // buffer was drained in the meantime
+	}
+	u.mutex.Unlock()
+
+	if oldest != nil {
+		u.drop(oldest)
+	}
+	return true
+}
+
+func (u *bufferedUpdater) drop(status *mesos.TaskStatus) {
+	stringUUID := uuid.UUID(status.GetUUID()).String()
+	log.WithField("UUID", stringUUID).
+		Warnf("State update buffer is full, dropping %s task state update", status.GetState())
+	u.dropped.Inc(1)
+	u.mutex.Lock()
+	if u.lastQueued != nil && bytes.Equal(u.lastQueued.GetUUID(), status.GetUUID()) {
+		u.lastQueued = nil
+	}
+	u.mutex.Unlock()
+	u.wal.acknowledge(stringUUID)
+}
+
+func isTerminalState(state mesos.TaskState) bool {
+	switch state {
+	case mesos.TASK_FINISHED, mesos.TASK_FAILED, mesos.TASK_KILLED, mesos.TASK_ERROR,
+		mesos.TASK_LOST, mesos.TASK_DROPPED, mesos.TASK_GONE:
+		return true
+	}
+	return false
+}
+
+// isRedundantUpdate returns true if the next status reports the same task
+// state and health as the previous one, so the previous status could be
+// replaced with the next one. Only TASK_RUNNING updates (sent on every health
+// check change) are considered redundant, so state transitions are never
+// merged.
+func isRedundantUpdate(previous, next mesos.TaskStatus) bool {
+	if previous.GetState() != mesos.TASK_RUNNING || next.GetState() != mesos.TASK_RUNNING {
+		return false
+	}
+	if previous.GetTaskID() != next.GetTaskID() {
+		return false
+	}
+	return (previous.Healthy == nil) == (next.Healthy == nil) && previous.GetHealthy() == next.GetHealthy()
+}
+
 func (u *bufferedUpdater) Acknowledge(id []byte) {
 	uuidString := uuid.UUID(id).String()
 	log.WithField("UUID", uuidString).Info("Mesos acknowledged status update")
 	u.mutex.Lock()
-	delete(u.unAckStatuses, uuid.UUID(id).String())
+	delete(u.unAckStatuses, uuidString)
+	u.unacknowledged.Update(int64(len(u.unAckStatuses)))
 	u.mutex.Unlock()
+	u.wal.acknowledge(uuidString)
 }
 
 func (u *bufferedUpdater) GetUnacknowledged() []executor.Call_Update {
@@ -129,6 +318,7 @@ func (u *bufferedUpdater) Wait(timeout time.Duration) error {
 
 func (u *bufferedUpdater) loop() {
 	go func() {
+		retryDelay := initialRetryDelay
 		for {
 			select {
 			case status := <-u.buffer:
@@ -139,13 +329,31 @@ func (u *bufferedUpdater) loop() {
 				}).Info("Sending task state update to Mesos agent")
 
 				u.mutex.Lock()
-				u.unAckStatuses[stringUUID] = status
+				u.unAckStatuses[stringUUID] = *status
+				u.unacknowledged.Update(int64(len(u.unAckStatuses)))
+				u.bufferLength.Update(int64(len(u.buffer)))
+				if isTerminalState(status.GetState()) {
+					u.terminalQueued--
+				}
+				if u.lastQueued != nil && bytes.Equal(u.lastQueued.GetUUID(), status.GetUUID()) {
+					u.lastQueued = nil
+				}
 				u.mutex.Unlock()
 
-				if err := u.send(status); err != nil {
-					log.WithError(err).Warnf("Error sending %s task state update, requeuing", status.GetState())
-					u.buffer <- status
+				if err := u.send(*status); err != nil {
+					u.sendErrors.Inc(1)
+					log.WithError(err).Warnf("Error sending %s task state update, requeuing and retrying in %s",
+						status.GetState(), retryDelay)
+					u.queue(status)
+					select {
+					case <-time.After(retryDelay):
+					case <-u.ctx.Done():
+						return
+					}
+					retryDelay = nextRetryDelay(retryDelay)
+					continue
 				}
+				retryDelay = initialRetryDelay
 			case <-u.ctx.Done():
 				return
 			}
@@ -153,6 +361,15 @@ func (u *bufferedUpdater) loop() {
 	}()
 }
 
+// nextRetryDelay doubles given delay up to the maxRetryDelay.
+func nextRetryDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
 func (u *bufferedUpdater) send(status mesos.TaskStatus) error {
 	update := calls.Update(status).With(u.callOptions...)
 	response, err := u.httpClient.Do(update)
@@ -170,8 +387,8 @@ func (u *bufferedUpdater) send(status mesos.TaskStatus) error {
 // in a buffered channel (to allow non-blocking calls to the Update function).
 // It will be trying to send buffered state updates in a background goroutine
 // until Wait is called.
-func BufferedUpdater(cfg config.Config, bufferSize int) Updater {
-	buffer := make(chan mesos.TaskStatus, bufferSize)
+func BufferedUpdater(cfg config.Config, bufferSize int, options ...UpdaterOption) Updater {
+	buffer := make(chan *mesos.TaskStatus, bufferSize)
 	callOptions := executor.CallOptions{
 		calls.Executor(cfg.ExecutorID),
 		calls.Framework(cfg.FrameworkID),
@@ -188,13 +405,20 @@ func BufferedUpdater(cfg config.Config, bufferSize int) Updater {
 	)
 	ctx, ctxCancelFunc := context.WithCancel(context.Background())
 	updater := &bufferedUpdater{
-		buffer:        buffer,
-		callOptions:   callOptions,
-		cfg:           cfg,
-		ctx:           ctx,
-		ctxCancel:     ctxCancelFunc,
-		httpClient:    httpClient,
-		unAckStatuses: make(map[string]mesos.TaskStatus),
+		buffer:         buffer,
+		callOptions:    callOptions,
+		cfg:            cfg,
+		ctx:            ctx,
+		ctxCancel:      ctxCancelFunc,
+		httpClient:     httpClient,
+		unAckStatuses:  make(map[string]mesos.TaskStatus),
+		bufferLength:   metrics.GetOrRegisterGauge("state.update.BufferLength", metrics.DefaultRegistry),
+		unacknowledged: metrics.GetOrRegisterGauge("state.update.Unacknowledged", metrics.DefaultRegistry),
+		sendErrors:     metrics.GetOrRegisterCounter("state.update.SendErrors", metrics.DefaultRegistry),
+		dropped:        metrics.GetOrRegisterCounter("state.update.dropped.BufferFull", metrics.DefaultRegistry),
+	}
+	for _, option := range options {
+		option(updater)
 	}
 	updater.loop()
 	return updater
@@ -7,12 +7,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	mesos "github.com/mesos/mesos-go/api/v1/lib"
 	"github.com/mesos/mesos-go/api/v1/lib/executor/config"
+	metrics "github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIfSendsBufferedStateUpdatesOnExit(t *testing.T) {
@@ -39,9 +44,9 @@ func TestIfSendsBufferedStateUpdatesOnExit(t *testing.T) {
 	}
 	updater := BufferedUpdater(cfg, updatesCount) // ensure async Update call
 
-	// fill buffer with some data
+	// fill buffer with some data, tasks differ so updates are not coalesced
 	for i := 0; i < updatesCount; i++ {
-		updater.Update(mesos.TaskID{Value: "TaskID"}, mesos.TASK_RUNNING)
+		updater.Update(mesos.TaskID{Value: fmt.Sprintf("TaskID-%d", i)}, mesos.TASK_RUNNING)
 	}
 
 	// check if server was called
@@ -116,3 +121,206 @@ func TestIfSendsUpdatesWithMessageToMesosAgent(t *testing.T) {
 	updater.UpdateWithOptions(mesos.TaskID{Value: "TaskID"}, mesos.TASK_RUNNING, OptionalInfo{Message: &testMessage})
 	<-done // wait for server to be called
 }
+
+func TestIfReplaysUnacknowledgedStateUpdatesFromWriteAheadLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "updates.wal")
+	wal, _, err := openWriteAheadLog(path)
+	require.NoError(t, err)
+	status := newTestStatus(mesos.TASK_FAILED)
+	wal.append(status)
+
+	updates := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Add("Content-Type", "application/x-protobuf")
+		rw.WriteHeader(http.StatusOK)
+		updates <- true
+	}))
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	cfg := config.Config{AgentEndpoint: fmt.Sprintf("%s:%s", url.Hostname(), url.Port())}
+
+	updater := BufferedUpdater(cfg, 1, WriteAheadLog(path))
+
+	select {
+	case <-updates:
+	case <-time.After(time.Second):
+		t.Fatal("Replayed state update should be sent")
+	}
+	unacknowledged := updater.GetUnacknowledged()
+	require.Len(t, unacknowledged, 1)
+	assert.Equal(t, status.GetUUID(), unacknowledged[0].Status.GetUUID())
+}
+
+func TestIfBacksOffWhenAgentIsUnavailable(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		// simulate unavailable agent by dropping the connection
+		conn, _, err := rw.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		conn.Close()
+	}))
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	cfg := config.Config{AgentEndpoint: fmt.Sprintf("%s:%s", url.Hostname(), url.Port())}
+
+	updater := BufferedUpdater(cfg, 1)
+	updater.Update(mesos.TaskID{Value: "TaskID"}, mesos.TASK_FAILED)
+	err := updater.Wait(time.Second)
+
+	assert.Error(t, err)
+	// 100ms initial delay doubled on each failure allows only a few attempts per second
+	assert.True(t, atomic.LoadInt32(&calls) <= 5, "too many send attempts: %d", atomic.LoadInt32(&calls))
+	assert.True(t, atomic.LoadInt32(&calls) >= 2, "update should be retried")
+	sendErrors := metrics.DefaultRegistry.Get("state.update.SendErrors").(metrics.Counter)
+	assert.True(t, sendErrors.Count() >= 2)
+	unacknowledged := metrics.DefaultRegistry.Get("state.update.Unacknowledged").(metrics.Gauge)
+	assert.Equal(t, int64(1), unacknowledged.Value())
+}
+
+func TestNextRetryDelayShouldBeCapped(t *testing.T) {
+	assert.Equal(t, 2*initialRetryDelay, nextRetryDelay(initialRetryDelay))
+	assert.Equal(t, maxRetryDelay, nextRetryDelay(maxRetryDelay))
+}
+
+func TestIfCoalescesRedundantRunningUpdatesWhileUnsent(t *testing.T) {
+	updater := newUnstartedTestUpdater(10, BlockPolicy)
+	taskID := mesos.TaskID{Value: "TaskID"}
+	healthy := true
+	unhealthy := false
+	message := "health check failed"
+
+	updater.UpdateWithOptions(taskID, mesos.TASK_RUNNING, OptionalInfo{Healthy: &healthy})
+	updater.UpdateWithOptions(taskID, mesos.TASK_RUNNING, OptionalInfo{Healthy: &healthy})
+	updater.UpdateWithOptions(taskID, mesos.TASK_RUNNING, OptionalInfo{Healthy: &unhealthy, Message: &message})
+	updater.UpdateWithOptions(taskID, mesos.TASK_RUNNING, OptionalInfo{Healthy: &unhealthy, Message: &message})
+	updater.UpdateWithOptions(taskID, mesos.TASK_FAILED, OptionalInfo{Healthy: &unhealthy, Message: &message})
+	updater.UpdateWithOptions(taskID, mesos.TASK_FAILED, OptionalInfo{Healthy: &unhealthy, Message: &message})
+
+	require.Len(t, updater.buffer, 4)
+	first, second := <-updater.buffer, <-updater.buffer
+	assert.True(t, first.GetHealthy())
+	assert.False(t, second.GetHealthy())
+	third, fourth := <-updater.buffer, <-updater.buffer
+	assert.Equal(t, mesos.TASK_FAILED, third.GetState())
+	assert.Equal(t, mesos.TASK_FAILED, fourth.GetState())
+}
+
+func TestIfRedundantRunningUpdateIsMergedIntoTheQueuedOne(t *testing.T) {
+	updater := newUnstartedTestUpdater(10, BlockPolicy)
+	taskID := mesos.TaskID{Value: "TaskID"}
+	unhealthy := false
+	first, second := "first failure", "second failure"
+
+	updater.UpdateWithOptions(taskID, mesos.TASK_RUNNING, OptionalInfo{Healthy: &unhealthy, Message: &first})
+	queued := *updater.lastQueued.Timestamp - 1
+	updater.lastQueued.Timestamp = &queued
+	updater.UpdateWithOptions(taskID, mesos.TASK_RUNNING, OptionalInfo{Healthy: &unhealthy, Message: &second})
+
+	require.Len(t, updater.buffer, 1)
+	status := <-updater.buffer
+	assert.Equal(t, "second failure", status.GetMessage())
+	assert.True(t, status.GetTimestamp() > queued)
+}
+
+func TestIfDoesNotCoalesceRunningUpdatesAfterPreviousWasSent(t *testing.T) {
+	calls := make(chan bool, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Add("Content-Type", "application/x-protobuf")
+		rw.WriteHeader(http.StatusOK)
+		calls <- true
+	}))
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	cfg := config.Config{AgentEndpoint: fmt.Sprintf("%s:%s", url.Hostname(), url.Port())}
+	updater := BufferedUpdater(cfg, 2)
+	taskID := mesos.TaskID{Value: "TaskID"}
+
+	updater.Update(taskID, mesos.TASK_RUNNING)
+	<-calls
+	updater.Update(taskID, mesos.TASK_RUNNING)
+	<-calls
+
+	assert.Len(t, updater.GetUnacknowledged(), 2)
+}
+
+func TestIfDropNewestPolicyDropsUpdatesThatDoNotFitIntoBuffer(t *testing.T) {
+	updater := newUnstartedTestUpdater(2, DropNewestPolicy)
+
+	for _, id := range []string{"first", "second", "third"} {
+		updater.Update(mesos.TaskID{Value: id}, mesos.TASK_RUNNING)
+	}
+
+	require.Len(t, updater.buffer, 2)
+	first, second := <-updater.buffer, <-updater.buffer
+	assert.Equal(t, "first", first.TaskID.GetValue())
+	assert.Equal(t, "second", second.TaskID.GetValue())
+}
+
+func TestIfDropOldestPolicyDropsOldestUpdate(t *testing.T) {
+	updater := newUnstartedTestUpdater(2, DropOldestPolicy)
+
+	for _, id := range []string{"first", "second", "third"} {
+		updater.Update(mesos.TaskID{Value: id}, mesos.TASK_RUNNING)
+	}
+
+	require.Len(t, updater.buffer, 2)
+	first, second := <-updater.buffer, <-updater.buffer
+	assert.Equal(t, "second", first.TaskID.GetValue())
+	assert.Equal(t, "third", second.TaskID.GetValue())
+}
+
+func TestIfDropPoliciesNeverDropTerminalUpdates(t *testing.T) {
+	updater := newUnstartedTestUpdater(1, DropOldestPolicy)
+	taskID := mesos.TaskID{Value: "TaskID"}
+
+	updater.Update(taskID, mesos.TASK_FAILED)
+	updater.Update(taskID, mesos.TASK_RUNNING)
+
+	require.Len(t, updater.buffer, 1)
+	status := <-updater.buffer
+	assert.Equal(t, mesos.TASK_FAILED, status.GetState())
+
+	updater.Update(taskID, mesos.TASK_RUNNING)
+	done := make(chan bool)
+	go func() {
+		updater.Update(taskID, mesos.TASK_KILLED)
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatal("Terminal update should wait for space in buffer")
+	case <-time.After(100 * time.Millisecond):
+	}
+	status = <-updater.buffer
+	assert.Equal(t, mesos.TASK_RUNNING, status.GetState())
+	<-done
+	status = <-updater.buffer
+	assert.Equal(t, mesos.TASK_KILLED, status.GetState())
+}
+
+func TestIfDropOldestPolicyDropsNewUpdateWhenTerminalUpdateIsBuffered(t *testing.T) {
+	updater := newUnstartedTestUpdater(2, DropOldestPolicy)
+	updater.Update(mesos.TaskID{Value: "first"}, mesos.TASK_FAILED)
+	updater.Update(mesos.TaskID{Value: "second"}, mesos.TASK_RUNNING)
+	updater.Update(mesos.TaskID{Value: "third"}, mesos.TASK_RUNNING)
+
+	require.Len(t, updater.buffer, 2)
+	first, next := <-updater.buffer, <-updater.buffer
+	assert.Equal(t, mesos.TASK_FAILED, first.GetState())
+	assert.Equal(t, "second", next.TaskID.GetValue())
+}
+
+func newUnstartedTestUpdater(bufferSize int, policy BufferPolicy) *bufferedUpdater {
+	updater := &bufferedUpdater{
+		buffer:        make(chan *mesos.TaskStatus, bufferSize),
+		unAckStatuses: make(map[string]mesos.TaskStatus),
+		bufferLength:  metrics.NilGauge{},
+		dropped:       metrics.NilCounter{},
+	}
+	BufferFullPolicy(policy)(updater)
+	return updater
+}
@@ -0,0 +1,234 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	mesos "github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// walRecord is a single line of the write-ahead log. It contains either
+// a serialized task status or an UUID of acknowledged task status.
+type walRecord struct {
+	Status []byte `json:"status,omitempty"`
+	Ack    string `json:"ack,omitempty"`
+}
+
+// compactionThreshold is the number of acknowledgements after which the
+// write-ahead log is rewritten to contain only unacknowledged statuses.
+const compactionThreshold = 1000
+
+// writeAheadLog persists task status updates on disk, so updates that were not
+// acknowledged by Mesos agent can be resent after the executor restart. All
+// write errors are logged and disable the log, so they never block task state
+// reporting. Methods are safe to call on nil receiver.
+type writeAheadLog struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+
+	// pending holds serialized unacknowledged statuses by their UUID
+	pending map[string][]byte
+	order   []string
+	// acknowledged is the number of acknowledgements since the last compaction
+	acknowledged int
+	compactAfter int
+}
+
+// openWriteAheadLog opens the log stored under given path and returns task
+// statuses that were not acknowledged, in order they were appended. The log
+// file is compacted, so it contains only returned statuses.
+func openWriteAheadLog(path string) (*writeAheadLog, []mesos.TaskStatus, error) {
+	pending, err := readWriteAheadLog(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wal := &writeAheadLog{
+		path:         path,
+		pending:      make(map[string][]byte),
+		compactAfter: compactionThreshold,
+	}
+	for _, status := range pending {
+		data, err := status.Marshal()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to serialize task status: %s", err)
+		}
+		id := uuid.UUID(status.GetUUID()).String()
+		wal.pending[id] = data
+		wal.order = append(wal.order, id)
+	}
+	if err := wal.compact(); err != nil {
+		return nil, nil, err
+	}
+
+	return wal, pending, nil
+}
+
+func readWriteAheadLog(path string) ([]mesos.TaskStatus, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open write-ahead log: %s", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.WithError(err).Warn("Error closing write-ahead log")
+		}
+	}()
+
+	var order []string
+	statuses := make(map[string]mesos.TaskStatus)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// last record could be written partially when executor was killed
+			log.WithError(err).Warn("Skipping malformed write-ahead log record")
+			continue
+		}
+		if record.Ack != "" {
+			delete(statuses, record.Ack)
+			continue
+		}
+		var status mesos.TaskStatus
+		if err := status.Unmarshal(record.Status); err != nil {
+			log.WithError(err).Warn("Skipping malformed task status in write-ahead log")
+			continue
+		}
+		id := uuid.UUID(status.GetUUID()).String()
+		if _, ok := statuses[id]; !ok {
+			order = append(order, id)
+		}
+		statuses[id] = status
+	}
+	if err := scanner.Err(); err != nil {
+		log.WithError(err).Warn("Error reading write-ahead log, some records may be lost")
+	}
+
+	pending := make([]mesos.TaskStatus, 0, len(statuses))
+	for _, id := range order {
+		if status, ok := statuses[id]; ok {
+			pending = append(pending, status)
+		}
+	}
+	return pending, nil
+}
+
+func (l *writeAheadLog) append(status mesos.TaskStatus) {
+	if l == nil {
+		return
+	}
+	data, err := status.Marshal()
+	if err != nil {
+		log.WithError(err).Warn("Unable to serialize task status for write-ahead log")
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return
+	}
+
+	id := uuid.UUID(status.GetUUID()).String()
+	if _, ok := l.pending[id]; !ok {
+		l.order = append(l.order, id)
+	}
+	l.pending[id] = data
+	l.write(walRecord{Status: data})
+}
+
+func (l *writeAheadLog) acknowledge(id string) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return
+	}
+
+	delete(l.pending, id)
+	l.write(walRecord{Ack: id})
+	l.acknowledged++
+	if l.file != nil && l.acknowledged >= l.compactAfter {
+		if err := l.compact(); err != nil {
+			log.WithError(err).Warn("Unable to compact write-ahead log, disabling it")
+			l.disable()
+		}
+	}
+}
+
+// compact rewrites the log, so it contains only unacknowledged statuses.
+// It should be called with the mutex held.
+func (l *writeAheadLog) compact() error {
+	tmpPath := l.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to create write-ahead log: %s", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	order := make([]string, 0, len(l.pending))
+	for _, id := range l.order {
+		data, ok := l.pending[id]
+		if !ok {
+			continue
+		}
+		order = append(order, id)
+		line, err := json.Marshal(walRecord{Status: data})
+		if err == nil {
+			_, err = writer.Write(append(line, '\n'))
+		}
+		if err != nil {
+			_ = file.Close()
+			return fmt.Errorf("unable to write write-ahead log: %s", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to write write-ahead log: %s", err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to replace write-ahead log: %s", err)
+	}
+
+	if l.file != nil {
+		if err := l.file.Close(); err != nil {
+			log.WithError(err).Warn("Error closing write-ahead log")
+		}
+	}
+	l.file = file
+	l.order = order
+	l.acknowledged = 0
+	return nil
+}
+
+// write appends the record to the log. It should be called with the mutex held.
+func (l *writeAheadLog) write(record walRecord) {
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = l.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		log.WithError(err).Warn("Unable to write to write-ahead log, disabling it")
+		l.disable()
+	}
+}
+
+func (l *writeAheadLog) disable() {
+	if l.file != nil {
+		_ = l.file.Close()
+	}
+	l.file = nil
+	l.pending = nil
+	l.order = nil
+}
@@ -0,0 +1,105 @@
+package state
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mesos "github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfWriteAheadLogReturnsOnlyUnacknowledgedStatuses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "updates.wal")
+
+	wal, pending, err := openWriteAheadLog(path)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	acknowledged := newTestStatus(mesos.TASK_RUNNING)
+	failed := newTestStatus(mesos.TASK_FAILED)
+	wal.append(acknowledged)
+	wal.append(failed)
+	wal.acknowledge(uuid.UUID(acknowledged.GetUUID()).String())
+
+	_, pending, err = openWriteAheadLog(path)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, failed.GetUUID(), pending[0].GetUUID())
+	assert.Equal(t, mesos.TASK_FAILED, pending[0].GetState())
+}
+
+func TestIfWriteAheadLogSkipsMalformedRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "updates.wal")
+
+	wal, _, err := openWriteAheadLog(path)
+	require.NoError(t, err)
+	status := newTestStatus(mesos.TASK_KILLED)
+	wal.append(status)
+	_, err = wal.file.WriteString(`{"status":"AAA`)
+	require.NoError(t, err)
+
+	_, pending, err := openWriteAheadLog(path)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, status.GetUUID(), pending[0].GetUUID())
+}
+
+func TestIfWriteAheadLogIsCompactedAfterAcknowledgements(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "updates.wal")
+
+	wal, _, err := openWriteAheadLog(path)
+	require.NoError(t, err)
+	wal.compactAfter = 2
+	starting := newTestStatus(mesos.TASK_STARTING)
+	running := newTestStatus(mesos.TASK_RUNNING)
+	failed := newTestStatus(mesos.TASK_FAILED)
+	wal.append(starting)
+	wal.append(running)
+	wal.append(failed)
+	wal.acknowledge(uuid.UUID(starting.GetUUID()).String())
+	wal.acknowledge(uuid.UUID(running.GetUUID()).String())
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(content, []byte("\n")))
+	assert.Equal(t, 0, wal.acknowledged)
+
+	killed := newTestStatus(mesos.TASK_KILLED)
+	wal.append(killed)
+	_, pending, err := openWriteAheadLog(path)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, failed.GetUUID(), pending[0].GetUUID())
+	assert.Equal(t, killed.GetUUID(), pending[1].GetUUID())
+}
+
+func TestIfNilWriteAheadLogIsNoop(t *testing.T) {
+	var wal *writeAheadLog
+
+	assert.NotPanics(t, func() {
+		wal.append(newTestStatus(mesos.TASK_RUNNING))
+		wal.acknowledge("id")
+	})
+}
+
+func newTestStatus(state mesos.TaskState) mesos.TaskStatus {
+	return mesos.TaskStatus{
+		TaskID: mesos.TaskID{Value: "TaskID"},
+		State:  &state,
+		UUID:   []byte(uuid.NewRandom()),
+	}
+}
@@ -1,8 +1,11 @@
 package xio
 
 import (
+	"bytes"
+	"compress/gzip"
 	"errors"
 	"io"
+	"sync"
 
 	"golang.org/x/time/rate"
 )
@@ -42,7 +45,13 @@ func DecorateWriter(writer io.Writer, decorators ...WriterDecorator) io.Writer {
 // second) for io.Writer. If write rate exceeds the passed limit it will return
 // an error.
 func RateLimit(limit int) WriterDecorator {
-	limiter := rate.NewLimiter(rate.Limit(limit), limit)
+	return RateLimitWithBurst(limit, limit)
+}
+
+// RateLimitWithBurst works like RateLimit but allows bursts of up to burst
+// Write calls exceeding the average rate limit.
+func RateLimitWithBurst(limit, burst int) WriterDecorator {
+	limiter := rate.NewLimiter(rate.Limit(limit), burst)
 	return func(writer io.Writer) io.Writer {
 		return WriterFunc(func(p []byte) (int, error) {
 			if !limiter.Allow() {
@@ -65,3 +74,36 @@ func SizeLimit(size int) WriterDecorator {
 		})
 	}
 }
+
+// Gzip decorator is used to compress data written to io.Writer. Every write is
+// compressed separately into a complete gzip member, so message boundaries
+// (e.g. newline framing) are preserved and concatenated writes form a valid
+// multi-member gzip stream. It returns number of uncompressed bytes written.
+func Gzip(level int) WriterDecorator {
+	return func(writer io.Writer) io.Writer {
+		var mutex sync.Mutex
+		var buffer bytes.Buffer
+		compressor, err := gzip.NewWriterLevel(&buffer, level)
+		if err != nil {
+			return WriterFunc(func([]byte) (int, error) {
+				return 0, err
+			})
+		}
+		return WriterFunc(func(p []byte) (int, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			buffer.Reset()
+			compressor.Reset(&buffer)
+			if _, err := compressor.Write(p); err != nil {
+				return 0, err
+			}
+			if err := compressor.Close(); err != nil {
+				return 0, err
+			}
+			if _, err := writer.Write(buffer.Bytes()); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		})
+	}
+}
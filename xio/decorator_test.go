@@ -1,6 +1,9 @@
 package xio
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
 	"os"
 	"testing"
 
@@ -31,3 +34,40 @@ func TestIfLimitsWritesByRate(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, ErrRateLimitExceeded, err)
 }
+
+func TestIfAllowsBurstOfWritesAboveRate(t *testing.T) {
+	writer := DecorateWriter(ioutil.Discard, RateLimitWithBurst(1, 3))
+
+	for i := 0; i < 3; i++ {
+		_, err := writer.Write([]byte("1"))
+		require.NoError(t, err)
+	}
+	_, err := writer.Write([]byte("2"))
+
+	assert.Equal(t, ErrRateLimitExceeded, err)
+}
+
+func TestIfCompressesEveryWriteWithGzip(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := DecorateWriter(&buffer, Gzip(gzip.BestSpeed))
+
+	n, err := writer.Write([]byte("first\n"))
+	require.NoError(t, err)
+	assert.Equal(t, len("first\n"), n)
+	_, err = writer.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	reader, err := gzip.NewReader(&buffer)
+	require.NoError(t, err)
+	decompressed, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(decompressed))
+}
+
+func TestIfGzipReturnsErrorForInvalidCompressionLevel(t *testing.T) {
+	writer := DecorateWriter(os.Stdout, Gzip(100))
+
+	_, err := writer.Write([]byte("bytes"))
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package xio
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.WriteCloser appending to the file at given path. When
+// the write would make the file larger than MaxSize bytes, the file is rotated:
+// it is renamed to path.1 (previously rotated files are shifted to path.2 and
+// so on) and a new file is created. Only MaxFiles rotated files are kept.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// NewRotatingFile opens (or creates) the file at given path for appending.
+// File is not rotated when maxSize is not positive.
+func NewRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file.
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.file.Close()
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open log file: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to stat log file: %s", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts rotated files and opens a new one. The file is reopened even
+// when it could not be rotated, so next writes do not fail.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("unable to close log file: %s", err)
+	}
+	err := f.shift()
+	if openErr := f.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (f *RotatingFile) shift() error {
+	if f.maxFiles <= 0 {
+		if err := os.Remove(f.path); err != nil {
+			return fmt.Errorf("unable to rotate log file: %s", err)
+		}
+		return nil
+	}
+	for i := f.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(f.rotatedPath(i), f.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to rotate log file: %s", err)
+		}
+	}
+	if err := os.Rename(f.path, f.rotatedPath(1)); err != nil {
+		return fmt.Errorf("unable to rotate log file: %s", err)
+	}
+	return nil
+}
+
+func (f *RotatingFile) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
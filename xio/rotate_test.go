@@ -0,0 +1,58 @@
+package xio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfRotatesFileWhenItWouldExceedMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "executor.log")
+
+	file, err := NewRotatingFile(path, 8, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		n, err := file.Write([]byte(line))
+		require.NoError(t, err)
+		assert.Equal(t, len(line), n)
+	}
+	require.NoError(t, file.Close())
+
+	assertFileContent(t, path, "fourth\n")
+	assertFileContent(t, path+".1", "third\n")
+	assertFileContent(t, path+".2", "second\n")
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestIfAppendsToExistingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "executor.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("old\n"), 0644))
+
+	file, err := NewRotatingFile(path, 8, 1)
+	require.NoError(t, err)
+	_, err = file.Write([]byte("new\n"))
+	require.NoError(t, err)
+	_, err = file.Write([]byte("newest\n"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	assertFileContent(t, path, "newest\n")
+	assertFileContent(t, path+".1", "old\nnew\n")
+}
+
+func assertFileContent(t *testing.T, path, expected string) {
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(content))
+}
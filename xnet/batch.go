@@ -0,0 +1,205 @@
+package xnet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultBatchMaxDelay is a time after which batch is sent when MaxDelay of
+// BatchingSender is not set.
+const DefaultBatchMaxDelay = time.Second
+
+// BatchingSender is a Sender that accumulates payloads sent to the same address
+// and sends them to the underlying Sender with a single write. Payloads should
+// be self delimited (e.g. newline terminated log lines), as they are simply
+// concatenated. Batch is sent when adding next payload would exceed MaxSize
+// bytes, after MaxDelay since its first payload was added or when sender
+// resources are released. Payloads larger than MaxSize are sent immediately.
+// Batches that could not be sent are dropped and counted with Dropped counter
+// (when set). Error of sending a batch after MaxDelay is returned by the next
+// call for the same address, so callers can fail over to other addresses.
+type BatchingSender struct {
+	Sender   Sender
+	MaxSize  int
+	MaxDelay time.Duration
+	Dropped  metrics.Counter
+
+	mutex   sync.Mutex
+	batches map[Address]*batch
+	errs    map[Address]error
+}
+
+type batch struct {
+	payload []byte
+	timer   *time.Timer
+}
+
+// Send adds given payload to the batch of passed address. It returns number of
+// bytes added to the batch or sent and error - if there was any. Errors of
+// sending previous batches to the same address are returned as well and then
+// the payload is not added to the batch.
+func (s *BatchingSender) Send(addr Address, payload []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err, ok := s.errs[addr]; ok {
+		delete(s.errs, addr)
+		return 0, err
+	}
+	if b, ok := s.batches[addr]; ok && len(b.payload)+len(payload) > s.MaxSize {
+		if err := s.flush(addr); err != nil {
+			return 0, err
+		}
+	}
+	if len(payload) >= s.MaxSize {
+		return s.Sender.Send(addr, payload)
+	}
+
+	if s.batches == nil {
+		s.batches = make(map[Address]*batch)
+	}
+	b, ok := s.batches[addr]
+	if !ok {
+		b = &batch{payload: make([]byte, 0, s.MaxSize)}
+		b.timer = time.AfterFunc(s.maxDelay(), func() { s.flushExpired(addr, b) })
+		s.batches[addr] = b
+	}
+	b.payload = append(b.payload, payload...)
+	return len(payload), nil
+}
+
+// Flush sends all accumulated batches. Errors of previously sent batches are
+// returned as well.
+func (s *BatchingSender) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	errs := s.pendingErrors(nil)
+	if err := s.flushAll(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return MultiError(errs)
+	}
+	return nil
+}
+
+// Prune sends batches of addresses not present on the passed list and frees
+// resources allocated for them by the underlying Sender.
+func (s *BatchingSender) Prune(addrs []Address) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keep := make(map[Address]bool, len(addrs))
+	for _, addr := range addrs {
+		keep[addr] = true
+	}
+	errs := s.pendingErrors(keep)
+	for addr := range s.batches {
+		if keep[addr] {
+			continue
+		}
+		if err := s.flush(addr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if pruner, ok := s.Sender.(Pruner); ok {
+		if err := pruner.Prune(addrs); err != nil {
+			errs = append(errs, err)
+		}
+	} else if err := s.Sender.Release(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return MultiError(errs)
+	}
+	return nil
+}
+
+// Release sends all accumulated batches and frees resources used by the
+// underlying Sender. Errors of previously sent batches are returned as well.
+func (s *BatchingSender) Release() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	errs := s.pendingErrors(nil)
+	if err := s.flushAll(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.Sender.Release(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return MultiError(errs)
+	}
+	return nil
+}
+
+func (s *BatchingSender) maxDelay() time.Duration {
+	if s.MaxDelay <= 0 {
+		return DefaultBatchMaxDelay
+	}
+	return s.MaxDelay
+}
+
+// flushExpired sends the batch when its delay passed and it was not sent yet.
+// Sending error is kept to be returned by the next call for the address.
+func (s *BatchingSender) flushExpired(addr Address, b *batch) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.batches[addr] != b {
+		return
+	}
+	if err := s.flush(addr); err != nil {
+		log.WithError(err).Warnf("Unable to send batch to %s", addr)
+		if s.errs == nil {
+			s.errs = make(map[Address]error)
+		}
+		s.errs[addr] = err
+	}
+}
+
+// pendingErrors returns and forgets errors of sending batches to addresses
+// not present in keep set.
+func (s *BatchingSender) pendingErrors(keep map[Address]bool) []error {
+	var errs []error
+	for addr, err := range s.errs {
+		if keep[addr] {
+			continue
+		}
+		errs = append(errs, err)
+		delete(s.errs, addr)
+	}
+	return errs
+}
+
+func (s *BatchingSender) flushAll() error {
+	var errs []error
+	for addr := range s.batches {
+		if err := s.flush(addr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return MultiError(errs)
+	}
+	return nil
+}
+
+func (s *BatchingSender) flush(addr Address) error {
+	b := s.batches[addr]
+	delete(s.batches, addr)
+	b.timer.Stop()
+	if _, err := s.Sender.Send(addr, b.payload); err != nil {
+		if s.Dropped != nil {
+			s.Dropped.Inc(1)
+		}
+		return fmt.Errorf("batch of %d bytes dropped: %s", len(b.payload), err)
+	}
+	return nil
+}
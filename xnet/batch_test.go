@@ -0,0 +1,128 @@
+package xnet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIfBatchingSenderSendsBatchWhenItWouldExceedMaxSize(t *testing.T) {
+	sender := &MockSender{}
+	sender.On("Send", Address("1"), []byte("a\nb\n")).Return(4, nil).Once()
+	batching := &BatchingSender{Sender: sender, MaxSize: 5, MaxDelay: time.Hour}
+
+	for _, line := range []string{"a\n", "b\n", "c\n"} {
+		n, err := batching.Send("1", []byte(line))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+	}
+
+	sender.AssertExpectations(t)
+}
+
+func TestIfBatchingSenderSendsBatchAfterMaxDelay(t *testing.T) {
+	sent := make(chan []byte, 1)
+	sender := &MockSender{}
+	sender.On("Send", Address("1"), mock.Anything).Return(4, nil).Once().Run(func(args mock.Arguments) {
+		sent <- args.Get(1).([]byte)
+	})
+	batching := &BatchingSender{Sender: sender, MaxSize: 1024, MaxDelay: 10 * time.Millisecond}
+
+	_, _ = batching.Send("1", []byte("a\n"))
+	_, _ = batching.Send("1", []byte("b\n"))
+
+	select {
+	case payload := <-sent:
+		assert.Equal(t, []byte("a\nb\n"), payload)
+	case <-time.After(time.Second):
+		t.Fatal("batch was not sent")
+	}
+}
+
+func TestIfBatchingSenderSendsLargePayloadsImmediately(t *testing.T) {
+	sender := &MockSender{}
+	sender.On("Send", Address("1"), []byte("a\n")).Return(2, nil).Once()
+	sender.On("Send", Address("1"), []byte("large\n")).Return(6, nil).Once()
+	batching := &BatchingSender{Sender: sender, MaxSize: 4, MaxDelay: time.Hour}
+
+	_, _ = batching.Send("1", []byte("a\n"))
+	n, err := batching.Send("1", []byte("large\n"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	sender.AssertExpectations(t)
+}
+
+func TestIfBatchingSenderSendsBatchesOnRelease(t *testing.T) {
+	sender := &MockSender{}
+	sender.On("Send", Address("1"), []byte("a\n")).Return(2, nil).Once()
+	sender.On("Send", Address("2"), []byte("b\n")).Return(2, nil).Once()
+	sender.On("Release").Return(nil).Once()
+	batching := &BatchingSender{Sender: sender, MaxSize: 1024, MaxDelay: time.Hour}
+
+	_, _ = batching.Send("1", []byte("a\n"))
+	_, _ = batching.Send("2", []byte("b\n"))
+
+	assert.NoError(t, batching.Release())
+	sender.AssertExpectations(t)
+}
+
+func TestIfBatchingSenderSendsBatchesOfPrunedAddresses(t *testing.T) {
+	sender := &MockPruningSender{}
+	sender.On("Send", Address("2"), []byte("b\n")).Return(2, nil).Once()
+	sender.On("Prune", []Address{"1"}).Return(nil).Once()
+	batching := &BatchingSender{Sender: sender, MaxSize: 1024, MaxDelay: time.Hour}
+
+	_, _ = batching.Send("1", []byte("a\n"))
+	_, _ = batching.Send("2", []byte("b\n"))
+
+	assert.NoError(t, batching.Prune([]Address{"1"}))
+	sender.AssertExpectations(t)
+	sender.AssertNotCalled(t, "Send", Address("1"), mock.Anything)
+}
+
+func TestIfBatchingSenderReturnsErrorOfExpiredBatchOnNextSend(t *testing.T) {
+	sent := make(chan struct{}, 1)
+	sender := &MockSender{}
+	sender.On("Send", Address("1"), []byte("a\n")).Return(0, errors.New("connection refused")).Once().Run(func(mock.Arguments) {
+		sent <- struct{}{}
+	})
+	sender.On("Send", Address("1"), []byte("b\n")).Return(2, nil)
+	dropped := metrics.NewCounter()
+	batching := &BatchingSender{Sender: sender, MaxSize: 1024, MaxDelay: 10 * time.Millisecond, Dropped: dropped}
+
+	_, _ = batching.Send("1", []byte("a\n"))
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("batch was not sent")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	n, err := batching.Send("1", []byte("b\n"))
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, int64(1), dropped.Count())
+
+	n, err = batching.Send("1", []byte("b\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestIfBatchingSenderReturnsErrorsOfFailedBatchesOnRelease(t *testing.T) {
+	sender := &MockSender{}
+	sender.On("Send", Address("1"), []byte("a\n")).Return(0, errors.New("connection refused")).Once()
+	sender.On("Release").Return(nil).Once()
+	dropped := metrics.NewCounter()
+	batching := &BatchingSender{Sender: sender, MaxSize: 1024, MaxDelay: time.Hour, Dropped: dropped}
+
+	_, _ = batching.Send("1", []byte("a\n"))
+
+	assert.Error(t, batching.Release())
+	assert.Equal(t, int64(1), dropped.Count())
+	sender.AssertExpectations(t)
+}
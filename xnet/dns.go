@@ -0,0 +1,58 @@
+package xnet
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NewDNSDiscoveryServiceClient returns DiscoveryServiceClient that resolves
+// service instances with DNS SRV records (_service._proto.domain). Returned
+// client implements WeightedDiscoveryServiceClient as well, using weights of
+// the records.
+func NewDNSDiscoveryServiceClient(proto, domain string) DiscoveryServiceClient {
+	return &dnsDiscoveryServiceClient{
+		proto:     proto,
+		domain:    domain,
+		lookupSRV: net.LookupSRV,
+	}
+}
+
+type dnsDiscoveryServiceClient struct {
+	proto     string
+	domain    string
+	lookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+}
+
+func (c *dnsDiscoveryServiceClient) GetAddrsByName(serviceName string) ([]Address, error) {
+	weightedInstances, err := c.GetWeightedAddrsByName(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]Address, len(weightedInstances))
+	for i, instance := range weightedInstances {
+		instances[i] = instance.Address
+	}
+
+	return instances, nil
+}
+
+// GetWeightedAddrsByName returns instances with weights of their SRV records.
+func (c *dnsDiscoveryServiceClient) GetWeightedAddrsByName(serviceName string) ([]WeightedAddress, error) {
+	_, records, err := c.lookupSRV(serviceName, c.proto, c.domain)
+	if err != nil {
+		return nil, fmt.Errorf("could NOT find service in DNS: %s", err)
+	}
+
+	instances := make([]WeightedAddress, len(records))
+	for i, record := range records {
+		instances[i] = WeightedAddress{
+			Address: Address(net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))),
+			Weight:  int(record.Weight),
+		}
+	}
+
+	return instances, nil
+}
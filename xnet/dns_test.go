@@ -0,0 +1,49 @@
+package xnet
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfGetAddrsByNameReturnsInstancesFromSRVRecords(t *testing.T) {
+	client := &dnsDiscoveryServiceClient{
+		proto:  "tcp",
+		domain: "example.com",
+		lookupSRV: func(service, proto, name string) (string, []*net.SRV, error) {
+			require.Equal(t, "logstash", service)
+			require.Equal(t, "tcp", proto)
+			require.Equal(t, "example.com", name)
+			return "_logstash._tcp.example.com.", []*net.SRV{
+				{Target: "logstash-1.example.com.", Port: 5000, Weight: 10},
+				{Target: "logstash-2.example.com.", Port: 5001, Weight: 20},
+			}, nil
+		},
+	}
+
+	addrs, err := client.GetAddrsByName("logstash")
+	require.NoError(t, err)
+	weightedAddrs, err := client.GetWeightedAddrsByName("logstash")
+	require.NoError(t, err)
+
+	assert.Equal(t, []Address{"logstash-1.example.com:5000", "logstash-2.example.com:5001"}, addrs)
+	assert.Equal(t, []WeightedAddress{
+		{Address: "logstash-1.example.com:5000", Weight: 10},
+		{Address: "logstash-2.example.com:5001", Weight: 20},
+	}, weightedAddrs)
+}
+
+func TestIfGetAddrsByNameReturnsErrorIfSRVLookupFails(t *testing.T) {
+	client := &dnsDiscoveryServiceClient{
+		lookupSRV: func(service, proto, name string) (string, []*net.SRV, error) {
+			return "", nil, errors.New("no such host")
+		},
+	}
+
+	_, err := client.GetAddrsByName("logstash")
+
+	assert.EqualError(t, err, "could NOT find service in DNS: no such host")
+}
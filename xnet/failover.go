@@ -0,0 +1,87 @@
+package xnet
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errNoInstances      = errors.New("no instances available")
+	errTiersUnavailable = errors.New("all failover tiers are unavailable")
+)
+
+// FailoverTier is a group of instances used by FailoverWriter. Writes are
+// spread between tier instances using round robin algorithm.
+type FailoverTier struct {
+	Provider InstanceProvider
+	Sender   Sender
+}
+
+// FailoverWriter returns writer that writes to the first available tier from
+// the passed ordered list. Tier is demoted after maxFailures consecutive write
+// errors and is used again (probed) after probeInterval elapses.
+func FailoverWriter(tiers []FailoverTier, maxFailures int, probeInterval time.Duration) io.Writer {
+	writer := &failoverWriter{maxFailures: maxFailures, probeInterval: probeInterval}
+	for _, tier := range tiers {
+		writer.tiers = append(writer.tiers, &failoverTier{FailoverTier: tier})
+	}
+	return writer
+}
+
+type failoverTier struct {
+	FailoverTier
+
+	instances    []Address
+	next         int
+	failures     int
+	demotedUntil time.Time
+}
+
+type failoverWriter struct {
+	tiers         []*failoverTier
+	maxFailures   int
+	probeInterval time.Duration
+}
+
+func (w *failoverWriter) Write(payload []byte) (int, error) {
+	now := time.Now()
+	err := errTiersUnavailable
+	for i, tier := range w.tiers {
+		if now.Before(tier.demotedUntil) {
+			continue
+		}
+		var n int
+		n, err = tier.write(payload)
+		if err == nil {
+			tier.failures = 0
+			return n, nil
+		}
+		tier.failures++
+		if tier.failures >= w.maxFailures {
+			log.WithError(err).Warnf("Demoting failover tier %d for %s", i, w.probeInterval)
+			tier.demotedUntil = now.Add(w.probeInterval)
+		}
+	}
+	return 0, err
+}
+
+func (t *failoverTier) write(payload []byte) (int, error) {
+	select {
+	case newInstances := <-t.Provider:
+		log.WithField("instances", newInstances).Info("Received new instances for FailoverWriter")
+		t.instances = newInstances
+		t.next = 0
+		releaseUnused(t.Sender, newInstances)
+	default:
+	}
+	if len(t.instances) == 0 {
+		return 0, errNoInstances
+	}
+
+	instance := t.instances[t.next%len(t.instances)]
+	t.next = (t.next + 1) % len(t.instances)
+	return t.Sender.Send(instance, payload)
+}
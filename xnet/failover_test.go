@@ -0,0 +1,96 @@
+package xnet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailoverShouldWriteToPrimaryTierWhenItIsHealthy(t *testing.T) {
+	primary, secondary := &MockSender{}, &MockSender{}
+	primary.On("Send", Address("1"), []byte("x")).Return(1, nil).Twice()
+	primary.On("Release").Return(nil)
+
+	writer := FailoverWriter([]FailoverTier{
+		{Provider: providerOf("1"), Sender: primary},
+		{Provider: providerOf("2"), Sender: secondary},
+	}, 1, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		_, err := writer.Write([]byte("x"))
+		assert.NoError(t, err)
+	}
+
+	primary.AssertExpectations(t)
+	secondary.AssertNotCalled(t, "Send", Address("2"), []byte("x"))
+}
+
+func TestFailoverShouldUseSecondaryTierAfterPrimaryIsDemoted(t *testing.T) {
+	primary, secondary := &MockSender{}, &MockSender{}
+	primary.On("Send", Address("1"), []byte("x")).Return(0, errors.New("error")).Twice()
+	primary.On("Release").Return(nil)
+	secondary.On("Send", Address("2"), []byte("x")).Return(1, nil).Times(3)
+	secondary.On("Release").Return(nil)
+
+	writer := FailoverWriter([]FailoverTier{
+		{Provider: providerOf("1"), Sender: primary},
+		{Provider: providerOf("2"), Sender: secondary},
+	}, 2, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		_, err := writer.Write([]byte("x"))
+		assert.NoError(t, err)
+	}
+
+	primary.AssertExpectations(t)
+	secondary.AssertExpectations(t)
+}
+
+func TestFailoverShouldProbeDemotedTierAfterInterval(t *testing.T) {
+	primary, secondary := &MockSender{}, &MockSender{}
+	primary.On("Send", Address("1"), []byte("x")).Return(0, errors.New("error")).Once()
+	primary.On("Send", Address("1"), []byte("x")).Return(1, nil).Once()
+	primary.On("Release").Return(nil)
+	secondary.On("Send", Address("2"), []byte("x")).Return(1, nil).Once()
+	secondary.On("Release").Return(nil)
+
+	writer := FailoverWriter([]FailoverTier{
+		{Provider: providerOf("1"), Sender: primary},
+		{Provider: providerOf("2"), Sender: secondary},
+	}, 1, time.Millisecond)
+
+	_, err := writer.Write([]byte("x"))
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = writer.Write([]byte("x"))
+	assert.NoError(t, err)
+
+	primary.AssertExpectations(t)
+	secondary.AssertExpectations(t)
+}
+
+func TestFailoverShouldReturnErrorWhenAllTiersFail(t *testing.T) {
+	secondary := &MockSender{}
+	secondary.On("Send", Address("2"), []byte("x")).Return(0, errors.New("error")).Once()
+	secondary.On("Release").Return(nil)
+
+	writer := FailoverWriter([]FailoverTier{
+		{Provider: make(chan []Address), Sender: &MockSender{}},
+		{Provider: providerOf("2"), Sender: secondary},
+	}, 1, time.Hour)
+
+	_, err := writer.Write([]byte("x"))
+	assert.Error(t, err)
+	_, err = writer.Write([]byte("x"))
+	assert.Equal(t, errTiersUnavailable, err)
+
+	secondary.AssertExpectations(t)
+}
+
+func providerOf(addrs ...Address) InstanceProvider {
+	provider := make(chan []Address, 1)
+	provider <- addrs
+	return provider
+}
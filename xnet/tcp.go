@@ -1,6 +1,7 @@
 package xnet
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 
@@ -8,11 +9,17 @@ import (
 )
 
 // TCPSender is a Sender implementation that can write payload to the network
-// address and reuses TCP connections for the same addresses.
+// address and reuses TCP connections for the same addresses. Connections are
+// wrapped with TLS when TLSConfig is set. When MaxConnections is positive, the
+// least recently used connections are closed to keep the pool within the limit.
 type TCPSender struct {
-	Dialer net.Dialer
+	Dialer         net.Dialer
+	TLSConfig      *tls.Config
+	MaxConnections int
 
 	connections map[Address]net.Conn
+	lastUsed    map[Address]uint64
+	uses        uint64
 }
 
 // Send sends given payload to passed address. Data is sent using pool of TCP
@@ -20,6 +27,7 @@ type TCPSender struct {
 func (s *TCPSender) Send(addr Address, payload []byte) (int, error) {
 	if s.connections == nil {
 		s.connections = make(map[Address]net.Conn)
+		s.lastUsed = make(map[Address]uint64)
 	}
 	conn, ok := s.connections[addr]
 	if !ok {
@@ -27,22 +35,45 @@ func (s *TCPSender) Send(addr Address, payload []byte) (int, error) {
 		if err != nil {
 			return 0, fmt.Errorf("unable to dial %s address: %s", addr, err)
 		}
+		s.evictLeastRecentlyUsed()
 		s.connections[addr] = newConn
 		conn = newConn
 	}
+	s.uses++
+	s.lastUsed[addr] = s.uses
 	n, err := conn.Write(payload)
 	if err != nil {
 		log.WithError(err).Info("Closing TCP connection because of an error")
 		// let's be nice and at least try to close connection on our side
-		closeErr := s.connections[addr].Close()
-		if closeErr != nil {
+		if closeErr := s.close(addr); closeErr != nil {
 			log.WithError(closeErr).Warn("Unable to close TCP connection properly")
 		}
-		delete(s.connections, addr)
 	}
 	return n, err
 }
 
+// Prune closes connections to addresses not present on the passed list.
+// Connections are recreated lazily when the address is used again.
+func (s *TCPSender) Prune(addrs []Address) error {
+	keep := make(map[Address]bool, len(addrs))
+	for _, addr := range addrs {
+		keep[addr] = true
+	}
+	var errs []error
+	for addr := range s.connections {
+		if keep[addr] {
+			continue
+		}
+		if err := s.close(addr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return MultiError(errs)
+	}
+	return nil
+}
+
 // Release frees system sockets used by sender.
 func (s *TCPSender) Release() error {
 	if s.connections == nil {
@@ -55,13 +86,46 @@ func (s *TCPSender) Release() error {
 		}
 	}
 	s.connections = nil
+	s.lastUsed = nil
 	if len(errs) > 0 {
 		return MultiError(errs)
 	}
 	return nil
 }
 
+// evictLeastRecentlyUsed closes the least recently used connection when the
+// pool is full.
+func (s *TCPSender) evictLeastRecentlyUsed() {
+	if s.MaxConnections <= 0 || len(s.connections) < s.MaxConnections {
+		return
+	}
+	var oldest Address
+	for addr := range s.connections {
+		if oldest == "" || s.lastUsed[addr] < s.lastUsed[oldest] {
+			oldest = addr
+		}
+	}
+	log.WithField("address", oldest).Debug("Closing least recently used TCP connection")
+	if err := s.close(oldest); err != nil {
+		log.WithError(err).Warn("Unable to close TCP connection properly")
+	}
+}
+
+func (s *TCPSender) close(addr Address) error {
+	err := s.connections[addr].Close()
+	delete(s.connections, addr)
+	delete(s.lastUsed, addr)
+	return err
+}
+
 func (s *TCPSender) dial(addr Address) (net.Conn, error) {
+	if s.TLSConfig != nil {
+		conn, err := tls.DialWithDialer(&s.Dialer, "tcp", string(addr), s.TLSConfig)
+		if err != nil {
+			return nil, err // we want plain error here
+		}
+		return conn, nil
+	}
 	conn, err := s.Dialer.Dial("tcp", string(addr))
 	if err != nil {
 		return nil, err // we want plain error here
@@ -1,6 +1,9 @@
 package xnet
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -48,6 +51,65 @@ func TestIfTCPNetworkSenderReleasesResources(t *testing.T) {
 	assert.Empty(t, sender.connections)
 }
 
+func TestIfTCPNetworkSenderEvictsLeastRecentlyUsedConnections(t *testing.T) {
+	var addrs []Address
+	for i := 0; i < 3; i++ {
+		listener, results, err := xnettest.LoopbackServer("tcp")
+		require.NoError(t, err)
+		defer listener.Close()
+		go func() {
+			for range results {
+			}
+		}()
+		addrs = append(addrs, Address(listener.Addr().String()))
+	}
+
+	sender := &TCPSender{MaxConnections: 2}
+	defer sender.Release()
+
+	for _, addr := range []Address{addrs[0], addrs[1], addrs[0], addrs[2]} {
+		_, err := sender.Send(addr, []byte("test"))
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, sender.connections, 2)
+	assert.Contains(t, sender.connections, addrs[0])
+	assert.Contains(t, sender.connections, addrs[2])
+
+	_, err := sender.Send(addrs[1], []byte("test"))
+	require.NoError(t, err)
+	assert.Contains(t, sender.connections, addrs[1])
+	assert.NotContains(t, sender.connections, addrs[0])
+}
+
+func TestIfTCPNetworkSenderPrunesConnectionsToRemovedAddresses(t *testing.T) {
+	listener1, results1, err := xnettest.LoopbackServer("tcp")
+	require.NoError(t, err)
+	defer listener1.Close()
+	listener2, results2, err := xnettest.LoopbackServer("tcp")
+	require.NoError(t, err)
+	defer listener2.Close()
+	addr1, addr2 := Address(listener1.Addr().String()), Address(listener2.Addr().String())
+
+	sender := &TCPSender{}
+	defer sender.Release()
+	_, err = sender.Send(addr1, []byte("test"))
+	require.NoError(t, err)
+	<-results1
+	_, err = sender.Send(addr2, []byte("test"))
+	require.NoError(t, err)
+	<-results2
+
+	require.NoError(t, sender.Prune([]Address{addr2}))
+
+	assert.Len(t, sender.connections, 1)
+	assert.Contains(t, sender.connections, addr2)
+
+	_, err = sender.Send(addr1, []byte("test"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test"), <-results1)
+}
+
 func TestIfTCPNetworkSenderReturnsNumberOfSentBytes(t *testing.T) {
 	listener, results, err := xnettest.LoopbackServer("tcp")
 	require.NoError(t, err)
@@ -69,3 +131,32 @@ func TestIfTCPNetworkSenderReturnsErrorWhenConnectionUnavailable(t *testing.T) {
 	assert.Error(t, err)
 	assert.Zero(t, bytesSent)
 }
+
+func TestIfTCPNetworkSenderSendsPayloadOverTLS(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server.TLS)
+	require.NoError(t, err)
+	defer listener.Close()
+	received := make(chan []byte)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, _ := conn.Read(buf)
+		received <- buf[:n]
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	sender := &TCPSender{TLSConfig: &tls.Config{RootCAs: roots, ServerName: "example.com"}}
+	defer sender.Release()
+
+	_, err = sender.Send(Address(listener.Addr().String()), []byte("test"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte("test"), <-received)
+}
@@ -3,43 +3,106 @@ package xnet
 import (
 	"fmt"
 	"net"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // UDPSender is a Sender implementation that can write payload to the network
-// address and reuses single system socket. It uses UDP packets to send data.
+// address and reuses UDP sockets for the same addresses. It uses UDP packets to
+// send data. When WriteBuffer is positive, it is used as a size of the system
+// send buffer of every socket.
 type UDPSender struct {
-	conn *net.UDPConn
+	WriteBuffer int
+
+	connections map[Address]*net.UDPConn
 }
 
 // Send sends given payload to passed address. Data is sent using UDP packets.
 // It returns number of bytes sent and error - if there was any.
 func (s *UDPSender) Send(addr Address, payload []byte) (int, error) {
-	if s.conn == nil {
-		conn, err := net.ListenUDP("udp", nil)
+	if s.connections == nil {
+		s.connections = make(map[Address]*net.UDPConn)
+	}
+	conn, ok := s.connections[addr]
+	if !ok {
+		newConn, err := s.dial(addr)
 		if err != nil {
-			return 0, fmt.Errorf("could not create connection: %s", err)
+			return 0, err
 		}
-		s.conn = conn
-	}
-
-	udpAddr, err := net.ResolveUDPAddr("udp", string(addr))
-	if err != nil {
-		return 0, fmt.Errorf("invalid address %s: %s", addr, err)
+		s.connections[addr] = newConn
+		conn = newConn
 	}
 
-	n, err := s.conn.WriteTo(payload, udpAddr)
+	n, err := conn.Write(payload)
 	if err != nil {
+		if closeErr := s.close(addr); closeErr != nil {
+			log.WithError(closeErr).Warn("Unable to close UDP socket properly")
+		}
 		return 0, fmt.Errorf("could not sent payload to %s: %s", addr, err)
 	}
 	return n, nil
 }
 
-// Release frees system socket used by sender.
+// Prune closes sockets used for addresses not present on the passed list.
+// Sockets are recreated lazily when the address is used again.
+func (s *UDPSender) Prune(addrs []Address) error {
+	keep := make(map[Address]bool, len(addrs))
+	for _, addr := range addrs {
+		keep[addr] = true
+	}
+	var errs []error
+	for addr := range s.connections {
+		if keep[addr] {
+			continue
+		}
+		if err := s.close(addr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return MultiError(errs)
+	}
+	return nil
+}
+
+// Release frees system sockets used by sender.
 func (s *UDPSender) Release() error {
-	if s.conn == nil {
+	if s.connections == nil {
 		return nil
 	}
-	err := s.conn.Close()
-	s.conn = nil
+	var errs []error
+	for _, conn := range s.connections {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.connections = nil
+	if len(errs) > 0 {
+		return MultiError(errs)
+	}
+	return nil
+}
+
+func (s *UDPSender) close(addr Address) error {
+	err := s.connections[addr].Close()
+	delete(s.connections, addr)
 	return err
 }
+
+func (s *UDPSender) dial(addr Address) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", string(addr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %s", addr, err)
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("could not create connection: %s", err)
+	}
+	if s.WriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(s.WriteBuffer); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("could not set write buffer: %s", err)
+		}
+	}
+	return conn, nil
+}
@@ -31,3 +31,32 @@ func TestUDPNetworkSendShouldReturnNumberOfSentBytes(t *testing.T) {
 	assert.Equal(t, 4, bytesSent)
 	assert.Equal(t, []byte("test"), <-result)
 }
+
+func TestUDPNetworkSenderShouldReuseSocketsAndPruneRemovedAddresses(t *testing.T) {
+	conn1, result1, err := xnettest.LoopbackPacketServer("udp")
+	require.NoError(t, err)
+	defer conn1.Close()
+	conn2, result2, err := xnettest.LoopbackPacketServer("udp")
+	require.NoError(t, err)
+	defer conn2.Close()
+	addr1, addr2 := Address(conn1.LocalAddr().String()), Address(conn2.LocalAddr().String())
+
+	sender := &UDPSender{WriteBuffer: 64 * 1024}
+	defer sender.Release()
+
+	for _, addr := range []Address{addr1, addr2, addr1} {
+		_, err := sender.Send(addr, []byte("test"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []byte("test"), <-result1)
+	assert.Equal(t, []byte("test"), <-result2)
+	assert.Equal(t, []byte("test"), <-result1)
+	assert.Len(t, sender.connections, 2)
+
+	require.NoError(t, sender.Prune([]Address{addr2}))
+	assert.Len(t, sender.connections, 1)
+	assert.Contains(t, sender.connections, addr2)
+
+	require.NoError(t, sender.Release())
+	assert.Empty(t, sender.connections)
+}
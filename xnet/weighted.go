@@ -0,0 +1,129 @@
+package xnet
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WeightedAddress is an address of a service instance with its weight.
+type WeightedAddress struct {
+	Address Address
+	Weight  int
+}
+
+// WeightedInstanceProvider is the channel where updated list of desired
+// service instances with their weights are published.
+type WeightedInstanceProvider <-chan []WeightedAddress
+
+// WeightedDiscoveryServiceClient represents discovery service client that can
+// return list of services with their weights.
+type WeightedDiscoveryServiceClient interface {
+	// GetWeightedAddrsByName returns list of services with given name
+	GetWeightedAddrsByName(serviceName string) ([]WeightedAddress, error)
+}
+
+// WeightedRoundRobinWriter returns writer with weighted round robin
+// functionality. Writes are distributed between instances proportionally to
+// their weights. Instances with non positive weight are treated as having
+// weight of 1.
+func WeightedRoundRobinWriter(instanceProvider WeightedInstanceProvider, sender Sender) io.Writer {
+	return &weightedRoundRobinWriter{provider: instanceProvider, sender: sender}
+}
+
+type weightedInstance struct {
+	address Address
+	weight  int
+	current int
+}
+
+type weightedRoundRobinWriter struct {
+	provider    WeightedInstanceProvider
+	sender      Sender
+	instances   []*weightedInstance
+	totalWeight int
+}
+
+func (r *weightedRoundRobinWriter) Write(payload []byte) (int, error) {
+	if r.instances == nil {
+		r.updateInstances(<-r.provider)
+	}
+
+	select {
+	case newInstances := <-r.provider:
+		log.WithField("instances", newInstances).Info("Received new instances for WeightedRoundRobinWriter")
+		r.updateInstances(newInstances)
+	default:
+	}
+	return r.sender.Send(r.next(), payload)
+}
+
+func (r *weightedRoundRobinWriter) updateInstances(newInstances []WeightedAddress) {
+	r.instances = make([]*weightedInstance, len(newInstances))
+	r.totalWeight = 0
+	addresses := make([]Address, len(newInstances))
+	for i, instance := range newInstances {
+		weight := instance.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		r.instances[i] = &weightedInstance{address: instance.Address, weight: weight}
+		r.totalWeight += weight
+		addresses[i] = instance.Address
+	}
+	releaseUnused(r.sender, addresses)
+}
+
+// next selects instance using smooth weighted round robin algorithm, so
+// instances with higher weights are not selected in bursts.
+func (r *weightedRoundRobinWriter) next() Address {
+	var selected *weightedInstance
+	for _, instance := range r.instances {
+		instance.current += instance.weight
+		if selected == nil || instance.current > selected.current {
+			selected = instance
+		}
+	}
+	if selected == nil {
+		return ""
+	}
+	selected.current -= r.totalWeight
+	return selected.address
+}
+
+// WeightedDiscoveryServiceInstanceProvider returns WeightedInstanceProvider
+// that is updated with list of instances in interval. Instances are resolved
+// for the first time immediately.
+func WeightedDiscoveryServiceInstanceProvider(serviceName string, interval time.Duration, client WeightedDiscoveryServiceClient) WeightedInstanceProvider {
+	instancesChan := make(chan []WeightedAddress)
+
+	go func() {
+		var currInstances []WeightedAddress
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			newInstances, err := client.GetWeightedAddrsByName(serviceName)
+			if err != nil {
+				log.WithError(err).Warn("Unable to get newInstances from discovery service")
+				continue
+			}
+			sort.Slice(newInstances, func(i, j int) bool {
+				return newInstances[i].Address < newInstances[j].Address
+			})
+			if !reflect.DeepEqual(currInstances, newInstances) {
+				if len(newInstances) > 0 {
+					log.WithField("instances", newInstances).Infof("Service %q instances in discovery changed - sending update", serviceName)
+					currInstances = newInstances
+					instancesChan <- newInstances
+				} else {
+					log.WithField("instances", newInstances).Infof("Service %q instances in discovery changed - ignoring empty update", serviceName)
+				}
+			}
+		}
+	}()
+
+	return instancesChan
+}
@@ -0,0 +1,76 @@
+package xnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWeightedRoundRobinShouldDistributeWritesProportionallyToWeights(t *testing.T) {
+	provider := make(chan []WeightedAddress, 1)
+	provider <- []WeightedAddress{{Address: "1", Weight: 3}, {Address: "2", Weight: 1}}
+
+	sent := map[Address]int{}
+	sender := &MockSender{}
+	sender.On("Send", mock.Anything, []byte("x")).Return(1, nil).Run(func(args mock.Arguments) {
+		sent[args.Get(0).(Address)]++
+	})
+	sender.On("Release").Return(nil)
+
+	writer := WeightedRoundRobinWriter(provider, sender)
+
+	for i := 0; i < 8; i++ {
+		_, err := writer.Write([]byte("x"))
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, map[Address]int{"1": 6, "2": 2}, sent)
+}
+
+func TestWeightedRoundRobinShouldTreatNonPositiveWeightsAsEqual(t *testing.T) {
+	provider := make(chan []WeightedAddress, 2)
+	provider <- []WeightedAddress{{Address: "1"}, {Address: "2", Weight: -5}}
+
+	sender := &MockSender{}
+	sender.On("Send", Address("1"), []byte("x")).Return(1, nil).Once()
+	sender.On("Send", Address("2"), []byte("x")).Return(1, nil).Once()
+	sender.On("Send", Address("3"), []byte("x")).Return(1, nil).Once()
+	sender.On("Release").Return(nil)
+
+	writer := WeightedRoundRobinWriter(provider, sender)
+
+	_, err := writer.Write([]byte("x"))
+	assert.NoError(t, err)
+	_, err = writer.Write([]byte("x"))
+	assert.NoError(t, err)
+
+	provider <- []WeightedAddress{{Address: "3", Weight: 10}}
+
+	_, err = writer.Write([]byte("x"))
+
+	assert.NoError(t, err)
+	sender.AssertExpectations(t)
+}
+
+func TestWeightedDiscoveryServiceInstanceProviderShouldPublishSortedInstances(t *testing.T) {
+	client := &weightedDiscoveryServiceClient{instances: []WeightedAddress{
+		{Address: "192.0.2.2:80", Weight: 1},
+		{Address: "192.0.2.1:80", Weight: 5},
+	}}
+
+	provider := WeightedDiscoveryServiceInstanceProvider("service name", 1, client)
+
+	assert.Equal(t, []WeightedAddress{
+		{Address: "192.0.2.1:80", Weight: 5},
+		{Address: "192.0.2.2:80", Weight: 1},
+	}, <-provider)
+}
+
+type weightedDiscoveryServiceClient struct {
+	instances []WeightedAddress
+}
+
+func (c *weightedDiscoveryServiceClient) GetWeightedAddrsByName(string) ([]WeightedAddress, error) {
+	return append([]WeightedAddress(nil), c.instances...), nil
+}
@@ -28,19 +28,34 @@ type Sender interface {
 	Release() error
 }
 
+// Pruner is an optional interface of Sender that can free resources allocated
+// only for addresses that are no longer used.
+type Pruner interface {
+	// Prune frees resources allocated for addresses not present on the list.
+	Prune([]Address) error
+}
+
 // Address of a service in IP:PORT format
 type Address string
 
+// failedInstanceSkipPeriod is a time for which RoundRobinWriter skips instances
+// that failed to receive data.
+const failedInstanceSkipPeriod = 5 * time.Second
+
 // RoundRobinWriter returns writer with round robin functionality. Every write
-// could be sent to different backend.
-func RoundRobinWriter(instanceProvider InstanceProvider, sender Sender) io.Writer {
+// could be sent to different backend. When sending to an instance fails, the
+// next ones are tried and the failed instance is skipped for a while. Error is
+// returned only when no instance accepted the data. Closing the writer releases
+// the sender, so data buffered by it is sent.
+func RoundRobinWriter(instanceProvider InstanceProvider, sender Sender) io.WriteCloser {
 	return &roundRobinWriter{provider: instanceProvider, sender: sender, instances: nil}
 }
 
 type roundRobinWriter struct {
-	provider  InstanceProvider
-	sender    Sender
-	instances chan Address
+	provider    InstanceProvider
+	sender      Sender
+	instances   chan Address
+	failedUntil map[Address]time.Time
 }
 
 func (r *roundRobinWriter) Write(byte []byte) (int, error) {
@@ -58,33 +73,94 @@ func (r *roundRobinWriter) Write(byte []byte) (int, error) {
 	}
 }
 
+func (r *roundRobinWriter) Close() error {
+	return r.sender.Release()
+}
+
 func (r *roundRobinWriter) updateInstances(newInstances []Address) {
 	r.instances = make(chan Address, len(newInstances))
+	failedUntil := make(map[Address]time.Time)
 	for _, instance := range newInstances {
 		r.instances <- instance
+		if until, ok := r.failedUntil[instance]; ok {
+			failedUntil[instance] = until
+		}
 	}
-	if err := r.sender.Release(); err != nil {
+	r.failedUntil = failedUntil
+	releaseUnused(r.sender, newInstances)
+}
+
+// releaseUnused frees sender resources that are not used by passed instances.
+// Senders that are not Pruners release all resources.
+func releaseUnused(sender Sender, instances []Address) {
+	if pruner, ok := sender.(Pruner); ok {
+		if err := pruner.Prune(instances); err != nil {
+			log.WithError(err).Warn("Unable to prune xnet.Sender resources")
+		}
+		return
+	}
+	if err := sender.Release(); err != nil {
 		log.WithError(err).Warn("Unable to release xnet.Sender resources")
 	}
 }
 
 func (r *roundRobinWriter) write(payload []byte) (int, error) {
-	// Read next instance from queue
-	instance := <-r.instances
-	// Enqueue instance for round robin behaviour
-	r.instances <- instance
+	if cap(r.instances) == 0 {
+		return 0, errNoInstances
+	}
 
-	return r.sender.Send(instance, payload)
+	now := time.Now()
+	var skipped []Address
+	var err error
+	for i := 0; i < cap(r.instances); i++ {
+		// Read next instance from queue
+		instance := <-r.instances
+		// Enqueue instance for round robin behaviour
+		r.instances <- instance
+
+		if now.Before(r.failedUntil[instance]) {
+			skipped = append(skipped, instance)
+			continue
+		}
+		var n int
+		if n, err = r.send(instance, payload, now); err == nil {
+			return n, nil
+		}
+	}
+	// it is better to retry failed instances than to drop data
+	for _, instance := range skipped {
+		var n int
+		if n, err = r.send(instance, payload, now); err == nil {
+			return n, nil
+		}
+	}
+	return 0, err
+}
+
+func (r *roundRobinWriter) send(instance Address, payload []byte, now time.Time) (int, error) {
+	n, err := r.sender.Send(instance, payload)
+	if err != nil {
+		if _, ok := r.failedUntil[instance]; !ok {
+			log.WithError(err).Warnf("Unable to send data to %s, skipping it for %s", instance, failedInstanceSkipPeriod)
+		}
+		r.failedUntil[instance] = now.Add(failedInstanceSkipPeriod)
+		return n, err
+	}
+	delete(r.failedUntil, instance)
+	return n, nil
 }
 
 // DiscoveryServiceInstanceProvider returns InstanceProvider that is updated with
-// list of instances in interval
+// list of instances in interval. Instances are resolved for the first time
+// immediately.
 func DiscoveryServiceInstanceProvider(serviceName string, interval time.Duration, client DiscoveryServiceClient) InstanceProvider {
 	instancesChan := make(chan []Address)
 
 	go func() {
 		var currInstances []Address
-		for range time.NewTicker(interval).C {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
 			newInstances, err := client.GetAddrsByName(serviceName)
 			if err != nil {
 				log.WithError(err).Warn("Unable to get newInstances from discovery service")
@@ -115,29 +191,84 @@ type DiscoveryServiceClient interface {
 	GetAddrsByName(serviceName string) ([]Address, error)
 }
 
-// NewConsulDiscoveryServiceClient returns DiscoverServiceClient backed by Consul
-func NewConsulDiscoveryServiceClient(client *api.Client) DiscoveryServiceClient {
+// NewConsulDiscoveryServiceClient returns DiscoverServiceClient backed by Consul.
+// Returned client implements WeightedDiscoveryServiceClient as well. When there
+// are no healthy instances in the local datacenter, passed fallback
+// datacenters are queried in order. When there are no healthy instances at
+// all, every instance registered in the local datacenter is returned.
+func NewConsulDiscoveryServiceClient(client *api.Client, fallbackDatacenters ...string) DiscoveryServiceClient {
 	return &consulDiscoveryServiceClient{
-		client: client,
+		client:              client,
+		fallbackDatacenters: fallbackDatacenters,
 	}
 }
 
 type consulDiscoveryServiceClient struct {
-	client *api.Client
+	client              *api.Client
+	fallbackDatacenters []string
 }
 
 func (c *consulDiscoveryServiceClient) GetAddrsByName(serviceName string) ([]Address, error) {
-	//TODO(janisz): Add fallback to other datacenters with query
-	opts := api.QueryOptions{AllowStale: true, UseCache: true, MaxAge: 5 * time.Minute}
-	services, _, err := c.client.Health().Service(serviceName, "", true, &opts)
+	weightedInstances, err := c.GetWeightedAddrsByName(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]Address, len(weightedInstances))
+	for i, instance := range weightedInstances {
+		instances[i] = instance.Address
+	}
+
+	return instances, nil
+}
+
+// GetWeightedAddrsByName returns instances with their passing weights
+// configured in Consul.
+func (c *consulDiscoveryServiceClient) GetWeightedAddrsByName(serviceName string) ([]WeightedAddress, error) {
+	instances, err := c.getWeightedAddrsByNameInDatacenter(serviceName, "", true)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, datacenter := range c.fallbackDatacenters {
+		if len(instances) > 0 {
+			break
+		}
+		log.Debugf("No %q instances found, falling back to %s datacenter", serviceName, datacenter)
+		instances, err = c.getWeightedAddrsByNameInDatacenter(serviceName, datacenter, true)
+		if err != nil {
+			log.WithError(err).Warnf("Unable to get %q instances from %s datacenter", serviceName, datacenter)
+		}
+	}
+
+	if len(instances) == 0 {
+		// it is better to send data to unhealthy instances than to stop sending at all
+		instances, err = c.getWeightedAddrsByNameInDatacenter(serviceName, "", false)
+		if err != nil {
+			return nil, err
+		}
+		if len(instances) > 0 {
+			log.Warnf("No passing %q instances found, using all registered instances", serviceName)
+		}
+	}
+
+	return instances, nil
+}
+
+func (c *consulDiscoveryServiceClient) getWeightedAddrsByNameInDatacenter(serviceName, datacenter string, passingOnly bool) ([]WeightedAddress, error) {
+	opts := api.QueryOptions{Datacenter: datacenter, AllowStale: true, UseCache: true, MaxAge: 5 * time.Minute}
+	services, _, err := c.client.Health().Service(serviceName, "", passingOnly, &opts)
 
 	if err != nil {
 		return nil, fmt.Errorf("could NOT find service in Consul: %s", err)
 	}
 
-	instances := make([]Address, len(services))
+	instances := make([]WeightedAddress, len(services))
 	for i, instance := range services {
-		instances[i] = Address(net.JoinHostPort(instance.Service.Address, strconv.Itoa(instance.Service.Port)))
+		instances[i] = WeightedAddress{
+			Address: Address(net.JoinHostPort(instance.Service.Address, strconv.Itoa(instance.Service.Port))),
+			Weight:  instance.Service.Weights.Passing,
+		}
 	}
 
 	return instances, nil
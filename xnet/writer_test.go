@@ -1,6 +1,7 @@
 package xnet
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -76,6 +77,21 @@ func TestDiscoveryServiceInstanceProviderShouldPeriodicallyUpdatesInstances(t *t
 	assert.Empty(t, ret)
 }
 
+func TestDiscoveryServiceInstanceProviderShouldResolveInstancesImmediately(t *testing.T) {
+	ret := make(chan []Address, 1)
+	client := &StubDiscoveryServiceClient{returns: ret}
+	ret <- []Address{"192.0.2.1:80"}
+
+	provider := DiscoveryServiceInstanceProvider("service name", time.Hour, client)
+
+	select {
+	case instances := <-provider:
+		assert.Equal(t, []Address{"192.0.2.1:80"}, instances)
+	case <-time.After(time.Second):
+		t.Fatal("instances were not resolved before the first interval elapsed")
+	}
+}
+
 func TestIfUpdatesAddressesOnlyIfTheyChanged(t *testing.T) {
 	returns := make(chan []Address, 5)
 	discoveryServiceClient := &StubDiscoveryServiceClient{returns}
@@ -152,6 +168,71 @@ func TestRoundRobinShouldStartFromTheBegginingAfterUpdate(t *testing.T) {
 	sender.AssertExpectations(t)
 }
 
+func TestRoundRobinShouldTryNextInstanceAndSkipFailedOne(t *testing.T) {
+	provider := make(chan []Address, 1)
+	provider <- []Address{"1", "2", "3"}
+
+	sender := &MockSender{}
+	sender.On("Send", Address("1"), []byte("x")).Return(0, errors.New("connection refused")).Once()
+	sender.On("Send", Address("2"), []byte("x")).Return(1, nil).Twice()
+	sender.On("Send", Address("3"), []byte("x")).Return(1, nil).Once()
+	sender.On("Release").Return(nil)
+
+	writer := RoundRobinWriter(provider, sender)
+
+	for i := 0; i < 3; i++ {
+		n, err := writer.Write([]byte("x"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+
+	sender.AssertExpectations(t)
+}
+
+func TestRoundRobinShouldReturnErrorWhenAllInstancesFail(t *testing.T) {
+	provider := make(chan []Address, 1)
+	provider <- []Address{"1", "2"}
+
+	sender := &MockSender{}
+	sender.On("Send", Address("1"), []byte("x")).Return(0, errors.New("first error"))
+	sender.On("Send", Address("2"), []byte("x")).Return(0, errors.New("last error"))
+	sender.On("Release").Return(nil)
+
+	writer := RoundRobinWriter(provider, sender)
+
+	n, err := writer.Write([]byte("x"))
+	assert.EqualError(t, err, "last error")
+	assert.Equal(t, 0, n)
+
+	// failed instances are retried when there are no other ones
+	_, err = writer.Write([]byte("x"))
+	assert.Error(t, err)
+	sender.AssertNumberOfCalls(t, "Send", 4)
+}
+
+func TestRoundRobinShouldPruneSenderResourcesAfterUpdate(t *testing.T) {
+	provider := make(chan []Address, 2)
+	provider <- []Address{"1", "2"}
+
+	sender := &MockPruningSender{}
+	sender.On("Send", Address("1"), []byte("x")).Return(1, nil).Twice()
+	sender.On("Prune", []Address{"1", "2"}).Return(nil).Once()
+	sender.On("Prune", []Address{"1", "3"}).Return(nil).Once()
+
+	writer := RoundRobinWriter(provider, sender)
+
+	_, err := writer.Write([]byte("x"))
+	assert.NoError(t, err)
+
+	provider <- []Address{"1", "3"}
+
+	_, err = writer.Write([]byte("x"))
+
+	assert.NoError(t, err)
+	sender.AssertExpectations(t)
+	sender.AssertNotCalled(t, "Release")
+}
+
 func TestDiscoveryServiceInstanceProviderShouldNotUpdateWithEmptyInstancesOnError(t *testing.T) {
 	client := ErrorDiscoveryServiceClient{}
 
@@ -214,6 +295,52 @@ func TestIfGetAddrsByNameReturnsOnlyMatchingInstancesFromConsul(t *testing.T) {
 	}
 }
 
+func TestIfGetAddrsByNameReturnsInstancesFromFallbackDatacenterInConsul(t *testing.T) {
+	config, server := createTestConsulServer(t)
+	defer stopConsul(server)
+	secondaryServer, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.Datacenter = "dc2"
+	})
+	require.NoError(t, err)
+	defer stopConsul(secondaryServer)
+	secondaryServer.JoinWAN(t, server.WANAddr)
+
+	consulApiClient, err := api.NewClient(config)
+	require.NoError(t, err)
+	secondaryConfig := api.DefaultConfig()
+	secondaryConfig.Address = secondaryServer.HTTPAddr
+	secondaryApiClient, err := api.NewClient(secondaryConfig)
+	require.NoError(t, err)
+
+	// given
+	for id, name := range []string{"A", "A", "B"} {
+		err = secondaryApiClient.Agent().ServiceRegister(registration(id, name))
+		require.NoError(t, err)
+	}
+	err = consulApiClient.Agent().ServiceRegister(registration(3, "B"))
+	require.NoError(t, err)
+
+	client := NewConsulDiscoveryServiceClient(consulApiClient, "dc2")
+
+	testCases := []struct {
+		name string
+		want []Address
+	}{
+		{"A", []Address{"192.0.2.0:0", "192.0.2.1:1"}},
+		{"B", []Address{"192.0.2.3:3"}},
+		{"C", []Address{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("serviceName=%s", tc.name), func(t *testing.T) {
+			addr, err := client.GetAddrsByName(tc.name)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, addr)
+		})
+	}
+}
+
 func TestIfGetAddrsByNameReturnsEmptyListIfNoMatchingInstancesInConsul(t *testing.T) {
 	config, server := createTestConsulServer(t)
 	consulApiClient, err := api.NewClient(config)
@@ -267,6 +394,30 @@ func TestIfGetAddrsByNameReturnsOnlyHealthyInstancesFromConsul(t *testing.T) {
 	assert.Equal(t, 1, len(addr))
 }
 
+func TestIfGetAddrsByNameReturnsAllInstancesIfNoneIsHealthyInConsul(t *testing.T) {
+	config, server := createTestConsulServer(t)
+	consulApiClient, err := api.NewClient(config)
+	defer stopConsul(server)
+
+	require.NoError(t, err)
+
+	agent := consulApiClient.Agent()
+	// given
+	for id, name := range []string{"A", "A"} {
+		err = agent.ServiceRegister(registration(id, name))
+		require.NoError(t, err)
+	}
+	// when
+	server.AddCheck(t, "check0", "0", "critical")
+	server.AddCheck(t, "check1", "1", "critical")
+
+	client := consulDiscoveryServiceClient{client: consulApiClient}
+	// then
+	addr, err := client.GetAddrsByName("A")
+	assert.NoError(t, err)
+	assert.Equal(t, []Address{"192.0.2.0:0", "192.0.2.1:1"}, addr)
+}
+
 func registration(id int, name string) *api.AgentServiceRegistration {
 	return &api.AgentServiceRegistration{
 		ID:                fmt.Sprint(id),
@@ -306,3 +457,12 @@ func (s *MockSender) Release() error {
 	args := s.Called()
 	return args.Error(0)
 }
+
+type MockPruningSender struct {
+	MockSender
+}
+
+func (s *MockPruningSender) Prune(addrs []Address) error {
+	args := s.Called(addrs)
+	return args.Error(0)
+}